@@ -29,7 +29,14 @@ import (
 )
 
 var args struct {
-	wait time.Duration
+	wait      time.Duration
+	sweep     bool
+	olderThan time.Duration
+	dryRun    bool
+	config    string
+	retries   int
+	metrics   string
+	once      bool
 }
 
 var Cmd = &cobra.Command{
@@ -48,9 +55,63 @@ func init() {
 		0,
 		"How long to wait before remofing the project.",
 	)
+	flags.BoolVar(
+		&args.sweep,
+		"sweep",
+		false,
+		"Instead of waiting and then deleting the project where the cleaner runs, "+
+			"delete all the projects created by the runner that are older than "+
+			"the value of the '--older-than' option.",
+	)
+	flags.DurationVar(
+		&args.olderThan,
+		"older-than",
+		0,
+		"Age of the projects that will be deleted in sweep mode.",
+	)
+	flags.BoolVar(
+		&args.dryRun,
+		"dry-run",
+		false,
+		"In sweep mode only list the projects that would be deleted.",
+	)
+	flags.StringVar(
+		&args.config,
+		"config",
+		"",
+		"OpenShift client configuration file used in sweep mode. If not specified "+
+			"the configuration provided by the cluster to the pod will be used.",
+	)
+	flags.IntVar(
+		&args.retries,
+		"retries",
+		5,
+		"Number of times that the deletion of the project will be retried if it fails.",
+	)
+	flags.StringVar(
+		&args.metrics,
+		"metrics-listen",
+		"",
+		"Address and port where the cleaner will expose its metrics, in the '/metrics' "+
+			"path. If not specified the metrics will not be exposed.",
+	)
+	flags.BoolVar(
+		&args.once,
+		"once",
+		false,
+		"Exit after trying to delete the project, instead of waiting to be stopped. "+
+			"Suitable for running the cleaner as a job. The exit code is non zero if the "+
+			"project couldn't be deleted. A stop signal received while waiting cancels "+
+			"the deletion.",
+	)
 }
 
 func execute(cmd *cobra.Command, argv []string) int {
+	// Run the sweep mode if requested:
+	if args.sweep {
+		return sweep()
+	}
+
 	// Check the command line:
 	if args.wait == 0 {
 		log.Errorf("Option --wait is mandatory")
@@ -65,6 +126,8 @@ func execute(cmd *cobra.Command, argv []string) int {
 	// Create the cleaner:
 	clnr, err := cleaner.NewCleaner().
 		Wait(args.wait).
+		Retries(args.retries).
+		MetricsListen(args.metrics).
 		Build()
 	if err != nil {
 		log.Errorf("Can't create cleaner: %v", err)
@@ -94,8 +157,22 @@ func execute(cmd *cobra.Command, argv []string) int {
 		return 1
 	}
 
-	// Wait till we receive a stop signal:
-	<-signals
+	// Wait till we receive a stop signal. In one shot mode we also stop waiting when the
+	// cleaner finishes, and then the result of the deletion is the result of the command.
+	var done <-chan struct{}
+	if args.once {
+		done = clnr.Done()
+	}
+	select {
+	case <-signals:
+	case <-done:
+		err = clnr.Err()
+		if err != nil {
+			log.Errorf("Can't delete project: %v", err)
+			return 1
+		}
+		return 0
+	}
 
 	// Stop the cleaner:
 	err = clnr.Stop()
@@ -107,6 +184,34 @@ func execute(cmd *cobra.Command, argv []string) int {
 	return 0
 }
 
+func sweep() int {
+	// Check the command line:
+	if args.olderThan == 0 {
+		log.Errorf("Option --older-than is mandatory in sweep mode")
+		return 1
+	}
+
+	// Create the sweeper:
+	swpr, err := cleaner.NewSweeper().
+		Config(args.config).
+		OlderThan(args.olderThan).
+		DryRun(args.dryRun).
+		Build()
+	if err != nil {
+		log.Errorf("Can't create sweeper: %v", err)
+		return 1
+	}
+
+	// Run it:
+	err = swpr.Run()
+	if err != nil {
+		log.Errorf("Can't sweep projects: %v", err)
+		return 1
+	}
+
+	return 0
+}
+
 func run(cmd *cobra.Command, argv []string) {
 	code := execute(cmd, argv)
 	os.Exit(code)
@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package list
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/util/homedir"
+
+	"github.com/jhernand/sandbox/pkg/lister"
+)
+
+var args struct {
+	config string
+	output string
+}
+
+var Cmd = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the OpenShift projects created by the runner",
+	Long:  "Lists the OpenShift projects created by the runner.",
+	Run:   run,
+}
+
+func init() {
+	// Calculate the default value for the configuration file command line flag:
+	configDefault := ""
+	homeDir := homedir.HomeDir()
+	if homeDir != "" {
+		configDefault = filepath.Join(homeDir, ".kube", "config")
+	}
+
+	// Define the command line flags:
+	flags := Cmd.Flags()
+	flags.StringVar(
+		&args.config,
+		"config",
+		configDefault,
+		"OpenShift client configuration file.",
+	)
+	flags.StringVar(
+		&args.output,
+		"output",
+		"table",
+		"Output format. Valid values are 'table' and 'json'.",
+	)
+}
+
+func run(cmd *cobra.Command, argv []string) {
+	os.Exit(execute(cmd, argv))
+}
+
+func execute(cmd *cobra.Command, argv []string) int {
+	// Check the command line:
+	if args.output != "table" && args.output != "json" {
+		log.Errorf("Output format '%s' isn't valid, should be 'table' or 'json'", args.output)
+		return 1
+	}
+
+	// Create the lister:
+	lstr, err := lister.NewLister().
+		Config(args.config).
+		Build()
+	if err != nil {
+		log.Errorf("Can't create lister: %v", err)
+		return 1
+	}
+
+	// Get the projects:
+	projects, err := lstr.List()
+	if err != nil {
+		log.Errorf("Can't list projects: %v", err)
+		return 1
+	}
+
+	// Print the results:
+	switch args.output {
+	case "json":
+		err = printJSON(projects)
+	default:
+		err = printTable(projects)
+	}
+	if err != nil {
+		log.Errorf("Can't print projects: %v", err)
+		return 1
+	}
+
+	return 0
+}
+
+func printJSON(projects []*lister.Project) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(projects)
+}
+
+func printTable(projects []*lister.Project) error {
+	now := time.Now()
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(writer, "NAME\tOWNER\tCREATED\tAGE\tCLEANER\tRESULT\n")
+	for _, project := range projects {
+		owner := project.Owner
+		if owner == "" {
+			owner = "-"
+		}
+		age := now.Sub(project.Created).Round(time.Second)
+		result := "-"
+		if project.Result != nil {
+			result = fmt.Sprintf(
+				"%s (%d of %d failed)",
+				project.Result.Status, project.Result.Failed, project.Result.Binaries,
+			)
+		}
+		fmt.Fprintf(
+			writer,
+			"%s\t%s\t%s\t%s\t%t\t%s\n",
+			project.Name,
+			owner,
+			project.Created.Format(time.RFC3339),
+			age,
+			project.Cleaner,
+			result,
+		)
+	}
+	return writer.Flush()
+}
@@ -29,8 +29,12 @@ import (
 	"github.com/spf13/pflag"
 
 	"github.com/jhernand/sandbox/cmd/sandbox/cleaner"
+	"github.com/jhernand/sandbox/cmd/sandbox/list"
 	"github.com/jhernand/sandbox/cmd/sandbox/runner"
+	"github.com/jhernand/sandbox/cmd/sandbox/selftest"
 	"github.com/jhernand/sandbox/cmd/sandbox/server"
+	"github.com/jhernand/sandbox/cmd/sandbox/shell"
+	"github.com/jhernand/sandbox/cmd/sandbox/status"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -62,6 +66,10 @@ func init() {
 	root.AddCommand(runner.Cmd)
 	root.AddCommand(server.Cmd)
 	root.AddCommand(cleaner.Cmd)
+	root.AddCommand(list.Cmd)
+	root.AddCommand(status.Cmd)
+	root.AddCommand(selftest.Cmd)
+	root.AddCommand(shell.Cmd)
 }
 
 func run(cmd *cobra.Command, argv []string) {
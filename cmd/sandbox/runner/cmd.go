@@ -17,11 +17,17 @@ limitations under the License.
 package runner
 
 import (
+	"context"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/util/homedir"
 
 	"github.com/jhernand/sandbox/pkg/runner"
@@ -31,16 +37,69 @@ var args struct {
 	config    string
 	proxy     string
 	insecure  bool
+	caCert    string
 	compile   bool
+	vet       string
+	goCache   string
+	pattern   string
+	binaries  []string
+	plan      string
 	recursive bool
 	keep      bool
+	readOnly  bool
+	reuse     string
+	check     bool
+	preflight bool
+	timeout   time.Duration
+	srvIdle   time.Duration
+	idleConns int
+	idleTime  time.Duration
+	keepAlive bool
+	shuffle   string
+	parallel  int
+	retries   int
+	flakyPass bool
+	failFast  bool
+	quiet     bool
+	deadline  bool
+	json      bool
+	outGlobs  []string
+	artDir    string
+	uploadMin int64
+	noTests   bool
+	passthru  bool
+	noAdmit   bool
+	basePath  string
+	fixtures  []string
+	image     string
+	dbImage   string
+	pull      string
+	warmup    bool
+	dnsPolicy string
+	dnsServer []string
+	dnsSearch []string
+	dnsOption []string
+	annotate  []string
+	costCtr   string
+	labels    []string
+	only      []string
+	skip      []string
+	notifyURL string
+	notifyKey string
+	goVersion string
+	endpoint  bool
+	showToken bool
+	profiles  []string
+	parProfs  bool
 }
 
 var Cmd = &cobra.Command{
 	Use:   "runner [DIRECTORY]...",
 	Short: "Runs a collection of tests inside an OpenShift project",
-	Long:  "Runs a collection of tests inside an OpenShift project.",
-	Run:   run,
+	Long: "Runs a collection of tests inside an OpenShift project. Directories ending " +
+		"with '/...', like './pkg/...', are replaced by all the directories of that " +
+		"subtree that contain test files, like in 'go test'.",
+	Run: run,
 }
 
 func init() {
@@ -59,6 +118,23 @@ func init() {
 		configDefault,
 		"OpenShift client configuration file.",
 	)
+	flags.StringArrayVar(
+		&args.profiles,
+		"profile",
+		nil,
+		"Cluster that the tests will run against, in the form 'NAME=CONFIG[,CONTEXT]', "+
+			"where 'CONFIG' is the OpenShift client configuration file and 'CONTEXT' "+
+			"the context of that file. If the file is empty the one given with "+
+			"'--config' is used, and if the context is empty the current one. Can be "+
+			"used multiple times to run the same tests against several clusters.",
+	)
+	flags.BoolVar(
+		&args.parProfs,
+		"parallel-profiles",
+		false,
+		"Run the tests against all the profiles at the same time, instead of one after "+
+			"the other. Requires disabling compilation.",
+	)
 	flags.StringVar(
 		&args.proxy,
 		"proxy",
@@ -73,6 +149,13 @@ func init() {
 			"certificates signed by unknown certificate authorities should "+
 			"be accepted.",
 	)
+	flags.StringVar(
+		&args.caCert,
+		"ca-cert",
+		"",
+		"File containing the PEM encoded CA certificates used to verify the certificate "+
+			"of the route of the server, instead of the CA certificates of the system.",
+	)
 	flags.BoolVar(
 		&args.recursive,
 		"recursive",
@@ -89,6 +172,49 @@ func init() {
 			"intended for situations where you want or need to compile the test "+
 			"binaries with additional options that aren't supported by the runner.",
 	)
+	flags.StringVar(
+		&args.vet,
+		"vet",
+		"",
+		"Value of the '-vet' flag passed to the 'go test -c ...' command. Can be 'off' "+
+			"to disable the checks, or a comma separated list of checks. If not "+
+			"specified the default checks of Go are used.",
+	)
+	flags.StringVar(
+		&args.goCache,
+		"build-cache",
+		"",
+		"Directory where the Go build and module caches used by the 'go test -c ...' "+
+			"command are kept. Reusing the same directory in repeated runs, for example "+
+			"saving and restoring it in CI, avoids compiling again the dependencies that "+
+			"didn't change. Requires '--compile=true'. If not specified the default "+
+			"caches of Go are used.",
+	)
+	flags.StringVar(
+		&args.pattern,
+		"binary-pattern",
+		"*.test",
+		"Glob pattern used to find the test binaries in the current directory. Useful "+
+			"when the binaries were compiled manually, with '--compile=false', and "+
+			"have non standard names.",
+	)
+	flags.StringArrayVar(
+		&args.binaries,
+		"binary",
+		nil,
+		"Test binary to run. Can be used multiple times. Requires '--compile=false'. "+
+			"When used the '--binary-pattern' option is ignored and the directories "+
+			"aren't needed.",
+	)
+	flags.StringVar(
+		&args.plan,
+		"plan",
+		"",
+		"JSON file describing a plan of test binaries that the server will run in order "+
+			"in a shared directory, for example a setup binary, the main test and a "+
+			"verification binary. Requires '--compile=false', and can't be used "+
+			"together with directories or '--binary'.",
+	)
 	flags.BoolVar(
 		&args.keep,
 		"keep",
@@ -97,28 +223,540 @@ func init() {
 			"the tests. If this is set to 'true' then the OpenShift project will be "+
 			"preserved.",
 	)
+	flags.BoolVar(
+		&args.readOnly,
+		"read-only",
+		false,
+		"Run the server with a read only root file system, so that it, and the tests, "+
+			"can only write to the working directory.",
+	)
+	flags.StringVar(
+		&args.reuse,
+		"reuse",
+		"",
+		"Name of a project to reuse. If the project already exists and contains a "+
+			"server the tests will be sent to that server, and the project will "+
+			"not be deleted. Otherwise the project will be created with this name. "+
+			"Use it together with '--keep' so that the project is preserved for "+
+			"later runs.",
+	)
+	flags.BoolVar(
+		&args.check,
+		"check",
+		false,
+		"Check connectivity and permissions, and print a report, without running the "+
+			"tests.",
+	)
+	flags.BoolVar(
+		&args.preflight,
+		"preflight",
+		true,
+		"Check that the user has the permissions needed to create the project and the "+
+			"objects inside it before trying to create them.",
+	)
+	flags.DurationVar(
+		&args.timeout,
+		"timeout",
+		10*time.Minute,
+		"Maximum time that the execution of each test binary can take, including "+
+			"sending it to the server and receiving the results.",
+	)
+	flags.DurationVar(
+		&args.srvIdle,
+		"server-idle-timeout",
+		0,
+		"Time after which the server stops if it doesn't receive requests, so that its "+
+			"pod completes and releases its resources. The default is zero, which "+
+			"means that the server never stops.",
+	)
+	flags.IntVar(
+		&args.idleConns,
+		"max-idle-conns",
+		10,
+		"Maximum number of idle connections to the server kept open for reuse.",
+	)
+	flags.DurationVar(
+		&args.idleTime,
+		"idle-conn-timeout",
+		90*time.Second,
+		"How long idle connections to the server are kept open for reuse.",
+	)
+	flags.BoolVar(
+		&args.keepAlive,
+		"keep-alives",
+		true,
+		"Keep connections to the server open and reuse them for later requests. "+
+			"Disabling this opens a new connection for each request.",
+	)
+	flags.StringVar(
+		&args.shuffle,
+		"shuffle",
+		"",
+		"Value of the '-test.shuffle' flag passed to the test binaries. Use 'on' to "+
+			"shuffle with a random seed, or a number to use that seed. The seed "+
+			"used by each binary is reported.",
+	)
+	flags.IntVar(
+		&args.parallel,
+		"test-parallel",
+		0,
+		"Value of the '-test.parallel' flag passed to the test binaries, the maximum "+
+			"number of tests of the same binary that run in parallel. If zero the flag "+
+			"isn't passed.",
+	)
+	flags.IntVar(
+		&args.retries,
+		"retry-failed",
+		0,
+		"Number of times to run again test binaries that fail. Binaries that pass in "+
+			"a retry are reported as flaky.",
+	)
+	flags.BoolVar(
+		&args.flakyPass,
+		"flaky-pass",
+		false,
+		"Consider successful the test binaries that fail but then pass when retried.",
+	)
+	flags.BoolVar(
+		&args.failFast,
+		"fail-fast",
+		false,
+		"Stop running test binaries as soon as one fails. When used with "+
+			"'--retry-failed' a binary is only considered failed after all retries.",
+	)
+	flags.BoolVar(
+		&args.quiet,
+		"quiet",
+		false,
+		"Write the output of test binaries only when they fail, followed by a summary "+
+			"of the run at the end.",
+	)
+	flags.BoolVar(
+		&args.deadline,
+		"deadline",
+		false,
+		"Pass to the test binaries the deadline calculated from their timeout, as the "+
+			"'-test.timeout' flag and as the 'TEST_DEADLINE' environment variable.",
+	)
+	flags.BoolVar(
+		&args.json,
+		"json",
+		false,
+		"Write the events generated by 'go test -json' instead of the output of the "+
+			"test binaries. If the server can't generate them the output is written.",
+	)
+	flags.StringArrayVar(
+		&args.outGlobs,
+		"output-glob",
+		nil,
+		"Pattern of files produced by the test binaries, relative to the directory where "+
+			"they run, that the server will return after running them, for example "+
+			"'testdata/*.golden'. Can be used multiple times. Requires '--artifact-dir'.",
+	)
+	flags.StringVar(
+		&args.artDir,
+		"artifact-dir",
+		"",
+		"Local directory where the files returned because of '--output-glob' will be "+
+			"written, in a sub-directory for each test binary. When running against "+
+			"several profiles there is also a sub-directory for each profile.",
+	)
+	flags.Int64Var(
+		&args.uploadMin,
+		"upload-threshold",
+		32*1024*1024,
+		"Size in bytes above which test binaries are uploaded to the server in chunks, "+
+			"so that when a chunk fails the upload continues from there instead of "+
+			"starting again. If zero binaries are always sent inside the request.",
+	)
+	flags.BoolVar(
+		&args.noTests,
+		"fail-on-no-tests",
+		true,
+		"Fail when no directory contains test files or no test binary is found, as that "+
+			"usually means that the directories given are wrong.",
+	)
+	flags.BoolVar(
+		&args.passthru,
+		"passthrough",
+		false,
+		"Use passthrough TLS termination for the route of the server, so that the "+
+			"server terminates TLS itself and HTTP/2 can be used. The certificate of "+
+			"the server will only be accepted if '--insecure' is also used.",
+	)
+	flags.BoolVar(
+		&args.noAdmit,
+		"skip-route-wait",
+		false,
+		"Don't wait for the route of the server to be admitted, only for the server to "+
+			"respond through the host of the route, for up to five minutes. Useful in "+
+			"busy clusters where the router is slow to admit routes.",
+	)
+	flags.StringVar(
+		&args.basePath,
+		"base-path",
+		"",
+		"Path prefix of the URLs of the server, for example '/sandbox'. The route "+
+			"of the server will only receive requests for that path. Can't be used "+
+			"together with '--passthrough'.",
+	)
+	flags.StringArrayVar(
+		&args.labels,
+		"label",
+		nil,
+		"Labels of a directory, in the form 'DIRECTORY=LABEL,...', for example "+
+			"'pkg/db=integration,slow'. Can be used multiple times. The directory "+
+			"must contain tests.",
+	)
+	flags.StringSliceVar(
+		&args.only,
+		"only-labels",
+		nil,
+		"Only run the tests of directories that have at least one of these labels.",
+	)
+	flags.StringSliceVar(
+		&args.skip,
+		"skip-labels",
+		nil,
+		"Don't run the tests of directories that have any of these labels.",
+	)
+	flags.StringVar(
+		&args.notifyURL,
+		"notify-url",
+		"",
+		"URL of a webhook that will receive a POST request with a JSON summary of the "+
+			"run when it finishes.",
+	)
+	flags.StringVar(
+		&args.notifyKey,
+		"notify-secret",
+		"",
+		"Secret used to sign the notifications sent to the webhook. The signature is "+
+			"sent in the 'X-Sandbox-Signature' header.",
+	)
+	flags.StringVar(
+		&args.goVersion,
+		"require-go-version",
+		"",
+		"Constraint that the version of Go used to compile the tests must satisfy, "+
+			"for example '>=1.12' or '=1.13'. If not satisfied the tests will not "+
+			"run.",
+	)
+	flags.BoolVar(
+		&args.endpoint,
+		"print-endpoint",
+		false,
+		"Print the address of the server, so that it can be used manually, for example "+
+			"with 'curl'. If '--keep' is also used the runner exits after printing it, "+
+			"without running the tests.",
+	)
+	flags.BoolVar(
+		&args.showToken,
+		"show-token",
+		false,
+		"Print also the authentication token of the server when '--print-endpoint' is "+
+			"used. The token is sensitive, so avoid using this in shared logs.",
+	)
+	flags.StringSliceVar(
+		&args.fixtures,
+		"fixture",
+		nil,
+		"File that will be uploaded to the server once and shared by all the test "+
+			"binaries. Can be used multiple times. The tests will find the files in "+
+			"the directory indicated by the 'SANDBOX_FIXTURES' environment variable.",
+	)
+	flags.StringVar(
+		&args.image,
+		"image",
+		"",
+		"Image used to run the server and the cleaner, for example a copy in a mirror "+
+			"registry. If not specified the value of the 'SANDBOX_IMAGE' environment "+
+			"variable is used, and if that isn't set the default image.",
+	)
+	flags.StringVar(
+		&args.dbImage,
+		"db-image",
+		"",
+		"Image used by the tests to run the database servers. If not specified the value "+
+			"of the 'SANDBOX_DB_IMAGE' environment variable is used, and if that isn't set "+
+			"the default image.",
+	)
+	flags.StringVar(
+		&args.pull,
+		"pull-policy",
+		string(corev1.PullAlways),
+		"Policy used to pull the image of the server and the cleaner. Can be 'Always', "+
+			"'IfNotPresent' or 'Never'.",
+	)
+	flags.BoolVar(
+		&args.warmup,
+		"warmup",
+		false,
+		"Pull the image of the server with a short lived pod before creating the server, "+
+			"and fail immediately if it can't be pulled.",
+	)
+	flags.StringVar(
+		&args.dnsPolicy,
+		"dns-policy",
+		"",
+		"DNS policy of the pods of the server and the cleaner. Can be 'ClusterFirst', "+
+			"'ClusterFirstWithHostNet', 'Default' or 'None'. If not specified the "+
+			"default of the cluster is used.",
+	)
+	flags.StringSliceVar(
+		&args.dnsServer,
+		"dns-nameserver",
+		nil,
+		"DNS name server for the pods of the server and the cleaner. Can be used "+
+			"multiple times. Required when the DNS policy is 'None'.",
+	)
+	flags.StringSliceVar(
+		&args.dnsSearch,
+		"dns-search",
+		nil,
+		"DNS search domain for the pods of the server and the cleaner. Can be used "+
+			"multiple times.",
+	)
+	flags.StringSliceVar(
+		&args.dnsOption,
+		"dns-option",
+		nil,
+		"DNS resolver option for the pods of the server and the cleaner, with the form "+
+			"'NAME' or 'NAME=VALUE', for example 'ndots=2'. Can be used multiple times.",
+	)
+	flags.StringArrayVar(
+		&args.annotate,
+		"project-annotation",
+		nil,
+		"Annotation added to the project, with the form 'KEY=VALUE', for example to "+
+			"attribute the cost of the tests to a team. Can be used multiple times.",
+	)
+	flags.StringVar(
+		&args.costCtr,
+		"cost-center",
+		"",
+		"Cost center that the resources used by the tests should be charged to. It is "+
+			"stored in the 'sandbox.jhernand/cost-center' annotation of the project.",
+	)
 }
 
 func execute(cmd *cobra.Command, argv []string) int {
+	// Only run the checks if requested:
+	if args.check {
+		return check()
+	}
+
 	// Check the command line:
-	if len(argv) == 0 {
+	if len(argv) == 0 && len(args.binaries) == 0 && args.plan == "" {
 		log.Error("Expected at least one test to run")
 		return 1
 	}
+	profiles, err := parseProfiles()
+	if err != nil {
+		log.Errorf("Can't parse profiles: %v", err)
+		return 1
+	}
+	if args.parProfs && args.compile && len(profiles) > 0 {
+		log.Error(
+			"Profiles can only run in parallel when compilation is disabled, as " +
+				"all of them would compile the same test binaries",
+		)
+		return 1
+	}
+
+	// Without profiles run the tests once, with the configuration given in the command line:
+	if len(profiles) == 0 {
+		summary, err := runProfile(profile{config: args.config}, argv)
+		if err != nil {
+			log.Errorf("Can't run tests: %v", err)
+			return 1
+		}
+		if summary == nil {
+			return 0
+		}
+		if summary.Failed > 0 {
+			log.Info("Tests failed")
+			return 1
+		}
+		log.Infof("Tests passed")
+		return 0
+	}
+
+	// Run the tests against each profile, one after the other or all at the same time:
+	summaries := make([]*runner.RunSummary, len(profiles))
+	errs := make([]error, len(profiles))
+	if args.parProfs {
+		var group sync.WaitGroup
+		for i := range profiles {
+			group.Add(1)
+			go func(i int) {
+				defer group.Done()
+				summaries[i], errs[i] = runProfile(profiles[i], argv)
+			}(i)
+		}
+		group.Wait()
+	} else {
+		for i := range profiles {
+			log.Infof("Running tests for profile '%s'", profiles[i].name)
+			summaries[i], errs[i] = runProfile(profiles[i], argv)
+		}
+	}
+
+	// Print the combined report:
+	code := 0
+	for i, prfl := range profiles {
+		summary := summaries[i]
+		switch {
+		case errs[i] != nil:
+			fmt.Printf("FAIL %s: %v\n", prfl.name, errs[i])
+			code = 1
+		case summary == nil:
+			fmt.Printf("SKIP %s: tests weren't run\n", prfl.name)
+		case summary.Failed > 0:
+			fmt.Printf(
+				"FAIL %s: %d of %d test binaries failed\n",
+				prfl.name, summary.Failed, summary.Binaries,
+			)
+			code = 1
+		default:
+			fmt.Printf(
+				"PASS %s: %d of %d test binaries passed\n",
+				prfl.name, summary.Passed, summary.Binaries,
+			)
+		}
+	}
+	return code
+}
+
+// profile contains the details of one of the clusters that the tests run against.
+type profile struct {
+	name    string
+	config  string
+	context string
+}
+
+// parseProfiles parses the profiles given in the command line. Each of them has the form
+// 'NAME=CONFIG[,CONTEXT]', where an empty configuration file means the one given with the
+// '--config' option.
+func parseProfiles() (profiles []profile, err error) {
+	names := map[string]bool{}
+	for _, arg := range args.profiles {
+		equals := strings.Index(arg, "=")
+		if equals <= 0 {
+			err = fmt.Errorf(
+				"profile '%s' should have the form 'NAME=CONFIG[,CONTEXT]'",
+				arg,
+			)
+			return
+		}
+		current := profile{
+			name:   arg[0:equals],
+			config: arg[equals+1:],
+		}
+		comma := strings.LastIndex(current.config, ",")
+		if comma != -1 {
+			current.context = current.config[comma+1:]
+			current.config = current.config[0:comma]
+		}
+		if current.config == "" {
+			current.config = args.config
+		}
+		if names[current.name] {
+			err = fmt.Errorf("profile '%s' is used more than once", current.name)
+			return
+		}
+		names[current.name] = true
+		profiles = append(profiles, current)
+	}
+	return
+}
 
+// runProfile creates a runner for the cluster of the given profile, runs the tests and destroys
+// the runner. It returns the summary of the run, or nil if the tests weren't run because the
+// project is kept to use the server manually.
+func runProfile(prfl profile, argv []string) (summary *runner.RunSummary, err error) {
 	// Create the runner:
-	rnnr, err := runner.NewRunner().
-		Config(args.config).
+	builder := runner.NewRunner()
+	for _, arg := range args.labels {
+		equals := strings.Index(arg, "=")
+		if equals == -1 {
+			err = fmt.Errorf("label '%s' should have the form 'DIRECTORY=LABEL,...'", arg)
+			return
+		}
+		builder.Labels(arg[0:equals], strings.Split(arg[equals+1:], ",")...)
+	}
+	for _, arg := range args.annotate {
+		equals := strings.Index(arg, "=")
+		if equals == -1 {
+			err = fmt.Errorf("annotation '%s' should have the form 'KEY=VALUE'", arg)
+			return
+		}
+		builder.ProjectAnnotation(arg[0:equals], arg[equals+1:])
+	}
+	if args.costCtr != "" {
+		builder.CostCenter(args.costCtr)
+	}
+	if len(args.dnsServer) > 0 || len(args.dnsSearch) > 0 || len(args.dnsOption) > 0 {
+		builder.DNSConfig(dnsConfig())
+	}
+	artDir := args.artDir
+	if artDir != "" && prfl.name != "" {
+		artDir = filepath.Join(artDir, prfl.name)
+	}
+	rnnr, err := builder.
+		Config(prfl.config).
+		ConfigContext(prfl.context).
+		Profile(prfl.name).
 		Proxy(args.proxy).
 		Insecure(args.insecure).
+		CACert(args.caCert).
 		Keep(args.keep).
+		ReadOnly(args.readOnly).
+		Reuse(args.reuse).
+		Preflight(args.preflight).
+		Timeout(args.timeout).
+		ServerIdleTimeout(args.srvIdle).
+		MaxIdleConns(args.idleConns).
+		IdleConnTimeout(args.idleTime).
+		KeepAlives(args.keepAlive).
+		Shuffle(args.shuffle).
+		TestParallel(args.parallel).
+		RetryFailed(args.retries).
+		FlakyPass(args.flakyPass).
+		FailFast(args.failFast).
+		Quiet(args.quiet).
+		Deadline(args.deadline).
+		JSONEvents(args.json).
+		OutputGlobs(args.outGlobs...).
+		ArtifactDir(artDir).
+		UploadThreshold(args.uploadMin).
+		FailOnNoTests(args.noTests).
+		Passthrough(args.passthru).
+		SkipRouteWait(args.noAdmit).
+		BasePath(args.basePath).
+		Image(args.image).
+		DatabaseImage(args.dbImage).
+		PullPolicy(corev1.PullPolicy(args.pull)).
+		Warmup(args.warmup).
+		DNSPolicy(corev1.DNSPolicy(args.dnsPolicy)).
+		OnlyLabels(args.only...).
+		SkipLabels(args.skip...).
+		NotifyURL(args.notifyURL).
+		NotifySecret(args.notifyKey).
+		RequireGoVersion(args.goVersion).
+		Fixtures(args.fixtures...).
 		Compile(args.compile).
+		Vet(args.vet).
+		BuildCache(args.goCache).
+		BinaryPattern(args.pattern).
+		Binaries(args.binaries...).
+		Plan(args.plan).
 		Recursive(args.recursive).
 		Directories(argv...).
 		Build()
 	if err != nil {
-		log.Errorf("Can't create runner: %v", err)
-		return 1
+		err = fmt.Errorf("can't create runner: %v", err)
+		return
 	}
 
 	// Remember to destroy the runner:
@@ -130,18 +768,79 @@ func execute(cmd *cobra.Command, argv []string) int {
 	}
 	defer destroy()
 
+	// Print the endpoint of the server, and stop if the project will be kept, so that the user
+	// can use the server manually:
+	if args.endpoint {
+		printEndpoint(rnnr.Server())
+		if args.keep {
+			log.Infof("Project '%s' will be kept, not running tests", rnnr.Project())
+			return
+		}
+	}
+
 	// Run the tests:
-	failed, err := rnnr.Run()
+	summary, err = rnnr.RunAll(context.Background())
 	if err != nil {
-		log.Errorf("Can't run tests: %v", err)
-		return 1
+		summary = nil
+	}
+	return
+}
+
+// dnsConfig creates the DNS configuration from the command line options.
+func dnsConfig() corev1.PodDNSConfig {
+	config := corev1.PodDNSConfig{
+		Nameservers: args.dnsServer,
+		Searches:    args.dnsSearch,
+	}
+	for _, arg := range args.dnsOption {
+		option := corev1.PodDNSConfigOption{
+			Name: arg,
+		}
+		equals := strings.Index(arg, "=")
+		if equals != -1 {
+			value := arg[equals+1:]
+			option.Name = arg[0:equals]
+			option.Value = &value
+		}
+		config.Options = append(config.Options, option)
+	}
+	return config
+}
+
+// printEndpoint prints the address of the given server and, if requested, the token.
+func printEndpoint(server *runner.Server) {
+	fmt.Printf("Address: %s%s\n", server.Address(), server.BasePath())
+	if args.showToken {
+		fmt.Printf("Token (sensitive): %s\n", server.Token())
+	} else {
+		fmt.Printf("Token: use '--show-token' to print it\n")
 	}
-	if failed > 0 {
-		log.Info("Tests failed")
+}
+
+func check() int {
+	// Run the checks:
+	results, err := runner.NewRunner().
+		Config(args.config).
+		Proxy(args.proxy).
+		Insecure(args.insecure).
+		Image(args.image).
+		Check()
+	if err != nil {
+		log.Errorf("Can't run checks: %v", err)
 		return 1
 	}
-	log.Infof("Tests passed")
-	return 0
+
+	// Print the report:
+	code := 0
+	for _, result := range results {
+		if result.Error != nil {
+			fmt.Printf("FAIL %s: %v\n", result.Name, result.Error)
+			code = 1
+		} else {
+			fmt.Printf("PASS %s\n", result.Name)
+		}
+	}
+	return code
 }
 
 func run(cmd *cobra.Command, argv []string) {
@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package selftest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+
+	"github.com/jhernand/sandbox/pkg/api"
+	"github.com/jhernand/sandbox/pkg/runner"
+	"github.com/jhernand/sandbox/pkg/server"
+)
+
+var args struct {
+	timeout time.Duration
+	args    []string
+}
+
+var Cmd = &cobra.Command{
+	Use:   "selftest BINARY",
+	Short: "Runs a test binary with a local server",
+	Long: "Starts the server inside this process, listening in the loopback interface, sends " +
+		"it the given test binary and prints the results. This is intended to check that " +
+		"the server works, for example after changing the image, without a cluster. The " +
+		"exit code is non zero if the test fails.",
+	Run: run,
+}
+
+func init() {
+	flags := Cmd.Flags()
+	flags.DurationVar(
+		&args.timeout,
+		"timeout",
+		10*time.Minute,
+		"Maximum time that the test binary can run.",
+	)
+	flags.StringArrayVar(
+		&args.args,
+		"arg",
+		nil,
+		"Argument that will be passed to the test binary, for example '-test.v'. Can be "+
+			"used multiple times.",
+	)
+}
+
+func run(cmd *cobra.Command, argv []string) {
+	os.Exit(execute(cmd, argv))
+}
+
+func execute(cmd *cobra.Command, argv []string) int {
+	// Check the command line:
+	if len(argv) != 1 {
+		log.Errorf("Exactly one test binary is required")
+		return 1
+	}
+	binary := argv[0]
+
+	// Read the binary:
+	data, err := ioutil.ReadFile(binary)
+	if err != nil {
+		log.Errorf("Can't read test binary '%s': %v", binary, err)
+		return 1
+	}
+
+	// Create a temporary working directory for the server:
+	work, err := ioutil.TempDir("", "selftest")
+	if err != nil {
+		log.Errorf("Can't create working directory: %v", err)
+		return 1
+	}
+	defer func() {
+		err := os.RemoveAll(work)
+		if err != nil {
+			log.Errorf("Can't remove working directory '%s': %v", work, err)
+		}
+	}()
+
+	// Generate a random token:
+	token, err := uuid.NewRandom()
+	if err != nil {
+		log.Errorf("Can't generate token: %v", err)
+		return 1
+	}
+
+	// Open a listener in an ephemeral port of the loopback interface:
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Errorf("Can't create listener: %v", err)
+		return 1
+	}
+
+	// Create and start the server:
+	srvr, err := server.NewServer().
+		Listener(listener).
+		Token(token.String()).
+		Work(work).
+		Build()
+	if err != nil {
+		log.Errorf("Can't create server: %v", err)
+		return 1
+	}
+	defer func() {
+		err := srvr.Destroy()
+		if err != nil {
+			log.Errorf("Can't destroy server: %v", err)
+		}
+	}()
+	err = srvr.Start()
+	if err != nil {
+		log.Errorf("Can't start server: %v", err)
+		return 1
+	}
+	address := fmt.Sprintf("http://%s", listener.Addr())
+	log.Infof("Server is listening in address '%s'", address)
+
+	// Create the client:
+	client, err := runner.NewServer().
+		Address(address).
+		Token(token.String()).
+		Build()
+	if err != nil {
+		log.Errorf("Can't create client: %v", err)
+		return 1
+	}
+
+	// Send the binary:
+	log.Infof("Sending test binary '%s'", binary)
+	sum := sha256.Sum256(data)
+	response, err := client.Send(&api.Test{
+		Binary:   data,
+		Checksum: hex.EncodeToString(sum[:]),
+		Args:     args.args,
+		Timeout:  args.timeout.String(),
+	})
+	if err != nil {
+		log.Errorf("Can't send test binary '%s': %v", binary, err)
+		return 1
+	}
+
+	// Print the results:
+	_, _ = os.Stdout.Write(response.Out)
+	_, _ = os.Stderr.Write(response.Err)
+	if response.TimedOut {
+		log.Errorf("Test binary '%s' didn't finish after %s", binary, args.timeout)
+	}
+	log.Infof("Test binary '%s' finished with exit code %d", binary, response.Code)
+	if response.Code != 0 {
+		return 1
+	}
+
+	return 0
+}
@@ -18,9 +18,12 @@ package server
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -31,7 +34,31 @@ import (
 var args struct {
 	listen string
 	token  string
+	tokenF string
 	work   string
+	audit  string
+	keep   bool
+	gzip   bool
+	age    time.Duration
+	sweep  time.Duration
+	allow  []string
+	deny   []string
+	cert   string
+	key    string
+	fetch  []string
+	ttl    time.Duration
+	once   time.Duration
+	upTTL  time.Duration
+	runAs  string
+	tee    bool
+	keyTTL time.Duration
+	maxOut int64
+	maxArt int64
+	base   string
+	budget int64
+	clean  bool
+	idle   time.Duration
+	access bool
 }
 
 var Cmd = &cobra.Command{
@@ -49,7 +76,10 @@ func init() {
 		"listen",
 		defaultListen,
 		fmt.Sprintf(
-			"Address and port where the server will listen for requests.",
+			"Address and port where the server will listen for requests. IPv6 "+
+				"addresses must be inside brackets, for example '[::]:8000'. If the "+
+				"host is empty the server listens in all the IPv4 and IPv6 addresses. "+
+				"Ignored when the server is started by systemd with socket activation.",
 		),
 	)
 	flags.StringVar(
@@ -62,6 +92,16 @@ func init() {
 				"if it isn't specified.",
 		),
 	)
+	flags.StringVar(
+		&args.tokenF,
+		"token-file",
+		"",
+		fmt.Sprintf(
+			"File containing the authentication token that the server will require "+
+				"in every HTTP request. This is an alternative to the '--token' "+
+				"option that avoids passing the token in the command line.",
+		),
+	)
 	flags.StringVar(
 		&args.work,
 		"work",
@@ -72,30 +112,269 @@ func init() {
 				"the default temporary directory.",
 		),
 	)
+	flags.StringVar(
+		&args.audit,
+		"audit",
+		"",
+		fmt.Sprintf(
+			"Path of the file where the server will write the audit log, containing "+
+				"one JSON line for each test binary executed. If not specified the "+
+				"audit log will not be written.",
+		),
+	)
+	flags.BoolVar(
+		&args.keep,
+		"keep-on-failure",
+		false,
+		"Remove the directory of each test when it succeeds, but preserve it when it "+
+			"fails.",
+	)
+	flags.BoolVar(
+		&args.gzip,
+		"compress-kept",
+		false,
+		"Compress the test binary and the output files of the test directories that "+
+			"aren't removed, adding the '.gz' extension to their names.",
+	)
+	flags.DurationVar(
+		&args.age,
+		"keep-age",
+		0,
+		"How long to preserve the directories of failed tests when the "+
+			"'--keep-on-failure' option is used. If not specified they will never "+
+			"be removed.",
+	)
+	flags.DurationVar(
+		&args.sweep,
+		"sweep-age",
+		0,
+		"Maximum age of test directories. Directories that haven't been modified for "+
+			"longer than this will be periodically removed, regardless of the "+
+			"'--keep-on-failure' option. If not specified they will never be removed.",
+	)
+	flags.StringSliceVar(
+		&args.allow,
+		"env-allow",
+		nil,
+		"Prefix of the names of environment variables of the server that will be "+
+			"passed to the tests. Can be used multiple times. If not specified all "+
+			"variables will be passed, except the denied ones.",
+	)
+	flags.StringSliceVar(
+		&args.deny,
+		"env-deny",
+		nil,
+		"Prefix of the names of environment variables of the server that will not "+
+			"be passed to the tests. Can be used multiple times. Variables known to "+
+			"contain sensitive information are never passed.",
+	)
+	flags.StringVar(
+		&args.cert,
+		"tls-cert",
+		"",
+		"File containing the TLS certificate. If specified the server will use HTTPS "+
+			"and HTTP/2.",
+	)
+	flags.StringVar(
+		&args.key,
+		"tls-key",
+		"",
+		"File containing the TLS key.",
+	)
+	flags.StringSliceVar(
+		&args.fetch,
+		"allow-fetch-from",
+		nil,
+		"URL of a location where the server is allowed to download test binaries "+
+			"from. Can be used multiple times. If not specified the server will "+
+			"not download binaries.",
+	)
+	flags.DurationVar(
+		&args.ttl,
+		"fixture-ttl",
+		24*time.Hour,
+		"Time that fixtures are kept after they were last uploaded or used. If zero "+
+			"fixtures will never be removed.",
+	)
+	flags.DurationVar(
+		&args.once,
+		"once-ttl",
+		0,
+		"Time after which the markers of the binaries executed with the once endpoint "+
+			"expire, so that the next request with the same key runs the binary again. "+
+			"If zero markers never expire.",
+	)
+	flags.DurationVar(
+		&args.upTTL,
+		"upload-ttl",
+		time.Hour,
+		"Time that binaries uploaded in chunks are kept after they were last modified "+
+			"or used. If zero uploads will never be removed.",
+	)
+	flags.StringVar(
+		&args.runAs,
+		"run-as-range",
+		"",
+		"Range of user identifiers that clients can request to run the tests with, "+
+			"for example '1000-1999'. If not specified clients can't request to "+
+			"run tests as a different user.",
+	)
+	flags.BoolVar(
+		&args.tee,
+		"tee-output",
+		false,
+		"Write the standard output and error of the tests to the log of the server, "+
+			"in addition to returning them to the client. Only has effect when the "+
+			"'--debug' option is also used.",
+	)
+	flags.DurationVar(
+		&args.keyTTL,
+		"idempotency-ttl",
+		time.Hour,
+		"Time that the results of tests submitted with an idempotency key are kept, "+
+			"so that retries of the same test return the saved result instead of "+
+			"running it again. If zero idempotency keys are ignored.",
+	)
+	flags.Int64Var(
+		&args.maxOut,
+		"max-output-bytes",
+		0,
+		"Maximum number of bytes of the standard output and of the standard error of "+
+			"each test that will be kept and returned to the client. The rest will be "+
+			"discarded. If zero there is no limit.",
+	)
+	flags.Int64Var(
+		&args.maxArt,
+		"max-artifact-bytes",
+		10*1024*1024,
+		"Maximum total number of bytes of the files produced by each test that will be "+
+			"returned to the client. Files that don't fit aren't returned. If zero there "+
+			"is no limit.",
+	)
+	flags.StringVar(
+		&args.base,
+		"base-path",
+		"",
+		"Path prefix of all the URLs served by the server, for example '/sandbox'. "+
+			"Needed when the server is behind a route or ingress that routes requests "+
+			"by path. If not specified the URLs aren't prefixed.",
+	)
+	flags.Int64Var(
+		&args.budget,
+		"memory-budget-bytes",
+		0,
+		"Maximum total size of the test binaries that will run at the same time. Binaries "+
+			"that don't fit wait till others finish. If zero there is no limit.",
+	)
+	flags.BoolVar(
+		&args.clean,
+		"clean-env",
+		false,
+		"Run the tests with a minimal environment containing only the 'PATH', 'HOME' and "+
+			"'TMPDIR' variables, instead of the environment of the server. Variables of the "+
+			"server are passed only if explicitly allowed with '--env-allow'. Recommended, "+
+			"as it makes tests independent of how the server is deployed.",
+	)
+	flags.DurationVar(
+		&args.idle,
+		"idle-timeout",
+		0,
+		"Time without requests after which the server stops and exits, so that the "+
+			"resources it uses are released without waiting for the project to be deleted. "+
+			"Tests in progress don't count as idle time. If zero the server never stops "+
+			"because it is idle.",
+	)
+	flags.BoolVar(
+		&args.access,
+		"access-log",
+		true,
+		"Write to the log a line for each request received and for each response sent. "+
+			"Use '--access-log=false' to reduce the volume of the log of servers that "+
+			"receive many requests.",
+	)
 }
 
 func execute(cmd *cobra.Command, argv []string) int {
 	// Check mandatory options:
+	if args.token != "" && args.tokenF != "" {
+		log.Errorf("Options '--token' and '--token-file' can't be used together")
+		return 1
+	}
+	if args.tokenF != "" {
+		data, err := ioutil.ReadFile(args.tokenF)
+		if err != nil {
+			log.Errorf("Can't read token file '%s': %v", args.tokenF, err)
+			return 1
+		}
+		args.token = strings.TrimSpace(string(data))
+	}
 	if args.token == "" {
-		log.Errorf("Option '--token' is mandatory")
+		log.Errorf("Option '--token' or '--token-file' is mandatory")
 		return 1
 	}
 
+	// Parse the range of user identifiers:
+	var runAsMin, runAsMax int
+	if args.runAs != "" {
+		_, err := fmt.Sscanf(args.runAs, "%d-%d", &runAsMin, &runAsMax)
+		if err != nil {
+			log.Errorf("Range of user identifiers '%s' isn't valid: %v", args.runAs, err)
+			return 1
+		}
+	}
+
 	// Create a channel to receive stop signals:
 	signals := make(chan os.Signal, 1)
 	signal.Notify(signals, syscall.SIGTERM)
 	signal.Notify(signals, syscall.SIGINT)
 
+	// Check if the server was started by systemd with socket activation:
+	listener, err := server.ActivationListener()
+	if err != nil {
+		log.Errorf("Can't get socket passed by systemd: %v", err)
+		return 1
+	}
+
 	// Create the server:
-	srvr, err := server.NewServer().
+	builder := server.NewServer()
+	if listener != nil {
+		builder.Listener(listener)
+	}
+	if args.runAs != "" {
+		builder.RunAsRange(runAsMin, runAsMax)
+	}
+	srvr, err := builder.
 		Listen(args.listen).
 		Token(args.token).
 		Work(args.work).
+		Audit(args.audit).
+		KeepOnFailure(args.keep).
+		CompressKept(args.gzip).
+		KeepAge(args.age).
+		SweepAge(args.sweep).
+		EnvAllow(args.allow...).
+		EnvDeny(args.deny...).
+		TLS(args.cert, args.key).
+		AllowFetchFrom(args.fetch...).
+		FixtureTTL(args.ttl).
+		OnceTTL(args.once).
+		UploadTTL(args.upTTL).
+		TeeOutput(args.tee).
+		ResultTTL(args.keyTTL).
+		MaxOutputBytes(args.maxOut).
+		MaxArtifactBytes(args.maxArt).
+		BasePath(args.base).
+		MemoryBudgetBytes(args.budget).
+		CleanEnv(args.clean).
+		IdleTimeout(args.idle).
+		AccessLog(args.access).
 		Build()
 	if err != nil {
 		log.Errorf("Can't create server: %v", err)
 		return 1
 	}
+	// Make sure that the server is stopped and its resources released when we return, even if
+	// something fails. If the server was already stopped explicitly it will not be stopped again.
 	destroy := func() {
 		err := srvr.Destroy()
 		if err != nil {
@@ -110,10 +389,18 @@ func execute(cmd *cobra.Command, argv []string) int {
 		log.Errorf("Can't start server: %v", err)
 		return 1
 	}
-	log.Infof("Server is now listening in address '%s'", args.listen)
+	if listener != nil {
+		log.Infof("Server is now listening in address '%s' passed by systemd", listener.Addr())
+	} else {
+		log.Infof("Server is now listening in address '%s'", srvr.Addr())
+	}
 
-	// Wait till we receive a stop signal:
-	<-signals
+	// Wait till we receive a stop signal or the server is idle:
+	select {
+	case <-signals:
+	case <-srvr.Idle():
+		log.Infof("Stopping server because it is idle")
+	}
 
 	// Stop the server:
 	err = srvr.Stop()
@@ -130,5 +417,6 @@ func run(cmd *cobra.Command, argv []string) {
 	os.Exit(code)
 }
 
-// Default listen address:
-const defaultListen = "0.0.0.0:8000"
+// Default listen address. The host is empty so that the server listens in all the addresses of
+// both families, and works in IPv4 only, IPv6 only and dual stack clusters:
+const defaultListen = ":8000"
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shell
+
+import (
+	"os"
+	"path/filepath"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/util/exec"
+	"k8s.io/client-go/util/homedir"
+
+	"github.com/jhernand/sandbox/pkg/shell"
+)
+
+var args struct {
+	config string
+}
+
+var Cmd = &cobra.Command{
+	Use:   "shell PROJECT [-- COMMAND [ARG...]]",
+	Short: "Opens a shell in the server pod of an OpenShift project created by the runner",
+	Long: "Opens an interactive shell in the server pod of an OpenShift project created by the " +
+		"runner, for example one preserved with the '--keep' option, without requiring the " +
+		"'oc' binary. If a command is given it is executed instead of the shell.",
+	Args: cobra.MinimumNArgs(1),
+	Run:  run,
+}
+
+func init() {
+	// Calculate the default value for the configuration file command line flag:
+	configDefault := ""
+	homeDir := homedir.HomeDir()
+	if homeDir != "" {
+		configDefault = filepath.Join(homeDir, ".kube", "config")
+	}
+
+	// Define the command line flags:
+	flags := Cmd.Flags()
+	flags.StringVar(
+		&args.config,
+		"config",
+		configDefault,
+		"OpenShift client configuration file.",
+	)
+}
+
+func run(cmd *cobra.Command, argv []string) {
+	os.Exit(execute(cmd, argv))
+}
+
+func execute(cmd *cobra.Command, argv []string) int {
+	// Create the shell:
+	shll, err := shell.NewShell().
+		Config(args.config).
+		Project(argv[0]).
+		Command(argv[1:]...).
+		Build()
+	if err != nil {
+		log.Errorf("Can't create shell: %v", err)
+		return 1
+	}
+
+	// Run the shell, and exit with the same code that it exits:
+	err = shll.Run()
+	if exitErr, ok := err.(exec.ExitError); ok {
+		return exitErr.ExitStatus()
+	}
+	if err != nil {
+		log.Errorf("Can't run shell: %v", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,143 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package status
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"k8s.io/client-go/util/homedir"
+
+	"github.com/jhernand/sandbox/pkg/lister"
+)
+
+var args struct {
+	config string
+	output string
+}
+
+var Cmd = &cobra.Command{
+	Use:   "status PROJECT",
+	Short: "Shows the status of an OpenShift project created by the runner",
+	Long: "Shows the status of an OpenShift project created by the runner, including the " +
+		"time remaining till the cleaner deletes it.",
+	Run: run,
+}
+
+func init() {
+	// Calculate the default value for the configuration file command line flag:
+	configDefault := ""
+	homeDir := homedir.HomeDir()
+	if homeDir != "" {
+		configDefault = filepath.Join(homeDir, ".kube", "config")
+	}
+
+	// Define the command line flags:
+	flags := Cmd.Flags()
+	flags.StringVar(
+		&args.config,
+		"config",
+		configDefault,
+		"OpenShift client configuration file.",
+	)
+	flags.StringVar(
+		&args.output,
+		"output",
+		"text",
+		"Output format. Valid values are 'text' and 'json'.",
+	)
+}
+
+func run(cmd *cobra.Command, argv []string) {
+	os.Exit(execute(cmd, argv))
+}
+
+func execute(cmd *cobra.Command, argv []string) int {
+	// Check the command line:
+	if len(argv) != 1 {
+		log.Errorf("Exactly one project name is required")
+		return 1
+	}
+	if args.output != "text" && args.output != "json" {
+		log.Errorf("Output format '%s' isn't valid, should be 'text' or 'json'", args.output)
+		return 1
+	}
+
+	// Create the lister:
+	lstr, err := lister.NewLister().
+		Config(args.config).
+		Build()
+	if err != nil {
+		log.Errorf("Can't create lister: %v", err)
+		return 1
+	}
+
+	// Get the project:
+	project, err := lstr.Get(argv[0])
+	if err != nil {
+		log.Errorf("Can't get project '%s': %v", argv[0], err)
+		return 1
+	}
+
+	// Print the results:
+	if args.output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(project)
+		if err != nil {
+			log.Errorf("Can't print project: %v", err)
+			return 1
+		}
+		return 0
+	}
+	now := time.Now()
+	owner := project.Owner
+	if owner == "" {
+		owner = "-"
+	}
+	fmt.Printf("Name: %s\n", project.Name)
+	fmt.Printf("Owner: %s\n", owner)
+	fmt.Printf(
+		"Created: %s (%s ago)\n",
+		project.Created.Format(time.RFC3339),
+		now.Sub(project.Created).Round(time.Second),
+	)
+	fmt.Printf("Cleaner: %t\n", project.Cleaner)
+	switch {
+	case project.Deadline == nil:
+		fmt.Printf("Deletion: unknown\n")
+	case project.Deadline.After(now):
+		fmt.Printf(
+			"Deletion: %s (in %s)\n",
+			project.Deadline.Format(time.RFC3339),
+			project.Deadline.Sub(now).Round(time.Second),
+		)
+	default:
+		fmt.Printf(
+			"Deletion: %s (overdue by %s)\n",
+			project.Deadline.Format(time.RFC3339),
+			now.Sub(*project.Deadline).Round(time.Second),
+		)
+	}
+
+	return 0
+}
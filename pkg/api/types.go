@@ -15,6 +15,10 @@ limitations under the License.
 */
 
 // This file contains the types that the server uses to marshal and unmarshal JSON.
+//
+// All the fields have explicit JSON names in snake case, for example 'binary_url', and are omitted
+// when they are empty. These names are part of version 'v1' of the API, so existing names must not
+// change: new fields can be added, but renaming a field requires a new version of the API.
 
 package api
 
@@ -27,21 +31,346 @@ type Error struct {
 // Test is the description of a test that will be passed back and forth between the test runner
 // and the server.
 type Test struct {
+	// Key is an idempotency key chosen by the client, for example a random UUID. When the
+	// server receives a test with a key that it has already seen from the same client it
+	// returns the result of the previous execution instead of running the binary again, so that
+	// clients can safely retry requests that failed because of network problems. If a test with
+	// the same key is still running the server responds with status code 409. The server only
+	// remembers keys for a limited time.
+	Key string `json:"key,omitempty"`
+
 	// Binary is the test binary.
 	Binary []byte `json:"binary,omitempty"`
 
+	// BinaryURL is the URL where the server should download the test binary from. If present
+	// the Binary field is ignored. The server only downloads binaries from the locations that
+	// it has been explicitly configured to allow.
+	BinaryURL string `json:"binary_url,omitempty"`
+
+	// UploadID is the identifier of a complete upload, created with the uploads endpoint, that
+	// contains the test binary. If present the Binary and BinaryURL fields are ignored. This is
+	// intended for large binaries, that can be uploaded in chunks, resuming the upload when a
+	// chunk fails. The same upload can be used by several tests till it expires.
+	UploadID string `json:"upload_id,omitempty"`
+
+	// Checksum is the hexadecimal SHA-256 of the test binary. If present the server will check
+	// it before executing the binary, and will refuse to execute it if it doesn't match.
+	Checksum string `json:"checksum,omitempty"`
+
 	// Args is the collection of command line arguments that will be passed to the test binary.
 	Args []string `json:"args,omitempty"`
 
 	// Env is the collection of environment variables that will be passed to the test binary.
 	Env map[string]string `json:"env,omitempty"`
 
+	// EnvFile is the content of a dotenv style environment file. The server writes it to the
+	// directory of the test, passing its path in the SANDBOX_ENV_FILE environment variable, and
+	// also adds the variables that it contains to the environment of the test binary. These
+	// variables take precedence over the environment of the server, and the variables in the
+	// Env field take precedence over them.
+	EnvFile []byte `json:"env_file,omitempty"`
+
+	// RunAsUser is the identifier of the user that will run the test binary. The group will be
+	// the same than the user. If not present the test binary will run with the same user than
+	// the server. The server rejects the request if the value isn't within the range of
+	// identifiers that it has been configured to allow.
+	RunAsUser *int `json:"run_as_user,omitempty"`
+
+	// Fixtures is the list of names of fixtures, previously uploaded to the server, that the
+	// test binary needs. The server will make them available in a directory whose path is
+	// passed to the test binary in the SANDBOX_FIXTURES environment variable.
+	Fixtures []string `json:"fixtures,omitempty"`
+
+	// Timeout is the maximum time that the test binary can run, using the syntax of Go
+	// durations, for example '10m'. If the binary doesn't finish in that time the server kills
+	// it and returns the output that it generated till then. If not present there is no limit.
+	Timeout string `json:"timeout,omitempty"`
+
+	// Deadline indicates if the server should pass to the test binary the deadline calculated
+	// from the timeout, both as the '-test.timeout' flag, added before the arguments, and as the
+	// TEST_DEADLINE environment variable, using the RFC 3339 format. This allows tests to adjust
+	// their own internal timeouts to the limit enforced by the server. It is ignored if there is
+	// no timeout.
+	Deadline bool `json:"deadline,omitempty"`
+
+	// JSONEvents indicates if the server should run the test binary with the '-test.v' flag, and
+	// convert its output with the test2json tool to the stream of events generated by the
+	// 'go test -json' command. The events are returned in the Events field, in addition to the
+	// output.
+	JSONEvents bool `json:"json_events,omitempty"`
+
+	// Package is the name of the package of the test binary, used to populate the Package
+	// field of the JSON events. It is optional.
+	Package string `json:"package,omitempty"`
+
+	// OutputGlobs is a list of patterns of files that the test binary produces and that the
+	// server should return in the Artifacts field after the binary finishes, for example
+	// 'testdata/*.golden' or 'cpu.prof'. Patterns use the syntax of the Go filepath.Match
+	// function and are relative to the directory where the binary runs. The server rejects
+	// absolute patterns and patterns that contain '..' elements.
+	OutputGlobs []string `json:"output_globs,omitempty"`
+
 	// Out is the output (stdout) generated by the execution of the test binary.
 	Out []byte `json:"out,omitempty"`
 
 	// Out are the errors (stderr) generated by the execution of the test binary.
 	Err []byte `json:"err,omitempty"`
 
+	// Events is the stream of events, one JSON object per line, in the format generated by
+	// the 'go test -json' command. It is only returned when requested with the JSONEvents
+	// field.
+	Events []byte `json:"events,omitempty"`
+
 	// Code is the code returned by the execution of the test binary.
 	Code int `json:"code,omitempty"`
+
+	// TimedOut indicates if the test binary was killed because it didn't finish before the
+	// timeout. In that case the code will be -1, and the output will contain what the binary
+	// wrote before it was killed.
+	TimedOut bool `json:"timed_out,omitempty"`
+
+	// Signal is the name of the signal that killed the test binary, for example 'SIGSEGV' for
+	// a crash or 'SIGKILL' when it was killed because of the timeout or because it ran out of
+	// memory. When the binary is killed by a signal the code is -1, so this is the only way to
+	// find out why it finished. Empty if the binary exited normally, or if the server can't
+	// find out the signal.
+	Signal string `json:"signal,omitempty"`
+
+	// Truncated indicates if the output or the errors generated by the test binary were larger
+	// than the limit configured in the server, and were therefore truncated. In that case the
+	// truncated content ends with an '[output truncated]' line.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Artifacts contains the regular files that match the OutputGlobs field, indexed by their
+	// path relative to the directory where the binary runs, using slashes as separators.
+	Artifacts map[string][]byte `json:"artifacts,omitempty"`
+
+	// OmittedArtifacts contains the paths of the files that match the OutputGlobs field but
+	// that aren't returned, because the total size would exceed the limit configured in the
+	// server, because they can't be read, or because they are symbolic links to files outside
+	// of the directory of the test.
+	OmittedArtifacts []string `json:"omitted_artifacts,omitempty"`
+
+	// Dir is the directory of the server where the files of the test have been preserved. It
+	// will only be returned when the server is configured to preserve the files of failed
+	// tests.
+	Dir string `json:"dir,omitempty"`
+}
+
+// Plan is the description of a sequence of tests that the server runs in order, sharing the same
+// directory. This is intended for scenarios where one binary prepares something, another uses it,
+// and a third checks the results.
+//
+// All the steps run with the same current directory, which is also their home directory and
+// contains the temporary directory, so files written by a step are visible to the next ones.
+// Environment variables don't flow from one step to the next: each step gets the variables of its
+// own Env and EnvFile fields. Steps that need to pass values to the next ones should write them
+// to files in the shared directory.
+type Plan struct {
+	// Steps is the list of tests to run, in order. In the request the fields of each step have
+	// the same meaning than in a test sent alone, except the Key field, that is ignored. In the
+	// response it contains the results of the steps that were executed, in the same order.
+	Steps []Test `json:"steps,omitempty"`
+
+	// StopOnFailure indicates if the server should stop executing steps when one of them
+	// fails. The steps that aren't executed aren't included in the response.
+	StopOnFailure bool `json:"stop_on_failure,omitempty"`
+
+	// Dir is the directory of the server, shared by all the steps, where the files of the plan
+	// have been preserved. It will only be returned when the server is configured to preserve
+	// the files of failed tests and a step failed or wasn't executed.
+	Dir string `json:"dir,omitempty"`
+}
+
+// Fixture is the description of a fixture uploaded to the server.
+type Fixture struct {
+	// Name is the name of the fixture.
+	Name string `json:"name,omitempty"`
+
+	// Size is the size of the fixture in bytes.
+	Size int64 `json:"size,omitempty"`
+
+	// Checksum is the hexadecimal SHA-256 of the content of the fixture.
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// Upload is the state of a test binary that is uploaded to the server in chunks. The client
+// creates the upload sending the total size and checksum of the binary, and then sends the chunks
+// in order. If sending a chunk fails the client can retrieve the state of the upload to find out
+// how many bytes the server received, and continue from there instead of starting again.
+type Upload struct {
+	// ID is the identifier assigned by the server to the upload.
+	ID string `json:"id,omitempty"`
+
+	// Size is the total size of the binary in bytes.
+	Size int64 `json:"size,omitempty"`
+
+	// Checksum is the hexadecimal SHA-256 of the complete binary. The server checks it when it
+	// receives the last chunk, and discards the upload if it doesn't match.
+	Checksum string `json:"checksum,omitempty"`
+
+	// Received is the number of bytes that the server has received, which is also the offset
+	// where the next chunk must start.
+	Received int64 `json:"received,omitempty"`
+
+	// Complete indicates that the server has received all the bytes of the binary and that the
+	// checksum matches, so that the upload can be used in the UploadID field of tests.
+	Complete bool `json:"complete,omitempty"`
+}
+
+// Once is the result of a test binary sent to the once endpoint of the server, that runs it only
+// if it hasn't already run successfully with the same key. This is intended for expensive
+// initializations, like loading a large data set into a database, that should happen only once
+// even when several test runs use the same server.
+type Once struct {
+	// Key is the key chosen by the client to identify the initialization.
+	Key string `json:"key,omitempty"`
+
+	// Cached indicates that the binary wasn't executed by this request because it had already
+	// run successfully with the same key, and that the result is the saved one.
+	Cached bool `json:"cached,omitempty"`
+
+	// Time is the time when the binary started to run, using the RFC 3339 format.
+	Time string `json:"time,omitempty"`
+
+	// Result is the result of the execution of the binary.
+	Result *Test `json:"result,omitempty"`
+}
+
+// Capabilities describes the optional features supported by the server, so that clients can check
+// them before using them. Features added to the server in the future will be added here, and
+// servers that don't know about them will not return them, which is the same than returning
+// false.
+type Capabilities struct {
+	// Compression indicates if the server can compress responses with gzip.
+	Compression bool `json:"compression,omitempty"`
+
+	// Fetch indicates if the server can download binaries from URLs, using the BinaryURL
+	// field of the test.
+	Fetch bool `json:"fetch,omitempty"`
+
+	// Fixtures indicates if the server accepts fixtures.
+	Fixtures bool `json:"fixtures,omitempty"`
+
+	// EnvFile indicates if the server accepts environment files, using the EnvFile field of
+	// the test.
+	EnvFile bool `json:"env_file,omitempty"`
+
+	// RunAsUser indicates if the server can run tests as other users, using the RunAsUser
+	// field of the test.
+	RunAsUser bool `json:"run_as_user,omitempty"`
+
+	// Timeout indicates if the server supports the Timeout field of the test.
+	Timeout bool `json:"timeout,omitempty"`
+
+	// Deadline indicates if the server can pass the deadline to the test binary, using the
+	// Deadline field of the test.
+	Deadline bool `json:"deadline,omitempty"`
+
+	// JSONEvents indicates if the server can convert the output of the test binaries to JSON
+	// events, using the JSONEvents field of the test. It is false when the test2json tool isn't
+	// available in the server.
+	JSONEvents bool `json:"json_events,omitempty"`
+
+	// Idempotency indicates if the server remembers the results of the tests submitted with an
+	// idempotency key, using the Key field of the test.
+	Idempotency bool `json:"idempotency,omitempty"`
+
+	// Plans indicates if the server can run plans of ordered steps.
+	Plans bool `json:"plans,omitempty"`
+
+	// Once indicates if the server can run binaries only once per key, using the once
+	// endpoint.
+	Once bool `json:"once,omitempty"`
+
+	// Artifacts indicates if the server can return the files produced by the tests, using
+	// the OutputGlobs field of the test.
+	Artifacts bool `json:"artifacts,omitempty"`
+
+	// Uploads indicates if the server accepts binaries uploaded in chunks, using the uploads
+	// endpoint and the UploadID field of the test.
+	Uploads bool `json:"uploads,omitempty"`
+
+	// MaxOutputBytes is the maximum size of the output and errors of the tests that the server
+	// returns. Zero means that there is no limit.
+	MaxOutputBytes int64 `json:"max_output_bytes,omitempty"`
+}
+
+// Config is the effective configuration of the server, useful to check that a change of the
+// deployment took effect. It never contains secrets: the token isn't included, and the user
+// information is removed from the URLs. Durations use the syntax of Go durations, for example
+// '1h0m0s', and are omitted when they are zero.
+type Config struct {
+	// Work is the working directory of the server.
+	Work string `json:"work,omitempty"`
+
+	// BasePath is the prefix of the paths of the API.
+	BasePath string `json:"base_path,omitempty"`
+
+	// TLSCert and TLSKey are the files containing the TLS certificate and key.
+	TLSCert string `json:"tls_cert,omitempty"`
+	TLSKey  string `json:"tls_key,omitempty"`
+
+	// Audit is the file where the server writes the audit log.
+	Audit string `json:"audit,omitempty"`
+
+	// KeepOnFailure indicates if the files of the tests that fail are preserved, and KeepAge
+	// is the time after which they are removed.
+	KeepOnFailure bool   `json:"keep_on_failure,omitempty"`
+	KeepAge       string `json:"keep_age,omitempty"`
+
+	// CompressKept indicates if the binaries and output files of the directories that are
+	// preserved are compressed.
+	CompressKept bool `json:"compress_kept,omitempty"`
+
+	// SweepAge is the time after which test directories are removed.
+	SweepAge string `json:"sweep_age,omitempty"`
+
+	// EnvAllow and EnvDeny are the prefixes of the environment variables of the server that
+	// are passed to the tests or removed.
+	EnvAllow []string `json:"env_allow,omitempty"`
+	EnvDeny  []string `json:"env_deny,omitempty"`
+
+	// CleanEnv indicates if the tests start with a minimal environment.
+	CleanEnv bool `json:"clean_env,omitempty"`
+
+	// FetchFrom are the locations where the server is allowed to download binaries from.
+	FetchFrom []string `json:"fetch_from,omitempty"`
+
+	// FixtureTTL is the time that fixtures are kept after they were last used.
+	FixtureTTL string `json:"fixture_ttl,omitempty"`
+
+	// RunAsRange is the range of user identifiers that clients can request, for example
+	// '1000-1999'.
+	RunAsRange string `json:"run_as_range,omitempty"`
+
+	// TeeOutput indicates if the output of the tests is copied to the log of the server.
+	TeeOutput bool `json:"tee_output,omitempty"`
+
+	// ResultTTL is the time that the results of the tests submitted with idempotency keys are
+	// remembered.
+	ResultTTL string `json:"result_ttl,omitempty"`
+
+	// OnceTTL is the time after which the markers of the binaries executed with the once
+	// endpoint expire.
+	OnceTTL string `json:"once_ttl,omitempty"`
+
+	// UploadTTL is the time after which the binaries uploaded in chunks are removed.
+	UploadTTL string `json:"upload_ttl,omitempty"`
+
+	// MaxOutputBytes is the maximum size of the output and errors of the tests.
+	MaxOutputBytes int64 `json:"max_output_bytes,omitempty"`
+
+	// MaxArtifactBytes is the maximum total size of the files produced by each test that are
+	// returned to the client.
+	MaxArtifactBytes int64 `json:"max_artifact_bytes,omitempty"`
+
+	// MemoryBudgetBytes is the maximum total size of the binaries that run at the same time.
+	MemoryBudgetBytes int64 `json:"memory_budget_bytes,omitempty"`
+
+	// IdleTimeout is the time without requests after which the server stops.
+	IdleTimeout string `json:"idle_timeout,omitempty"`
+
+	// AccessLog indicates if the server writes the access log.
+	AccessLog bool `json:"access_log,omitempty"`
 }
@@ -0,0 +1,151 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("JSON names", func() {
+	It("Uses snake case names for all the fields", func() {
+		types := []interface{}{
+			Error{},
+			Test{},
+			Plan{},
+			Fixture{},
+			Once{},
+			Upload{},
+			Capabilities{},
+			Config{},
+		}
+		for _, value := range types {
+			typ := reflect.TypeOf(value)
+			for i := 0; i < typ.NumField(); i++ {
+				field := typ.Field(i)
+				tag, ok := field.Tag.Lookup("json")
+				Expect(ok).To(BeTrue(), "field %s.%s has no JSON name", typ.Name(), field.Name)
+				parts := strings.Split(tag, ",")
+				Expect(parts[0]).To(
+					MatchRegexp(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`),
+					"field %s.%s has a JSON name that isn't snake case",
+					typ.Name(), field.Name,
+				)
+				Expect(parts[1:]).To(
+					ContainElement("omitempty"),
+					"field %s.%s isn't omitted when empty",
+					typ.Name(), field.Name,
+				)
+			}
+		}
+	})
+
+	It("Keeps the names of the fields of the test", func() {
+		user := 1000
+		test := Test{
+			Key:              "mykey",
+			Binary:           []byte("mybinary"),
+			BinaryURL:        "https://example.com/mybinary",
+			UploadID:         "myupload",
+			Checksum:         "mychecksum",
+			Args:             []string{"-test.v"},
+			Env:              map[string]string{"MYVAR": "myvalue"},
+			EnvFile:          []byte("MYVAR=myvalue"),
+			RunAsUser:        &user,
+			Fixtures:         []string{"myfixture"},
+			Timeout:          "10m",
+			Deadline:         true,
+			JSONEvents:       true,
+			Package:          "mypackage",
+			OutputGlobs:      []string{"*.out"},
+			Out:              []byte("myout"),
+			Err:              []byte("myerr"),
+			Events:           []byte("{}"),
+			Code:             1,
+			TimedOut:         true,
+			Signal:           "SIGKILL",
+			Truncated:        true,
+			Artifacts:        map[string][]byte{"my.out": []byte("mydata")},
+			OmittedArtifacts: []string{"other.out"},
+			Dir:              "/mydir",
+		}
+		data, err := json.Marshal(test)
+		Expect(err).ToNot(HaveOccurred())
+		var fields map[string]interface{}
+		err = json.Unmarshal(data, &fields)
+		Expect(err).ToNot(HaveOccurred())
+		var names []string
+		for name := range fields {
+			names = append(names, name)
+		}
+		Expect(names).To(ConsistOf(
+			"key",
+			"binary",
+			"binary_url",
+			"upload_id",
+			"checksum",
+			"args",
+			"env",
+			"env_file",
+			"run_as_user",
+			"fixtures",
+			"timeout",
+			"deadline",
+			"json_events",
+			"package",
+			"output_globs",
+			"out",
+			"err",
+			"events",
+			"code",
+			"timed_out",
+			"signal",
+			"truncated",
+			"artifacts",
+			"omitted_artifacts",
+			"dir",
+		))
+
+		// Check that nothing is lost when reading it back:
+		var result Test
+		err = json.Unmarshal(data, &result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result).To(Equal(test))
+	})
+
+	It("Keeps the names of the fields of the error", func() {
+		data, err := json.Marshal(Error{
+			Reason: "myreason",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(MatchJSON(`{"reason": "myreason"}`))
+		var result Error
+		err = json.Unmarshal(data, &result)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Reason).To(Equal("myreason"))
+	})
+
+	It("Omits empty fields", func() {
+		data, err := json.Marshal(Test{})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(MatchJSON(`{}`))
+	})
+})
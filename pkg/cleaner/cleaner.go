@@ -22,33 +22,51 @@ package cleaner
 import (
 	"fmt"
 	"io/ioutil"
+	"sync"
 	"time"
 
 	projectv1client "github.com/openshift/client-go/project/clientset/versioned/typed/project/v1"
 	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
 	"k8s.io/utils/pointer"
+
+	"github.com/jhernand/sandbox/pkg/internal"
 )
 
 // CleanerBuilder contains the information and logic needed to create the cleaner. Don't create
 // instances of this type directly; use the NewCleaner function instead.
 type CleanerBuilder struct {
-	wait time.Duration
+	wait          time.Duration
+	retries       int
+	metricsListen string
 }
 
 // Cleaner is the implementation of the cleaner.
 type Cleaner struct {
-	wait    time.Duration
-	api     *projectv1client.ProjectV1Client
-	project string
-	stop    chan bool
-	clean   *time.Timer
+	wait     time.Duration
+	retries  int
+	api      projectv1client.ProjectsGetter
+	coreV1   corev1client.ConfigMapsGetter
+	project  string
+	deadline time.Time
+	stop     chan bool
+	stopOnce sync.Once
+	clean    *time.Timer
+	done     chan struct{}
+	err      error
+	metrics  *metrics
+	listen   string
 }
 
 // NewCleaner creates a new object that knows how to delete the OpenShift project.
 func NewCleaner() *CleanerBuilder {
-	return &CleanerBuilder{}
+	return &CleanerBuilder{
+		retries: defaultRetries,
+	}
 }
 
 // Wait sets the time that the cleaner should wait before deleting the OpenShift project.
@@ -57,6 +75,24 @@ func (b *CleanerBuilder) Wait(value time.Duration) *CleanerBuilder {
 	return b
 }
 
+// Retries sets the number of times that the cleaner will retry the deletion of the project when
+// it fails, for example because the API server isn't available during an upgrade of the cluster.
+// The time between retries starts with one second and doubles after each retry, up to one
+// minute. The default is five.
+func (b *CleanerBuilder) Retries(value int) *CleanerBuilder {
+	b.retries = value
+	return b
+}
+
+// MetricsListen sets the address and port where the cleaner will expose its metrics, in the
+// '/metrics' path and using the Prometheus text format. The metrics are the number of projects
+// deleted, the number of projects that couldn't be deleted, and the time remaining till the
+// project will be deleted. If not specified the metrics aren't exposed.
+func (b *CleanerBuilder) MetricsListen(value string) *CleanerBuilder {
+	b.metricsListen = value
+	return b
+}
+
 // Build uses the information stored in the builder to create a new cleaner. Note that this will
 // create the cleaner but will not start it. To start it use the Start method.
 func (b *CleanerBuilder) Build() (c *Cleaner, err error) {
@@ -65,6 +101,10 @@ func (b *CleanerBuilder) Build() (c *Cleaner, err error) {
 		err = fmt.Errorf("wait time can't be zero")
 		return
 	}
+	if b.retries < 0 {
+		err = fmt.Errorf("number of retries can't be negative, but it is %d", b.retries)
+		return
+	}
 
 	// Get the name of the project from the file where the cluster writes it:
 	data, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
@@ -79,66 +119,239 @@ func (b *CleanerBuilder) Build() (c *Cleaner, err error) {
 		return
 	}
 
-	// Create the client for the projects API:
+	// Create the clients for the projects and core APIs:
 	api, err := projectv1client.NewForConfig(config)
 	if err != nil {
 		return
 	}
+	coreV1, err := corev1client.NewForConfig(config)
+	if err != nil {
+		return
+	}
 
 	// Create and populate the object:
 	c = &Cleaner{
 		wait:    b.wait,
+		retries: b.retries,
 		api:     api,
+		coreV1:  coreV1,
 		project: project,
+		stop:    make(chan bool),
+		done:    make(chan struct{}),
+		listen:  b.metricsListen,
 	}
+	c.metrics = newMetrics(c.Deadline)
 
 	return
 }
 
 // Start starts the cleaner. This will wait the time given in the configuration and then will
-// delete the project.
+// delete the project. The deletion time is saved in a config map inside the project, so that if
+// the cleaner is restarted it will only wait the remaining time, or delete the project
+// immediately if that time has already passed.
 func (c *Cleaner) Start() error {
-	// Create stop channel:
-	c.stop = make(chan bool)
+	// Calculate the time when the project will be deleted. If the cleaner was already started
+	// before, for example if the pod was restarted, the deadline will have been saved, and we
+	// need to use it instead of waiting again the complete time:
+	deadline, err := c.loadDeadline()
+	if err != nil {
+		return err
+	}
+	if deadline != nil {
+		c.deadline = *deadline
+		log.Infof(
+			"Project '%s' will be deleted at %s as saved by a previous run",
+			c.project, c.deadline.Format(time.RFC3339),
+		)
+	} else {
+		c.deadline = time.Now().Add(c.wait)
+		err = c.saveDeadline()
+		if err != nil {
+			return err
+		}
+		log.Infof(
+			"Project '%s' will be deleted at %s",
+			c.project, c.deadline.Format(time.RFC3339),
+		)
+	}
+
+	// Start the metrics server, if needed:
+	if c.listen != "" {
+		c.metrics.start(c.listen)
+	}
 
-	// Create the clean timer:
-	c.clean = time.NewTimer(c.wait)
+	// Create the clean timer. Note that if the deadline has already passed the timer will
+	// fire immediately:
+	c.clean = time.NewTimer(time.Until(c.deadline))
 
 	// Wait for the signals to stop or clean:
 	go func() {
+		defer close(c.done)
 		select {
 		case <-c.stop:
 			c.clean.Stop()
 		case <-c.clean.C:
-			c.do()
+			c.err = c.do()
 		}
 	}()
 
 	return nil
 }
 
+// Deadline returns the time when the cleaner will delete the project. This is only available
+// after the cleaner has been started.
+func (c *Cleaner) Deadline() time.Time {
+	return c.deadline
+}
+
+// Done returns a channel that is closed when the cleaner finishes its work, either because it
+// tried to delete the project or because it was stopped before. This is intended for running the
+// cleaner as a process that exits when the project has been deleted, instead of waiting to be
+// killed. Use the Err method to check if the deletion failed.
+func (c *Cleaner) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the error that prevented the deletion of the project, or nil if the project was
+// deleted or the cleaner was stopped before trying. It should only be called after the channel
+// returned by the Done method has been closed.
+func (c *Cleaner) Err() error {
+	return c.err
+}
+
+// loadDeadline loads the time when the project will be deleted from the config map where it was
+// saved by a previous run of the cleaner. It returns nil if the config map doesn't exist, or if
+// it doesn't contain a valid time.
+func (c *Cleaner) loadDeadline() (result *time.Time, err error) {
+	configMap, err := c.coreV1.ConfigMaps(c.project).Get(
+		internal.CleanerConfigMap,
+		metav1.GetOptions{},
+	)
+	if errors.IsNotFound(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	value, ok := configMap.Data[internal.CleanerDeadlineKey]
+	if !ok {
+		return
+	}
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Warnf("Can't parse saved deletion time '%s', will ignore it: %v", value, err)
+		err = nil
+		return
+	}
+	result = &deadline
+	return
+}
+
+// saveDeadline creates or updates the config map that contains the time when the project will be
+// deleted. This is used to resume the wait if the cleaner is restarted, and also by the status
+// command to display the remaining time.
+func (c *Cleaner) saveDeadline() error {
+	data := map[string]string{
+		internal.CleanerDeadlineKey: c.deadline.UTC().Format(time.RFC3339),
+	}
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: internal.CleanerConfigMap,
+			Labels: map[string]string{
+				internal.AppLabel: internal.CleanerApp,
+			},
+		},
+		Data: data,
+	}
+	_, err := c.coreV1.ConfigMaps(c.project).Create(configMap)
+	if errors.IsAlreadyExists(err) {
+		configMap, err = c.coreV1.ConfigMaps(c.project).Get(
+			internal.CleanerConfigMap,
+			metav1.GetOptions{},
+		)
+		if err != nil {
+			return err
+		}
+		configMap.Data = data
+		_, err = c.coreV1.ConfigMaps(c.project).Update(configMap)
+	}
+	return err
+}
+
 // Stop stops the the cleaner. This will cancel the deletion of the project, if it didn't
-// happen already.
+// happen already. It is safe to call this method multiple times.
 func (c *Cleaner) Stop() error {
-	c.stop <- true
-	return nil
+	var err error
+	c.stopOnce.Do(func() {
+		close(c.stop)
+		err = c.metrics.halt()
+	})
+	return err
 }
 
 // Destroy releases all the resources used by the cleaner.
 func (c *Cleaner) Destroy() error {
-	close(c.stop)
-	return nil
+	return c.Stop()
 }
 
-func (c *Cleaner) do() {
+// do deletes the project, retrying if it fails. If the project doesn't exist it is considered
+// already deleted. It returns an error if the project couldn't be deleted after all the retries.
+func (c *Cleaner) do() error {
 	log.Infof("Deleting project '%s'", c.project)
 	options := &metav1.DeleteOptions{
 		GracePeriodSeconds: pointer.Int64Ptr(1),
 	}
-	err := c.api.Projects().Delete(c.project, options)
-	if err != nil {
-		log.Errorf("Can't delete project '%s'", c.project)
-		return
+	delay := minRetryDelay
+	for attempt := 1; ; attempt++ {
+		err := c.api.Projects().Delete(c.project, options)
+		if errors.IsNotFound(err) {
+			log.Infof("Project '%s' doesn't exist, it was probably already deleted", c.project)
+			c.metrics.addDeleted()
+			return nil
+		}
+		if err == nil {
+			log.Infof("Project '%s' has been deleted", c.project)
+			c.metrics.addDeleted()
+			return nil
+		}
+		if attempt > c.retries {
+			c.metrics.addFailed()
+			log.Errorf(
+				"Can't delete project '%s' after %d attempts, it needs to be deleted "+
+					"manually: %v",
+				c.project, attempt, err,
+			)
+			return fmt.Errorf(
+				"can't delete project '%s' after %d attempts: %v",
+				c.project, attempt, err,
+			)
+		}
+		log.Warnf(
+			"Can't delete project '%s', will try again in %s: %v",
+			c.project, delay, err,
+		)
+
+		// Wait before trying again, unless the cleaner is stopped:
+		select {
+		case <-c.stop:
+			log.Infof("Cleaner was stopped, will not try again to delete project '%s'", c.project)
+			return nil
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
 	}
-	log.Infof("Project '%s' has been deleted", c.project)
 }
+
+// Default number of times that the deletion of the project is retried:
+const defaultRetries = 5
+
+// Minimum and maximum time to wait between retries of the deletion of the project. These are
+// variables so that tests can change them.
+var (
+	minRetryDelay = time.Second
+	maxRetryDelay = time.Minute
+)
@@ -0,0 +1,268 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cleaner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	projectv1 "github.com/openshift/api/project/v1"
+	projectfake "github.com/openshift/client-go/project/clientset/versioned/fake"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+var _ = Describe("Cleaner", func() {
+	var minDelay time.Duration
+	var maxDelay time.Duration
+	var hook *logtest.Hook
+	var projects *projectfake.Clientset
+	var core *fake.Clientset
+	var cleaner *Cleaner
+
+	BeforeEach(func() {
+		minDelay = minRetryDelay
+		maxDelay = maxRetryDelay
+		minRetryDelay = time.Millisecond
+		maxRetryDelay = 4 * time.Millisecond
+		hook = logtest.NewGlobal()
+		projects = projectfake.NewSimpleClientset(&projectv1.Project{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "myproject",
+			},
+		})
+		core = fake.NewSimpleClientset()
+		cleaner = &Cleaner{
+			wait:    time.Hour,
+			retries: 5,
+			api:     projects.ProjectV1(),
+			coreV1:  core.CoreV1(),
+			project: "myproject",
+			stop:    make(chan bool),
+			done:    make(chan struct{}),
+		}
+		cleaner.metrics = newMetrics(cleaner.Deadline)
+	})
+
+	AfterEach(func() {
+		err := cleaner.Stop()
+		Expect(err).ToNot(HaveOccurred())
+		minRetryDelay = minDelay
+		maxRetryDelay = maxDelay
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	})
+
+	// failDeletes makes the given number of deletions of the project fail. A negative number
+	// makes all of them fail.
+	failDeletes := func(count int) {
+		failures := 0
+		projects.PrependReactor(
+			"delete", "projects",
+			func(action clienttesting.Action) (bool, runtime.Object, error) {
+				if count >= 0 && failures >= count {
+					return false, nil, nil
+				}
+				failures++
+				return true, nil, fmt.Errorf("injected failure")
+			},
+		)
+	}
+
+	// deletes returns the number of times that the deletion of the project was requested.
+	deletes := func() int {
+		count := 0
+		for _, action := range projects.Actions() {
+			if action.GetVerb() == "delete" {
+				count++
+			}
+		}
+		return count
+	}
+
+	// exists checks if the project still exists.
+	exists := func() bool {
+		_, err := projects.ProjectV1().Projects().Get("myproject", metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false
+		}
+		Expect(err).ToNot(HaveOccurred())
+		return true
+	}
+
+	// saveDeadline creates the config map that contains the given deadline, as a previous run
+	// of the cleaner would do.
+	saveDeadline := func(value string) {
+		_, err := core.CoreV1().ConfigMaps("myproject").Create(&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: internal.CleanerConfigMap,
+			},
+			Data: map[string]string{
+				internal.CleanerDeadlineKey: value,
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	// savedDeadline returns the deadline saved in the config map.
+	savedDeadline := func() string {
+		configMap, err := core.CoreV1().ConfigMaps("myproject").Get(
+			internal.CleanerConfigMap,
+			metav1.GetOptions{},
+		)
+		Expect(err).ToNot(HaveOccurred())
+		return configMap.Data[internal.CleanerDeadlineKey]
+	}
+
+	Describe("Deletion", func() {
+		It("Deletes the project", func() {
+			err := cleaner.do()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exists()).To(BeFalse())
+			Expect(deletes()).To(Equal(1))
+		})
+
+		It("Considers a project that doesn't exist already deleted", func() {
+			projects = projectfake.NewSimpleClientset()
+			cleaner.api = projects.ProjectV1()
+			err := cleaner.do()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deletes()).To(Equal(1))
+			Expect(cleaner.metrics.deleted).To(BeEquivalentTo(1))
+		})
+
+		It("Retries with a delay that doubles up to the maximum", func() {
+			failDeletes(5)
+			err := cleaner.do()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exists()).To(BeFalse())
+			Expect(deletes()).To(Equal(6))
+			var delays []string
+			for _, entry := range hook.AllEntries() {
+				if entry.Level != log.WarnLevel {
+					continue
+				}
+				message := entry.Message
+				start := strings.Index(message, "will try again in ")
+				Expect(start).ToNot(BeNumerically("<", 0))
+				message = message[start+len("will try again in "):]
+				delays = append(delays, message[:strings.Index(message, ":")])
+			}
+			Expect(delays).To(Equal([]string{"1ms", "2ms", "4ms", "4ms", "4ms"}))
+		})
+
+		It("Gives up after the configured number of retries", func() {
+			failDeletes(-1)
+			cleaner.retries = 2
+			err := cleaner.do()
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("after 3 attempts"))
+			Expect(err.Error()).To(ContainSubstring("injected failure"))
+			Expect(deletes()).To(Equal(3))
+			Expect(exists()).To(BeTrue())
+			Expect(cleaner.metrics.failed).To(BeEquivalentTo(1))
+		})
+
+		It("Doesn't retry without retries", func() {
+			failDeletes(-1)
+			cleaner.retries = 0
+			err := cleaner.do()
+			Expect(err).To(HaveOccurred())
+			Expect(deletes()).To(Equal(1))
+		})
+
+		It("Stops retrying when stopped", func() {
+			failDeletes(-1)
+			minRetryDelay = time.Hour
+			maxRetryDelay = time.Hour
+			result := make(chan error, 1)
+			go func() {
+				result <- cleaner.do()
+			}()
+			Eventually(deletes).Should(Equal(1))
+			err := cleaner.Stop()
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(result).Should(Receive(BeNil()))
+			Expect(deletes()).To(Equal(1))
+		})
+	})
+
+	Describe("Deadline", func() {
+		It("Saves the deadline when started", func() {
+			before := time.Now()
+			err := cleaner.Start()
+			Expect(err).ToNot(HaveOccurred())
+			deadline := cleaner.Deadline()
+			Expect(deadline).To(BeTemporally("~", before.Add(time.Hour), time.Minute))
+			Expect(savedDeadline()).To(Equal(deadline.UTC().Format(time.RFC3339)))
+			Consistently(cleaner.Done(), 100*time.Millisecond).ShouldNot(BeClosed())
+			Expect(exists()).To(BeTrue())
+		})
+
+		It("Resumes from the deadline saved by a previous run", func() {
+			saved := time.Now().Add(10 * time.Minute).UTC().Truncate(time.Second)
+			saveDeadline(saved.Format(time.RFC3339))
+			err := cleaner.Start()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(cleaner.Deadline().Equal(saved)).To(BeTrue())
+			Expect(savedDeadline()).To(Equal(saved.Format(time.RFC3339)))
+			Consistently(cleaner.Done(), 100*time.Millisecond).ShouldNot(BeClosed())
+			Expect(exists()).To(BeTrue())
+		})
+
+		It("Deletes the project immediately if the saved deadline has passed", func() {
+			saved := time.Now().Add(-time.Minute).UTC()
+			saveDeadline(saved.Format(time.RFC3339))
+			err := cleaner.Start()
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(cleaner.Done()).Should(BeClosed())
+			Expect(cleaner.Err()).ToNot(HaveOccurred())
+			Expect(exists()).To(BeFalse())
+		})
+
+		It("Ignores a saved deadline that isn't valid", func() {
+			saveDeadline("junk")
+			before := time.Now()
+			err := cleaner.Start()
+			Expect(err).ToNot(HaveOccurred())
+			deadline := cleaner.Deadline()
+			Expect(deadline).To(BeTemporally("~", before.Add(time.Hour), time.Minute))
+			Expect(savedDeadline()).To(Equal(deadline.UTC().Format(time.RFC3339)))
+		})
+
+		It("Doesn't delete the project when stopped before the deadline", func() {
+			err := cleaner.Start()
+			Expect(err).ToNot(HaveOccurred())
+			err = cleaner.Stop()
+			Expect(err).ToNot(HaveOccurred())
+			Eventually(cleaner.Done()).Should(BeClosed())
+			Expect(cleaner.Err()).ToNot(HaveOccurred())
+			Expect(deletes()).To(BeZero())
+		})
+	})
+})
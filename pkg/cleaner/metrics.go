@@ -0,0 +1,119 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the metrics of the cleaner, and the minimal HTTP server that exposes them.
+
+package cleaner
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// metrics contains the counters of the cleaner. They are exposed using the Prometheus text format,
+// written directly in order to avoid adding the Prometheus client library for just three values.
+type metrics struct {
+	deleted  int64
+	failed   int64
+	deadline func() time.Time
+	server   *http.Server
+}
+
+// newMetrics creates the metrics. The deadline function is used to calculate the time that
+// remains till the project will be deleted.
+func newMetrics(deadline func() time.Time) *metrics {
+	return &metrics{
+		deadline: deadline,
+	}
+}
+
+// addDeleted increments the number of projects deleted successfully.
+func (m *metrics) addDeleted() {
+	atomic.AddInt64(&m.deleted, 1)
+}
+
+// addFailed increments the number of projects that couldn't be deleted, even after retrying.
+func (m *metrics) addFailed() {
+	atomic.AddInt64(&m.failed, 1)
+}
+
+// start starts the HTTP server that exposes the metrics in the '/metrics' path of the given
+// address.
+func (m *metrics) start(listen string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", m)
+	m.server = &http.Server{
+		Addr:    listen,
+		Handler: mux,
+	}
+	go func() {
+		err := m.server.ListenAndServe()
+		if err != nil && err != http.ErrServerClosed {
+			log.Errorf("Metrics server finished with error: %v", err)
+		}
+	}()
+	log.Infof("Metrics are available in address '%s'", listen)
+}
+
+// halt stops the HTTP server that exposes the metrics.
+func (m *metrics) halt() error {
+	if m.server == nil {
+		return nil
+	}
+	return m.server.Shutdown(context.Background())
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	remaining := time.Until(m.deadline()).Seconds()
+	if remaining < 0 {
+		remaining = 0
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintf(
+		w,
+		"# HELP %[1]s Number of projects deleted by the cleaner.\n"+
+			"# TYPE %[1]s counter\n"+
+			"%[1]s %[2]d\n",
+		deletedMetric, atomic.LoadInt64(&m.deleted),
+	)
+	fmt.Fprintf(
+		w,
+		"# HELP %[1]s Number of projects that the cleaner failed to delete.\n"+
+			"# TYPE %[1]s counter\n"+
+			"%[1]s %[2]d\n",
+		failedMetric, atomic.LoadInt64(&m.failed),
+	)
+	fmt.Fprintf(
+		w,
+		"# HELP %[1]s Seconds remaining till the cleaner deletes the project.\n"+
+			"# TYPE %[1]s gauge\n"+
+			"%[1]s %[2]g\n",
+		remainingMetric, remaining,
+	)
+}
+
+// Names of the metrics:
+const (
+	deletedMetric   = "sandbox_cleaner_deletions_total"
+	failedMetric    = "sandbox_cleaner_deletion_failures_total"
+	remainingMetric = "sandbox_cleaner_seconds_until_deletion"
+)
@@ -0,0 +1,168 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the sweeper that removes old projects created by the
+// runner, in particular the ones that were preserved with the keep option and that no cleaner
+// will ever remove.
+
+package cleaner
+
+import (
+	"fmt"
+	"time"
+
+	projectv1client "github.com/openshift/client-go/project/clientset/versioned/typed/project/v1"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/utils/pointer"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+// SweeperBuilder contains the information and logic needed to create the sweeper. Don't create
+// instances of this type directly; use the NewSweeper function instead.
+type SweeperBuilder struct {
+	config    string
+	olderThan time.Duration
+	dryRun    bool
+}
+
+// Sweeper is the implementation of the sweeper.
+type Sweeper struct {
+	olderThan time.Duration
+	dryRun    bool
+	api       projectv1client.ProjectsGetter
+}
+
+// NewSweeper creates a new object that knows how to build sweepers.
+func NewSweeper() *SweeperBuilder {
+	return &SweeperBuilder{}
+}
+
+// Config sets the configuration file that will be used to connect to the OpenShift API. If not set
+// it will use the configuration provided by the cluster to the pod.
+func (b *SweeperBuilder) Config(value string) *SweeperBuilder {
+	b.config = value
+	return b
+}
+
+// OlderThan sets the age of the projects that will be deleted. This is mandatory.
+func (b *SweeperBuilder) OlderThan(value time.Duration) *SweeperBuilder {
+	b.olderThan = value
+	return b
+}
+
+// DryRun indicates if the sweeper should only list the projects that it would delete, without
+// actually deleting them. The default is false.
+func (b *SweeperBuilder) DryRun(value bool) *SweeperBuilder {
+	b.dryRun = value
+	return b
+}
+
+// Build uses the information stored in the builder to create a new sweeper.
+func (b *SweeperBuilder) Build() (s *Sweeper, err error) {
+	// Check parameters:
+	if b.olderThan <= 0 {
+		err = fmt.Errorf("age of projects must be positive")
+		return
+	}
+
+	// Load the configuration either from the given configuration file or from the default
+	// location used when running inside a cluster:
+	config, err := clientcmd.BuildConfigFromFlags("", b.config)
+	if err != nil {
+		return
+	}
+
+	// Create the client for the projects API:
+	api, err := projectv1client.NewForConfig(config)
+	if err != nil {
+		return
+	}
+
+	// Create and populate the object:
+	s = &Sweeper{
+		olderThan: b.olderThan,
+		dryRun:    b.dryRun,
+		api:       api,
+	}
+
+	return
+}
+
+// Run deletes the projects created by the runner that are older than the configured age.
+func (s *Sweeper) Run() error {
+	// Find the projects created by the runner:
+	selector := fmt.Sprintf("%s=%s", internal.AppLabel, internal.ProjectApp)
+	list, err := s.api.Projects().List(metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Delete the ones that are too old:
+	limit := time.Now().Add(-s.olderThan)
+	options := &metav1.DeleteOptions{
+		GracePeriodSeconds: pointer.Int64Ptr(1),
+	}
+	failed := 0
+	for _, project := range list.Items {
+		created := project.CreationTimestamp.Time
+		value, ok := project.Annotations[internal.CreatedAtAnnotation]
+		if ok {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				log.Warnf(
+					"Can't parse creation time '%s' of project '%s', will use "+
+						"the creation timestamp instead: %v",
+					value, project.Name, err,
+				)
+			} else {
+				created = parsed
+			}
+		}
+		if created.After(limit) {
+			log.Debugf(
+				"Project '%s' was created at %s, will keep it",
+				project.Name, created.Format(time.RFC3339),
+			)
+			continue
+		}
+		if s.dryRun {
+			log.Infof(
+				"Project '%s' was created at %s, would delete it",
+				project.Name, created.Format(time.RFC3339),
+			)
+			continue
+		}
+		log.Infof(
+			"Project '%s' was created at %s, deleting it",
+			project.Name, created.Format(time.RFC3339),
+		)
+		err = s.api.Projects().Delete(project.Name, options)
+		if err != nil {
+			log.Errorf("Can't delete project '%s': %v", project.Name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d projects", failed)
+	}
+
+	return nil
+}
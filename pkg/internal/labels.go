@@ -14,9 +14,47 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// This file contains frequently used label names.
+// This file contains frequently used label and annotation names.
 
 package internal
 
 // Application label:
 const AppLabel = "app"
+
+// Value of the application label for the projects created by the runner:
+const ProjectApp = "sandbox"
+
+// Value of the application label for the cleaner pod:
+const CleanerApp = "cleaner"
+
+// Value of the application label for the server pod, also used as the name of the pod and of its
+// container:
+const ServerApp = "server"
+
+// Annotation that contains the time when the project was created, in RFC3339 format:
+const CreatedAtAnnotation = "sandbox.jhernand/created-at"
+
+// Annotation that contains the name of the user that created the project:
+const OwnerAnnotation = "sandbox.jhernand/owner"
+
+// Annotation that contains the cost center that the resources used by the project should be
+// charged to:
+const CostCenterAnnotation = "sandbox.jhernand/cost-center"
+
+// Annotations that contain the result of the last run of the tests in a project that is kept: the
+// status, the time when the run finished, in RFC3339 format, and the number of test binaries that
+// ran and that failed:
+const (
+	ResultAnnotation         = "sandbox.jhernand/result"
+	ResultAtAnnotation       = "sandbox.jhernand/result-at"
+	ResultBinariesAnnotation = "sandbox.jhernand/result-binaries"
+	ResultFailedAnnotation   = "sandbox.jhernand/result-failed"
+)
+
+// Values of the result annotation. The error value means that the run was stopped by an error,
+// so the counts may be incomplete:
+const (
+	ResultPassed = "passed"
+	ResultFailed = "failed"
+	ResultError  = "error"
+)
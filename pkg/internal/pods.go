@@ -14,11 +14,14 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// This file contains functions that are useful when building descriptions of pods.
+// This file contains functions that are useful when building and inspecting descriptions of pods.
 
 package internal
 
 import (
+	"fmt"
+	"strings"
+
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -33,6 +36,19 @@ func SecretVolume(name, secret string) corev1.Volume {
 	}
 }
 
+func ConfigMapVolume(name, configMap string) corev1.Volume {
+	return corev1.Volume{
+		Name: name,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: configMap,
+				},
+			},
+		},
+	}
+}
+
 func EmptyDirVolume(name string) corev1.Volume {
 	return corev1.Volume{
 		Name: name,
@@ -55,3 +71,139 @@ func SecretEnvVar(name, secret, key string) corev1.EnvVar {
 		},
 	}
 }
+
+// CheckDNS checks that the given DNS policy and configuration can be used in a pod. An empty
+// policy means that the default of the cluster will be used. The 'None' policy requires a
+// configuration containing at least one name server.
+func CheckDNS(policy corev1.DNSPolicy, config *corev1.PodDNSConfig) error {
+	switch policy {
+	case "", corev1.DNSClusterFirst, corev1.DNSClusterFirstWithHostNet, corev1.DNSDefault:
+	case corev1.DNSNone:
+		if config == nil || len(config.Nameservers) == 0 {
+			return fmt.Errorf("DNS policy '%s' requires at least one name server", policy)
+		}
+	default:
+		return fmt.Errorf(
+			"DNS policy '%s' isn't valid, it should be '%s', '%s', '%s' or '%s'",
+			policy,
+			corev1.DNSClusterFirst, corev1.DNSClusterFirstWithHostNet,
+			corev1.DNSDefault, corev1.DNSNone,
+		)
+	}
+	if config != nil {
+		for _, option := range config.Options {
+			if option.Name == "" {
+				return fmt.Errorf("name of DNS option can't be empty")
+			}
+		}
+	}
+	return nil
+}
+
+// SetDNS sets the DNS policy and configuration of the given pod. An empty policy or a nil
+// configuration leave the pod unchanged, so that the defaults of the cluster are used.
+func SetDNS(pod *corev1.Pod, policy corev1.DNSPolicy, config *corev1.PodDNSConfig) {
+	if policy != "" {
+		pod.Spec.DNSPolicy = policy
+	}
+	if config != nil {
+		pod.Spec.DNSConfig = config.DeepCopy()
+	}
+}
+
+// CheckPullPolicy checks that the given image pull policy can be used in a pod.
+func CheckPullPolicy(policy corev1.PullPolicy) error {
+	switch policy {
+	case corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever:
+		return nil
+	default:
+		return fmt.Errorf(
+			"image pull policy '%s' isn't valid, it should be '%s', '%s' or '%s'",
+			policy, corev1.PullAlways, corev1.PullIfNotPresent, corev1.PullNever,
+		)
+	}
+}
+
+// ImagePullProblems returns a human readable description of the containers of the given pod,
+// including the init containers, that are waiting because their image can't be pulled. It returns
+// an empty string if there is no such container.
+func ImagePullProblems(pod *corev1.Pod) string {
+	var problems []string
+	add := func(kind string, statuses []corev1.ContainerStatus) {
+		for _, status := range statuses {
+			waiting := status.State.Waiting
+			if waiting == nil || !imagePullReasons[waiting.Reason] {
+				continue
+			}
+			problems = append(problems, fmt.Sprintf(
+				"%s '%s' can't pull image '%s', reason is '%s': %s",
+				kind, status.Name, status.Image, waiting.Reason, waiting.Message,
+			))
+		}
+	}
+	add("init container", pod.Status.InitContainerStatuses)
+	add("container", pod.Status.ContainerStatuses)
+	return strings.Join(problems, "; ")
+}
+
+// imagePullReasons contains the reasons that the kubelet puts in the waiting state of a container
+// when its image can't be pulled.
+var imagePullReasons = map[string]bool{
+	"ErrImagePull":      true,
+	"ImagePullBackOff":  true,
+	"ErrImageNeverPull": true,
+	"InvalidImageName":  true,
+}
+
+// PodProblems returns a human readable description of the problems of the containers of the given
+// pod, including the init containers, for example that a container is waiting because the image
+// can't be pulled, or that it terminated with an error. The description includes the reason and
+// message of the current state and of the last termination of each container. It returns an
+// empty string if no problem is found.
+func PodProblems(pod *corev1.Pod) string {
+	var problems []string
+	add := func(kind string, statuses []corev1.ContainerStatus) {
+		for _, status := range statuses {
+			problems = append(problems, containerProblems(kind, &status)...)
+		}
+	}
+	add("init container", pod.Status.InitContainerStatuses)
+	add("container", pod.Status.ContainerStatuses)
+	if len(problems) == 0 && pod.Status.Reason != "" {
+		problems = append(problems, fmt.Sprintf(
+			"pod is in phase '%s' with reason '%s': %s",
+			pod.Status.Phase, pod.Status.Reason, pod.Status.Message,
+		))
+	}
+	return strings.Join(problems, "; ")
+}
+
+// containerProblems returns the descriptions of the problems of a container.
+func containerProblems(kind string, status *corev1.ContainerStatus) []string {
+	var problems []string
+	waiting := status.State.Waiting
+	if waiting != nil && waiting.Reason != "" {
+		problems = append(problems, fmt.Sprintf(
+			"%s '%s' is waiting with reason '%s': %s",
+			kind, status.Name, waiting.Reason, waiting.Message,
+		))
+	}
+	terminated := status.State.Terminated
+	if terminated != nil && terminated.ExitCode != 0 {
+		problems = append(problems, fmt.Sprintf(
+			"%s '%s' terminated with exit code %d and reason '%s': %s",
+			kind, status.Name, terminated.ExitCode, terminated.Reason,
+			terminated.Message,
+		))
+	}
+	last := status.LastTerminationState.Terminated
+	if last != nil && last.ExitCode != 0 {
+		problems = append(problems, fmt.Sprintf(
+			"%s '%s' was restarted %d times, last termination with exit code %d "+
+				"and reason '%s': %s",
+			kind, status.Name, status.RestartCount, last.ExitCode, last.Reason,
+			last.Message,
+		))
+	}
+	return problems
+}
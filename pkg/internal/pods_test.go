@@ -0,0 +1,142 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("DNS settings", func() {
+	It("Accepts empty policy and configuration", func() {
+		err := CheckDNS("", nil)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Accepts the standard policies", func() {
+		for _, policy := range []corev1.DNSPolicy{
+			corev1.DNSClusterFirst,
+			corev1.DNSClusterFirstWithHostNet,
+			corev1.DNSDefault,
+		} {
+			err := CheckDNS(policy, nil)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	It("Rejects unknown policy", func() {
+		err := CheckDNS("Junk", nil)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Rejects 'None' policy without name servers", func() {
+		err := CheckDNS(corev1.DNSNone, &corev1.PodDNSConfig{
+			Searches: []string{"example.com"},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Accepts 'None' policy with name servers", func() {
+		err := CheckDNS(corev1.DNSNone, &corev1.PodDNSConfig{
+			Nameservers: []string{"10.0.0.10"},
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Rejects options without name", func() {
+		err := CheckDNS("", &corev1.PodDNSConfig{
+			Options: []corev1.PodDNSConfigOption{
+				{
+					Name: "",
+				},
+			},
+		})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Doesn't change the pod if nothing is set", func() {
+		pod := &corev1.Pod{}
+		SetDNS(pod, "", nil)
+		Expect(pod.Spec.DNSPolicy).To(BeEmpty())
+		Expect(pod.Spec.DNSConfig).To(BeNil())
+	})
+
+	It("Copies the configuration to the pod", func() {
+		config := &corev1.PodDNSConfig{
+			Searches: []string{"database.myproject.svc"},
+		}
+		pod := &corev1.Pod{}
+		SetDNS(pod, corev1.DNSClusterFirst, config)
+		Expect(pod.Spec.DNSPolicy).To(Equal(corev1.DNSClusterFirst))
+		Expect(pod.Spec.DNSConfig).To(Equal(config))
+		config.Searches[0] = "changed"
+		Expect(pod.Spec.DNSConfig.Searches[0]).To(Equal("database.myproject.svc"))
+	})
+})
+
+var _ = Describe("Image pull settings", func() {
+	It("Accepts the standard policies", func() {
+		for _, policy := range []corev1.PullPolicy{
+			corev1.PullAlways,
+			corev1.PullIfNotPresent,
+			corev1.PullNever,
+		} {
+			err := CheckPullPolicy(policy)
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	It("Rejects unknown policy", func() {
+		err := CheckPullPolicy("Junk")
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Describes containers that can't pull the image", func() {
+		pod := &corev1.Pod{
+			Status: corev1.PodStatus{
+				ContainerStatuses: []corev1.ContainerStatus{
+					{
+						Name:  "creating",
+						Image: "myimage",
+						State: corev1.ContainerState{
+							Waiting: &corev1.ContainerStateWaiting{
+								Reason: "ContainerCreating",
+							},
+						},
+					},
+					{
+						Name:  "failing",
+						Image: "myimage",
+						State: corev1.ContainerState{
+							Waiting: &corev1.ContainerStateWaiting{
+								Reason:  "ErrImagePull",
+								Message: "unauthorized",
+							},
+						},
+					},
+				},
+			},
+		}
+		problems := ImagePullProblems(pod)
+		Expect(problems).To(ContainSubstring("'failing'"))
+		Expect(problems).To(ContainSubstring("'myimage'"))
+		Expect(problems).To(ContainSubstring("unauthorized"))
+		Expect(problems).ToNot(ContainSubstring("'creating'"))
+	})
+})
@@ -21,6 +21,7 @@ package internal
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -29,71 +30,108 @@ import (
 	routev1 "github.com/openshift/api/route/v1"
 	routev1client "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
 	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
+	appsv1client "k8s.io/client-go/kubernetes/typed/apps/v1"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/utils/pointer"
 )
 
 // WaitForPod waits till the given pod is ready. It returns the description of the pod contained
 // in the event that indicated that it is ready, or an error if something fails while checking or
-// if the pod isn't ready after one minute.
-func WaitForPod(client *corev1client.CoreV1Client, project, name string) (pod *corev1.Pod,
+// if the pod isn't ready after one minute. In that case the error includes the problems of the
+// containers of the pod, if any.
+func WaitForPod(client corev1client.PodsGetter, project, name string) (pod *corev1.Pod,
 	err error) {
+	return WaitForPodWith(client, project, name, isPodReady)
+}
+
+// WaitForPodWith is like WaitForPod, but it uses the given predicate to decide if the pod is ready
+// instead of the ready condition of the pod. This is useful for pods whose containers report
+// that they are ready before they actually are, or when only some of the containers matter.
+func WaitForPodWith(client corev1client.PodsGetter, project, name string,
+	predicate func(pod *corev1.Pod) bool) (pod *corev1.Pod, err error) {
 	log.Debugf("Waiting for pod '%s' to be ready", name)
-	wtch, err := client.Pods(project).Watch(metav1.ListOptions{
-		TimeoutSeconds: pointer.Int64Ptr(60),
-	})
+	object, done, err := watchUntil(client.Pods(project).Watch, name, waitTimeout,
+		func(object runtime.Object) (bool, error) {
+			tmp, ok := object.(*corev1.Pod)
+			return ok && predicate(tmp), nil
+		},
+	)
+	last, _ := object.(*corev1.Pod)
 	if err != nil {
+		err = fmt.Errorf("can't wait for pod '%s' to be ready: %v", name, err)
 		return
 	}
-	channel := wtch.ResultChan()
-	for event := range channel {
-		log.Debugf("Received '%s' event for pod '%s'", event.Type, name)
-		switch event.Type {
-		case watch.Added, watch.Modified:
-			tmp, ok := event.Object.(*corev1.Pod)
+	if !done {
+		err = fmt.Errorf("pod '%s' isn't ready after %s", name, waitTimeout)
+		if last != nil {
+			problems := PodProblems(last)
+			if problems != "" {
+				err = fmt.Errorf("%v: %s", err, problems)
+			}
+		}
+		return
+	}
+	pod = last
+	return
+}
+
+// WaitForImages waits till the images of all the containers of the given pod have been pulled,
+// which is indicated by the containers having started. It returns the description of the pod
+// contained in the event that indicated that the images are available. It fails as soon as a
+// container is waiting because its image can't be pulled, with an error that describes the
+// problem, and also if something fails while checking or if the images aren't available before
+// the given timeout.
+func WaitForImages(client corev1client.PodsGetter, project, name string,
+	timeout time.Duration) (pod *corev1.Pod, err error) {
+	log.Debugf("Waiting for images of pod '%s' to be pulled", name)
+	object, done, err := watchUntil(client.Pods(project).Watch, name, timeout,
+		func(object runtime.Object) (bool, error) {
+			tmp, ok := object.(*corev1.Pod)
 			if !ok {
-				log.Errorf(
-					"Unknown type of object '%T' while waiting for pod '%s' "+
-						"to be ready, will ignore it",
-					event.Object, name,
-				)
-				continue
+				return false, nil
 			}
-			if isPodReady(tmp) {
-				log.Debugf("Pod '%s' is ready now", name)
-				wtch.Stop()
-				pod = tmp
-				break
+			problems := ImagePullProblems(tmp)
+			if problems != "" {
+				return false, errors.New(problems)
 			}
-		case watch.Deleted:
-			wtch.Stop()
-			err = fmt.Errorf(
-				"pod '%s' was deleted while waiting for it to be ready",
-				name,
-			)
-			return
-		case watch.Error:
-			wtch.Stop()
-			err = fmt.Errorf(
-				"unpexected error while waiting for pod '%s' to be ready: %v",
-				name, event.Object,
-			)
-			return
-		default:
-			log.Errorf(
-				"Unknown type of event '%s' while waiting for pod '%s' to be "+
-					"ready, will ignore it",
-				event.Type, name,
-			)
-			continue
-		}
+			return areImagesPulled(tmp), nil
+		},
+	)
+	if err != nil {
+		err = fmt.Errorf("can't wait for images of pod '%s' to be pulled: %v", name, err)
+		return
 	}
+	if !done {
+		err = fmt.Errorf("images of pod '%s' aren't pulled after %s", name, timeout)
+		return
+	}
+	pod, _ = object.(*corev1.Pod)
 	return
 }
 
+// areImagesPulled checks if the images of all the containers of the given pod have been pulled.
+// The kubelet doesn't report that directly, so this checks that all the containers are running
+// or have already terminated, as that only happens after pulling the image.
+func areImagesPulled(pod *corev1.Pod) bool {
+	if len(pod.Status.ContainerStatuses) < len(pod.Spec.Containers) {
+		return false
+	}
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Running == nil && status.State.Terminated == nil {
+			return false
+		}
+	}
+	return true
+}
+
 // isPodReady checks if the given pod is ready.
 func isPodReady(pod *corev1.Pod) bool {
 	for _, condition := range pod.Status.Conditions {
@@ -106,60 +144,126 @@ func isPodReady(pod *corev1.Pod) bool {
 	return false
 }
 
+// IsContainerReady returns a predicate, to use with WaitForPodWith, that checks if the container
+// of the pod with the given name is ready, ignoring the rest of the containers.
+func IsContainerReady(name string) func(pod *corev1.Pod) bool {
+	return func(pod *corev1.Pod) bool {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == name {
+				return status.Ready
+			}
+		}
+		return false
+	}
+}
+
+// WaitForJob waits till the given job finishes. The job is considered successful when at least one
+// of its pods succeeds, and failed when it has failed pods and the job controller has given up
+// retrying them. It returns the description of the job contained in the event that indicated
+// that it finished. If the job failed it also returns the description of the job, together with
+// an error. It also returns an error if something fails while checking or if the job doesn't
+// finish before the given timeout.
+func WaitForJob(client batchv1client.JobsGetter, project, name string,
+	timeout time.Duration) (job *batchv1.Job, err error) {
+	log.Debugf("Waiting for job '%s' to finish", name)
+	object, done, err := watchUntil(client.Jobs(project).Watch, name, timeout,
+		func(object runtime.Object) (bool, error) {
+			tmp, ok := object.(*batchv1.Job)
+			return ok && (tmp.Status.Succeeded >= 1 || isJobFailed(tmp)), nil
+		},
+	)
+	if err != nil {
+		err = fmt.Errorf("can't wait for job '%s' to finish: %v", name, err)
+		return
+	}
+	if !done {
+		err = fmt.Errorf("job '%s' didn't finish after %s", name, timeout)
+		return
+	}
+	job, _ = object.(*batchv1.Job)
+	if isJobFailed(job) {
+		err = fmt.Errorf("job '%s' failed", name)
+	}
+	return
+}
+
+// isJobFailed checks if the given job failed. That happens when the job controller adds the failed
+// condition, or when the number of failed pods exceeds the backoff limit.
+func isJobFailed(job *batchv1.Job) bool {
+	for _, condition := range job.Status.Conditions {
+		failed := condition.Type == batchv1.JobFailed
+		status := condition.Status == corev1.ConditionTrue
+		if failed && status {
+			return true
+		}
+	}
+	limit := int32(6)
+	if job.Spec.BackoffLimit != nil {
+		limit = *job.Spec.BackoffLimit
+	}
+	return job.Status.Failed > limit
+}
+
+// WaitForDeployment waits till all the replicas of the given deployment are available. It returns
+// the description of the deployment contained in the event that indicated that it is available,
+// or an error if something fails while checking or if the deployment isn't available after one
+// minute.
+func WaitForDeployment(client appsv1client.DeploymentsGetter, project, name string) (
+	deployment *appsv1.Deployment, err error) {
+	log.Debugf("Waiting for deployment '%s' to be available", name)
+	object, done, err := watchUntil(client.Deployments(project).Watch, name, waitTimeout,
+		func(object runtime.Object) (bool, error) {
+			tmp, ok := object.(*appsv1.Deployment)
+			return ok && isDeploymentAvailable(tmp), nil
+		},
+	)
+	if err != nil {
+		err = fmt.Errorf("can't wait for deployment '%s' to be available: %v", name, err)
+		return
+	}
+	if !done {
+		err = fmt.Errorf("deployment '%s' isn't available after %s", name, waitTimeout)
+		return
+	}
+	deployment, _ = object.(*appsv1.Deployment)
+	return
+}
+
+// isDeploymentAvailable checks if all the replicas of the given deployment are available. Note
+// that the status is only meaningful when the deployment controller has already processed the
+// latest generation of the deployment.
+func isDeploymentAvailable(deployment *appsv1.Deployment) bool {
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return false
+	}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	return deployment.Status.AvailableReplicas == replicas
+}
+
 // WaitForRoute waits till the given route is admitted. It returns the description of the route
 // contained in the event that indicates that it was admitted, or an error if something fails while
 // checking or the route isn't ready after waiting more than one minute.
-func WaitForRoute(client *routev1client.RouteV1Client, project, name string) (route *routev1.Route, err error) {
+func WaitForRoute(client routev1client.RoutesGetter, project, name string) (route *routev1.Route,
+	err error) {
 	log.Debugf("Waiting for route '%s' to be admitted", name)
-	wtch, err := client.Routes(project).Watch(metav1.ListOptions{
-		TimeoutSeconds: pointer.Int64Ptr(60),
-	})
+	object, done, err := watchUntil(client.Routes(project).Watch, name, waitTimeout,
+		func(object runtime.Object) (bool, error) {
+			tmp, ok := object.(*routev1.Route)
+			return ok && isRouteAdmitted(tmp), nil
+		},
+	)
 	if err != nil {
+		err = fmt.Errorf("can't wait for route '%s' to be admitted: %v", name, err)
 		return
 	}
-	channel := wtch.ResultChan()
-	for event := range channel {
-		log.Debugf("Received '%s' event for route '%s'", event.Type, name)
-		switch event.Type {
-		case watch.Added, watch.Modified:
-			tmp, ok := event.Object.(*routev1.Route)
-			if !ok {
-				log.Errorf(
-					"Unknown type of object '%T' while waiting for route '%s' "+
-						"to be admitted, will ignore it",
-					event.Object, name,
-				)
-				continue
-			}
-			if isRouteAdmitted(tmp) {
-				log.Debugf("Route '%s' is admitted now", name)
-				wtch.Stop()
-				route = tmp
-				break
-			}
-		case watch.Deleted:
-			wtch.Stop()
-			err = fmt.Errorf(
-				"route '%s' was deleted while waiting for it to be admitted",
-				name,
-			)
-			return
-		case watch.Error:
-			wtch.Stop()
-			err = fmt.Errorf(
-				"unpexected error while waiting for route '%s' to be admitted: %v",
-				name, event.Object,
-			)
-			return
-		default:
-			log.Errorf(
-				"Unknown type of event '%s' while waiting for route '%s' to be "+
-					"admitted, will ignore it",
-				event.Type, name,
-			)
-			continue
-		}
+	if !done {
+		err = fmt.Errorf("route '%s' isn't admitted after %s", name, waitTimeout)
+		return
 	}
+	route, _ = object.(*routev1.Route)
 	return
 }
 
@@ -188,11 +292,138 @@ func isIngressAdmitted(ingress *routev1.RouteIngress) bool {
 	return false
 }
 
+// waitOptions returns the options used to watch the object with the given name, with the given
+// timeout.
+func waitOptions(name string, timeout time.Duration) metav1.ListOptions {
+	return metav1.ListOptions{
+		FieldSelector:  fmt.Sprintf("metadata.name=%s", name),
+		TimeoutSeconds: pointer.Int64Ptr(int64(timeout.Seconds())),
+	}
+}
+
+// watchUntil opens a watch for the object with the given name, using the given function, and reads
+// its events with waitForCondition till the predicate returns true or an error, or till the given
+// timeout expires. The API server may close the watch before that, specially when it is busy, so
+// when that happens the watch is opened again, starting from the version of the last object
+// received, so that no event is lost. This is repeated at most watchRetries times. It returns the
+// same values as waitForCondition.
+func watchUntil(open func(options metav1.ListOptions) (watch.Interface, error), name string,
+	timeout time.Duration, predicate func(object runtime.Object) (bool, error)) (
+	last runtime.Object, done bool, err error) {
+	deadline := time.Now().Add(timeout)
+	version := ""
+	for retry := 0; ; retry++ {
+		// Open the watch for the time that remains till the deadline, starting from the last
+		// version received, if any:
+		options := waitOptions(name, time.Until(deadline))
+		options.ResourceVersion = version
+		var wtch watch.Interface
+		wtch, err = open(options)
+		if err != nil {
+			return
+		}
+		var object runtime.Object
+		object, done, err = waitForCondition(wtch, predicate)
+		if object != nil {
+			last = object
+			version = resourceVersion(object)
+		}
+		if err == errWatchExpired {
+			// The version is too old, so start again from the current state:
+			log.Debugf("Version '%s' of '%s' is too old, will watch from scratch", version, name)
+			version = ""
+			err = nil
+		}
+		if done || err != nil {
+			return
+		}
+
+		// Stop if the remaining time is less than the minimum timeout of a watch, or if we
+		// already retried too many times:
+		remaining := time.Until(deadline)
+		if remaining < time.Second || retry >= watchRetries {
+			return
+		}
+
+		// Wait a bit before opening the watch again, so that we don't overload a server that is
+		// having problems:
+		log.Debugf("Watch for '%s' finished before the deadline, will open it again", name)
+		delay := watchRetryDelay
+		if delay > remaining {
+			delay = remaining
+		}
+		time.Sleep(delay)
+	}
+}
+
+// resourceVersion returns the resource version of the given object, or an empty string if it
+// doesn't have metadata.
+func resourceVersion(object runtime.Object) string {
+	accessor, err := meta.Accessor(object)
+	if err != nil {
+		return ""
+	}
+	return accessor.GetResourceVersion()
+}
+
+// waitForCondition reads the events of the given watch, passing the objects of the added and
+// modified events to the given predicate, till the predicate returns true or an error, or till the
+// watch finishes. It returns the last object received, a flag indicating if the predicate returned
+// true, and the error returned by the predicate or generated because the object was deleted or
+// the watch failed. If the watch failed because the version that it started from is too old the
+// error is errWatchExpired. If the watch finishes, usually because of the timeout, it returns
+// false and no error.
+func waitForCondition(wtch watch.Interface, predicate func(object runtime.Object) (bool, error)) (
+	last runtime.Object, done bool, err error) {
+	defer wtch.Stop()
+	for event := range wtch.ResultChan() {
+		log.Debugf("Received '%s' event", event.Type)
+		switch event.Type {
+		case watch.Added, watch.Modified:
+			last = event.Object
+			done, err = predicate(event.Object)
+			if done || err != nil {
+				return
+			}
+		case watch.Deleted:
+			err = fmt.Errorf("object was deleted")
+			return
+		case watch.Error:
+			status, ok := event.Object.(*metav1.Status)
+			if ok && status.Code == http.StatusGone {
+				err = errWatchExpired
+				return
+			}
+			err = fmt.Errorf("watch failed: %v", event.Object)
+			return
+		default:
+			log.Errorf("Unknown type of event '%s', will ignore it", event.Type)
+		}
+	}
+	return
+}
+
+// Default time to wait for objects:
+const waitTimeout = time.Minute
+
+// errWatchExpired is the error returned by waitForCondition when the watch fails because the
+// version that it started from is too old.
+var errWatchExpired = errors.New("watch expired")
+
+// Maximum number of times that a watch is opened again when the API server closes it before the
+// deadline, and time to wait before opening it again. These are variables so that tests can
+// change them.
+var (
+	watchRetries    = 10
+	watchRetryDelay = time.Second
+)
+
 // WaitForServer waits till the given backend server is responding with an status code different to
 // 503, as that indicates that it is the actual backend server and not the OpenShift router that is
-// responding.
-func WaitForServer(client *http.Client, address string) error {
-	for i := 0; i < 60; i++ {
+// responding. It returns an error if the server isn't responding after the given timeout.
+func WaitForServer(client *http.Client, address string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
 		result, err := isServerResponding(client, address)
 		if err != nil {
 			return err
@@ -200,11 +431,18 @@ func WaitForServer(client *http.Client, address string) error {
 		if result {
 			return nil
 		}
-		time.Sleep(1 * time.Second)
+		if !time.Now().Add(serverPollInterval).Before(deadline) {
+			break
+		}
+		time.Sleep(serverPollInterval)
 	}
-	return fmt.Errorf("backend '%s' isn't responding after one minute", address)
+	return fmt.Errorf("backend '%s' isn't responding after %s", address, timeout)
 }
 
+// Time to wait between checks of the backend server. This is a variable so that tests can change
+// it.
+var serverPollInterval = time.Second
+
 // isServerResponding checks if the given backend server is responding with an status code other
 // different to 503.
 func isServerResponding(client *http.Client, address string) (result bool, err error) {
@@ -244,7 +482,7 @@ func WaitForDB(source *url.URL) error {
 		}
 		time.Sleep(1 * time.Second)
 	}
-	return fmt.Errorf("database '%s' isn't responding after one minute", source.String())
+	return fmt.Errorf("database '%s' isn't responding after one minute", source.Host)
 }
 
 // isDBResponding checks if the given database server is responding.
@@ -0,0 +1,556 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package internal
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	routev1 "github.com/openshift/api/route/v1"
+	routefake "github.com/openshift/client-go/route/clientset/versioned/fake"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/utils/pointer"
+)
+
+var _ = Describe("Wait for condition", func() {
+	var watcher *watch.FakeWatcher
+
+	BeforeEach(func() {
+		watcher = watch.NewFakeWithChanSize(10, false)
+	})
+
+	// isReady is a predicate that checks if the given pod is ready.
+	isReady := func(object runtime.Object) (bool, error) {
+		return isPodReady(object.(*corev1.Pod)), nil
+	}
+
+	It("Returns when the predicate is true", func() {
+		first := makePod(corev1.ConditionFalse)
+		second := makePod(corev1.ConditionTrue)
+		watcher.Add(first)
+		watcher.Modify(second)
+		last, done, err := waitForCondition(watcher, isReady)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(BeTrue())
+		Expect(last).To(BeIdenticalTo(second))
+	})
+
+	It("Stops the watch when it returns", func() {
+		watcher.Add(makePod(corev1.ConditionTrue))
+		_, _, err := waitForCondition(watcher, isReady)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(watcher.IsStopped()).To(BeTrue())
+	})
+
+	It("Returns the last object when the watch finishes", func() {
+		first := makePod(corev1.ConditionFalse)
+		watcher.Add(first)
+		watcher.Stop()
+		last, done, err := waitForCondition(watcher, isReady)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(done).To(BeFalse())
+		Expect(last).To(BeIdenticalTo(first))
+	})
+
+	It("Returns the error of the predicate", func() {
+		watcher.Add(makePod(corev1.ConditionFalse))
+		_, done, err := waitForCondition(watcher, func(object runtime.Object) (bool, error) {
+			return false, fmt.Errorf("my error")
+		})
+		Expect(err).To(MatchError("my error"))
+		Expect(done).To(BeFalse())
+	})
+
+	It("Returns an error when the object is deleted", func() {
+		watcher.Add(makePod(corev1.ConditionFalse))
+		watcher.Delete(makePod(corev1.ConditionFalse))
+		_, done, err := waitForCondition(watcher, isReady)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("deleted"))
+		Expect(done).To(BeFalse())
+	})
+
+	It("Returns an error when the watch fails", func() {
+		watcher.Error(&metav1.Status{
+			Message: "my error",
+		})
+		_, done, err := waitForCondition(watcher, isReady)
+		Expect(err).To(HaveOccurred())
+		Expect(done).To(BeFalse())
+	})
+})
+
+var _ = Describe("Wait helpers", func() {
+	var client *fake.Clientset
+	var watcher *watch.FakeWatcher
+	var delay time.Duration
+
+	BeforeEach(func() {
+		// Create a fake client that returns a watch where we can put events in advance, and
+		// that we can stop to simulate the timeout:
+		client = fake.NewSimpleClientset()
+		watcher = watch.NewFakeWithChanSize(10, false)
+		client.PrependWatchReactor("*", k8stesting.DefaultWatchReactor(watcher, nil))
+
+		// Don't wait between the retries that happen when the watch is stopped:
+		delay = watchRetryDelay
+		watchRetryDelay = 0
+	})
+
+	AfterEach(func() {
+		watchRetryDelay = delay
+	})
+
+	Describe("Job", func() {
+		It("Returns the job when it succeeds", func() {
+			watcher.Add(makeJob(0, 0))
+			watcher.Modify(makeJob(1, 0))
+			job, err := WaitForJob(client.BatchV1(), "myproject", "myjob", waitTimeout)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(job).ToNot(BeNil())
+			Expect(job.Status.Succeeded).To(BeNumerically("==", 1))
+		})
+
+		It("Returns the job and an error when it fails", func() {
+			watcher.Add(makeJob(0, 0))
+			watcher.Modify(makeJob(0, 1))
+			job, err := WaitForJob(client.BatchV1(), "myproject", "myjob", waitTimeout)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed"))
+			Expect(job).ToNot(BeNil())
+			Expect(job.Status.Failed).To(BeNumerically("==", 1))
+		})
+
+		It("Returns an error when the job is deleted", func() {
+			watcher.Add(makeJob(0, 0))
+			watcher.Delete(makeJob(0, 0))
+			job, err := WaitForJob(client.BatchV1(), "myproject", "myjob", waitTimeout)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("deleted"))
+			Expect(job).To(BeNil())
+		})
+
+		It("Returns an error when the job doesn't finish", func() {
+			watcher.Add(makeJob(0, 0))
+			watcher.Stop()
+			job, err := WaitForJob(client.BatchV1(), "myproject", "myjob", waitTimeout)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("didn't finish"))
+			Expect(job).To(BeNil())
+		})
+	})
+
+	Describe("Deployment", func() {
+		It("Returns the deployment when all replicas are available", func() {
+			watcher.Add(makeDeployment(1, 1, 0))
+			watcher.Modify(makeDeployment(1, 1, 1))
+			watcher.Modify(makeDeployment(1, 1, 2))
+			deployment, err := WaitForDeployment(client.AppsV1(), "myproject", "mydeployment")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(deployment).ToNot(BeNil())
+			Expect(deployment.Status.AvailableReplicas).To(BeNumerically("==", 2))
+		})
+
+		It("Ignores the status of previous generations", func() {
+			watcher.Add(makeDeployment(2, 1, 2))
+			watcher.Stop()
+			deployment, err := WaitForDeployment(client.AppsV1(), "myproject", "mydeployment")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("isn't available"))
+			Expect(deployment).To(BeNil())
+		})
+
+		It("Returns an error when the watch fails", func() {
+			watcher.Error(&metav1.Status{
+				Message: "my error",
+			})
+			deployment, err := WaitForDeployment(client.AppsV1(), "myproject", "mydeployment")
+			Expect(err).To(HaveOccurred())
+			Expect(deployment).To(BeNil())
+		})
+	})
+
+	Describe("Pod", func() {
+		It("Returns the pod when it is ready", func() {
+			watcher.Add(makePod(corev1.ConditionFalse))
+			watcher.Modify(makePod(corev1.ConditionTrue))
+			pod, err := WaitForPod(client.CoreV1(), "myproject", "mypod")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pod).ToNot(BeNil())
+		})
+
+		It("Uses the custom predicate", func() {
+			watcher.Add(makePod(corev1.ConditionTrue))
+			ready := makePod(corev1.ConditionFalse)
+			ready.Status.ContainerStatuses = []corev1.ContainerStatus{
+				{
+					Name:  "other",
+					Ready: false,
+				},
+				{
+					Name:  "main",
+					Ready: true,
+				},
+			}
+			watcher.Modify(ready)
+			pod, err := WaitForPodWith(
+				client.CoreV1(), "myproject", "mypod",
+				IsContainerReady("main"),
+			)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pod).To(BeIdenticalTo(ready))
+		})
+
+		It("Returns an error when the pod isn't ready", func() {
+			watcher.Add(makePod(corev1.ConditionFalse))
+			watcher.Stop()
+			pod, err := WaitForPod(client.CoreV1(), "myproject", "mypod")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("isn't ready"))
+			Expect(pod).To(BeNil())
+		})
+
+		It("Returns an error when the watch closes without events", func() {
+			watcher.Stop()
+			pod, err := WaitForPod(client.CoreV1(), "myproject", "mypod")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("isn't ready"))
+			Expect(pod).To(BeNil())
+		})
+	})
+
+	Describe("Images", func() {
+		It("Returns the pod when the containers are running", func() {
+			watcher.Add(makePullingPod(nil))
+			watcher.Modify(makePullingPod(&corev1.ContainerState{
+				Running: &corev1.ContainerStateRunning{},
+			}))
+			pod, err := WaitForImages(client.CoreV1(), "myproject", "mypod", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pod).ToNot(BeNil())
+		})
+
+		It("Returns the pod when the containers have terminated", func() {
+			watcher.Add(makePullingPod(&corev1.ContainerState{
+				Terminated: &corev1.ContainerStateTerminated{},
+			}))
+			pod, err := WaitForImages(client.CoreV1(), "myproject", "mypod", time.Minute)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pod).ToNot(BeNil())
+		})
+
+		It("Fails as soon as the image can't be pulled", func() {
+			watcher.Add(makePullingPod(&corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{
+					Reason:  "ImagePullBackOff",
+					Message: "manifest unknown",
+				},
+			}))
+			pod, err := WaitForImages(client.CoreV1(), "myproject", "mypod", time.Minute)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("ImagePullBackOff"))
+			Expect(err.Error()).To(ContainSubstring("manifest unknown"))
+			Expect(pod).To(BeNil())
+		})
+
+		It("Returns an error when the images aren't pulled", func() {
+			watcher.Add(makePullingPod(nil))
+			watcher.Stop()
+			pod, err := WaitForImages(client.CoreV1(), "myproject", "mypod", time.Minute)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("aren't pulled"))
+			Expect(pod).To(BeNil())
+		})
+	})
+
+	Describe("Route", func() {
+		var routes *routefake.Clientset
+
+		BeforeEach(func() {
+			routes = routefake.NewSimpleClientset()
+			routes.PrependWatchReactor("*", k8stesting.DefaultWatchReactor(watcher, nil))
+		})
+
+		It("Returns the route when it is admitted", func() {
+			watcher.Add(makeRoute(corev1.ConditionFalse))
+			watcher.Modify(makeRoute(corev1.ConditionTrue))
+			route, err := WaitForRoute(routes.RouteV1(), "myproject", "myroute")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(route).ToNot(BeNil())
+		})
+
+		It("Returns an error when the route isn't admitted", func() {
+			watcher.Add(makeRoute(corev1.ConditionFalse))
+			watcher.Stop()
+			route, err := WaitForRoute(routes.RouteV1(), "myproject", "myroute")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("isn't admitted"))
+			Expect(route).To(BeNil())
+		})
+
+		It("Returns an error when the watch closes without events", func() {
+			watcher.Stop()
+			route, err := WaitForRoute(routes.RouteV1(), "myproject", "myroute")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("isn't admitted"))
+			Expect(route).To(BeNil())
+		})
+	})
+})
+
+var _ = Describe("Watch retries", func() {
+	var client *fake.Clientset
+	var watchers []*watch.FakeWatcher
+	var versions []string
+	var delay time.Duration
+
+	BeforeEach(func() {
+		// Create a fake client that returns the prepared watches in order, repeating the last
+		// one, and that remembers the resource versions requested:
+		client = fake.NewSimpleClientset()
+		watchers = nil
+		versions = nil
+		client.PrependWatchReactor("*", func(action k8stesting.Action) (bool, watch.Interface,
+			error) {
+			restrictions := action.(k8stesting.WatchAction).GetWatchRestrictions()
+			versions = append(versions, restrictions.ResourceVersion)
+			index := len(versions) - 1
+			if index >= len(watchers) {
+				index = len(watchers) - 1
+			}
+			return true, watchers[index], nil
+		})
+
+		// Don't wait between retries:
+		delay = watchRetryDelay
+		watchRetryDelay = 0
+	})
+
+	AfterEach(func() {
+		watchRetryDelay = delay
+	})
+
+	// addWatcher adds a watcher to the list of watchers returned by the client.
+	addWatcher := func() *watch.FakeWatcher {
+		watcher := watch.NewFakeWithChanSize(10, false)
+		watchers = append(watchers, watcher)
+		return watcher
+	}
+
+	// makeVersionedPod creates a pod with the given ready condition and resource version.
+	makeVersionedPod := func(ready corev1.ConditionStatus, version string) *corev1.Pod {
+		pod := makePod(ready)
+		pod.ResourceVersion = version
+		return pod
+	}
+
+	It("Opens the watch again when it finishes early", func() {
+		first := addWatcher()
+		first.Add(makeVersionedPod(corev1.ConditionFalse, "1"))
+		first.Stop()
+		second := addWatcher()
+		ready := makeVersionedPod(corev1.ConditionTrue, "2")
+		second.Modify(ready)
+		pod, err := WaitForPod(client.CoreV1(), "myproject", "mypod")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pod).To(BeIdenticalTo(ready))
+		Expect(versions).To(Equal([]string{"", "1"}))
+	})
+
+	It("Watches from scratch when the version is too old", func() {
+		first := addWatcher()
+		first.Add(makeVersionedPod(corev1.ConditionFalse, "1"))
+		first.Stop()
+		second := addWatcher()
+		second.Error(&metav1.Status{
+			Code: http.StatusGone,
+		})
+		third := addWatcher()
+		ready := makeVersionedPod(corev1.ConditionTrue, "3")
+		third.Add(ready)
+		pod, err := WaitForPod(client.CoreV1(), "myproject", "mypod")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pod).To(BeIdenticalTo(ready))
+		Expect(versions).To(Equal([]string{"", "1", ""}))
+	})
+
+	It("Gives up after the maximum number of retries", func() {
+		watcher := addWatcher()
+		watcher.Add(makeVersionedPod(corev1.ConditionFalse, "1"))
+		watcher.Stop()
+		pod, err := WaitForPod(client.CoreV1(), "myproject", "mypod")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("isn't ready"))
+		Expect(pod).To(BeNil())
+		Expect(versions).To(HaveLen(watchRetries + 1))
+	})
+
+	It("Doesn't retry when the watch fails", func() {
+		watcher := addWatcher()
+		watcher.Error(&metav1.Status{
+			Message: "my error",
+		})
+		_, err := WaitForPod(client.CoreV1(), "myproject", "mypod")
+		Expect(err).To(HaveOccurred())
+		Expect(versions).To(HaveLen(1))
+	})
+})
+
+// makeJob creates a job with the given number of succeeded and failed pods, and no retries.
+func makeJob(succeeded, failed int32) *batchv1.Job {
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "myjob",
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: pointer.Int32Ptr(0),
+		},
+		Status: batchv1.JobStatus{
+			Succeeded: succeeded,
+			Failed:    failed,
+		},
+	}
+}
+
+// makeDeployment creates a deployment that requests two replicas, with the given generation,
+// observed generation and number of available replicas.
+func makeDeployment(generation, observed int64, available int32) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "mydeployment",
+			Generation: generation,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer.Int32Ptr(2),
+		},
+		Status: appsv1.DeploymentStatus{
+			ObservedGeneration: observed,
+			AvailableReplicas:  available,
+		},
+	}
+}
+
+// makePod creates a pod with the given status for the ready condition.
+func makePod(ready corev1.ConditionStatus) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "mypod",
+		},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{
+				Type:   corev1.PodReady,
+				Status: ready,
+			}},
+		},
+	}
+}
+
+// makePullingPod creates a pod with one container that has the given state. If the state is nil
+// the pod doesn't have the status of the container yet.
+func makePullingPod(state *corev1.ContainerState) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "mypod",
+		},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{
+				Name:  "main",
+				Image: "myimage",
+			}},
+		},
+	}
+	if state != nil {
+		pod.Status.ContainerStatuses = []corev1.ContainerStatus{{
+			Name:  "main",
+			Image: "myimage",
+			State: *state,
+		}}
+	}
+	return pod
+}
+
+// makeRoute creates a route with one ingress that has the given admitted condition.
+func makeRoute(admitted corev1.ConditionStatus) *routev1.Route {
+	return &routev1.Route{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "myroute",
+		},
+		Status: routev1.RouteStatus{
+			Ingress: []routev1.RouteIngress{{
+				Conditions: []routev1.RouteIngressCondition{{
+					Type:   routev1.RouteAdmitted,
+					Status: admitted,
+				}},
+			}},
+		},
+	}
+}
+
+var _ = Describe("Wait for server", func() {
+	var interval time.Duration
+
+	BeforeEach(func() {
+		interval = serverPollInterval
+		serverPollInterval = 10 * time.Millisecond
+	})
+
+	AfterEach(func() {
+		serverPollInterval = interval
+	})
+
+	It("Returns when the server stops responding with 503", func() {
+		calls := 0
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				if calls < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+		))
+		defer server.Close()
+		err := WaitForServer(server.Client(), server.URL, time.Minute)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(calls).To(Equal(3))
+	})
+
+	It("Returns an error when the server doesn't respond before the timeout", func() {
+		server := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+		))
+		defer server.Close()
+		err := WaitForServer(server.Client(), server.URL, 100*time.Millisecond)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("isn't responding after 100ms"))
+	})
+})
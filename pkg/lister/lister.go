@@ -0,0 +1,253 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the lister that finds the projects created by the
+// runner.
+
+package lister
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	projectv1 "github.com/openshift/api/project/v1"
+	projectv1client "github.com/openshift/client-go/project/clientset/versioned/typed/project/v1"
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+// ListerBuilder contains the information and logic needed to create the lister. Don't create
+// instances of this type directly; use the NewLister function instead.
+type ListerBuilder struct {
+	config string
+}
+
+// Lister is the implementation of the lister.
+type Lister struct {
+	coreV1    *corev1client.CoreV1Client
+	projectV1 *projectv1client.ProjectV1Client
+}
+
+// Project contains the information about a project created by the runner.
+type Project struct {
+	Name     string     `json:"name"`
+	Owner    string     `json:"owner,omitempty"`
+	Created  time.Time  `json:"created"`
+	Cleaner  bool       `json:"cleaner"`
+	Deadline *time.Time `json:"deadline,omitempty"`
+	Result   *Result    `json:"result,omitempty"`
+}
+
+// Result contains the result of the last run of the tests in a project that was kept, as recorded
+// by the runner in the annotations of the project.
+type Result struct {
+	Status   string    `json:"status"`
+	Time     time.Time `json:"time"`
+	Binaries int       `json:"binaries"`
+	Failed   int       `json:"failed"`
+}
+
+// NewLister creates a new object that knows how to build listers.
+func NewLister() *ListerBuilder {
+	return &ListerBuilder{}
+}
+
+// Config sets the configuration file that will be used to connect to the OpenShift API.
+func (b *ListerBuilder) Config(value string) *ListerBuilder {
+	b.config = value
+	return b
+}
+
+// Build uses the information stored in the builder to create a new lister.
+func (b *ListerBuilder) Build() (l *Lister, err error) {
+	// Load the configuration either from the given configuration file or from the default
+	// location used when running inside a cluster:
+	config, err := clientcmd.BuildConfigFromFlags("", b.config)
+	if err != nil {
+		return
+	}
+
+	// Create the clients:
+	coreV1, err := corev1client.NewForConfig(config)
+	if err != nil {
+		return
+	}
+	projectV1, err := projectv1client.NewForConfig(config)
+	if err != nil {
+		return
+	}
+
+	// Create and populate the object:
+	l = &Lister{
+		coreV1:    coreV1,
+		projectV1: projectV1,
+	}
+
+	return
+}
+
+// List returns the projects created by the runner, sorted by creation time.
+func (l *Lister) List() (projects []*Project, err error) {
+	// Find the projects created by the runner:
+	selector := fmt.Sprintf("%s=%s", internal.AppLabel, internal.ProjectApp)
+	list, err := l.projectV1.Projects().List(metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return
+	}
+
+	// Collect the details of each project:
+	projects = make([]*Project, len(list.Items))
+	for i := range list.Items {
+		projects[i], err = l.describe(&list.Items[i])
+		if err != nil {
+			return
+		}
+	}
+	sort.Slice(projects, func(i, j int) bool {
+		return projects[i].Created.Before(projects[j].Created)
+	})
+
+	return
+}
+
+// Get returns the details of the project with the given name.
+func (l *Lister) Get(name string) (project *Project, err error) {
+	item, err := l.projectV1.Projects().Get(name, metav1.GetOptions{})
+	if err != nil {
+		return
+	}
+	project, err = l.describe(item)
+	return
+}
+
+// describe collects the details of the given project.
+func (l *Lister) describe(item *projectv1.Project) (project *Project, err error) {
+	project = &Project{
+		Name:    item.Name,
+		Owner:   item.Annotations[internal.OwnerAnnotation],
+		Created: item.CreationTimestamp.Time,
+	}
+	value, ok := item.Annotations[internal.CreatedAtAnnotation]
+	if ok {
+		created, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			log.Warnf(
+				"Can't parse creation time '%s' of project '%s': %v",
+				value, item.Name, err,
+			)
+		} else {
+			project.Created = created
+		}
+	}
+	project.Result = l.result(item)
+	project.Cleaner, err = l.hasCleaner(item.Name)
+	if err != nil {
+		return
+	}
+	if project.Cleaner {
+		project.Deadline, err = l.cleanerDeadline(item.Name)
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// result returns the result of the last run of the tests in the given project, or nil if the
+// runner didn't record it. Values that can't be parsed are ignored with a warning, as they
+// shouldn't prevent listing the project.
+func (l *Lister) result(item *projectv1.Project) *Result {
+	status, ok := item.Annotations[internal.ResultAnnotation]
+	if !ok {
+		return nil
+	}
+	result := &Result{
+		Status: status,
+	}
+	value := item.Annotations[internal.ResultAtAnnotation]
+	when, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Warnf("Can't parse result time '%s' of project '%s': %v", value, item.Name, err)
+	} else {
+		result.Time = when
+	}
+	parse := func(annotation string) int {
+		value := item.Annotations[annotation]
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			log.Warnf(
+				"Can't parse value '%s' of annotation '%s' of project '%s': %v",
+				value, annotation, item.Name, err,
+			)
+		}
+		return number
+	}
+	result.Binaries = parse(internal.ResultBinariesAnnotation)
+	result.Failed = parse(internal.ResultFailedAnnotation)
+	return result
+}
+
+// cleanerDeadline returns the time when the cleaner will delete the project, as published by the
+// cleaner in the config map. It returns nil if the cleaner didn't publish it.
+func (l *Lister) cleanerDeadline(project string) (result *time.Time, err error) {
+	configMap, err := l.coreV1.ConfigMaps(project).Get(
+		internal.CleanerConfigMap,
+		metav1.GetOptions{},
+	)
+	if errors.IsNotFound(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	value, ok := configMap.Data[internal.CleanerDeadlineKey]
+	if !ok {
+		return
+	}
+	deadline, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		err = fmt.Errorf(
+			"can't parse deletion time '%s' of project '%s': %v",
+			value, project, err,
+		)
+		return
+	}
+	result = &deadline
+	return
+}
+
+// hasCleaner checks if the given project contains a cleaner pod.
+func (l *Lister) hasCleaner(project string) (result bool, err error) {
+	selector := fmt.Sprintf("%s=%s", internal.AppLabel, internal.CleanerApp)
+	list, err := l.coreV1.Pods(project).List(metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return
+	}
+	result = len(list.Items) > 0
+	return
+}
@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that adds custom annotations to the project created by the runner.
+
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+// ProjectAnnotation adds an annotation that will be added to the project, for example so that
+// cost tools can attribute the resources used by the tests to a team. The annotation is set
+// every time that the runner uses the project, replacing the previous value. The key must be a
+// valid annotation name, and it can't be one of the annotations that the runner uses
+// internally. This method can be called multiple times to add multiple annotations.
+func (b *RunnerBuilder) ProjectAnnotation(key, value string) *RunnerBuilder {
+	if b.annotations == nil {
+		b.annotations = map[string]string{}
+	}
+	b.annotations[key] = value
+	return b
+}
+
+// CostCenter sets the cost center that the resources used by the tests should be charged to. It
+// is stored in the 'sandbox.jhernand/cost-center' annotation of the project.
+func (b *RunnerBuilder) CostCenter(value string) *RunnerBuilder {
+	return b.ProjectAnnotation(internal.CostCenterAnnotation, value)
+}
+
+// checkAnnotations checks that the annotations given to the builder are valid.
+func (b *RunnerBuilder) checkAnnotations() error {
+	for key := range b.annotations {
+		if reservedAnnotations[key] {
+			return fmt.Errorf(
+				"annotation '%s' is used internally by the runner and can't be changed",
+				key,
+			)
+		}
+		problems := validation.IsQualifiedName(strings.ToLower(key))
+		if len(problems) > 0 {
+			return fmt.Errorf(
+				"annotation name '%s' isn't valid: %s",
+				key, strings.Join(problems, ", "),
+			)
+		}
+	}
+	return nil
+}
+
+// reservedAnnotations contains the names of the annotations that the runner uses internally, and
+// that can't be changed with the ProjectAnnotation method:
+var reservedAnnotations = map[string]bool{
+	internal.CreatedAtAnnotation:      true,
+	internal.OwnerAnnotation:          true,
+	internal.ResultAnnotation:         true,
+	internal.ResultAtAnnotation:       true,
+	internal.ResultBinariesAnnotation: true,
+	internal.ResultFailedAnnotation:   true,
+}
@@ -0,0 +1,63 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+var _ = Describe("Project annotations", func() {
+	It("Accepts valid names", func() {
+		err := NewRunner().
+			ProjectAnnotation("finops.example.com/team", "payments").
+			ProjectAnnotation("simple", "value").
+			CostCenter("cc-1234").
+			checkAnnotations()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Stores the cost center in its annotation", func() {
+		builder := NewRunner().CostCenter("cc-1234")
+		Expect(builder.annotations).To(HaveKeyWithValue(internal.CostCenterAnnotation, "cc-1234"))
+	})
+
+	It("Rejects invalid names", func() {
+		err := NewRunner().
+			ProjectAnnotation("not valid/team", "payments").
+			checkAnnotations()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("not valid/team"))
+	})
+
+	It("Rejects empty names", func() {
+		err := NewRunner().
+			ProjectAnnotation("", "payments").
+			checkAnnotations()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Rejects internal annotations", func() {
+		err := NewRunner().
+			ProjectAnnotation(internal.OwnerAnnotation, "somebody").
+			checkAnnotations()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("internally"))
+	})
+})
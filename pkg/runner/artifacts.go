@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that saves the files produced by the test binaries.
+
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+// ArtifactDir sets the local directory where the runner writes the files produced by the test
+// binaries and returned by the server, requested with the OutputGlobs method. The files of each
+// binary are written to a sub-directory with the name of the binary, keeping their paths relative
+// to the directory where the binary ran. It is mandatory when output globs are given.
+func (b *RunnerBuilder) ArtifactDir(value string) *RunnerBuilder {
+	b.artifactDir = value
+	return b
+}
+
+// OutputGlobs adds patterns of files that the test binaries produce and that the server should
+// return after running them, for example 'testdata/*.golden' or 'cpu.prof'. Patterns use the
+// syntax of the Go filepath.Match function and are relative to the directory where the binaries
+// run. They can't be absolute or contain '..' elements. The server limits the total size of the
+// files returned for each binary.
+func (b *RunnerBuilder) OutputGlobs(values ...string) *RunnerBuilder {
+	b.outputGlobs = append(b.outputGlobs, values...)
+	return b
+}
+
+// checkOutputGlobs checks that the output globs are valid and that the artifact directory has
+// been given if they are used.
+func (b *RunnerBuilder) checkOutputGlobs() error {
+	if len(b.outputGlobs) == 0 {
+		return nil
+	}
+	if b.artifactDir == "" {
+		return fmt.Errorf("artifact directory is mandatory when output globs are given")
+	}
+	for _, glob := range b.outputGlobs {
+		if glob == "" || filepath.IsAbs(glob) || strings.HasPrefix(glob, "/") {
+			return fmt.Errorf("output glob '%s' must be a relative path", glob)
+		}
+		if hasDotDot(glob) {
+			return fmt.Errorf("output glob '%s' can't contain '..' elements", glob)
+		}
+		_, err := filepath.Match(glob, "")
+		if err != nil {
+			return fmt.Errorf("output glob '%s' isn't valid: %v", glob, err)
+		}
+	}
+	return nil
+}
+
+// saveArtifacts writes the files returned by the server for the given test binary to a
+// sub-directory of the artifact directory with the given name. Failures are logged but not
+// returned, as they shouldn't change the result of the binary.
+func (r *Runner) saveArtifacts(binary, name string, response *api.Test) {
+	for _, omitted := range response.OmittedArtifacts {
+		log.Warnf(
+			"Server didn't return artifact '%s' of test binary '%s', it may exceed "+
+				"the size limit of the server",
+			omitted, binary,
+		)
+	}
+	if len(response.Artifacts) == 0 {
+		return
+	}
+	dir := filepath.Join(r.artifactDir, name)
+	for path, data := range response.Artifacts {
+		err := writeArtifact(dir, path, data)
+		if err != nil {
+			log.Errorf("Can't save artifact '%s' of test binary '%s': %v", path, binary, err)
+		}
+	}
+	log.Infof(
+		"Saved %d artifacts of test binary '%s' to directory '%s'",
+		len(response.Artifacts), binary, dir,
+	)
+}
+
+// writeArtifact writes an artifact to the given directory. The path comes from the server, so it
+// is checked to make sure that it can't be used to write outside of that directory.
+func writeArtifact(dir, path string, data []byte) error {
+	if path == "" || strings.HasPrefix(path, "/") || filepath.IsAbs(path) || hasDotDot(path) {
+		return fmt.Errorf("path '%s' isn't a relative path inside the artifact directory", path)
+	}
+	file := filepath.Join(dir, filepath.FromSlash(path))
+	err := os.MkdirAll(filepath.Dir(file), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+// hasDotDot checks if the given slash or operating system separated path contains '..'
+// elements.
+func hasDotDot(path string) bool {
+	for _, element := range strings.Split(filepath.ToSlash(path), "/") {
+		if element == ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ = Describe("Artifacts", func() {
+	var tmp string
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "runner")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Writes the artifacts in a directory for each binary", func() {
+		rnnr := &Runner{
+			artifactDir: tmp,
+		}
+		rnnr.saveArtifacts("/my/db.test", "db.test", &api.Test{
+			Artifacts: map[string][]byte{
+				"cpu.prof":          []byte("profile"),
+				"testdata/a.golden": []byte("a"),
+			},
+		})
+		data, err := ioutil.ReadFile(filepath.Join(tmp, "db.test", "cpu.prof"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("profile"))
+		data, err = ioutil.ReadFile(filepath.Join(tmp, "db.test", "testdata", "a.golden"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("a"))
+	})
+
+	It("Doesn't write artifacts outside of the directory", func() {
+		dir := filepath.Join(tmp, "artifacts")
+		rnnr := &Runner{
+			artifactDir: dir,
+		}
+		rnnr.saveArtifacts("db.test", "db.test", &api.Test{
+			Artifacts: map[string][]byte{
+				"../../escaped.txt":           []byte("junk"),
+				"ok/../../escaped.txt":        []byte("junk"),
+				filepath.Join(tmp, "abs.txt"): []byte("junk"),
+			},
+		})
+		files, err := ioutil.ReadDir(tmp)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+
+	It("Requires the artifact directory when there are output globs", func() {
+		err := NewRunner().OutputGlobs("*.prof").checkOutputGlobs()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("artifact directory is mandatory"))
+	})
+
+	It("Rejects globs that escape the directory of the binary", func() {
+		builder := NewRunner().ArtifactDir(tmp)
+		Expect(builder.OutputGlobs("/etc/*").checkOutputGlobs()).ToNot(Succeed())
+		builder = NewRunner().ArtifactDir(tmp)
+		Expect(builder.OutputGlobs("../*").checkOutputGlobs()).ToNot(Succeed())
+		builder = NewRunner().ArtifactDir(tmp)
+		Expect(builder.OutputGlobs("testdata/*.golden").checkOutputGlobs()).To(Succeed())
+	})
+})
@@ -0,0 +1,83 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Build cache", func() {
+	var tmp string
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "runner")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Inherits the environment when not configured", func() {
+		rnnr := &Runner{}
+		env, err := rnnr.compileEnv()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env).To(BeNil())
+	})
+
+	It("Creates the cache directories and passes them to Go", func() {
+		cache := filepath.Join(tmp, "cache")
+		rnnr := &Runner{
+			buildCache: cache,
+		}
+		env, err := rnnr.compileEnv()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env).To(ContainElement("GOCACHE=" + filepath.Join(cache, "build")))
+		Expect(env).To(ContainElement("GOMODCACHE=" + filepath.Join(cache, "mod")))
+		Expect(filepath.Join(cache, "build")).To(BeADirectory())
+		Expect(filepath.Join(cache, "mod")).To(BeADirectory())
+	})
+
+	It("Fails if the cache directory can't be created", func() {
+		file := filepath.Join(tmp, "file")
+		err := ioutil.WriteFile(file, nil, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		rnnr := &Runner{
+			buildCache: file,
+		}
+		_, err = rnnr.compileEnv()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("can't create build cache"))
+	})
+
+	It("Can't be used when compilation is disabled", func() {
+		_, err := NewRunner().
+			Directory(tmp).
+			Compile(false).
+			BuildCache(filepath.Join(tmp, "cache")).
+			Build()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("compilation is enabled"))
+	})
+})
@@ -0,0 +1,48 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that loads the CA certificates used to verify the certificate
+// presented by the route of the server.
+
+package runner
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// loadCACerts loads the PEM encoded CA certificates contained in the given file and returns a pool
+// that contains them. It fails if the file doesn't contain at least one valid certificate, as
+// that usually means that the wrong file was given, and using an empty pool would reject all the
+// connections with an error that doesn't explain why.
+func loadCACerts(path string) (pool *x509.CertPool, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("can't read CA certificates file '%s': %v", path, err)
+		return
+	}
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		pool = nil
+		err = fmt.Errorf(
+			"CA certificates file '%s' doesn't contain any valid PEM encoded certificate",
+			path,
+		)
+		return
+	}
+	return
+}
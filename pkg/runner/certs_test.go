@@ -0,0 +1,116 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CA certificates", func() {
+	var tmp string
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "runner")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// writeFile writes the given data to a file inside the temporary directory and returns its
+	// path.
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(tmp, name)
+		err := ioutil.WriteFile(path, data, 0644)
+		Expect(err).ToNot(HaveOccurred())
+		return path
+	}
+
+	It("Loads a valid bundle", func() {
+		path := writeFile("ca.pem", makeCACert())
+		pool, err := loadCACerts(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pool).ToNot(BeNil())
+		Expect(pool.Subjects()).To(HaveLen(1))
+	})
+
+	It("Rejects a file without certificates", func() {
+		path := writeFile("ca.pem", []byte("junk"))
+		pool, err := loadCACerts(path)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("doesn't contain any valid"))
+		Expect(pool).To(BeNil())
+	})
+
+	It("Rejects a file that doesn't exist", func() {
+		pool, err := loadCACerts(filepath.Join(tmp, "missing.pem"))
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("can't read"))
+		Expect(pool).To(BeNil())
+	})
+
+	It("Can't be used together with insecure", func() {
+		path := writeFile("ca.pem", makeCACert())
+		_, err := NewRunner().
+			Directory(tmp).
+			Insecure(true).
+			CACert(path).
+			Build()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("verification is disabled"))
+	})
+})
+
+// makeCACert generates a self signed CA certificate and returns it PEM encoded.
+func makeCACert() []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	Expect(err).ToNot(HaveOccurred())
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "My CA",
+		},
+		NotBefore:             now,
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).ToNot(HaveOccurred())
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	})
+}
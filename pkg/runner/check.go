@@ -0,0 +1,339 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the checks that can be performed before running the tests to verify that
+// the runner has the connectivity and permissions that it needs.
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// CheckResult is the result of one of the checks performed by the Check method.
+type CheckResult struct {
+	// Name is the human readable description of the check.
+	Name string
+
+	// Error is the reason of the failure of the check, or nil if it succeeded.
+	Error error
+}
+
+// Check uses the information stored in the builder to verify that the runner will be able to
+// run the tests, without actually creating anything. It checks that the OpenShift API is
+// reachable, that the user has permission to create projects, that the routes API is available
+// and that the sandbox image can be pulled. The returned error will only be different from nil
+// if the checks can't be performed at all; failures of individual checks are reported in the
+// returned results.
+func (b *RunnerBuilder) Check() (results []*CheckResult, err error) {
+	// Create the Kubernetes clients:
+	err = b.createClients()
+	if err != nil {
+		return
+	}
+
+	// Run the checks:
+	results = []*CheckResult{
+		{
+			Name:  "OpenShift API is reachable",
+			Error: b.checkAPI(),
+		},
+		{
+			Name:  "User can create projects",
+			Error: b.checkCan("create", "project.openshift.io", "projectrequests", ""),
+		},
+		{
+			Name:  "Routes API is available",
+			Error: b.checkRoutes(),
+		},
+		{
+			Name:  fmt.Sprintf("Image '%s' can be pulled", b.serverImage()),
+			Error: b.checkImage(b.serverImage()),
+		},
+	}
+
+	return
+}
+
+// checkProjectPermissions checks that the user has permission to create the project. If the
+// project is going to be reused and it already exists then the check isn't performed.
+func (b *RunnerBuilder) checkProjectPermissions() error {
+	if b.reuse != "" {
+		_, err := b.projectV1.Projects().Get(b.reuse, metav1.GetOptions{})
+		if err == nil {
+			return nil
+		}
+		if !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return b.checkCan("create", "project.openshift.io", "projectrequests", "")
+}
+
+// checkObjectPermissions checks that the user has permission to create the objects that the
+// runner creates inside the project.
+func (b *RunnerBuilder) checkObjectPermissions() error {
+	for _, resource := range projectResources {
+		err := b.checkCan("create", resource.Group, resource.Resource, b.project)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkAPI checks that the OpenShift API is reachable.
+func (b *RunnerBuilder) checkAPI() error {
+	version, err := b.discovery.ServerVersion()
+	if err != nil {
+		return err
+	}
+	log.Debugf("Server version is '%s'", version.GitVersion)
+	return nil
+}
+
+// checkRoutes checks that the OpenShift routes API is available.
+func (b *RunnerBuilder) checkRoutes() error {
+	_, err := b.discovery.ServerResourcesForGroupVersion("route.openshift.io/v1")
+	return err
+}
+
+// checkCan checks if the current user has permission to perform the given action. The namespace
+// can be empty for cluster wide resources.
+func (b *RunnerBuilder) checkCan(verb, group, resource, namespace string) error {
+	review := &authorizationv1.SelfSubjectAccessReview{
+		Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Group:     group,
+				Resource:  resource,
+			},
+		},
+	}
+	review, err := b.authorizationV1.SelfSubjectAccessReviews().Create(review)
+	if err != nil {
+		return err
+	}
+	if !review.Status.Allowed {
+		kind := resource
+		if group != "" {
+			kind = resource + "." + group
+		}
+		if review.Status.Reason != "" {
+			return fmt.Errorf(
+				"you lack permission to %s %s: %s",
+				verb, kind, review.Status.Reason,
+			)
+		}
+		return fmt.Errorf("you lack permission to %s %s", verb, kind)
+	}
+	return nil
+}
+
+// checkImage checks that the manifest of the given image can be retrieved anonymously from the
+// registry, which means that it can be pulled.
+func (b *RunnerBuilder) checkImage(image string) error {
+	// Split the image reference into registry, repository and tag or digest:
+	registry, repository, reference, err := parseImage(image)
+	if err != nil {
+		return err
+	}
+
+	// Create the HTTP client:
+	transport := &http.Transport{}
+	if b.proxy != "" {
+		proxyURL, err := url.Parse(b.proxy)
+		if err != nil {
+			return err
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	client := &http.Client{
+		Transport: transport,
+	}
+
+	// Try to get the manifest. If the registry requires a token then request an anonymous one
+	// and try again:
+	address := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repository, reference)
+	status, challenge, err := b.headManifest(client, address, "")
+	if err != nil {
+		return err
+	}
+	if status == http.StatusUnauthorized && challenge != "" {
+		var token string
+		token, err = b.registryToken(client, challenge)
+		if err != nil {
+			return err
+		}
+		status, _, err = b.headManifest(client, address, token)
+		if err != nil {
+			return err
+		}
+	}
+	if status != http.StatusOK {
+		return fmt.Errorf(
+			"registry '%s' responded with status code %d to request for manifest of "+
+				"image '%s'",
+			registry, status, image,
+		)
+	}
+	return nil
+}
+
+// parseImage splits the given image reference into the registry, the repository and the tag or
+// digest, for example 'quay.io', 'myorg/myimage' and 'v1'. The digest is separated with '@', and
+// the tag with a colon after the last slash, as a colon before that is the port of the registry.
+// When there is a digest it is used instead of the tag, and when there is neither of them the
+// reference is 'latest'.
+func parseImage(image string) (registry, repository, reference string, err error) {
+	slash := strings.Index(image, "/")
+	if slash < 0 {
+		err = fmt.Errorf("image '%s' doesn't contain a registry", image)
+		return
+	}
+	registry = image[0:slash]
+	repository = image[slash+1:]
+	reference = "latest"
+	at := strings.Index(repository, "@")
+	digest := ""
+	if at >= 0 {
+		digest = repository[at+1:]
+		repository = repository[0:at]
+	}
+	colon := strings.LastIndex(repository, ":")
+	if colon > strings.LastIndex(repository, "/") {
+		reference = repository[colon+1:]
+		repository = repository[0:colon]
+	}
+	if at >= 0 {
+		reference = digest
+	}
+	if registry == "" || repository == "" || reference == "" {
+		err = fmt.Errorf("image '%s' isn't valid", image)
+	}
+	return
+}
+
+// headManifest sends a HEAD request for the given image manifest address and returns the status
+// code and the authentication challenge sent by the registry, if any.
+func (b *RunnerBuilder) headManifest(client *http.Client, address,
+	token string) (status int, challenge string, err error) {
+	request, err := http.NewRequest(http.MethodHead, address, nil)
+	if err != nil {
+		return
+	}
+	request.Header.Set("Accept", strings.Join(manifestTypes, ", "))
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return
+	}
+	err = response.Body.Close()
+	if err != nil {
+		return
+	}
+	status = response.StatusCode
+	challenge = response.Header.Get("WWW-Authenticate")
+	return
+}
+
+// registryToken requests an anonymous token using the given bearer authentication challenge.
+func (b *RunnerBuilder) registryToken(client *http.Client, challenge string) (token string,
+	err error) {
+	// Parse the challenge, which has a format like this:
+	//
+	//	Bearer realm="https://...",service="...",scope="..."
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		err = fmt.Errorf("unsupported authentication challenge '%s'", challenge)
+		return
+	}
+	params := map[string]string{}
+	for _, param := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		equals := strings.Index(param, "=")
+		if equals < 0 {
+			continue
+		}
+		name := strings.TrimSpace(param[0:equals])
+		value := strings.Trim(strings.TrimSpace(param[equals+1:]), `"`)
+		params[name] = value
+	}
+	realm, ok := params["realm"]
+	if !ok {
+		err = fmt.Errorf("authentication challenge '%s' doesn't contain a realm", challenge)
+		return
+	}
+	query := url.Values{}
+	for _, name := range []string{"service", "scope"} {
+		value, ok := params[name]
+		if ok {
+			query.Set(name, value)
+		}
+	}
+
+	// Request the token:
+	response, err := client.Get(realm + "?" + query.Encode())
+	if err != nil {
+		return
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		err = fmt.Errorf(
+			"token request to '%s' failed with status code %d",
+			realm, response.StatusCode,
+		)
+		return
+	}
+	body := struct {
+		Token string `json:"token"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&body)
+	if err != nil {
+		return
+	}
+	token = body.Token
+	return
+}
+
+// projectResources are the types of objects that the runner creates inside the project.
+var projectResources = []schema.GroupResource{
+	{Group: "", Resource: "serviceaccounts"},
+	{Group: "", Resource: "secrets"},
+	{Group: "", Resource: "pods"},
+	{Group: "", Resource: "services"},
+	{Group: "rbac.authorization.k8s.io", Resource: "rolebindings"},
+	{Group: "route.openshift.io", Resource: "routes"},
+}
+
+// manifestTypes are the media types of image manifests that the check accepts.
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.oci.image.index.v1+json",
+}
@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	projectv1 "github.com/openshift/api/project/v1"
+	projectfake "github.com/openshift/client-go/project/clientset/versioned/fake"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var _ = Describe("Image references", func() {
+	DescribeTable(
+		"Splits valid references",
+		func(image, registry, repository, reference string) {
+			actualRegistry, actualRepository, actualReference, err := parseImage(image)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(actualRegistry).To(Equal(registry))
+			Expect(actualRepository).To(Equal(repository))
+			Expect(actualReference).To(Equal(reference))
+		},
+		Entry(
+			"With tag",
+			"quay.io/myorg/myimage:v1",
+			"quay.io", "myorg/myimage", "v1",
+		),
+		Entry(
+			"Without tag",
+			"quay.io/myorg/myimage",
+			"quay.io", "myorg/myimage", "latest",
+		),
+		Entry(
+			"With digest",
+			"quay.io/myorg/myimage@sha256:0123abcd",
+			"quay.io", "myorg/myimage", "sha256:0123abcd",
+		),
+		Entry(
+			"With tag and digest",
+			"quay.io/myorg/myimage:v1@sha256:0123abcd",
+			"quay.io", "myorg/myimage", "sha256:0123abcd",
+		),
+		Entry(
+			"With registry port and tag",
+			"myregistry:5000/myorg/myimage:v1",
+			"myregistry:5000", "myorg/myimage", "v1",
+		),
+		Entry(
+			"With registry port and without tag",
+			"myregistry:5000/myorg/myimage",
+			"myregistry:5000", "myorg/myimage", "latest",
+		),
+		Entry(
+			"With registry port and digest",
+			"myregistry:5000/myorg/myimage@sha256:0123abcd",
+			"myregistry:5000", "myorg/myimage", "sha256:0123abcd",
+		),
+	)
+
+	DescribeTable(
+		"Rejects invalid references",
+		func(image string) {
+			_, _, _, err := parseImage(image)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("Without registry", "myimage:v1"),
+		Entry("Without repository", "quay.io/"),
+		Entry("With empty tag", "quay.io/myorg/myimage:"),
+		Entry("With empty digest", "quay.io/myorg/myimage@"),
+	)
+})
+
+var _ = Describe("Permissions", func() {
+	var (
+		denied   map[string]bool
+		reviews  []*authorizationv1.ResourceAttributes
+		projects *projectfake.Clientset
+		builder  *RunnerBuilder
+	)
+
+	BeforeEach(func() {
+		// Deny the verbs and resources that the test puts in the denied map, in the form
+		// 'VERB RESOURCE', and allow the rest:
+		denied = map[string]bool{}
+		reviews = nil
+		core := fake.NewSimpleClientset()
+		core.PrependReactor(
+			"create", "selfsubjectaccessreviews",
+			func(action clienttesting.Action) (bool, runtime.Object, error) {
+				create := action.(clienttesting.CreateAction)
+				review := create.GetObject().(*authorizationv1.SelfSubjectAccessReview)
+				attributes := review.Spec.ResourceAttributes
+				reviews = append(reviews, attributes)
+				key := attributes.Verb + " " + attributes.Resource
+				review.Status.Allowed = !denied[key]
+				if denied[key] {
+					review.Status.Reason = "denied by test"
+				}
+				return true, review, nil
+			},
+		)
+		projects = projectfake.NewSimpleClientset()
+		builder = NewRunner()
+		builder.project = "myproject"
+		builder.authorizationV1 = core.AuthorizationV1()
+		builder.projectV1 = projects.ProjectV1()
+	})
+
+	It("Succeeds if the action is allowed", func() {
+		err := builder.checkCan("create", "", "pods", "myproject")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reviews).To(HaveLen(1))
+		Expect(reviews[0].Namespace).To(Equal("myproject"))
+		Expect(reviews[0].Verb).To(Equal("create"))
+		Expect(reviews[0].Resource).To(Equal("pods"))
+	})
+
+	It("Names the verb and the resource that are denied", func() {
+		denied["create pods"] = true
+		err := builder.checkCan("create", "", "pods", "myproject")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("permission to create pods"))
+		Expect(err.Error()).To(ContainSubstring("denied by test"))
+	})
+
+	It("Includes the group in the name of the resource", func() {
+		denied["create routes"] = true
+		err := builder.checkCan("create", "route.openshift.io", "routes", "myproject")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("permission to create routes.route.openshift.io"))
+	})
+
+	It("Checks that the project can be created", func() {
+		denied["create projectrequests"] = true
+		err := builder.checkProjectPermissions()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(
+			"permission to create projectrequests.project.openshift.io",
+		))
+	})
+
+	It("Doesn't check the project if it is reused and exists", func() {
+		_, err := projects.ProjectV1().Projects().Create(&projectv1.Project{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "myproject",
+			},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		builder.reuse = "myproject"
+		denied["create projectrequests"] = true
+		err = builder.checkProjectPermissions()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reviews).To(BeEmpty())
+	})
+
+	It("Checks the project if it is reused but doesn't exist", func() {
+		builder.reuse = "myproject"
+		denied["create projectrequests"] = true
+		err := builder.checkProjectPermissions()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("permission to create projectrequests"))
+	})
+
+	It("Checks all the objects created inside the project", func() {
+		err := builder.checkObjectPermissions()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reviews).To(HaveLen(len(projectResources)))
+		for _, review := range reviews {
+			Expect(review.Namespace).To(Equal("myproject"))
+			Expect(review.Verb).To(Equal("create"))
+		}
+	})
+
+	It("Fails if one of the objects can't be created", func() {
+		denied["create rolebindings"] = true
+		err := builder.checkObjectPermissions()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring(
+			"permission to create rolebindings.rbac.authorization.k8s.io",
+		))
+	})
+})
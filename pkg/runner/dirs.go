@@ -0,0 +1,78 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that expands the directory arguments that use the '...' wildcard.
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// expandDirs replaces the directories that end with the '...' wildcard, like './pkg/...', with
+// the directories of that subtree that contain test files, the same that 'go test' does. Like
+// the Go tools it ignores the 'testdata' and 'vendor' directories, and the directories whose
+// names start with a dot or an underscore. Directories without the wildcard are returned as
+// they are. The result is sorted and doesn't contain duplicates.
+func expandDirs(dirs []string) (result []string, err error) {
+	set := map[string]bool{}
+	for _, dir := range dirs {
+		if dir != wildcard && !strings.HasSuffix(dir, string(filepath.Separator)+wildcard) {
+			set[dir] = true
+			continue
+		}
+		root := strings.TrimSuffix(strings.TrimSuffix(dir, wildcard), string(filepath.Separator))
+		if root == "" {
+			root = "."
+		}
+		err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.IsDir() && path != root && skipDir(info.Name()) {
+				return filepath.SkipDir
+			}
+			if info.Mode().IsRegular() && strings.HasSuffix(path, "_test.go") {
+				set[filepath.Dir(path)] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return
+		}
+	}
+	result = make([]string, 0, len(set))
+	for dir := range set {
+		result = append(result, dir)
+	}
+	sort.Strings(result)
+	return
+}
+
+// skipDir checks if a directory with the given name should be ignored when expanding the '...'
+// wildcard.
+func skipDir(name string) bool {
+	return name == "testdata" ||
+		name == "vendor" ||
+		strings.HasPrefix(name, ".") ||
+		strings.HasPrefix(name, "_")
+}
+
+// The wildcard that matches a directory and all its subdirectories:
+const wildcard = "..."
@@ -0,0 +1,114 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Expand directories", func() {
+	var tmp string
+	var cwd string
+
+	BeforeEach(func() {
+		var err error
+
+		// Create a tree of directories, some of them containing test files:
+		tmp, err = ioutil.TempDir("", "runner")
+		Expect(err).ToNot(HaveOccurred())
+		for _, file := range []string{
+			"main_test.go",
+			"pkg/api/api.go",
+			"pkg/db/db_test.go",
+			"pkg/db/testdata/data_test.go",
+			"pkg/server/server_test.go",
+			"pkg/.hidden/hidden_test.go",
+			"pkg/_ignored/ignored_test.go",
+			"vendor/dep/dep_test.go",
+			"tests/main_test.go",
+		} {
+			path := filepath.Join(tmp, filepath.FromSlash(file))
+			err = os.MkdirAll(filepath.Dir(path), 0755)
+			Expect(err).ToNot(HaveOccurred())
+			err = ioutil.WriteFile(path, nil, 0644)
+			Expect(err).ToNot(HaveOccurred())
+		}
+
+		// Change into the temporary directory, so that relative paths are resolved
+		// from there:
+		cwd, err = os.Getwd()
+		Expect(err).ToNot(HaveOccurred())
+		err = os.Chdir(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.Chdir(cwd)
+		Expect(err).ToNot(HaveOccurred())
+		err = os.RemoveAll(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Expands the whole tree", func() {
+		dirs, err := expandDirs([]string{"./..."})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dirs).To(Equal([]string{
+			".",
+			"pkg/db",
+			"pkg/server",
+			"tests",
+		}))
+	})
+
+	It("Expands a subtree", func() {
+		dirs, err := expandDirs([]string{"./pkg/..."})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dirs).To(Equal([]string{
+			"pkg/db",
+			"pkg/server",
+		}))
+	})
+
+	It("Preserves literal directories", func() {
+		dirs, err := expandDirs([]string{"./tests", "./pkg/api"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dirs).To(Equal([]string{
+			"./pkg/api",
+			"./tests",
+		}))
+	})
+
+	It("Combines literal and expanded directories", func() {
+		dirs, err := expandDirs([]string{"tests", "./pkg/...", "pkg/db"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(dirs).To(Equal([]string{
+			"pkg/db",
+			"pkg/server",
+			"tests",
+		}))
+	})
+
+	It("Fails if the root of the subtree doesn't exist", func() {
+		_, err := expandDirs([]string{"./missing/..."})
+		Expect(err).To(HaveOccurred())
+	})
+})
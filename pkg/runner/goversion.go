@@ -0,0 +1,186 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that checks the version of the Go toolchain used to compile the
+// tests.
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RequireGoVersion sets a constraint that the version of the Go toolchain used to compile the
+// tests must satisfy. The constraint is an optional operator, one of '>=', '>', '<=', '<' or '=',
+// followed by a version, for example '>=1.12' or '=1.13'. If the operator is omitted '>=' is
+// used. The '=' operator only compares the components present in the constraint, so '=1.13' is
+// satisfied by 1.13.4. If the toolchain doesn't satisfy the constraint the Run method fails before
+// compiling. The default is to accept any version. Note that this is only checked when the tests
+// are compiled.
+func (b *RunnerBuilder) RequireGoVersion(value string) *RunnerBuilder {
+	b.goRequire = value
+	return b
+}
+
+// goConstraint is a parsed version constraint.
+type goConstraint struct {
+	text    string
+	op      string
+	version []int
+}
+
+// parseGoConstraint parses the given version constraint.
+func parseGoConstraint(text string) (result *goConstraint, err error) {
+	matches := goConstraintRE.FindStringSubmatch(strings.TrimSpace(text))
+	if matches == nil {
+		err = fmt.Errorf(
+			"go version constraint '%s' isn't valid, it should be an optional operator "+
+				"followed by a version, for example '>=1.12'",
+			text,
+		)
+		return
+	}
+	op := matches[1]
+	if op == "" {
+		op = ">="
+	}
+	version, err := parseGoVersion(matches[2])
+	if err != nil {
+		return
+	}
+	result = &goConstraint{
+		text:    text,
+		op:      op,
+		version: version,
+	}
+	return
+}
+
+// check checks if the given version satisfies the constraint.
+func (c *goConstraint) check(version []int) bool {
+	if c.op == "=" {
+		for i, value := range c.version {
+			if i >= len(version) || version[i] != value {
+				return false
+			}
+		}
+		return true
+	}
+	result := compareGoVersions(version, c.version)
+	switch c.op {
+	case ">=":
+		return result >= 0
+	case ">":
+		return result > 0
+	case "<=":
+		return result <= 0
+	case "<":
+		return result < 0
+	}
+	return false
+}
+
+// goVersion runs the 'go version' command and returns the version of the toolchain, for example
+// 'go1.13.4'.
+func goVersion() (version string, err error) {
+	output, err := exec.Command("go", "version").Output()
+	if err != nil {
+		err = fmt.Errorf("can't run 'go version': %v", err)
+		return
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) < 3 || !strings.HasPrefix(fields[2], "go") {
+		err = fmt.Errorf("can't parse output of 'go version': %s", output)
+		return
+	}
+	version = fields[2]
+	return
+}
+
+// checkGoVersion runs the 'go version' command, saves the result so that it is reported in the
+// summary of the run and checks that it satisfies the constraint, if any.
+func (r *Runner) checkGoVersion() error {
+	text, err := goVersion()
+	if err != nil {
+		return err
+	}
+	r.goVersion = text
+	log.Infof("Tests will be compiled with Go version '%s'", text)
+	if r.goRequire == nil {
+		return nil
+	}
+	version, err := parseGoVersion(strings.TrimPrefix(text, "go"))
+	if err != nil {
+		return err
+	}
+	if !r.goRequire.check(version) {
+		return fmt.Errorf(
+			"go version '%s' doesn't satisfy constraint '%s'",
+			text, r.goRequire.text,
+		)
+	}
+	return nil
+}
+
+// parseGoVersion parses a version like '1.13.4' and returns its numeric components. Suffixes of
+// pre-release versions, like 'beta1' in '1.14beta1', are ignored.
+func parseGoVersion(text string) (result []int, err error) {
+	for _, field := range strings.Split(text, ".") {
+		digits := goDigitsRE.FindString(field)
+		if digits == "" {
+			err = fmt.Errorf("go version '%s' isn't valid", text)
+			return
+		}
+		var value int
+		value, err = strconv.Atoi(digits)
+		if err != nil {
+			return
+		}
+		result = append(result, value)
+	}
+	return
+}
+
+// compareGoVersions returns a negative number if the first version is older than the second,
+// zero if they are the same, and a positive number if it is newer. Missing components are
+// considered zero.
+func compareGoVersions(a, b []int) int {
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// Regular expressions used to parse versions and constraints:
+var (
+	goConstraintRE = regexp.MustCompile(`^(>=|<=|>|<|=)?\s*([0-9][0-9A-Za-z.]*)$`)
+	goDigitsRE     = regexp.MustCompile(`^[0-9]+`)
+)
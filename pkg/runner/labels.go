@@ -0,0 +1,165 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that selects the test directories and binaries using labels.
+
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Labels adds labels to the given directory. Labels are used to select what tests to run, with the
+// OnlyLabels and SkipLabels methods, and are reported together with the results of the test binary
+// of the directory. The directory must be one of the directories that contain tests, either given
+// explicitly or found scanning recursively, otherwise the Run method will fail. This method can be
+// called multiple times for the same directory, and the labels will be added.
+func (b *RunnerBuilder) Labels(dir string, values ...string) *RunnerBuilder {
+	if b.labels == nil {
+		b.labels = map[string][]string{}
+	}
+	dir = filepath.Clean(dir)
+	b.labels[dir] = append(b.labels[dir], values...)
+	return b
+}
+
+// OnlyLabels adds labels that the directories must have in order to run their tests. Directories
+// that don't have at least one of these labels will be skipped. The default is to run the tests
+// of all the directories.
+func (b *RunnerBuilder) OnlyLabels(values ...string) *RunnerBuilder {
+	b.onlyLabels = append(b.onlyLabels, values...)
+	return b
+}
+
+// SkipLabels adds labels of directories whose tests will not run. Directories that have at least
+// one of these labels will be skipped, even if they also have one of the labels given with the
+// OnlyLabels method. The default is to not skip any directory.
+func (b *RunnerBuilder) SkipLabels(values ...string) *RunnerBuilder {
+	b.skipLabels = append(b.skipLabels, values...)
+	return b
+}
+
+// checkLabels checks that the labels given to the builder are valid.
+func (b *RunnerBuilder) checkLabels() error {
+	var all []string
+	for _, values := range b.labels {
+		all = append(all, values...)
+	}
+	all = append(all, b.onlyLabels...)
+	all = append(all, b.skipLabels...)
+	for _, label := range all {
+		if label == "" || strings.ContainsAny(label, ", \t\n") {
+			return fmt.Errorf(
+				"label '%s' isn't valid, it can't be empty or contain commas or spaces",
+				label,
+			)
+		}
+	}
+	return nil
+}
+
+// checkLabelDirs checks that all the directories that have labels are directories that contain
+// tests.
+func (r *Runner) checkLabelDirs() error {
+	known := map[string]bool{}
+	for _, dir := range r.dirs {
+		known[filepath.Clean(dir)] = true
+	}
+	for dir := range r.labels {
+		if !known[dir] {
+			return fmt.Errorf(
+				"directory '%s' has labels but it doesn't contain tests",
+				dir,
+			)
+		}
+	}
+	return nil
+}
+
+// selectDirs returns the directories whose tests should run according to their labels.
+func (r *Runner) selectDirs(dirs []string) []string {
+	var selected []string
+	for _, dir := range dirs {
+		labels := r.labels[filepath.Clean(dir)]
+		if r.selected(labels) {
+			selected = append(selected, dir)
+		} else {
+			log.Infof("Skipping directory '%s' because of its labels", dir)
+		}
+	}
+	return selected
+}
+
+// selectBinaries returns the test binaries that should run according to the labels of the
+// directories where they were compiled from.
+func (r *Runner) selectBinaries(binaries []string) []string {
+	var selected []string
+	for _, binary := range binaries {
+		labels := r.binaryLabels(binary)
+		if r.selected(labels) {
+			selected = append(selected, binary)
+		} else {
+			log.Infof("Skipping test binary '%s' because of its labels", binary)
+		}
+	}
+	return selected
+}
+
+// selected checks if the tests that have the given labels should run.
+func (r *Runner) selected(labels []string) bool {
+	if containsAny(labels, r.skipLabels) {
+		return false
+	}
+	if len(r.onlyLabels) > 0 && !containsAny(labels, r.onlyLabels) {
+		return false
+	}
+	return true
+}
+
+// binaryLabels returns the labels of the given test binary. These are the labels of the directory
+// that the binary is compiled from. The 'go test -c' command names the binary after the last
+// element of the path of the package, so that is what we use to find the directory.
+func (r *Runner) binaryLabels(binary string) []string {
+	var labels []string
+	for dir, values := range r.labels {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if filepath.Base(abs)+".test" == filepath.Base(binary) {
+			labels = append(labels, values...)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// containsAny checks if the first slice contains any of the values of the second.
+func containsAny(values, candidates []string) bool {
+	for _, value := range values {
+		for _, candidate := range candidates {
+			if value == candidate {
+				return true
+			}
+		}
+	}
+	return false
+}
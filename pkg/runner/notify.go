@@ -0,0 +1,220 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that sends the summary of a run to a webhook.
+
+package runner
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// RunSummary is the summary of a run. It is returned by the RunAll method and sent to the
+// notification webhook.
+type RunSummary struct {
+	// Profile is the name of the cluster profile that the tests ran against, if any.
+	Profile string `json:"profile,omitempty"`
+
+	// Project is the name of the OpenShift project where the tests ran.
+	Project string `json:"project,omitempty"`
+
+	// Kept indicates if the project is preserved after the run.
+	Kept bool `json:"kept,omitempty"`
+
+	// Binaries is the number of test binaries selected to run.
+	Binaries int `json:"binaries"`
+
+	// Passed is the number of test binaries that are considered successful. Flaky binaries are
+	// only included when the FlakyPass option is enabled, otherwise they are counted as failed.
+	Passed int `json:"passed"`
+
+	// Failed is the number of test binaries that are considered failed, the same value that the
+	// Run method returns.
+	Failed int `json:"failed"`
+
+	// Flaky is the number of test binaries that failed and then passed when retried.
+	Flaky int `json:"flaky"`
+
+	// GoVersion is the version of the Go toolchain used to compile the tests, for example
+	// 'go1.13.4'. It will be empty if the tests weren't compiled by the runner.
+	GoVersion string `json:"go_version,omitempty"`
+
+	// Duration is the time that the run took, in seconds.
+	Duration float64 `json:"duration"`
+
+	// Dirs are the directories of the server where the files of failed tests have been
+	// preserved.
+	Dirs []string `json:"dirs,omitempty"`
+
+	// Error is the error that stopped the run, or the errors of the binaries that couldn't be
+	// sent to the server, if any.
+	Error string `json:"error,omitempty"`
+
+	// Results contains the result of each test binary, in the order that they were run.
+	Results []*BinaryResult `json:"results,omitempty"`
+}
+
+// BinaryResult is the result of running one test binary.
+type BinaryResult struct {
+	// Binary is the name of the test binary, for example 'db.test'.
+	Binary string `json:"binary"`
+
+	// Labels are the labels of the directory of the test binary.
+	Labels []string `json:"labels,omitempty"`
+
+	// Skipped indicates that the binary wasn't sent to the server, because of a previous
+	// failure and the fail fast option, or because the run was cancelled.
+	Skipped bool `json:"skipped,omitempty"`
+
+	// Passed indicates if the binary is considered successful. That means that its last
+	// execution succeeded and that it isn't a flaky binary counted as failed.
+	Passed bool `json:"passed"`
+
+	// Flaky indicates that the binary failed and then passed when retried.
+	Flaky bool `json:"flaky,omitempty"`
+
+	// Attempts is the number of times that the binary was sent to the server.
+	Attempts int `json:"attempts"`
+
+	// Code is the exit code of the last execution of the binary.
+	Code int `json:"code"`
+
+	// TimedOut indicates that the server killed the binary because it didn't finish in time.
+	TimedOut bool `json:"timed_out,omitempty"`
+
+	// Signal is the name of the signal that killed the last execution of the binary, if any.
+	Signal string `json:"signal,omitempty"`
+
+	// Truncated indicates that the output of the binary was truncated by the server.
+	Truncated bool `json:"truncated,omitempty"`
+
+	// Dir is the directory of the server where the files of the binary were preserved, if any.
+	Dir string `json:"dir,omitempty"`
+
+	// Duration is the time that the binary took, including retries, in seconds.
+	Duration float64 `json:"duration"`
+
+	// Error is the error that prevented running the binary, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// NotifyURL sets the URL of a webhook that will receive a POST request with the summary of the
+// run, in JSON format, when the Run method finishes, regardless of the result. Failures to send
+// the notification are logged but don't change the result of the run. The default is to not
+// send notifications.
+func (b *RunnerBuilder) NotifyURL(value string) *RunnerBuilder {
+	b.notifyURL = value
+	return b
+}
+
+// NotifySecret sets the secret used to sign the notifications. When set the requests sent to the
+// webhook will contain a X-Sandbox-Signature header with the text 'sha256=' followed by the
+// hexadecimal HMAC-SHA256 of the body calculated with this secret, so that the receiver can
+// check that they were sent by the runner. The default is to not sign notifications.
+func (b *RunnerBuilder) NotifySecret(value string) *RunnerBuilder {
+	b.notifySecret = value
+	return b
+}
+
+// notify sends the summary to the notification webhook, if configured. Errors are logged but not
+// returned, as they shouldn't change the result of the run. Many webhooks, Slack for example,
+// contain the secret in the path of the URL, so only the scheme and the host are logged.
+func (r *Runner) notify(summary *RunSummary) {
+	if r.notifyURL == "" {
+		return
+	}
+	host := notifyHost(r.notifyURL)
+	log.Infof("Sending summary of run to '%s'", host)
+	err := r.sendNotification(summary)
+	if err != nil {
+		log.Errorf("Can't send summary of run to '%s': %v", host, err)
+	}
+}
+
+// notifyHost returns the scheme and the host of the given webhook URL, without the rest of the
+// URL, so that it can be written to the log.
+func notifyHost(value string) string {
+	parsed, err := url.Parse(value)
+	if err != nil || parsed.Host == "" {
+		return "webhook"
+	}
+	return fmt.Sprintf("%s://%s", parsed.Scheme, parsed.Host)
+}
+
+// sendNotification sends the summary to the notification webhook.
+func (r *Runner) sendNotification(summary *RunSummary) error {
+	// Serialize the request body:
+	httpBody, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	// Send the HTTP request:
+	httpRequest, err := http.NewRequest(http.MethodPost, r.notifyURL, bytes.NewReader(httpBody))
+	if err != nil {
+		return fmt.Errorf("webhook URL isn't valid")
+	}
+	httpRequest.Header.Set("Content-Type", "application/json")
+	if r.notifySecret != "" {
+		httpRequest.Header.Set(notifySignatureHeader, notifySignature(r.notifySecret, httpBody))
+	}
+	httpClient := &http.Client{
+		Timeout: notifyTimeout,
+	}
+	httpResponse, err := httpClient.Do(httpRequest)
+	if err != nil {
+		// The error returned by the client contains the URL, so we return only the cause:
+		urlErr, ok := err.(*url.Error)
+		if ok {
+			err = urlErr.Err
+		}
+		return err
+	}
+	defer httpResponse.Body.Close()
+	_, err = io.Copy(ioutil.Discard, httpResponse.Body)
+	if err != nil {
+		return err
+	}
+	if httpResponse.StatusCode < 200 || httpResponse.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status code %d", httpResponse.StatusCode)
+	}
+	return nil
+}
+
+// notifySignature calculates the value of the signature header for the given body.
+func notifySignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	_, _ = mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Name of the header that contains the signature of notifications:
+const notifySignatureHeader = "X-Sandbox-Signature"
+
+// Maximum time to wait for the webhook to respond:
+const notifyTimeout = 30 * time.Second
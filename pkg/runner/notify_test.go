@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("Notifications", func() {
+	var hook *logtest.Hook
+	var status int
+	var body []byte
+	var signature string
+	var listener *httptest.Server
+
+	BeforeEach(func() {
+		hook = logtest.NewGlobal()
+		status = http.StatusOK
+		listener = httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				var err error
+				body, err = ioutil.ReadAll(r.Body)
+				Expect(err).ToNot(HaveOccurred())
+				signature = r.Header.Get(notifySignatureHeader)
+				w.WriteHeader(status)
+			},
+		))
+	})
+
+	AfterEach(func() {
+		listener.Close()
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+	})
+
+	// logged returns the text of all the messages written to the log.
+	logged := func() []string {
+		var result []string
+		for _, entry := range hook.AllEntries() {
+			text, err := entry.String()
+			Expect(err).ToNot(HaveOccurred())
+			result = append(result, text)
+		}
+		return result
+	}
+
+	It("Sends the signed summary", func() {
+		rnnr := &Runner{
+			notifyURL:    listener.URL + "/hooks/mysecret",
+			notifySecret: "mykey",
+		}
+		rnnr.notify(&RunSummary{
+			Project: "myproject",
+			Passed:  1,
+		})
+		summary := &RunSummary{}
+		err := json.Unmarshal(body, summary)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Project).To(Equal("myproject"))
+		Expect(summary.Passed).To(Equal(1))
+		Expect(signature).To(Equal(notifySignature("mykey", body)))
+	})
+
+	It("Doesn't log the path of the URL", func() {
+		rnnr := &Runner{
+			notifyURL: listener.URL + "/hooks/mysecret",
+		}
+		rnnr.notify(&RunSummary{})
+		messages := logged()
+		Expect(messages).ToNot(BeEmpty())
+		for _, message := range messages {
+			Expect(message).To(ContainSubstring(listener.URL))
+			Expect(message).ToNot(ContainSubstring("mysecret"))
+		}
+	})
+
+	It("Doesn't log the path of the URL when the webhook fails", func() {
+		status = http.StatusInternalServerError
+		rnnr := &Runner{
+			notifyURL: listener.URL + "/hooks/mysecret",
+		}
+		rnnr.notify(&RunSummary{})
+		messages := logged()
+		Expect(messages).To(ContainElement(ContainSubstring("status code 500")))
+		for _, message := range messages {
+			Expect(message).ToNot(ContainSubstring("mysecret"))
+		}
+	})
+
+	It("Doesn't log the path of the URL when the connection fails", func() {
+		address := listener.URL
+		listener.Close()
+		rnnr := &Runner{
+			notifyURL: address + "/hooks/mysecret",
+		}
+		rnnr.notify(&RunSummary{})
+		entries := hook.AllEntries()
+		Expect(entries).ToNot(BeEmpty())
+		Expect(entries[len(entries)-1].Level).To(Equal(log.ErrorLevel))
+		for _, message := range logged() {
+			Expect(message).ToNot(ContainSubstring("mysecret"))
+		}
+	})
+})
@@ -0,0 +1,244 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that runs plans of ordered steps described in a manifest file.
+
+package runner
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+// planManifest is the content of the file that describes a plan. For example:
+//
+//	{
+//	  "stop_on_failure": true,
+//	  "steps": [
+//	    {
+//	      "binary": "setup.test"
+//	    },
+//	    {
+//	      "binary": "main.test",
+//	      "args": ["-test.v"],
+//	      "env": {
+//	        "MYVAR": "myvalue"
+//	      },
+//	      "timeout": "5m",
+//	      "parallel": 2
+//	    },
+//	    {
+//	      "binary": "verify.test"
+//	    }
+//	  ]
+//	}
+type planManifest struct {
+	StopOnFailure bool        `json:"stop_on_failure,omitempty"`
+	Steps         []*planStep `json:"steps,omitempty"`
+}
+
+// planStep is the description of one step of the plan manifest. Relative paths of binaries are
+// relative to the directory that contains the manifest file.
+type planStep struct {
+	Binary   string            `json:"binary,omitempty"`
+	Args     []string          `json:"args,omitempty"`
+	Env      map[string]string `json:"env,omitempty"`
+	Timeout  string            `json:"timeout,omitempty"`
+	Parallel int               `json:"parallel,omitempty"`
+}
+
+// Plan sets the name of a manifest file, in JSON format, that describes a plan: an ordered list of
+// test binaries that the server runs one after the other in the same directory, so that they can
+// share state, for example a setup binary, the main test and a verification binary. The plan is
+// run instead of the test binaries found in directories, so it can't be used together with
+// directories or binaries, and it requires disabling compilation. Note that the complete plan is
+// sent to the server in one request, so all the steps need to finish before the timeout.
+func (b *RunnerBuilder) Plan(value string) *RunnerBuilder {
+	b.plan = value
+	return b
+}
+
+// loadPlan loads the plan manifest from the given file, checking that it is valid.
+func loadPlan(path string) (manifest *planManifest, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("can't read plan file '%s': %v", path, err)
+		return
+	}
+	manifest = &planManifest{}
+	err = json.Unmarshal(data, manifest)
+	if err != nil {
+		err = fmt.Errorf("can't parse plan file '%s': %v", path, err)
+		return
+	}
+	if len(manifest.Steps) == 0 {
+		err = fmt.Errorf("plan file '%s' doesn't contain any step", path)
+		return
+	}
+	base := filepath.Dir(path)
+	for i, step := range manifest.Steps {
+		if step == nil || step.Binary == "" {
+			err = fmt.Errorf("step %d of plan file '%s' doesn't have a binary", i+1, path)
+			return
+		}
+		if !filepath.IsAbs(step.Binary) {
+			step.Binary = filepath.Join(base, step.Binary)
+		}
+		if step.Timeout != "" {
+			var timeout time.Duration
+			timeout, err = time.ParseDuration(step.Timeout)
+			if err != nil || timeout <= 0 {
+				err = fmt.Errorf(
+					"timeout '%s' of step %d of plan file '%s' isn't a valid "+
+						"positive duration",
+					step.Timeout, i+1, path,
+				)
+				return
+			}
+		}
+		if step.Parallel < 0 {
+			err = fmt.Errorf(
+				"parallelism %d of step %d of plan file '%s' can't be negative",
+				step.Parallel, i+1, path,
+			)
+			return
+		}
+	}
+	return
+}
+
+// runPlan sends the plan to the server and adds the results of its steps to the given summary. It
+// returns the number of steps that failed.
+func (r *Runner) runPlan(ctx context.Context, summary *RunSummary) (failed int, err error) {
+	// Check that the server supports plans:
+	sender, ok := r.sender.(planSender)
+	if !ok || !r.capabilities.Plans {
+		err = fmt.Errorf("server doesn't support plans")
+		return
+	}
+	summary.Binaries = len(r.plan.Steps)
+
+	// Upload the fixtures:
+	err = r.uploadFixtures()
+	if err != nil {
+		return
+	}
+
+	// Prepare the request:
+	request := &api.Plan{
+		StopOnFailure: r.plan.StopOnFailure,
+		Steps:         make([]api.Test, len(r.plan.Steps)),
+	}
+	for i, step := range r.plan.Steps {
+		var data []byte
+		data, err = ioutil.ReadFile(step.Binary)
+		if err != nil {
+			err = fmt.Errorf("can't read test binary from file '%s': %v", step.Binary, err)
+			return
+		}
+		sum := sha256.Sum256(data)
+		args := step.Args
+		parallel := step.Parallel
+		if parallel == 0 {
+			parallel = r.testParallel
+		}
+		if parallel > 0 {
+			args = append([]string{fmt.Sprintf("-test.parallel=%d", parallel)}, args...)
+		}
+		request.Steps[i] = api.Test{
+			Checksum: hex.EncodeToString(sum[:]),
+			Args:     args,
+			Env:      step.Env,
+			Timeout:  step.Timeout,
+			Deadline: r.deadline,
+		}
+		if r.jsonEvents {
+			request.Steps[i].JSONEvents = true
+			request.Steps[i].Package = binaryPackage(step.Binary)
+		}
+		if r.deadline && step.Timeout == "" {
+			request.Steps[i].Timeout = r.binaryTimeout().String()
+		}
+		for _, fixture := range r.fixtures {
+			request.Steps[i].Fixtures = append(
+				request.Steps[i].Fixtures,
+				filepath.Base(fixture),
+			)
+		}
+		request.Steps[i].OutputGlobs = r.outputGlobs
+		err = r.attachBinary(ctx, step.Binary, data, &request.Steps[i])
+		if err != nil {
+			return
+		}
+	}
+
+	// Send the plan:
+	log.Infof("Running plan with %d steps", len(r.plan.Steps))
+	response, err := sender.SendPlan(ctx, request)
+	if err != nil {
+		err = fmt.Errorf("can't send plan: %v", err)
+		return
+	}
+
+	// Report the results of the steps. The steps that weren't executed because a previous one
+	// failed aren't included in the response.
+	for i, step := range r.plan.Steps {
+		result := &BinaryResult{
+			Binary: step.Binary,
+		}
+		summary.Results = append(summary.Results, result)
+		if i >= len(response.Steps) {
+			log.Infof("Step %d with test binary '%s' wasn't executed", i+1, step.Binary)
+			result.Skipped = true
+			continue
+		}
+		stepResponse := &response.Steps[i]
+		log.Infof("Results of step %d of plan follow", i+1)
+		r.report(step.Binary, stepResponse)
+		r.saveArtifacts(
+			step.Binary,
+			fmt.Sprintf("%d-%s", i+1, filepath.Base(step.Binary)),
+			stepResponse,
+		)
+		result.Attempts = 1
+		result.Code = stepResponse.Code
+		result.TimedOut = stepResponse.TimedOut
+		result.Signal = stepResponse.Signal
+		result.Truncated = stepResponse.Truncated
+		if stepResponse.Code == 0 {
+			summary.Passed++
+			result.Passed = true
+		} else {
+			failed++
+		}
+	}
+	if response.Dir != "" {
+		log.Infof("Files of plan have been preserved in server directory '%s'", response.Dir)
+		summary.Dirs = append(summary.Dirs, response.Dir)
+	}
+
+	return
+}
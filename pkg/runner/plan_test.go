@@ -0,0 +1,207 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ = Describe("Plan", func() {
+	var tmp string
+	var sender *fakeSender
+	var rnnr *Runner
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "plan")
+		Expect(err).ToNot(HaveOccurred())
+		sender = &fakeSender{
+			responses: map[string][]*api.Test{},
+		}
+		rnnr = &Runner{
+			timeout: time.Minute,
+			sender:  sender,
+			capabilities: &api.Capabilities{
+				Plans: true,
+			},
+		}
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// write writes a file with the given name and content to the temporary directory, and
+	// returns its path.
+	write := func(name, content string) string {
+		path := filepath.Join(tmp, name)
+		err := ioutil.WriteFile(path, []byte(content), 0755)
+		Expect(err).ToNot(HaveOccurred())
+		return path
+	}
+
+	// prepare creates a test binary with the given name and prepares the responses that the
+	// sender will return for it, like in the tests of the Run method.
+	prepare := func(name string, responses ...*api.Test) {
+		write(name+".test", name)
+		sender.responses[name] = responses
+	}
+
+	It("Resolves binaries relative to the manifest", func() {
+		path := write("plan.json", `{
+			"steps": [
+				{ "binary": "setup.test" },
+				{ "binary": "/abs/main.test", "timeout": "5m" }
+			]
+		}`)
+		manifest, err := loadPlan(path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(manifest.Steps).To(HaveLen(2))
+		Expect(manifest.Steps[0].Binary).To(Equal(filepath.Join(tmp, "setup.test")))
+		Expect(manifest.Steps[1].Binary).To(Equal("/abs/main.test"))
+	})
+
+	It("Rejects manifest without steps", func() {
+		path := write("plan.json", `{}`)
+		_, err := loadPlan(path)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Rejects step without binary", func() {
+		path := write("plan.json", `{ "steps": [ {} ] }`)
+		_, err := loadPlan(path)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Rejects step with invalid timeout", func() {
+		path := write("plan.json", `{ "steps": [ { "binary": "a.test", "timeout": "junk" } ] }`)
+		_, err := loadPlan(path)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Runs the steps of the plan", func() {
+		prepare("setup", &api.Test{Code: 0})
+		prepare("main", &api.Test{Code: 1})
+		prepare("verify", &api.Test{Code: 0})
+		manifest, err := loadPlan(write("plan.json", `{
+			"steps": [
+				{ "binary": "setup.test" },
+				{ "binary": "main.test", "env": { "MYVAR": "myvalue" } },
+				{ "binary": "verify.test" }
+			]
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+		rnnr.plan = manifest
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Binaries).To(Equal(3))
+		Expect(summary.Passed).To(Equal(2))
+		Expect(summary.Failed).To(Equal(1))
+		Expect(summary.Results).To(HaveLen(3))
+		Expect(summary.Results[1].Code).To(Equal(1))
+		Expect(sender.requests).To(HaveLen(3))
+		Expect(sender.requests[1].Env).To(HaveKeyWithValue("MYVAR", "myvalue"))
+	})
+
+	It("Rejects step with negative parallelism", func() {
+		path := write("plan.json", `{ "steps": [ { "binary": "a.test", "parallel": -1 } ] }`)
+		_, err := loadPlan(path)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Uses the parallelism of the step instead of the one of the runner", func() {
+		prepare("setup", &api.Test{Code: 0})
+		prepare("main", &api.Test{Code: 0})
+		manifest, err := loadPlan(write("plan.json", `{
+			"steps": [
+				{ "binary": "setup.test", "args": ["-test.v"] },
+				{ "binary": "main.test", "args": ["-test.v"], "parallel": 1 }
+			]
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+		rnnr.plan = manifest
+		rnnr.testParallel = 4
+		_, err = rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.requests).To(HaveLen(2))
+		Expect(sender.requests[0].Args).To(Equal([]string{"-test.parallel=4", "-test.v"}))
+		Expect(sender.requests[1].Args).To(Equal([]string{"-test.parallel=1", "-test.v"}))
+	})
+
+	It("Uses the runner timeout for steps without one when deadline is enabled", func() {
+		prepare("setup", &api.Test{Code: 0})
+		prepare("main", &api.Test{Code: 0})
+		manifest, err := loadPlan(write("plan.json", `{
+			"steps": [
+				{ "binary": "setup.test" },
+				{ "binary": "main.test", "timeout": "5m" }
+			]
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+		rnnr.plan = manifest
+		rnnr.deadline = true
+		rnnr.capabilities.Deadline = true
+		_, err = rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.requests).To(HaveLen(2))
+		Expect(sender.requests[0].Timeout).To(Equal("54s"))
+		Expect(sender.requests[0].Deadline).To(BeTrue())
+		Expect(sender.requests[1].Timeout).To(Equal("5m"))
+		Expect(sender.requests[1].Deadline).To(BeTrue())
+	})
+
+	It("Reports skipped steps when stopping on failure", func() {
+		prepare("setup", &api.Test{Code: 1})
+		prepare("main", &api.Test{Code: 0})
+		manifest, err := loadPlan(write("plan.json", `{
+			"stop_on_failure": true,
+			"steps": [
+				{ "binary": "setup.test" },
+				{ "binary": "main.test" }
+			]
+		}`))
+		Expect(err).ToNot(HaveOccurred())
+		rnnr.plan = manifest
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Failed).To(Equal(1))
+		Expect(summary.Results).To(HaveLen(2))
+		Expect(summary.Results[0].Passed).To(BeFalse())
+		Expect(summary.Results[1].Skipped).To(BeTrue())
+	})
+
+	It("Fails if the server doesn't support plans", func() {
+		prepare("setup", &api.Test{Code: 0})
+		manifest, err := loadPlan(write("plan.json", `{ "steps": [ { "binary": "setup.test" } ] }`))
+		Expect(err).ToNot(HaveOccurred())
+		rnnr.plan = manifest
+		rnnr.capabilities = &api.Capabilities{}
+		_, err = rnnr.RunAll(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(sender.requests).To(BeEmpty())
+	})
+})
@@ -0,0 +1,70 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that records the result of the run in the annotations of the
+// project.
+
+package runner
+
+import (
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+// markResult records the result of the run in the annotations of the project, so that the tools
+// that watch projects, like the 'list' command, can show it without parsing the logs. This is
+// only done when the project is kept, as otherwise it is deleted right after the run. Errors are
+// logged but not returned, as they shouldn't change the result of the run.
+func (r *Runner) markResult(summary *RunSummary) {
+	if !r.keep {
+		return
+	}
+	err := r.updateResult(summary)
+	if err != nil {
+		log.Warnf("Can't record result in project '%s': %v", r.project, err)
+	}
+}
+
+// updateResult updates the annotations of the project with the result contained in the given
+// summary.
+func (r *Runner) updateResult(summary *RunSummary) error {
+	status := internal.ResultPassed
+	switch {
+	case summary.Error != "":
+		status = internal.ResultError
+	case summary.Failed > 0:
+		status = internal.ResultFailed
+	}
+	projects := r.projectV1.Projects()
+	project, err := projects.Get(r.project, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if project.Annotations == nil {
+		project.Annotations = map[string]string{}
+	}
+	project.Annotations[internal.ResultAnnotation] = status
+	project.Annotations[internal.ResultAtAnnotation] = time.Now().UTC().Format(time.RFC3339)
+	project.Annotations[internal.ResultBinariesAnnotation] = strconv.Itoa(summary.Binaries)
+	project.Annotations[internal.ResultFailedAnnotation] = strconv.Itoa(summary.Failed)
+	_, err = projects.Update(project)
+	return err
+}
@@ -0,0 +1,65 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that deletes the objects created while provisioning the project
+// when provisioning fails.
+
+package runner
+
+import (
+	log "github.com/sirupsen/logrus"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// rollback remembers the objects created while provisioning the project, so that they can be
+// deleted if provisioning fails. Objects that already existed, for example because the project is
+// being reused, aren't remembered, so they are never deleted.
+type rollback struct {
+	steps []*rollbackStep
+}
+
+// rollbackStep contains the details needed to delete one object.
+type rollbackStep struct {
+	kind   string
+	name   string
+	delete func(name string, options *metav1.DeleteOptions) error
+}
+
+// add remembers that an object of the given kind and name has been created, together with the
+// function that deletes it, usually the Delete method of the client for that kind of object.
+func (r *rollback) add(kind, name string, delete func(string, *metav1.DeleteOptions) error) {
+	r.steps = append(r.steps, &rollbackStep{
+		kind:   kind,
+		name:   name,
+		delete: delete,
+	})
+}
+
+// run deletes the remembered objects in the reverse order that they were created. Failures are
+// logged but they don't stop the deletion of the rest of the objects, as this is used when
+// something has already failed and that is the error that should be reported.
+func (r *rollback) run() {
+	for i := len(r.steps) - 1; i >= 0; i-- {
+		step := r.steps[i]
+		log.Infof("Deleting %s '%s' because provisioning failed", step.kind, step.name)
+		err := step.delete(step.name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			log.Errorf("Can't delete %s '%s': %v", step.kind, step.name, err)
+		}
+	}
+	r.steps = nil
+}
@@ -0,0 +1,324 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	projectv1 "github.com/openshift/api/project/v1"
+	userv1 "github.com/openshift/api/user/v1"
+	projectfake "github.com/openshift/client-go/project/clientset/versioned/fake"
+	routefake "github.com/openshift/client-go/route/clientset/versioned/fake"
+	userfake "github.com/openshift/client-go/user/clientset/versioned/fake"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	clienttesting "k8s.io/client-go/testing"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+var _ = Describe("Rollback", func() {
+	It("Deletes the objects in reverse order", func() {
+		var deleted []string
+		remember := func(name string, options *metav1.DeleteOptions) error {
+			deleted = append(deleted, name)
+			return nil
+		}
+		created := &rollback{}
+		created.add("secret", "a", remember)
+		created.add("pod", "b", remember)
+		created.add("service", "c", remember)
+		created.run()
+		Expect(deleted).To(Equal([]string{"c", "b", "a"}))
+	})
+
+	It("Continues after a failed deletion", func() {
+		var deleted []string
+		remember := func(name string, options *metav1.DeleteOptions) error {
+			deleted = append(deleted, name)
+			return nil
+		}
+		fail := func(name string, options *metav1.DeleteOptions) error {
+			return fmt.Errorf("injected failure")
+		}
+		created := &rollback{}
+		created.add("secret", "a", remember)
+		created.add("pod", "b", fail)
+		created.run()
+		Expect(deleted).To(Equal([]string{"a"}))
+	})
+
+	Describe("Provisioning", func() {
+		var (
+			core     *fake.Clientset
+			projects *projectfake.Clientset
+			routes   *routefake.Clientset
+			builder  *RunnerBuilder
+		)
+
+		BeforeEach(func() {
+			core = fake.NewSimpleClientset()
+			routes = routefake.NewSimpleClientset()
+			users := userfake.NewSimpleClientset(&userv1.User{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "~",
+				},
+			})
+
+			// The fake doesn't know that creating a project request creates the project, so
+			// we add the project in advance and pretend that the request succeeded:
+			project := &projectv1.Project{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "myproject",
+				},
+			}
+			projects = projectfake.NewSimpleClientset(project)
+			projects.PrependReactor(
+				"create", "projectrequests",
+				func(action clienttesting.Action) (bool, runtime.Object, error) {
+					return true, project, nil
+				},
+			)
+
+			// Make the creation of the route fail, which happens after creating almost all
+			// the other objects:
+			routes.PrependReactor(
+				"create", "routes",
+				func(action clienttesting.Action) (bool, runtime.Object, error) {
+					return true, nil, fmt.Errorf("injected failure")
+				},
+			)
+
+			builder = NewRunner()
+			builder.reuse = "myproject"
+			builder.preflight = false
+			builder.coreV1 = core.CoreV1()
+			builder.rbacV1 = core.RbacV1()
+			builder.projectV1 = projects.ProjectV1()
+			builder.routeV1 = routes.RouteV1()
+			builder.userV1 = users.UserV1()
+		})
+
+		It("Deletes the created objects when provisioning fails", func() {
+			err := builder.provision()
+			Expect(err).To(MatchError("injected failure"))
+			project := builder.project
+
+			// Check that the project and the objects inside it have been deleted:
+			_, err = projects.ProjectV1().Projects().Get(project, metav1.GetOptions{})
+			Expect(err).To(HaveOccurred())
+			pods, err := core.CoreV1().Pods(project).List(metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pods.Items).To(BeEmpty())
+			services, err := core.CoreV1().Services(project).List(metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(services.Items).To(BeEmpty())
+			secrets, err := core.CoreV1().Secrets(project).List(metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(secrets.Items).To(BeEmpty())
+			accounts, err := core.CoreV1().ServiceAccounts(project).List(metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(accounts.Items).To(BeEmpty())
+			bindings, err := core.RbacV1().RoleBindings(project).List(metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(bindings.Items).To(BeEmpty())
+		})
+
+		It("Keeps the created objects when the project should be kept", func() {
+			builder.keep = true
+			err := builder.provision()
+			Expect(err).To(MatchError("injected failure"))
+			project := builder.project
+
+			// Check that the project and the server are still there:
+			_, err = projects.ProjectV1().Projects().Get(project, metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			pods, err := core.CoreV1().Pods(project).List(metav1.ListOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pods.Items).ToNot(BeEmpty())
+		})
+
+		Describe("Reusing a project that already exists", func() {
+			BeforeEach(func() {
+				projects.PrependReactor(
+					"create", "projectrequests",
+					func(action clienttesting.Action) (bool, runtime.Object, error) {
+						return true, nil, errors.NewAlreadyExists(
+							projectv1.Resource("projectrequests"),
+							"myproject",
+						)
+					},
+				)
+			})
+
+			It("Doesn't create the cleaner", func() {
+				err := builder.provision()
+				Expect(err).To(MatchError("injected failure"))
+				Expect(builder.createdProject).To(BeFalse())
+				for _, action := range core.Actions() {
+					create, ok := action.(clienttesting.CreateAction)
+					if !ok {
+						continue
+					}
+					object, err := meta.Accessor(create.GetObject())
+					Expect(err).ToNot(HaveOccurred())
+					Expect(object.GetName()).ToNot(Equal(cleanerApp))
+				}
+			})
+
+			It("Doesn't add the labels and annotations of the runner", func() {
+				err := builder.provision()
+				Expect(err).To(MatchError("injected failure"))
+				project, err := projects.ProjectV1().Projects().Get(
+					"myproject", metav1.GetOptions{},
+				)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(project.Labels).ToNot(HaveKey(internal.AppLabel))
+				Expect(project.Annotations).ToNot(HaveKey(internal.CreatedAtAnnotation))
+				Expect(project.Annotations).ToNot(HaveKey(internal.OwnerAnnotation))
+			})
+
+			It("Adds the custom annotations", func() {
+				builder.annotations = map[string]string{
+					"example.com/team": "payments",
+				}
+				err := builder.provision()
+				Expect(err).To(MatchError("injected failure"))
+				project, err := projects.ProjectV1().Projects().Get(
+					"myproject", metav1.GetOptions{},
+				)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(project.Labels).ToNot(HaveKey(internal.AppLabel))
+				Expect(project.Annotations).To(
+					HaveKeyWithValue("example.com/team", "payments"),
+				)
+			})
+
+			It("Doesn't delete the project", func() {
+				err := builder.provision()
+				Expect(err).To(MatchError("injected failure"))
+				rnnr := &Runner{
+					project:        builder.project,
+					projectV1:      projects.ProjectV1(),
+					createdProject: builder.createdProject,
+				}
+				err = rnnr.Destroy()
+				Expect(err).ToNot(HaveOccurred())
+				for _, action := range projects.Actions() {
+					Expect(action.GetVerb()).ToNot(Equal("delete"))
+				}
+				_, err = projects.ProjectV1().Projects().Get(
+					"myproject", metav1.GetOptions{},
+				)
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		It("Adds the labels and annotations of the runner to the project that it created", func() {
+			builder.keep = true
+			err := builder.provision()
+			Expect(err).To(MatchError("injected failure"))
+			project, err := projects.ProjectV1().Projects().Get("myproject", metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(project.Labels).To(HaveKeyWithValue(internal.AppLabel, internal.ProjectApp))
+			Expect(project.Annotations).To(HaveKey(internal.CreatedAtAnnotation))
+			Expect(project.Annotations).To(HaveKeyWithValue(internal.OwnerAnnotation, "~"))
+		})
+
+		It("Deletes the project that it created", func() {
+			rnnr := &Runner{
+				project:        "myproject",
+				projectV1:      projects.ProjectV1(),
+				createdProject: true,
+			}
+			err := rnnr.Destroy()
+			Expect(err).ToNot(HaveOccurred())
+			_, err = projects.ProjectV1().Projects().Get("myproject", metav1.GetOptions{})
+			Expect(errors.IsNotFound(err)).To(BeTrue())
+		})
+
+		It("Lets the server pod complete when it stops because it is idle", func() {
+			builder.keep = true
+			builder.serverIdleTimeout = 10 * time.Minute
+			err := builder.provision()
+			Expect(err).To(MatchError("injected failure"))
+			pod, err := core.CoreV1().Pods(builder.project).Get(serverApp, metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pod.Spec.RestartPolicy).To(Equal(corev1.RestartPolicyOnFailure))
+			command := pod.Spec.Containers[0].Command
+			Expect(command).To(ContainElement("--idle-timeout=10m0s"))
+		})
+
+		It("Doesn't pass the idle timeout to the server by default", func() {
+			builder.keep = true
+			err := builder.provision()
+			Expect(err).To(MatchError("injected failure"))
+			pod, err := core.CoreV1().Pods(builder.project).Get(serverApp, metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			for _, arg := range pod.Spec.Containers[0].Command {
+				Expect(arg).ToNot(HavePrefix("--idle-timeout"))
+			}
+		})
+
+		It("Replaces the pod of a server that has finished", func() {
+			pods := core.CoreV1().Pods("myproject")
+			_, err := pods.Create(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: serverApp,
+				},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodSucceeded,
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			builder.keep = true
+			err = builder.provision()
+			Expect(err).To(MatchError("injected failure"))
+			pod, err := pods.Get(serverApp, metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pod.Status.Phase).To(BeEmpty())
+			Expect(pod.Spec.Containers).ToNot(BeEmpty())
+		})
+
+		It("Keeps the pod of a server that is running", func() {
+			pods := core.CoreV1().Pods("myproject")
+			_, err := pods.Create(&corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: serverApp,
+				},
+				Status: corev1.PodStatus{
+					Phase: corev1.PodRunning,
+				},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			builder.keep = true
+			err = builder.provision()
+			Expect(err).To(MatchError("injected failure"))
+			pod, err := pods.Get(serverApp, metav1.GetOptions{})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(pod.Status.Phase).To(Equal(corev1.PodRunning))
+		})
+	})
+})
@@ -19,16 +19,25 @@ limitations under the License.
 package runner
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -37,16 +46,22 @@ import (
 	routev1 "github.com/openshift/api/route/v1"
 	projectv1client "github.com/openshift/client-go/project/clientset/versioned/typed/project/v1"
 	routev1client "github.com/openshift/client-go/route/clientset/versioned/typed/route/v1"
+	userv1client "github.com/openshift/client-go/user/clientset/versioned/typed/user/v1"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/discovery"
+	authorizationv1client "k8s.io/client-go/kubernetes/typed/authorization/v1"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	rbacv1client "k8s.io/client-go/kubernetes/typed/rbac/v1"
+	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
+	"k8s.io/utils/pointer"
 
 	"github.com/jhernand/sandbox/pkg/api"
 	"github.com/jhernand/sandbox/pkg/internal"
@@ -56,56 +71,225 @@ import (
 // instances of this type directly; use the NewRunner function instead.
 type RunnerBuilder struct {
 	// Compilation options:
-	compile   bool
-	recursive bool
-	dirs      []string
+	compile    bool
+	recursive  bool
+	dirs       []string
+	vet        string
+	buildCache string
+
+	// Test binaries to run, either given explicitly or found with a pattern:
+	binaries []string
+	pattern  string
+
+	// File containing the plan of ordered steps to run instead of the test binaries:
+	plan string
 
 	// Details to connect to the OpenShift API:
 	config   string
+	context  string
 	proxy    string
 	insecure bool
 
+	// Name of the cluster profile, used to label the summary:
+	profile string
+
 	// Name of the OpenShift project:
 	project string
 
 	// Kubernetes API clients:
-	coreV1    *corev1client.CoreV1Client
-	projectV1 *projectv1client.ProjectV1Client
-	rbacV1    *rbacv1client.RbacV1Client
-	routeV1   *routev1client.RouteV1Client
+	coreV1          corev1client.CoreV1Interface
+	projectV1       projectv1client.ProjectV1Interface
+	rbacV1          rbacv1client.RbacV1Interface
+	routeV1         routev1client.RouteV1Interface
+	userV1          userv1client.UserV1Interface
+	authorizationV1 authorizationv1client.AuthorizationV1Interface
+	discovery       *discovery.DiscoveryClient
+
+	// Objects created while provisioning the project, deleted if provisioning fails:
+	created *rollback
 
 	// Details of the server:
 	server *Server
 
 	// Flag indicating if the OpenShift project should be preserved when the runner is destroyed:
 	keep bool
+
+	// Flag indicating if the OpenShift project was created by the runner. Projects that already
+	// existed are reused, and they are never deleted.
+	createdProject bool
+
+	// Flag indicating if the root file system of the server should be read only:
+	readOnly bool
+
+	// Name of an existing project that should be reused:
+	reuse string
+
+	// Custom annotations added to the project:
+	annotations map[string]string
+
+	// Flag indicating if permissions should be checked before creating objects:
+	preflight bool
+
+	// Maximum time that the execution of a test binary can take:
+	timeout time.Duration
+
+	// Flag indicating if the route should use passthrough TLS termination:
+	passthrough bool
+
+	// Flag indicating if the runner shouldn't wait for the route to be admitted:
+	skipRouteWait bool
+
+	// File containing the CA certificates used to verify the certificate of the route of the
+	// server, and the pool loaded from it:
+	caCert string
+	caPool *x509.CertPool
+
+	// Path prefix of the URLs of the server:
+	basePath string
+
+	// Images used by the server and the cleaner, and by the database servers that the tests
+	// create:
+	image   string
+	dbImage string
+
+	// Policy used to pull the image of the server and the cleaner, and flag indicating if the
+	// image should be pulled by a warm-up pod before creating them:
+	pullPolicy corev1.PullPolicy
+	warmup     bool
+
+	// DNS settings of the pods of the server and the cleaner:
+	dnsPolicy corev1.DNSPolicy
+	dnsConfig *corev1.PodDNSConfig
+
+	// Time after which the server stops if it doesn't receive requests:
+	serverIdleTimeout time.Duration
+
+	// Settings of the pool of connections to the server:
+	maxIdleConns    int
+	idleConnTimeout time.Duration
+	keepAlives      bool
+
+	// Test execution options:
+	shuffle      string
+	testParallel int
+	retryFailed  int
+	flakyPass    bool
+	failFast     bool
+	failNoTests  bool
+	fixtures     []string
+	quiet        bool
+	deadline     bool
+	jsonEvents   bool
+
+	// Patterns of the files produced by the test binaries that the server returns, and local
+	// directory where they are written:
+	outputGlobs []string
+	artifactDir string
+
+	// Size above which test binaries are uploaded in chunks:
+	uploadMin int64
+
+	// Labels of the directories, and labels used to select them:
+	labels     map[string][]string
+	onlyLabels []string
+	skipLabels []string
+
+	// Notification webhook:
+	notifyURL    string
+	notifySecret string
+
+	// Constraint for the version of Go used to compile the tests:
+	goRequire string
 }
 
 // Runner is the test runner.
 type Runner struct {
 	// Compilation options:
-	compile   bool
-	recursive bool
-	dirs      []string
+	compile    bool
+	recursive  bool
+	dirs       []string
+	vet        string
+	buildCache string
+
+	// Test binaries to run, either given explicitly or found with a pattern:
+	binaries []string
+	pattern  string
+
+	// Plan of ordered steps to run instead of the test binaries:
+	plan *planManifest
+
+	// Name of the cluster profile, used to label the summary:
+	profile string
+
+	// Test execution options:
+	timeout      time.Duration
+	shuffle      string
+	testParallel int
+	retryFailed  int
+	flakyPass    bool
+	failFast     bool
+	failNoTests  bool
+	fixtures     []string
+	quiet        bool
+	deadline     bool
+	jsonEvents   bool
+
+	// Patterns of the files produced by the test binaries that the server returns, and local
+	// directory where they are written:
+	outputGlobs []string
+	artifactDir string
+
+	// Size above which test binaries are uploaded in chunks:
+	uploadMin int64
+
+	// Labels of the directories, and labels used to select them:
+	labels     map[string][]string
+	onlyLabels []string
+	skipLabels []string
+
+	// Notification webhook:
+	notifyURL    string
+	notifySecret string
+
+	// Constraint for the version of Go used to compile the tests, and the version that was
+	// actually used:
+	goRequire *goConstraint
+	goVersion string
 
 	// Name of the OpenShift project:
 	project string
 
 	// Kubernetes API clients:
-	projectV1 *projectv1client.ProjectV1Client
+	projectV1 projectv1client.ProjectsGetter
 
 	// Details of the server:
 	server *Server
 
+	// Object used to send the test binaries to the server, and capabilities of the server:
+	sender       Sender
+	capabilities *api.Capabilities
+
 	// Flag indicating if the OpenShift project should be preserved when the runner is destroyed:
 	keep bool
+
+	// Flag indicating if the OpenShift project was created by the runner:
+	createdProject bool
 }
 
 // NewRunner creates a new object that knows how to build test runners.
 func NewRunner() *RunnerBuilder {
 	return &RunnerBuilder{
-		compile:   true,
-		recursive: false,
+		compile:         true,
+		recursive:       false,
+		pattern:         defaultBinaryPattern,
+		preflight:       true,
+		failNoTests:     true,
+		timeout:         defaultTimeout,
+		pullPolicy:      corev1.PullAlways,
+		maxIdleConns:    defaultMaxIdleConns,
+		idleConnTimeout: defaultIdleConnTimeout,
+		keepAlives:      true,
+		uploadMin:       defaultUploadThreshold,
 	}
 }
 
@@ -116,6 +300,21 @@ func (b *RunnerBuilder) Config(value string) *RunnerBuilder {
 	return b
 }
 
+// ConfigContext sets the name of the context of the configuration file that will be used to
+// connect to the OpenShift API. If not set the current context of the file is used.
+func (b *RunnerBuilder) ConfigContext(value string) *RunnerBuilder {
+	b.context = value
+	return b
+}
+
+// Profile sets the name of the cluster profile that the runner is used for, when the same tests
+// are run against several clusters. It is copied to the summary of the run, so that the results
+// of the different clusters can be told apart. The default is empty.
+func (b *RunnerBuilder) Profile(value string) *RunnerBuilder {
+	b.profile = value
+	return b
+}
+
 // Proxy sets the URL of the proxy server that will be used to connect to the OpenShift API.
 func (b *RunnerBuilder) Proxy(value string) *RunnerBuilder {
 	b.proxy = value
@@ -130,12 +329,33 @@ func (b *RunnerBuilder) Insecure(value bool) *RunnerBuilder {
 	return b
 }
 
+// CACert sets the file containing the PEM encoded CA certificates that will be used to verify the
+// certificate presented by the route of the server, instead of the CA certificates of the system.
+// This is useful when the router of the cluster uses a certificate signed by a private CA, as it
+// avoids disabling the verification with the Insecure option.
+func (b *RunnerBuilder) CACert(value string) *RunnerBuilder {
+	b.caCert = value
+	return b
+}
+
 // Compile indicates if the test binaries should be compiled. The default value is true.
 func (b *RunnerBuilder) Compile(value bool) *RunnerBuilder {
 	b.compile = value
 	return b
 }
 
+// BuildCache sets the directory that will be used to keep the Go build and module caches used by
+// the 'go test -c ...' command. The build cache is stored in the 'build' subdirectory and passed
+// in the GOCACHE environment variable, and the module cache is stored in the 'mod' subdirectory
+// and passed in the GOMODCACHE environment variable. The directories are created if they don't
+// exist. Reusing the same directory in repeated runs avoids compiling again the dependencies that
+// didn't change, which is useful in CI environments where the default cache of the user isn't
+// preserved. The default is empty, which means that the default caches of Go are used.
+func (b *RunnerBuilder) BuildCache(value string) *RunnerBuilder {
+	b.buildCache = value
+	return b
+}
+
 // Recursive indicates if the given package names should be recursively scanned looking for all the
 // test suites. The default value is false.
 func (b *RunnerBuilder) Recursive(value bool) *RunnerBuilder {
@@ -161,17 +381,587 @@ func (b *RunnerBuilder) Keep(value bool) *RunnerBuilder {
 	return b
 }
 
+// ReadOnly indicates if the root file system of the server should be read only. When this is
+// true the server, and the tests that it runs, will only be able to write to the working
+// directory. The default is false.
+func (b *RunnerBuilder) ReadOnly(value bool) *RunnerBuilder {
+	b.readOnly = value
+	return b
+}
+
+// Reuse sets the name of a project that should be reused. If the project already exists and it
+// contains a server then the runner will send the tests to that server instead of creating a new
+// project and a new server. A project that already exists is never deleted by the runner, and the
+// cleaner isn't installed in it. If the project doesn't exist it will be created with that name,
+// and it can then be reused by other runners, in particular if the Keep option is also used.
+func (b *RunnerBuilder) Reuse(value string) *RunnerBuilder {
+	b.reuse = value
+	return b
+}
+
+// Preflight indicates if the runner should check that the user has the permissions needed to
+// create the project and the objects inside it before trying to create them. This turns the
+// authorization errors that would happen in the middle of the creation into clear errors that
+// explain what permission is missing. The default is true.
+func (b *RunnerBuilder) Preflight(value bool) *RunnerBuilder {
+	b.preflight = value
+	return b
+}
+
+// Timeout sets the maximum time that the execution of a test binary can take, including the time
+// to send it to the server and to receive the results. This is used as the timeout of the route
+// that exposes the server, so that the OpenShift router doesn't cut the connection before. The
+// HTTP client used to send the tests has a slightly larger timeout, so that a route that
+// stops responding doesn't block the runner forever. The server kills the test binaries that
+// don't finish in nine tenths of this time, leaving the rest for the transfer of the binary and
+// the results, so that the output that they generated before is still reported. The default is
+// ten minutes.
+func (b *RunnerBuilder) Timeout(value time.Duration) *RunnerBuilder {
+	b.timeout = value
+	return b
+}
+
+// MaxIdleConns sets the maximum number of idle connections to the server that will be kept open
+// for reuse by later requests. The default is 10.
+func (b *RunnerBuilder) MaxIdleConns(value int) *RunnerBuilder {
+	b.maxIdleConns = value
+	return b
+}
+
+// IdleConnTimeout sets the time that an idle connection to the server will be kept open for reuse
+// by later requests. The default is 90 seconds.
+func (b *RunnerBuilder) IdleConnTimeout(value time.Duration) *RunnerBuilder {
+	b.idleConnTimeout = value
+	return b
+}
+
+// KeepAlives indicates if connections to the server should be kept open and reused by later
+// requests. Disabling this makes the runner open a new connection for each request, which is
+// slower, but can help with proxies or load balancers that close idle connections without
+// notice. The default is true.
+func (b *RunnerBuilder) KeepAlives(value bool) *RunnerBuilder {
+	b.keepAlives = value
+	return b
+}
+
+// ServerIdleTimeout sets the time after which the server stops if it doesn't receive requests.
+// The pod of the server then completes and releases its resources, without waiting for the
+// project to be deleted. This is intended for servers that are used only for one run, as a
+// project that is reused later gets a new server. The default is zero, which means that the
+// server never stops.
+func (b *RunnerBuilder) ServerIdleTimeout(value time.Duration) *RunnerBuilder {
+	b.serverIdleTimeout = value
+	return b
+}
+
+// BinaryPattern sets the glob pattern used to find the test binaries in the current directory,
+// after compiling them, if needed. This is useful when the test binaries were compiled manually,
+// with the Compile option set to false, and have non standard names. The default is '*.test',
+// which matches the names of the binaries generated by the 'go test -c ...' command.
+func (b *RunnerBuilder) BinaryPattern(value string) *RunnerBuilder {
+	b.pattern = value
+	return b
+}
+
+// Binaries adds test binaries that will be sent to the server. When binaries are added the
+// pattern set with BinaryPattern isn't used, and the binaries don't need to be in the current
+// directory. This can only be used when the Compile option is false, as otherwise the runner
+// would compile binaries and then ignore them. When binaries are added the directories aren't
+// needed.
+func (b *RunnerBuilder) Binaries(values ...string) *RunnerBuilder {
+	b.binaries = append(b.binaries, values...)
+	return b
+}
+
+// Vet sets the value of the -vet flag that will be passed to the 'go test -c ...' command used to
+// compile the test binaries. It can be 'off' to disable the checks, or a comma separated list of
+// the names of the checks to run, for example 'atomic,bool'. This is useful when a false
+// positive of one of the checks prevents the compilation of the tests. The default is empty,
+// which means that the flag isn't passed and the default checks of Go are used.
+func (b *RunnerBuilder) Vet(value string) *RunnerBuilder {
+	b.vet = value
+	return b
+}
+
+// Shuffle sets the value of the -test.shuffle flag that will be passed to the test binaries. It
+// can be 'on' to shuffle the tests with a random seed, or a number to use that specific seed. The
+// seed used by each test binary is extracted from its output and reported, so that a failed run
+// can be reproduced later. The default is to not shuffle the tests.
+func (b *RunnerBuilder) Shuffle(value string) *RunnerBuilder {
+	b.shuffle = value
+	return b
+}
+
+// TestParallel sets the value of the -test.parallel flag that will be passed to the test binaries,
+// which is the maximum number of test functions of the same binary that run in parallel. It is
+// useful to limit tests that use many resources. Note that this doesn't change how many test
+// binaries run at the same time: the runner always sends them to the server one after the other.
+// Steps of plans can override it with the 'parallel' field of the manifest. The default is zero,
+// which means that the flag isn't passed and the binaries use the default of Go, the value of
+// GOMAXPROCS.
+func (b *RunnerBuilder) TestParallel(value int) *RunnerBuilder {
+	b.testParallel = value
+	return b
+}
+
+// RetryFailed sets the number of times that a test binary that fails will be executed again. If
+// it passes in one of these retries it is reported as flaky. The default is to not retry.
+func (b *RunnerBuilder) RetryFailed(value int) *RunnerBuilder {
+	b.retryFailed = value
+	return b
+}
+
+// FlakyPass indicates if the test binaries that fail but then pass when retried should be
+// considered successful. The default is false, so flaky binaries are reported as flaky and also
+// counted as failed.
+func (b *RunnerBuilder) FlakyPass(value bool) *RunnerBuilder {
+	b.flakyPass = value
+	return b
+}
+
+// FailFast indicates if the runner should stop running test binaries as soon as one of them fails.
+// When used together with RetryFailed a binary is only considered failed after all the retries
+// have been exhausted. The default is false.
+func (b *RunnerBuilder) FailFast(value bool) *RunnerBuilder {
+	b.failFast = value
+	return b
+}
+
+// Quiet indicates if the runner should omit the output and the details of the test binaries that
+// pass, writing them only for the binaries that fail, followed by a summary of the run at the
+// end. This is useful for continuous integration logs, where the output of hundreds of passing
+// binaries hides the ones that fail. The default is false.
+func (b *RunnerBuilder) Quiet(value bool) *RunnerBuilder {
+	b.quiet = value
+	return b
+}
+
+// Deadline indicates if the test binaries should receive the deadline calculated from their
+// timeout, as the '-test.timeout' flag and as the TEST_DEADLINE environment variable, in RFC 3339
+// format, so that they can adjust their internal timeouts to the limit enforced by the server.
+// The timeout of test binaries is derived from the value given with the Timeout method. The
+// steps of plans that have their own timeout in the manifest use it instead, and the steps that
+// don't use the timeout derived from the Timeout method. The default is false.
+func (b *RunnerBuilder) Deadline(value bool) *RunnerBuilder {
+	b.deadline = value
+	return b
+}
+
+// JSONEvents indicates if the runner should write, instead of the output of the test binaries, the
+// stream of JSON events generated by the 'go test -json' command, so that it can be processed by
+// tools that understand that format, like gotestsum. The server generates the events running
+// the binaries with the -test.v flag and converting their output with the test2json tool. If the
+// server doesn't have that tool the runner writes a warning and the output of the binaries as
+// usual. The default is false.
+func (b *RunnerBuilder) JSONEvents(value bool) *RunnerBuilder {
+	b.jsonEvents = value
+	return b
+}
+
+// FailOnNoTests indicates if the runner should fail when there are no directories containing
+// test files, or when there are no test binaries to run. This is usually caused by a mistake in
+// the directories given to the runner, and without this the run would succeed without running
+// any test. The default is true.
+func (b *RunnerBuilder) FailOnNoTests(value bool) *RunnerBuilder {
+	b.failNoTests = value
+	return b
+}
+
+// Fixtures adds files that will be uploaded to the server once, before running the test binaries,
+// and that will then be made available to all the test binaries. The test binaries will find them
+// in the directory indicated by the SANDBOX_FIXTURES environment variable, with the same name
+// that they have locally.
+func (b *RunnerBuilder) Fixtures(values ...string) *RunnerBuilder {
+	b.fixtures = append(b.fixtures, values...)
+	return b
+}
+
+// Passthrough indicates if the route that exposes the server should use passthrough TLS
+// termination instead of edge termination. With passthrough the server terminates TLS itself,
+// using the certificate that OpenShift generates for the service, and the connection between the
+// runner and the server can use HTTP/2. Note that with edge termination the OpenShift router may
+// downgrade the connection to HTTP/1. Note also that the certificate of the server is signed by
+// the service CA of the cluster, and issued for the name of the service, not of the route, so
+// it will not be accepted unless the Insecure option is also used. The default is false.
+func (b *RunnerBuilder) Passthrough(value bool) *RunnerBuilder {
+	b.passthrough = value
+	return b
+}
+
+// SkipRouteWait indicates if the runner should skip waiting for the route of the server to be
+// admitted. Instead it uses the host that the API server assigns to the route when it is created,
+// and polls the server through that host till it responds, for up to five minutes instead of
+// one. This avoids spurious failures in busy clusters where the router takes longer to admit the
+// route than to start forwarding requests. If the route doesn't have a host yet the runner waits
+// for the admission anyhow. The default is false.
+func (b *RunnerBuilder) SkipRouteWait(value bool) *RunnerBuilder {
+	b.skipRouteWait = value
+	return b
+}
+
+// BasePath sets the path prefix of the URLs of the server. The route that exposes the server will
+// only accept requests for that path, so that the host can be shared with other applications that
+// are routed by path. The value must start with a slash, for example '/sandbox'. It can't be used
+// together with passthrough TLS termination, as OpenShift doesn't support paths in those routes.
+// The default is empty, which means that the server receives requests for all the paths.
+func (b *RunnerBuilder) BasePath(value string) *RunnerBuilder {
+	b.basePath = strings.TrimRight(value, "/")
+	return b
+}
+
+// Image sets the image used to run the server and the cleaner, for example when the cluster can
+// only pull images from a mirror registry. If not set the value of the 'SANDBOX_IMAGE'
+// environment variable is used, and if that isn't set either the default is
+// 'quay.io/jhernand/sandbox'.
+func (b *RunnerBuilder) Image(value string) *RunnerBuilder {
+	b.image = value
+	return b
+}
+
+// DatabaseImage sets the image used by the sandboxes created by the tests to run the database
+// servers. It is passed to the tests in the 'SANDBOX_DB_IMAGE' environment variable of the server.
+// If not set the value of that variable in the environment of the runner is used, and if that
+// isn't set either the tests use the default image of each database engine.
+func (b *RunnerBuilder) DatabaseImage(value string) *RunnerBuilder {
+	b.dbImage = value
+	return b
+}
+
+// PullPolicy sets the policy used to pull the image of the server and of the cleaner. The default
+// is 'Always', so that changes to the image are always used. Use 'IfNotPresent' to avoid pulling
+// the image again in nodes that already have it.
+func (b *RunnerBuilder) PullPolicy(value corev1.PullPolicy) *RunnerBuilder {
+	b.pullPolicy = value
+	return b
+}
+
+// Warmup enables or disables the warm-up of the image of the server. When enabled the runner
+// first creates a short lived pod that only pulls the image, and fails with a message that
+// describes the problem as soon as the image can't be pulled, instead of waiting for the server
+// pod till the timeout expires. The default is to not do the warm-up.
+func (b *RunnerBuilder) Warmup(value bool) *RunnerBuilder {
+	b.warmup = value
+	return b
+}
+
+// DNSPolicy sets the DNS policy of the pods of the server and of the cleaner, for example 'None'
+// when the name servers are given explicitly with the DNSConfig method. The default is to use the
+// default policy of the cluster.
+func (b *RunnerBuilder) DNSPolicy(value corev1.DNSPolicy) *RunnerBuilder {
+	b.dnsPolicy = value
+	return b
+}
+
+// DNSConfig sets the DNS configuration, like name servers, search domains and resolver options,
+// of the pods of the server and of the cleaner. This is useful in clusters with custom DNS setups,
+// where the default resolution doesn't find the services that the tests need. The default is to
+// use the default configuration of the cluster.
+func (b *RunnerBuilder) DNSConfig(value corev1.PodDNSConfig) *RunnerBuilder {
+	b.dnsConfig = value.DeepCopy()
+	return b
+}
+
 // Build uses the information stored in the builder to create a new runner.
 func (b *RunnerBuilder) Build() (rnnr *Runner, err error) {
 	// Check parameters:
-	if len(b.dirs) == 0 {
-		err = fmt.Errorf("at least one directory must be provided")
+	if len(b.dirs) == 0 && len(b.binaries) == 0 && b.plan == "" {
+		err = fmt.Errorf("at least one directory, binary or plan must be provided")
+		return
+	}
+	if b.plan != "" && (len(b.dirs) > 0 || len(b.binaries) > 0) {
+		err = fmt.Errorf("plan can't be used together with directories or binaries")
+		return
+	}
+	if b.plan != "" && b.compile {
+		err = fmt.Errorf("plan can only be used when compilation is disabled")
+		return
+	}
+	if len(b.binaries) > 0 && b.compile {
+		err = fmt.Errorf("binaries can only be given explicitly when compilation is disabled")
+		return
+	}
+	if b.buildCache != "" && !b.compile {
+		err = fmt.Errorf("build cache can only be used when compilation is enabled")
+		return
+	}
+	_, err = filepath.Match(b.pattern, "")
+	if err != nil {
+		err = fmt.Errorf("binary pattern '%s' isn't valid: %v", b.pattern, err)
+		return
+	}
+	for _, binary := range b.binaries {
+		_, err = os.Stat(binary)
+		if err != nil {
+			err = fmt.Errorf("can't check binary '%s': %v", binary, err)
+			return
+		}
+	}
+	if b.maxIdleConns < 0 {
+		err = fmt.Errorf(
+			"maximum number of idle connections can't be negative, but it is %d",
+			b.maxIdleConns,
+		)
+		return
+	}
+	if b.idleConnTimeout < 0 {
+		err = fmt.Errorf(
+			"idle connection timeout can't be negative, but it is %s",
+			b.idleConnTimeout,
+		)
+		return
+	}
+	if b.timeout <= 0 {
+		err = fmt.Errorf("timeout must be positive, but it is %s", b.timeout)
+		return
+	}
+	if b.serverIdleTimeout < 0 {
+		err = fmt.Errorf(
+			"server idle timeout can't be negative, but it is %s",
+			b.serverIdleTimeout,
+		)
+		return
+	}
+	if b.retryFailed < 0 {
+		err = fmt.Errorf("number of retries can't be negative, but it is %d", b.retryFailed)
+		return
+	}
+	err = b.checkLabels()
+	if err != nil {
+		return
+	}
+	err = b.checkOutputGlobs()
+	if err != nil {
+		return
+	}
+	if b.uploadMin < 0 {
+		err = fmt.Errorf(
+			"upload threshold can't be negative, but it is %d",
+			b.uploadMin,
+		)
+		return
+	}
+	err = b.checkAnnotations()
+	if err != nil {
 		return
 	}
+	var goRequire *goConstraint
+	if b.goRequire != "" {
+		goRequire, err = parseGoConstraint(b.goRequire)
+		if err != nil {
+			return
+		}
+	}
+	if b.basePath != "" && !strings.HasPrefix(b.basePath, "/") {
+		err = fmt.Errorf("base path must start with a slash, but it is '%s'", b.basePath)
+		return
+	}
+	if b.caCert != "" && b.insecure {
+		err = fmt.Errorf("CA certificates can't be used when verification is disabled")
+		return
+	}
+	if b.caCert != "" {
+		b.caPool, err = loadCACerts(b.caCert)
+		if err != nil {
+			return
+		}
+	}
+	if b.basePath != "" && b.passthrough {
+		err = fmt.Errorf("base path can't be used with passthrough termination")
+		return
+	}
+	if b.shuffle != "" && b.shuffle != "on" && b.shuffle != "off" {
+		_, err = strconv.ParseInt(b.shuffle, 10, 64)
+		if err != nil {
+			err = fmt.Errorf(
+				"shuffle must be 'on', 'off' or a number, but it is '%s'",
+				b.shuffle,
+			)
+			return
+		}
+	}
+
+	if b.testParallel < 0 {
+		err = fmt.Errorf("test parallelism can't be negative, but it is %d", b.testParallel)
+		return
+	}
+
+	err = internal.CheckDNS(b.dnsPolicy, b.dnsConfig)
+	if err != nil {
+		return
+	}
+	err = internal.CheckPullPolicy(b.pullPolicy)
+	if err != nil {
+		return
+	}
+	if b.vet != "" && !vetRE.MatchString(b.vet) {
+		err = fmt.Errorf(
+			"vet must be 'off' or a comma separated list of checks, but it is '%s'",
+			b.vet,
+		)
+		return
+	}
+
+	// Check that the fixtures exist and that their names are unique:
+	names := map[string]string{}
+	for _, fixture := range b.fixtures {
+		name := filepath.Base(fixture)
+		previous, ok := names[name]
+		if ok {
+			err = fmt.Errorf(
+				"fixtures '%s' and '%s' have the same name",
+				previous, fixture,
+			)
+			return
+		}
+		names[name] = fixture
+		_, err = os.Stat(fixture)
+		if err != nil {
+			err = fmt.Errorf("can't check fixture '%s': %v", fixture, err)
+			return
+		}
+	}
 
-	// Make a copy of the directories array:
+	// Make a copy of the directories, binaries and fixtures arrays:
 	dirs := make([]string, len(b.dirs))
 	copy(dirs, b.dirs)
+	binaries := make([]string, len(b.binaries))
+	copy(binaries, b.binaries)
+	fixtures := make([]string, len(b.fixtures))
+	copy(fixtures, b.fixtures)
+	labels := make(map[string][]string, len(b.labels))
+	for dir, values := range b.labels {
+		labels[dir] = append([]string(nil), values...)
+	}
+	onlyLabels := make([]string, len(b.onlyLabels))
+	copy(onlyLabels, b.onlyLabels)
+	skipLabels := make([]string, len(b.skipLabels))
+	copy(skipLabels, b.skipLabels)
+	outputGlobs := make([]string, len(b.outputGlobs))
+	copy(outputGlobs, b.outputGlobs)
+
+	// Load the plan:
+	var plan *planManifest
+	if b.plan != "" {
+		plan, err = loadPlan(b.plan)
+		if err != nil {
+			return
+		}
+	}
+
+	// Create the Kubernetes clients:
+	err = b.createClients()
+	if err != nil {
+		return
+	}
+
+	// Check that the user can create the project:
+	if b.preflight {
+		err = b.checkProjectPermissions()
+		if err != nil {
+			return
+		}
+	}
+
+	// The Go tool requires the cache directories to be absolute paths:
+	buildCache := b.buildCache
+	if buildCache != "" {
+		buildCache, err = filepath.Abs(buildCache)
+		if err != nil {
+			err = fmt.Errorf("can't get absolute path of build cache '%s': %v", b.buildCache, err)
+			return
+		}
+	}
+
+	// Make sure that the project, the cleaner and the server exist:
+	err = b.provision()
+	if err != nil {
+		return
+	}
+
+	// Create and populate the runner object:
+	rnnr = &Runner{
+		compile:        b.compile,
+		recursive:      b.recursive,
+		vet:            b.vet,
+		buildCache:     buildCache,
+		binaries:       binaries,
+		pattern:        b.pattern,
+		plan:           plan,
+		profile:        b.profile,
+		dirs:           dirs,
+		timeout:        b.timeout,
+		shuffle:        b.shuffle,
+		testParallel:   b.testParallel,
+		retryFailed:    b.retryFailed,
+		flakyPass:      b.flakyPass,
+		failFast:       b.failFast,
+		failNoTests:    b.failNoTests,
+		fixtures:       fixtures,
+		quiet:          b.quiet,
+		deadline:       b.deadline,
+		jsonEvents:     b.jsonEvents,
+		outputGlobs:    outputGlobs,
+		artifactDir:    b.artifactDir,
+		uploadMin:      b.uploadMin,
+		labels:         labels,
+		onlyLabels:     onlyLabels,
+		skipLabels:     skipLabels,
+		notifyURL:      b.notifyURL,
+		notifySecret:   b.notifySecret,
+		goRequire:      goRequire,
+		keep:           b.keep,
+		createdProject: b.createdProject,
+		project:        b.project,
+		projectV1:      b.projectV1,
+		server:         b.server,
+		sender:         b.server,
+		capabilities:   b.server.capabilities,
+	}
+
+	return
+}
+
+// provision makes sure that the project, the cleaner and the server exist. The objects that it
+// creates are remembered, and if something fails they are deleted in reverse order, unless the
+// project should be kept, so that the next attempt starts clean instead of finding the objects
+// left by this one.
+func (b *RunnerBuilder) provision() (err error) {
+	b.created = &rollback{}
+	defer func() {
+		if err != nil && !b.keep {
+			b.created.run()
+		}
+	}()
+	err = b.ensureProject()
+	if err != nil {
+		return
+	}
+	if b.preflight {
+		err = b.checkObjectPermissions()
+		if err != nil {
+			return
+		}
+	}
+	if b.warmup {
+		err = b.warmupImage()
+		if err != nil {
+			return
+		}
+	}
+	if !b.keep && b.createdProject {
+		err = b.ensureCleaner()
+		if err != nil {
+			return
+		}
+	}
+	err = b.ensureServer()
+	return
+}
+
+// createClients loads the configuration needed to connect to the OpenShift API and creates the
+// clients.
+func (b *RunnerBuilder) createClients() error {
+	var err error
 
 	// If the configuration is then try to get it from the `~/.kube/config' file:
 	configFile := b.config
@@ -185,16 +975,35 @@ func (b *RunnerBuilder) Build() (rnnr *Runner, err error) {
 				err = nil
 			}
 			if err != nil {
-				return
+				return err
 			}
 		}
 	}
 
 	// Load the configuration either from the given configuration file or from the default
-	// location used when running inside a cluster:
-	restConfig, err := clientcmd.BuildConfigFromFlags("", configFile)
+	// location used when running inside a cluster. If a context was given then the file is
+	// required, and the context is used instead of the current one:
+	var restConfig *rest.Config
+	if b.context != "" {
+		if configFile == "" {
+			return fmt.Errorf(
+				"context '%s' can't be used without a configuration file",
+				b.context,
+			)
+		}
+		restConfig, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+			&clientcmd.ClientConfigLoadingRules{
+				ExplicitPath: configFile,
+			},
+			&clientcmd.ConfigOverrides{
+				CurrentContext: b.context,
+			},
+		).ClientConfig()
+	} else {
+		restConfig, err = clientcmd.BuildConfigFromFlags("", configFile)
+	}
 	if err != nil {
-		return
+		return err
 	}
 
 	// Configure the proxy:
@@ -202,7 +1011,7 @@ func (b *RunnerBuilder) Build() (rnnr *Runner, err error) {
 	if b.proxy != "" {
 		proxy, err = url.Parse(b.proxy)
 		if err != nil {
-			return
+			return err
 		}
 		restConfig.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
 			t, ok := rt.(*http.Transport)
@@ -223,57 +1032,43 @@ func (b *RunnerBuilder) Build() (rnnr *Runner, err error) {
 	// Create the Kubernetes clients:
 	b.coreV1, err = corev1client.NewForConfig(restConfig)
 	if err != nil {
-		return
+		return err
 	}
 	b.projectV1, err = projectv1client.NewForConfig(restConfig)
 	if err != nil {
-		return
+		return err
 	}
 	b.rbacV1, err = rbacv1client.NewForConfig(restConfig)
 	if err != nil {
-		return
+		return err
 	}
 	b.routeV1, err = routev1client.NewForConfig(restConfig)
 	if err != nil {
-		return
+		return err
 	}
-
-	// Make sure that the project, the cleaner and the server exist:
-	err = b.ensureProject()
+	b.userV1, err = userv1client.NewForConfig(restConfig)
 	if err != nil {
-		return
-	}
-	if !b.keep {
-		err = b.ensureCleaner()
-		if err != nil {
-			return
-		}
+		return err
 	}
-	err = b.ensureServer()
+	b.authorizationV1, err = authorizationv1client.NewForConfig(restConfig)
 	if err != nil {
-		return
+		return err
 	}
-
-	// Create and populate the runner object:
-	rnnr = &Runner{
-		compile:   b.compile,
-		recursive: b.recursive,
-		dirs:      dirs,
-		keep:      b.keep,
-		project:   b.project,
-		projectV1: b.projectV1,
-		server:    b.server,
+	b.discovery, err = discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return err
 	}
 
-	return
+	return nil
 }
 
 // Destroy releases all the resources used by the runner.
 func (r *Runner) Destroy() error {
 	var err error
 
-	// Delete the OpenShift project:
-	if r.keep {
+	// Delete the OpenShift project, but only if it was created by the runner, as projects that
+	// already existed may be shared or owned by the user:
+	if !r.keep && r.createdProject {
 		log.Infof("Deleting project '%s'", r.project)
 		err = r.projectV1.Projects().Delete(r.project, nil)
 		if errors.IsNotFound(err) {
@@ -287,8 +1082,87 @@ func (r *Runner) Destroy() error {
 	return nil
 }
 
-// Run runs the tests and returns the of failed tests.
+// Project returns the name of the OpenShift project where the tests run.
+func (r *Runner) Project() string {
+	return r.project
+}
+
+// Run runs the tests and returns the number of failed tests. It is a simplified version of the
+// RunAll method, intended for the command line tool.
 func (r *Runner) Run() (failed int, err error) {
+	summary, err := r.RunAll(context.Background())
+	if summary != nil {
+		failed = summary.Failed
+	}
+	return
+}
+
+// RunAll finds the directories that contain tests, compiles them, sends the test binaries to the
+// server and returns the summary of the run, including the result of each binary. The summary is
+// returned even when the run is stopped by an error, with the results collected till then. The
+// binaries that can't be sent to the server count as failed, and their errors are returned once
+// the rest of the binaries finish. If the context is cancelled the binaries that didn't start yet
+// are skipped and the error of the context is returned.
+func (r *Runner) RunAll(ctx context.Context) (summary *RunSummary, err error) {
+	// Send the summary of the run to the webhook when finished:
+	start := time.Now()
+	summary = &RunSummary{
+		Profile: r.profile,
+		Project: r.project,
+		Kept:    r.keep || !r.createdProject,
+	}
+	failed := 0
+	defer func() {
+		summary.Failed = failed
+		summary.GoVersion = r.goVersion
+		summary.Duration = time.Since(start).Seconds()
+		if err != nil {
+			summary.Error = err.Error()
+		}
+		if r.quiet {
+			log.Infof(
+				"Passed %d of %d test binaries, %d failed",
+				summary.Passed, summary.Binaries, summary.Failed,
+			)
+		}
+		r.markResult(summary)
+		r.notify(summary)
+	}()
+
+	// Check that the server supports deadlines, if requested:
+	if r.deadline && !r.capabilities.Deadline {
+		err = fmt.Errorf("server doesn't support deadlines")
+		return
+	}
+
+	// Check that the server can generate JSON events, if requested, and write the output of
+	// the binaries as usual if it can't:
+	if r.jsonEvents && !r.capabilities.JSONEvents {
+		log.Warnf("Server can't generate JSON events, will write the output of test binaries")
+		r.jsonEvents = false
+	}
+
+	// Check that the server can return the files produced by the binaries, if requested:
+	if len(r.outputGlobs) > 0 && !r.capabilities.Artifacts {
+		err = fmt.Errorf("server doesn't support returning artifacts")
+		return
+	}
+
+	// If there is a plan run it instead of the test binaries:
+	if r.plan != nil {
+		failed, err = r.runPlan(ctx, summary)
+		return
+	}
+
+	// Remember the directories given by the user, to report them if no test is found:
+	searched := r.dirs
+
+	// Expand the directories that use the '...' wildcard:
+	r.dirs, err = expandDirs(r.dirs)
+	if err != nil {
+		return
+	}
+
 	// Enrich the list of directories recursively looking for directories that contain test
 	// files, if needed:
 	if r.recursive {
@@ -311,72 +1185,441 @@ func (r *Runner) Run() (failed int, err error) {
 		}
 	}
 
-	// Compile the test binaries if needed:
+	// Check that the directories that have labels contain tests, and then remove the ones that
+	// are excluded by their labels:
+	err = r.checkLabelDirs()
+	if err != nil {
+		return
+	}
+	r.dirs = r.selectDirs(r.dirs)
+	if r.compile && r.failNoTests && len(r.dirs) == 0 {
+		err = fmt.Errorf("no test files found in directories %s", quoteList(searched))
+		return
+	}
+
+	// Compile the test binaries if needed, checking first the version of the compiler:
 	if r.compile {
+		err = r.checkGoVersion()
+		if err != nil {
+			return
+		}
 		err = r.compileBinaries()
 		if err != nil {
 			return
 		}
 	}
 
-	// Find the generated test binaries:
-	binaries, err := filepath.Glob("*.test")
-	if err != nil {
+	// Find the test binaries, unless they were given explicitly:
+	var binaries []string
+	if len(r.binaries) > 0 {
+		binaries = make([]string, len(r.binaries))
+		copy(binaries, r.binaries)
+	} else {
+		binaries, err = filepath.Glob(r.pattern)
+		if err != nil {
+			return
+		}
+		sort.Strings(binaries)
+	}
+	binaries = r.selectBinaries(binaries)
+	summary.Binaries = len(binaries)
+	if r.failNoTests && len(binaries) == 0 {
+		err = fmt.Errorf("no test binaries found for directories %s", quoteList(searched))
+		return
+	}
+
+	// Dump the list of binaries:
+	if len(binaries) == 1 {
+		log.Infof("Found one test binary")
+	} else {
+		log.Infof("Found %d test binaries", len(binaries))
+	}
+	if log.IsLevelEnabled(log.DebugLevel) {
+		for _, binary := range binaries {
+			log.Debugf("Found test binary '%s'", binary)
+		}
+	}
+
+	// Calculate the arguments for the test binaries:
+	var args []string
+	if r.shuffle != "" {
+		args = append(args, fmt.Sprintf("-test.shuffle=%s", r.shuffle))
+	}
+	if r.testParallel > 0 {
+		args = append(args, fmt.Sprintf("-test.parallel=%d", r.testParallel))
+	}
+
+	// Upload the fixtures:
+	err = r.uploadFixtures()
+	if err != nil {
+		return
+	}
+
+	// Send the binaries fo the server for execution. The errors that prevent running a binary
+	// don't stop the run unless fail fast is enabled, so they are collected and returned at the
+	// end:
+	var flaky []string
+	var runErrs []error
+	for i, binary := range binaries {
+		// Stop if the context has been cancelled:
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			log.Infof("Run was cancelled: %v", err)
+			r.skipRemaining(binaries[i:])
+			summary.Results = append(summary.Results, skippedResults(binaries[i:])...)
+			break
+		}
+
+		result := &BinaryResult{
+			Binary: binary,
+			Labels: r.binaryLabels(binary),
+		}
+		summary.Results = append(summary.Results, result)
+		binaryStart := time.Now()
+		response, runErr := r.runBinary(ctx, binary, args)
+		result.Attempts++
+		if runErr != nil {
+			log.Errorf("Can't run test binary '%s': %v", binary, runErr)
+			result.Error = runErr.Error()
+			result.Duration = time.Since(binaryStart).Seconds()
+			runErrs = append(runErrs, runErr)
+			failed++
+			if r.failFast {
+				r.skipRemaining(binaries[i+1:])
+				summary.Results = append(summary.Results, skippedResults(binaries[i+1:])...)
+				break
+			}
+			continue
+		}
+
+		// Run again the binaries that failed, to find out if they are flaky:
+		for retry := 1; response.Code != 0 && retry <= r.retryFailed; retry++ {
+			log.Infof(
+				"Retrying failed test binary '%s', attempt %d of %d",
+				binary, retry, r.retryFailed,
+			)
+			response, runErr = r.runBinary(ctx, binary, args)
+			result.Attempts++
+			if runErr != nil {
+				log.Errorf("Can't retry test binary '%s': %v", binary, runErr)
+				result.Error = runErr.Error()
+				runErrs = append(runErrs, runErr)
+				break
+			}
+			if response.Code == 0 {
+				log.Warnf("Test binary '%s' is flaky, it passed after %d retries", binary, retry)
+				flaky = append(flaky, binary)
+				result.Flaky = true
+			}
+		}
+		result.Duration = time.Since(binaryStart).Seconds()
+		if runErr == nil {
+			result.Code = response.Code
+			result.TimedOut = response.TimedOut
+			result.Signal = response.Signal
+			result.Truncated = response.Truncated
+			result.Dir = response.Dir
+		}
+		if runErr == nil && response.Dir != "" {
+			summary.Dirs = append(summary.Dirs, response.Dir)
+		}
+
+		// Flaky binaries passed in the last attempt, but they count as failed unless
+		// configured otherwise:
+		if runErr == nil && response.Code == 0 && (!result.Flaky || r.flakyPass) {
+			summary.Passed++
+			result.Passed = true
+		} else {
+			failed++
+			if r.failFast {
+				r.skipRemaining(binaries[i+1:])
+				summary.Results = append(summary.Results, skippedResults(binaries[i+1:])...)
+				break
+			}
+		}
+	}
+
+	// Report the flaky binaries:
+	summary.Flaky = len(flaky)
+	if len(flaky) > 0 {
+		log.Warnf("Found %d flaky test binaries", len(flaky))
+		for _, binary := range flaky {
+			log.Warnf("Test binary '%s' is flaky", binary)
+		}
+	}
+
+	// Return the errors that prevented running binaries, unless the run was cancelled, as then
+	// the error of the context is more relevant:
+	if err == nil && len(runErrs) == 1 {
+		err = runErrs[0]
+	} else if err == nil && len(runErrs) > 1 {
+		messages := make([]string, len(runErrs))
+		for i, runErr := range runErrs {
+			messages[i] = runErr.Error()
+		}
+		err = fmt.Errorf(
+			"can't run %d test binaries: %s",
+			len(runErrs), strings.Join(messages, "; "),
+		)
+	}
+
+	return
+}
+
+// skipRemaining reports the test binaries that weren't executed because a previous one failed and
+// the fail fast option is enabled.
+func (r *Runner) skipRemaining(binaries []string) {
+	if len(binaries) == 0 {
+		return
+	}
+	log.Infof("Skipping %d remaining test binaries because of previous failure", len(binaries))
+	for _, binary := range binaries {
+		log.Debugf("Skipping test binary '%s'", binary)
+	}
+}
+
+// uploadFixtures uploads all the fixture files to the server.
+func (r *Runner) uploadFixtures() error {
+	if len(r.fixtures) > 0 && !r.capabilities.Fixtures {
+		return fmt.Errorf("server doesn't support fixtures")
+	}
+	for _, fixture := range r.fixtures {
+		err := r.uploadFixture(fixture)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uploadFixture uploads the given fixture file to the server.
+func (r *Runner) uploadFixture(path string) error {
+	name := filepath.Base(path)
+	log.Infof("Uploading fixture '%s' from file '%s'", name, path)
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't open fixture file '%s': %v", path, err)
+	}
+	defer file.Close()
+	fixture, err := r.server.PutFixture(name, file)
+	if err != nil {
+		return fmt.Errorf("can't upload fixture '%s': %v", name, err)
+	}
+	log.Infof(
+		"Uploaded fixture '%s' with size %d and checksum '%s'",
+		fixture.Name, fixture.Size, fixture.Checksum,
+	)
+	return nil
+}
+
+// skippedResults returns the results for binaries that weren't sent to the server.
+func skippedResults(binaries []string) []*BinaryResult {
+	results := make([]*BinaryResult, len(binaries))
+	for i, binary := range binaries {
+		results[i] = &BinaryResult{
+			Binary:  binary,
+			Skipped: true,
+		}
+	}
+	return results
+}
+
+// runBinary sends the given test binary to the server, waits till it finishes and writes the
+// results.
+func (r *Runner) runBinary(ctx context.Context, binary string, args []string) (response *api.Test,
+	err error) {
+	// In quiet mode we don't know yet if the binary will pass, and the report of the failed
+	// binaries already contains its name, so this is only a debug message:
+	logf := log.Infof
+	if r.quiet {
+		logf = log.Debugf
+	}
+	labels := r.binaryLabels(binary)
+	if len(labels) > 0 {
+		logf(
+			"Running test binary '%s' with labels '%s'",
+			binary, strings.Join(labels, ", "),
+		)
+	} else {
+		logf("Running test binary '%s'", binary)
+	}
+	data, err := ioutil.ReadFile(binary)
+	if err != nil {
+		err = fmt.Errorf("can't read test binary from file '%s': %v", binary, err)
+		return
+	}
+	sum := sha256.Sum256(data)
+	request := &api.Test{
+		Checksum: hex.EncodeToString(sum[:]),
+		Args:     args,
+	}
+	err = r.attachBinary(ctx, binary, data, request)
+	if err != nil {
+		return
+	}
+	if r.capabilities.Idempotency {
+		var key uuid.UUID
+		key, err = uuid.NewRandom()
+		if err != nil {
+			return
+		}
+		request.Key = key.String()
+	}
+	if r.capabilities.Timeout {
+		request.Timeout = r.binaryTimeout().String()
+		request.Deadline = r.deadline
+	}
+	if r.jsonEvents {
+		request.JSONEvents = true
+		request.Package = binaryPackage(binary)
+	}
+	for _, fixture := range r.fixtures {
+		request.Fixtures = append(request.Fixtures, filepath.Base(fixture))
+	}
+	request.OutputGlobs = r.outputGlobs
+	// Retry if the response was truncated, but only if the server supports idempotency keys,
+	// as otherwise the binary would run again:
+	start := time.Now()
+	response, err = r.send(ctx, request)
+	for retry := 1; IsTruncated(err) && request.Key != "" && retry <= sendRetries; retry++ {
+		log.Warnf(
+			"Response for test binary '%s' was truncated, retrying, attempt %d of %d",
+			binary, retry, sendRetries,
+		)
+		response, err = r.resend(ctx, binary, request, start.Add(r.timeout))
+	}
+	if err != nil {
+		err = fmt.Errorf("can't send request for test binary '%s': %v", binary, err)
+		return
+	}
+	r.report(binary, response)
+	r.saveArtifacts(binary, filepath.Base(binary), response)
+	return
+}
+
+// resend sends again a request that has a key. The route may have cut the connection while the
+// test is still running in the server, and then the server responds with a conflict till it
+// finishes, so in that case it waits and tries again till the given deadline.
+func (r *Runner) resend(ctx context.Context, binary string, request *api.Test,
+	deadline time.Time) (response *api.Test, err error) {
+	delay := resendMinDelay
+	for {
+		response, err = r.send(ctx, request)
+		if !IsConflict(err) || time.Now().Add(delay).After(deadline) {
+			return
+		}
+		log.Infof(
+			"Test binary '%s' is still running in the server, will try again in %s",
+			binary, delay,
+		)
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			return
+		case <-time.After(delay):
+		}
+		delay *= 2
+		if delay > resendMaxDelay {
+			delay = resendMaxDelay
+		}
+	}
+}
+
+// binaryPackage returns the name of the package of the given test binary, used in the JSON
+// events. It is the name of the file without the extension, for example 'db' for 'db.test', as
+// that is the name that the 'go test -c' command uses.
+func binaryPackage(binary string) string {
+	name := filepath.Base(binary)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// binaryTimeout returns the timeout that is sent to the server for each test binary. It is a bit
+// less than the timeout of the runner, so that the server has time to return the output generated
+// by the binary before the request is cancelled.
+func (r *Runner) binaryTimeout() time.Duration {
+	return r.timeout - r.timeout/10
+}
+
+// report writes the output of the given test binary and logs the details of its results. In quiet
+// mode this is only done for the binaries that fail.
+func (r *Runner) report(binary string, response *api.Test) {
+	if r.quiet && response.Code == 0 && !response.TimedOut && response.Signal == "" {
+		log.Debugf("Test binary '%s' passed, omitting its output", binary)
 		return
 	}
-	sort.Strings(binaries)
-
-	// Dump the list of binaries:
-	if len(binaries) == 1 {
-		log.Infof("Found one test binary")
+	if response.Events != nil {
+		log.Infof("JSON events of test binary '%s' follow", binary)
+		_, _ = os.Stdout.Write(response.Events)
+	} else if response.Out != nil {
+		log.Infof("Output of test binary '%s' follows", binary)
+		_, _ = os.Stdout.Write(response.Out)
 	} else {
-		log.Infof("Found %d test binaries", len(binaries))
+		log.Infof("Test binary '%s' didn't produce output", binary)
 	}
-	if log.IsLevelEnabled(log.DebugLevel) {
-		for _, binary := range binaries {
-			log.Debugf("Found test binary '%s'", binary)
-		}
+	if response.Err != nil {
+		log.Infof("Error output of test binary '%s' follows", binary)
+		_, _ = os.Stderr.Write(response.Err)
+	} else {
+		log.Infof("Test binary '%s' didn't produce error output", binary)
+	}
+	if response.Truncated {
+		log.Infof(
+			"Output of test binary '%s' was truncated because it exceeded the limit "+
+				"of the server",
+			binary,
+		)
+	}
+	if response.TimedOut {
+		log.Errorf(
+			"Test binary '%s' was killed because it didn't finish in time, the "+
+				"output above is what it generated till then",
+			binary,
+		)
+	}
+	if response.Signal != "" {
+		log.Infof("Test binary '%s' was killed by signal %s", binary, response.Signal)
+	} else {
+		log.Infof("Test binary '%s' finished with exit code %d", binary, response.Code)
 	}
+	seed := shuffleSeed(response.Out)
+	if seed != "" {
+		log.Infof("Tests of binary '%s' were shuffled with seed %s", binary, seed)
+	}
+	if response.Dir != "" {
+		log.Infof(
+			"Files of test binary '%s' have been preserved in server directory '%s'",
+			binary, response.Dir,
+		)
+	}
+}
 
-	// Send the binaries fo the server for execution:
-	failed = 0
-	for _, binary := range binaries {
-		log.Infof("Running test binary '%s'", binary)
-		var bytes []byte
-		bytes, err = ioutil.ReadFile(binary)
-		if err != nil {
-			log.Errorf("Can't read test binary from file '%s': %v", binary, err)
-			continue
-		}
-		var request *api.Test
-		request = &api.Test{
-			Binary: bytes,
-		}
-		var response *api.Test
-		response, err = r.server.Send(request)
-		if err != nil {
-			log.Errorf("Can't send request for test binary '%s': %v", binary, err)
-			continue
-		}
-		if response.Out != nil {
-			log.Infof("Output of test binary '%s' follows", binary)
-			_, _ = os.Stdout.Write(response.Out)
-		} else {
-			log.Infof("Test binary '%s' didnt' produce output", binary)
-		}
-		if response.Err != nil {
-			log.Infof("Error output of test binary '%s' follows", binary)
-			_, _ = os.Stderr.Write(response.Err)
-		} else {
-			log.Infof("Test binary '%s' didn't produce error output", binary)
-		}
-		log.Infof("Test binary '%s' finished with exit code %d", binary, response.Code)
-		if response.Code != 0 {
-			failed++
-		}
+// send sends the given request using the sender, with the context if the sender supports it.
+func (r *Runner) send(ctx context.Context, request *api.Test) (response *api.Test, err error) {
+	sender, ok := r.sender.(contextSender)
+	if ok {
+		return sender.SendContext(ctx, request)
 	}
+	return r.sender.Send(request)
+}
 
-	return
+// shuffleSeed extracts from the output of a test binary the seed that was used to shuffle the
+// tests. Returns an empty string if the output doesn't contain the seed.
+func shuffleSeed(out []byte) string {
+	match := shuffleSeedRE.FindSubmatch(out)
+	if match == nil {
+		return ""
+	}
+	return string(match[1])
+}
+
+// quoteList returns a string containing the given values quoted and separated by commas, to use
+// in messages.
+func quoteList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = "'" + value + "'"
+	}
+	return strings.Join(quoted, ", ")
 }
 
 // scanDirectories recursively scans the directories given by the caller, and adds the
@@ -405,30 +1648,76 @@ func (r *Runner) scanDirectories() error {
 	return nil
 }
 
+// CompileError is the error returned by the Run method when the compilation of a test binary
+// fails. It contains the output of the compiler, so that it can be presented to the user without
+// having to parse the standard error.
+type CompileError struct {
+	// Package is the package whose test binary failed to compile, for example './pkg/db'.
+	Package string
+
+	// ExitCode is the exit code of the 'go test -c' command.
+	ExitCode int
+
+	// Output is the combined standard output and error of the 'go test -c' command.
+	Output string
+}
+
+// Error is the implementation of the error interface.
+func (e *CompileError) Error() string {
+	return fmt.Sprintf(
+		"compilation of test binary for package '%s' finished with exit code %d",
+		e.Package, e.ExitCode,
+	)
+}
+
 // compileBinaries compiles the test binaries using the `go test -c ...` command.
 func (r *Runner) compileBinaries() error {
+	// Prepare the cache directories, if needed:
+	compileEnv, err := r.compileEnv()
+	if err != nil {
+		return err
+	}
+
 	for _, directory := range r.dirs {
-		log.Infof("Compiling test binary for directory '%s'", directory)
+		if r.vet != "" {
+			log.Infof(
+				"Compiling test binary for directory '%s' with vet '%s'",
+				directory, r.vet,
+			)
+		} else {
+			log.Infof("Compiling test binary for directory '%s'", directory)
+		}
 		pckg := directory
 		if !strings.HasPrefix(directory, dotSeparator) {
 			pckg = dotSeparator + directory
 		}
-		compileCmd := exec.Command("go", "test", "-c", pckg)
-		compileCmd.Stdout = os.Stdout
-		compileCmd.Stderr = os.Stderr
+		// Send the output of the compiler to the standard error and also to a buffer, so
+		// that it can be included in the error. Note that the same writer is used for the
+		// standard output and error so that the command doesn't write to the buffer
+		// concurrently.
+		compileOut := &bytes.Buffer{}
+		compileWriter := io.MultiWriter(os.Stderr, compileOut)
+		compileArgs := []string{"test", "-c"}
+		if r.vet != "" {
+			compileArgs = append(compileArgs, "-vet="+r.vet)
+		}
+		compileArgs = append(compileArgs, pckg)
+		compileCmd := exec.Command("go", compileArgs...)
+		compileCmd.Stdout = compileWriter
+		compileCmd.Stderr = compileWriter
+		compileCmd.Env = compileEnv
 		if log.IsLevelEnabled(log.DebugLevel) {
 			log.Debugf("Running command '%s'", strings.Join(compileCmd.Args, " "))
 		}
-		err := compileCmd.Run()
+		err = compileCmd.Run()
 		if err != nil {
 			compileStatus, ok := err.(*exec.ExitError)
 			if ok {
-				compileCode := compileStatus.ExitCode()
-				err = fmt.Errorf(
-					"compilation of tests binary for directory '%s' finished "+
-						"with exist code %d",
-					directory, compileCode,
-				)
+				err = &CompileError{
+					Package:  pckg,
+					ExitCode: compileStatus.ExitCode(),
+					Output:   compileOut.String(),
+				}
 			}
 			return err
 		}
@@ -436,14 +1725,40 @@ func (r *Runner) compileBinaries() error {
 	return nil
 }
 
+// compileEnv returns the environment for the 'go test -c ...' command. When a build cache
+// directory has been configured it creates the subdirectories for the build and module caches and
+// adds the GOCACHE and GOMODCACHE variables pointing to them. Otherwise it returns nil, so that
+// the command inherits the environment of the runner.
+func (r *Runner) compileEnv() (result []string, err error) {
+	if r.buildCache == "" {
+		return
+	}
+	goCache := filepath.Join(r.buildCache, "build")
+	modCache := filepath.Join(r.buildCache, "mod")
+	for _, dir := range []string{goCache, modCache} {
+		err = os.MkdirAll(dir, 0755)
+		if err != nil {
+			err = fmt.Errorf("can't create build cache directory '%s': %v", dir, err)
+			return
+		}
+	}
+	log.Infof("Using build cache directory '%s'", r.buildCache)
+	result = append(os.Environ(), "GOCACHE="+goCache, "GOMODCACHE="+modCache)
+	return
+}
+
 // ensureProject makes sure that the OpenShift project exists, creating it if needed.
 func (b *RunnerBuilder) ensureProject() error {
-	// Generate a name for the project:
+	// Generate a name for the project, unless we were asked to reuse an existing one:
 	usr, err := user.Current()
 	if err != nil {
 		return err
 	}
-	b.project = fmt.Sprintf("sandbox-%s-%d", usr.Username, time.Now().Unix())
+	if b.reuse != "" {
+		b.project = b.reuse
+	} else {
+		b.project = fmt.Sprintf("sandbox-%s-%d", usr.Username, time.Now().Unix())
+	}
 
 	// Create the project:
 	log.Infof("Creating project '%s'", b.project)
@@ -453,7 +1768,26 @@ func (b *RunnerBuilder) ensureProject() error {
 		},
 	}
 	_, err = b.projectV1.ProjectRequests().Create(request)
+	if err == nil {
+		b.createdProject = true
+		b.created.add("project", b.project, b.projectV1.Projects().Delete)
+	}
 	if errors.IsAlreadyExists(err) {
+		log.Infof("Project '%s' already exists, will reuse it and keep it", b.project)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Add the labels and annotations that identify the project as created by the runner, if it
+	// was created now, and the custom annotations. Note that this may fail if the user doesn't
+	// have permission to update the project, and in that case we just write a warning, as the
+	// project is still usable. But if custom annotations were requested we fail, as whoever
+	// requested them, for example to attribute costs, depends on them.
+	err = b.markProject()
+	if errors.IsForbidden(err) && len(b.annotations) == 0 {
+		log.Warnf("Can't add labels and annotations to project '%s': %v", b.project, err)
 		err = nil
 	}
 	if err != nil {
@@ -467,6 +1801,12 @@ func (b *RunnerBuilder) ensureProject() error {
 		},
 	}
 	_, err = b.coreV1.ServiceAccounts(b.project).Create(account)
+	if err == nil {
+		b.created.add(
+			"service account", account.Name,
+			b.coreV1.ServiceAccounts(b.project).Delete,
+		)
+	}
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
@@ -493,6 +1833,12 @@ func (b *RunnerBuilder) ensureProject() error {
 		},
 	}
 	_, err = b.rbacV1.RoleBindings(b.project).Create(binding)
+	if err == nil {
+		b.created.add(
+			"role binding", binding.Name,
+			b.rbacV1.RoleBindings(b.project).Delete,
+		)
+	}
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
@@ -503,6 +1849,43 @@ func (b *RunnerBuilder) ensureProject() error {
 	return nil
 }
 
+// markProject adds to the project the labels and annotations that identify it as created by the
+// runner, and that record when and by whom it was created. This is only done when the runner
+// created the project, as the sweeper of the cleaner deletes the projects that have that label,
+// and it shouldn't delete a project that the runner only reused. The custom annotations are
+// always set, as the check of the builder guarantees that they don't collide with the internal
+// ones.
+func (b *RunnerBuilder) markProject() error {
+	if !b.createdProject && len(b.annotations) == 0 {
+		return nil
+	}
+	project, err := b.projectV1.Projects().Get(b.project, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if project.Annotations == nil {
+		project.Annotations = map[string]string{}
+	}
+	if b.createdProject {
+		if project.Labels == nil {
+			project.Labels = map[string]string{}
+		}
+		project.Labels[internal.AppLabel] = internal.ProjectApp
+		project.Annotations[internal.CreatedAtAnnotation] =
+			time.Now().UTC().Format(time.RFC3339)
+		owner, err := b.userV1.Users().Get("~", metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		project.Annotations[internal.OwnerAnnotation] = owner.Name
+	}
+	for key, value := range b.annotations {
+		project.Annotations[key] = value
+	}
+	_, err = b.projectV1.Projects().Update(project)
+	return err
+}
+
 // ensureCleaner makes sure that the cleaner exists, creating it if needed.
 func (b *RunnerBuilder) ensureCleaner() error {
 	var err error
@@ -514,6 +1897,12 @@ func (b *RunnerBuilder) ensureCleaner() error {
 		},
 	}
 	_, err = b.coreV1.ServiceAccounts(b.project).Create(account)
+	if err == nil {
+		b.created.add(
+			"service account", account.Name,
+			b.coreV1.ServiceAccounts(b.project).Delete,
+		)
+	}
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
@@ -540,6 +1929,12 @@ func (b *RunnerBuilder) ensureCleaner() error {
 		},
 	}
 	_, err = b.rbacV1.RoleBindings(b.project).Create(binding)
+	if err == nil {
+		b.created.add(
+			"role binding", binding.Name,
+			b.rbacV1.RoleBindings(b.project).Delete,
+		)
+	}
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
@@ -566,13 +1961,17 @@ func (b *RunnerBuilder) ensureCleaner() error {
 						"cleaner",
 						"--wait=1m",
 					},
-					Image:           sandboxImage,
-					ImagePullPolicy: corev1.PullAlways,
+					Image:           b.serverImage(),
+					ImagePullPolicy: b.pullPolicy,
 				},
 			},
 		},
 	}
+	internal.SetDNS(pod, b.dnsPolicy, b.dnsConfig)
 	_, err = b.coreV1.Pods(b.project).Create(pod)
+	if err == nil {
+		b.created.add("pod", pod.Name, b.coreV1.Pods(b.project).Delete)
+	}
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
@@ -583,14 +1982,104 @@ func (b *RunnerBuilder) ensureCleaner() error {
 	return nil
 }
 
+// warmupImage makes sure that the image of the server and the cleaner can be pulled before
+// creating them. It creates a pod that uses the image to run a command that finishes immediately,
+// waits till the image is pulled and then deletes the pod.
+func (b *RunnerBuilder) warmupImage() error {
+	image := b.serverImage()
+	log.Infof("Pulling image '%s'", image)
+
+	// Create the pod. The name is generated because a pod from a previous run may still be
+	// terminating in a reused project:
+	labels := map[string]string{
+		internal.AppLabel: warmupApp,
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: warmupApp + "-",
+			Labels:       labels,
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{
+				{
+					Name: warmupApp,
+					Command: []string{
+						sandboxCommand,
+						"--help",
+					},
+					Image:           image,
+					ImagePullPolicy: b.pullPolicy,
+				},
+			},
+		},
+	}
+	internal.SetDNS(pod, b.dnsPolicy, b.dnsConfig)
+	pods := b.coreV1.Pods(b.project)
+	pod, err := pods.Create(pod)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err := pods.Delete(pod.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			log.Errorf("Can't delete warm-up pod '%s': %v", pod.Name, err)
+		}
+	}()
+
+	// Wait till the image is pulled:
+	_, err = internal.WaitForImages(b.coreV1, b.project, pod.Name, warmupTimeout)
+	if err != nil {
+		return fmt.Errorf("can't pull image '%s': %v", image, err)
+	}
+	log.Infof("Image '%s' is available", image)
+
+	return nil
+}
+
+// removeCompletedServer deletes the pod of the server if it has already finished, for example
+// because it stopped after being idle, and waits till it is gone.
+func (b *RunnerBuilder) removeCompletedServer() error {
+	pods := b.coreV1.Pods(b.project)
+	pod, err := pods.Get(serverApp, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if pod.Status.Phase != corev1.PodSucceeded && pod.Status.Phase != corev1.PodFailed {
+		return nil
+	}
+	log.Infof("Server pod '%s' has finished, will replace it", pod.Name)
+	err = pods.Delete(pod.Name, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	deadline := time.Now().Add(serverDeleteTimeout)
+	for {
+		_, err = pods.Get(pod.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !time.Now().Add(serverDeletePoll).Before(deadline) {
+			break
+		}
+		time.Sleep(serverDeletePoll)
+	}
+	return fmt.Errorf("server pod '%s' still exists after %s", pod.Name, serverDeleteTimeout)
+}
+
 // ensureServer makes sure that the server exists in the OpenShift project, creating it if needed.
 func (b *RunnerBuilder) ensureServer() error {
-	// Generate the random token that will be used to authenticate to the runner server:
-	id, err := uuid.NewRandom()
+	// Make sure that the token that will be used to authenticate to the server exists:
+	token, err := b.ensureServerToken()
 	if err != nil {
 		return err
 	}
-	token := id.String()
 
 	// Create the service account that will be used to run the server:
 	account := &corev1.ServiceAccount{
@@ -599,6 +2088,12 @@ func (b *RunnerBuilder) ensureServer() error {
 		},
 	}
 	_, err = b.coreV1.ServiceAccounts(b.project).Create(account)
+	if err == nil {
+		b.created.add(
+			"service account", account.Name,
+			b.coreV1.ServiceAccounts(b.project).Delete,
+		)
+	}
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
@@ -625,6 +2120,12 @@ func (b *RunnerBuilder) ensureServer() error {
 		},
 	}
 	_, err = b.rbacV1.RoleBindings(b.project).Create(binding)
+	if err == nil {
+		b.created.add(
+			"role binding", binding.Name,
+			b.rbacV1.RoleBindings(b.project).Delete,
+		)
+	}
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
@@ -634,8 +2135,73 @@ func (b *RunnerBuilder) ensureServer() error {
 
 	// Create the specifications of the volumes that will be used by the runner:
 	workVolume := internal.EmptyDirVolume("work")
+	tokenVolume := internal.SecretVolume("token", serverTokenSecretName)
+	podVolumes := []corev1.Volume{
+		workVolume,
+		tokenVolume,
+	}
+	podMounts := []corev1.VolumeMount{
+		{
+			Name:      workVolume.Name,
+			MountPath: serverWork,
+		},
+		{
+			Name:      tokenVolume.Name,
+			MountPath: serverTokenDir,
+			ReadOnly:  true,
+		},
+	}
+
+	// Calculate the command line of the server:
+	podCommand := []string{
+		sandboxCommand,
+		"server",
+		fmt.Sprintf(
+			"--listen=%s",
+			net.JoinHostPort(serverAddress, strconv.Itoa(serverPort)),
+		),
+		fmt.Sprintf(
+			"--token-file=%s",
+			filepath.Join(serverTokenDir, serverTokenSecretKey),
+		),
+		fmt.Sprintf("--work=%s", serverWork),
+	}
+	if b.basePath != "" {
+		podCommand = append(podCommand, fmt.Sprintf("--base-path=%s", b.basePath))
+	}
+	if b.serverIdleTimeout > 0 {
+		podCommand = append(
+			podCommand,
+			fmt.Sprintf("--idle-timeout=%s", b.serverIdleTimeout),
+		)
+	}
+
+	// If the route uses passthrough termination then the server needs to terminate TLS itself,
+	// using the certificate that OpenShift generates for the service:
+	if b.passthrough {
+		tlsVolume := internal.SecretVolume("tls", serverTLSSecretName)
+		podVolumes = append(podVolumes, tlsVolume)
+		podMounts = append(podMounts, corev1.VolumeMount{
+			Name:      tlsVolume.Name,
+			MountPath: serverTLSDir,
+			ReadOnly:  true,
+		})
+		podCommand = append(
+			podCommand,
+			fmt.Sprintf("--tls-cert=%s", filepath.Join(serverTLSDir, "tls.crt")),
+			fmt.Sprintf("--tls-key=%s", filepath.Join(serverTLSDir, "tls.key")),
+		)
+	}
+
+	// A server that stopped because it was idle leaves a completed pod behind, and the project
+	// may be reused later, so remove that pod before trying to create the new one:
+	err = b.removeCompletedServer()
+	if err != nil {
+		return err
+	}
 
-	// Create the server pod:
+	// Create the server pod. The server exits successfully when it stops because it is idle,
+	// and then the pod should complete instead of starting it again:
 	podLabels := map[string]string{
 		internal.AppLabel: serverApp,
 	}
@@ -645,31 +2211,19 @@ func (b *RunnerBuilder) ensureServer() error {
 			Labels: podLabels,
 		},
 		Spec: corev1.PodSpec{
+			RestartPolicy:      corev1.RestartPolicyOnFailure,
 			ServiceAccountName: serverApp,
-			Volumes: []corev1.Volume{
-				workVolume,
-			},
+			Volumes:            podVolumes,
 			Containers: []corev1.Container{
 				{
-					Name: serverApp,
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      workVolume.Name,
-							MountPath: serverWork,
-						},
-					},
-					Command: []string{
-						sandboxCommand,
-						"server",
-						fmt.Sprintf(
-							"--listen=%s:%d",
-							serverAddress, serverPort,
-						),
-						fmt.Sprintf("--token=%s", token),
-						fmt.Sprintf("--work=%s", serverWork),
+					Name:            serverApp,
+					VolumeMounts:    podMounts,
+					Command:         podCommand,
+					Image:           b.serverImage(),
+					ImagePullPolicy: b.pullPolicy,
+					SecurityContext: &corev1.SecurityContext{
+						ReadOnlyRootFilesystem: pointer.BoolPtr(b.readOnly),
 					},
-					Image:           sandboxImage,
-					ImagePullPolicy: corev1.PullAlways,
 					Ports: []corev1.ContainerPort{
 						{
 							ContainerPort: serverPort,
@@ -680,7 +2234,19 @@ func (b *RunnerBuilder) ensureServer() error {
 			},
 		},
 	}
+	dbImage := b.databaseImage()
+	if dbImage != "" {
+		container := &pod.Spec.Containers[0]
+		container.Env = append(container.Env, corev1.EnvVar{
+			Name:  databaseImageEnv,
+			Value: dbImage,
+		})
+	}
+	internal.SetDNS(pod, b.dnsPolicy, b.dnsConfig)
 	_, err = b.coreV1.Pods(b.project).Create(pod)
+	if err == nil {
+		b.created.add("pod", pod.Name, b.coreV1.Pods(b.project).Delete)
+	}
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
@@ -692,10 +2258,16 @@ func (b *RunnerBuilder) ensureServer() error {
 	serviceLabels := map[string]string{
 		internal.AppLabel: serverApp,
 	}
+	serviceAnnotations := map[string]string{}
+	if b.passthrough {
+		serviceAnnotations["service.alpha.openshift.io/serving-cert-secret-name"] =
+			serverTLSSecretName
+	}
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:   serverApp,
-			Labels: serviceLabels,
+			Name:        serverApp,
+			Labels:      serviceLabels,
+			Annotations: serviceAnnotations,
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: map[string]string{
@@ -710,6 +2282,9 @@ func (b *RunnerBuilder) ensureServer() error {
 		},
 	}
 	_, err = b.coreV1.Services(b.project).Create(service)
+	if err == nil {
+		b.created.add("service", service.Name, b.coreV1.Services(b.project).Delete)
+	}
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
@@ -722,7 +2297,13 @@ func (b *RunnerBuilder) ensureServer() error {
 		internal.AppLabel: serverApp,
 	}
 	routeAnnotations := map[string]string{
-		"haproxy.router.openshift.io/timeout": "10m",
+		"haproxy.router.openshift.io/timeout": fmt.Sprintf(
+			"%ds", int64(b.timeout/time.Second),
+		),
+	}
+	routeTermination := routev1.TLSTerminationEdge
+	if b.passthrough {
+		routeTermination = routev1.TLSTerminationPassthrough
 	}
 	route := &routev1.Route{
 		ObjectMeta: metav1.ObjectMeta{
@@ -735,86 +2316,301 @@ func (b *RunnerBuilder) ensureServer() error {
 				Kind: "Service",
 				Name: serverApp,
 			},
+			Path: b.basePath,
 			TLS: &routev1.TLSConfig{
-				Termination: routev1.TLSTerminationEdge,
+				Termination: routeTermination,
 			},
 		},
 	}
-	_, err = b.routeV1.Routes(b.project).Create(route)
+	routes := b.routeV1.Routes(b.project)
+	created, err := routes.Create(route)
+	if err == nil {
+		b.created.add("route", route.Name, routes.Delete)
+	}
 	if errors.IsAlreadyExists(err) {
-		err = nil
+		created, err = routes.Get(serverApp, metav1.GetOptions{})
 	}
 	if err != nil {
 		return err
 	}
 
-	// Wait till the server and the route are ready:
+	// Wait till the server is ready:
 	pod, err = internal.WaitForPod(b.coreV1, b.project, serverApp)
 	if err != nil {
 		return err
 	}
-	route, err = internal.WaitForRoute(b.routeV1, b.project, serverApp)
-	if err != nil {
-		return err
+
+	// Wait till the route is admitted, unless that was disabled. In that case we use the host
+	// that the API server assigned to the route when it was created, and the router will start
+	// forwarding requests to the server when it catches up, so polling the server below is
+	// enough, but it may take longer.
+	serverWait := serverReadyTimeout
+	if b.skipRouteWait && created.Spec.Host != "" {
+		log.Infof(
+			"Not waiting for route '%s' to be admitted, will use host '%s'",
+			serverApp, created.Spec.Host,
+		)
+		route = created
+		serverWait = serverRouteLagTimeout
+	} else {
+		if b.skipRouteWait {
+			log.Warnf(
+				"Route '%s' doesn't have a host yet, will wait till it is admitted",
+				serverApp,
+			)
+		}
+		route, err = internal.WaitForRoute(b.routeV1, b.project, serverApp)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Now that the route is ready we can calculate the complete address of the server:
 	address := fmt.Sprintf("https://%s", route.Spec.Host)
 
-	// Create the HTTP client:
-	transport := &http.Transport{}
+	// Create the HTTP clients. They share the transport, but the client used to check if the
+	// server is ready has a short timeout, while the client used to send the tests has a timeout
+	// larger than the timeout of the route.
+	transport, err := b.serverTransport()
+	if err != nil {
+		return err
+	}
+	probe := &http.Client{
+		Transport: transport,
+		Timeout:   serverProbeTimeout,
+	}
 	client := &http.Client{
 		Transport: transport,
+		Timeout:   b.timeout + serverTimeoutMargin,
+	}
+
+	// Wait till the server is responding:
+	err = internal.WaitForServer(probe, address+b.basePath, serverWait)
+	if err != nil {
+		return err
+	}
+
+	// Create and populate the object:
+	b.server = &Server{
+		token:    token,
+		address:  address,
+		basePath: b.basePath,
+		client:   client,
+	}
+
+	// Find out what features the server supports. This may be an older image deployed in a
+	// reused project, so we can't assume that it supports everything that the runner knows:
+	b.server.capabilities, err = b.server.Capabilities()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// serverTransport creates the HTTP transport used to send requests to the server, with the
+// settings of the pool of connections, the proxy and the TLS configuration given in the builder.
+func (b *RunnerBuilder) serverTransport() (transport *http.Transport, err error) {
+	dialer := &net.Dialer{
+		Timeout:   serverDialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	transport = &http.Transport{
+		DialContext:         dialer.DialContext,
+		MaxIdleConns:        b.maxIdleConns,
+		MaxIdleConnsPerHost: b.maxIdleConns,
+		IdleConnTimeout:     b.idleConnTimeout,
+		DisableKeepAlives:   !b.keepAlives,
 	}
 	if b.proxy != "" {
 		var proxyURL *url.URL
 		proxyURL, err = url.Parse(b.proxy)
 		if err != nil {
-			return err
+			return
 		}
 		transport.Proxy = http.ProxyURL(proxyURL)
 	}
-	if b.insecure {
+	if b.insecure || b.caPool != nil {
 		transport.TLSClientConfig = &tls.Config{
 			InsecureSkipVerify: b.insecure,
+			RootCAs:            b.caPool,
 		}
 	}
+	err = http2.ConfigureTransport(transport)
+	return
+}
 
-	// Wait till the server is responding:
-	err = internal.WaitForServer(client, address)
+// ensureServerToken makes sure that the secret containing the token used to authenticate to the
+// server exists, and returns the token. If the secret already exists, because the project is being
+// reused, then the token stored in it is returned.
+func (b *RunnerBuilder) ensureServerToken() (token string, err error) {
+	// Generate a random token:
+	id, err := uuid.NewRandom()
 	if err != nil {
-		return err
+		return
 	}
+	token = id.String()
 
-	// Create and populate the object:
-	b.server = &Server{
-		token:   token,
-		address: address,
-		client:  client,
+	// Try to save the generated token to a secret. If this fails because the secret already
+	// exists then we discard the generated token and use the one in the existing secret
+	// instead.
+	labels := map[string]string{
+		internal.AppLabel: serverApp,
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   serverTokenSecretName,
+			Labels: labels,
+		},
+		Data: map[string][]byte{
+			serverTokenSecretKey: []byte(token),
+		},
+	}
+	secrets := b.coreV1.Secrets(b.project)
+	_, err = secrets.Create(secret)
+	if err == nil {
+		b.created.add("secret", secret.Name, secrets.Delete)
+	}
+	if errors.IsAlreadyExists(err) {
+		secret, err = secrets.Get(serverTokenSecretName, metav1.GetOptions{})
+		if err != nil {
+			return
+		}
+		data := secret.Data[serverTokenSecretKey]
+		if len(data) == 0 {
+			err = fmt.Errorf(
+				"server token secret '%s' already exists but the '%s' key is "+
+					"missing or empty",
+				secret.Name, serverTokenSecretKey,
+			)
+			return
+		}
+		log.Infof("Using existing server token from secret '%s'", secret.Name)
+		token = string(data)
 	}
 
-	return nil
+	return
+}
+
+// serverImage returns the image used to run the server and the cleaner. The value given with the
+// Image method takes precedence over the environment variable, and that over the default.
+func (b *RunnerBuilder) serverImage() string {
+	if b.image != "" {
+		return b.image
+	}
+	value := os.Getenv(sandboxImageEnv)
+	if value != "" {
+		return value
+	}
+	return sandboxImage
+}
+
+// databaseImage returns the image that the tests should use to run the database servers, or an
+// empty string if they should use the default.
+func (b *RunnerBuilder) databaseImage() string {
+	if b.dbImage != "" {
+		return b.dbImage
+	}
+	return os.Getenv(databaseImageEnv)
 }
 
 // Sandbox constants:
 const (
-	sandboxCommand = "/usr/local/bin/sandbox"
-	sandboxImage   = "quay.io/jhernand/sandbox"
+	sandboxCommand  = "/usr/local/bin/sandbox"
+	sandboxImage    = "quay.io/jhernand/sandbox"
+	sandboxImageEnv = "SANDBOX_IMAGE"
+)
+
+// Database constants:
+const (
+	databaseImageEnv = "SANDBOX_DB_IMAGE"
 )
 
 // Cleaner constants:
 const (
-	cleanerApp = "cleaner"
+	cleanerApp = internal.CleanerApp
+)
+
+// Warm-up constants. The timeout is long because pulling the image to a node that doesn't have
+// any of its layers may take several minutes:
+const (
+	warmupApp     = "warmup"
+	warmupTimeout = 5 * time.Minute
 )
 
 // Server constants:
 const (
-	serverApp     = "server"
-	serverAddress = "0.0.0.0"
-	serverPort    = 8000
-	serverWork    = "/var/cache/sandbox"
+	serverApp      = internal.ServerApp
+	serverAddress  = "" // All the IPv4 and IPv6 addresses.
+	serverPort     = 8000
+	serverWork     = "/var/cache/sandbox"
+	serverTokenDir = "/etc/sandbox/token"
+	serverTLSDir   = "/etc/sandbox/tls"
+)
+
+// Name of the secret that contains the TLS certificate and key of the server, generated by
+// OpenShift when the route uses passthrough termination:
+const serverTLSSecretName = "server-tls"
+
+// Timeouts used when connecting to the server:
+const (
+	defaultTimeout      = 10 * time.Minute
+	serverDialTimeout   = 5 * time.Second
+	serverProbeTimeout  = 10 * time.Second
+	serverTimeoutMargin = 1 * time.Minute
+)
+
+// Time to wait till the server responds through the route. It is longer when the runner doesn't
+// wait for the route to be admitted, as then it also includes the time that the router needs to
+// start forwarding requests to the server.
+const (
+	serverReadyTimeout    = 1 * time.Minute
+	serverRouteLagTimeout = 5 * time.Minute
+)
+
+// Time to wait till the completed pod of a previous server is deleted, and time between checks:
+const (
+	serverDeleteTimeout = 1 * time.Minute
+	serverDeletePoll    = 1 * time.Second
+)
+
+// Number of times that a test binary is sent again when the response of the server is truncated:
+const sendRetries = 1
+
+// Minimum and maximum time to wait before sending a test binary again when the server responds
+// that it is still running. These are variables so that tests can change them.
+var (
+	resendMinDelay = 1 * time.Second
+	resendMaxDelay = 30 * time.Second
+)
+
+// Default settings of the pool of connections to the server:
+const (
+	defaultMaxIdleConns    = 10
+	defaultIdleConnTimeout = 90 * time.Second
+)
+
+// Names of the secret, and of the key inside that secret, that contain the token used to
+// authenticate to the server:
+const (
+	serverTokenSecretName = "server-token"
+	serverTokenSecretKey  = "token"
 )
 
+// shuffleSeedRE is the regular expression used to extract the shuffle seed from the output of the
+// test binaries. When shuffling is enabled the binaries write a line like this at the beginning:
+//
+//	-test.shuffle 1574245743564538331
+var shuffleSeedRE = regexp.MustCompile(`(?m)^-test\.shuffle (\d+)$`)
+
+// defaultBinaryPattern is the default pattern used to find the test binaries, matching the names
+// generated by the 'go test -c ...' command.
+const defaultBinaryPattern = "*.test"
+
+// vetRE is the regular expression used to check the value of the vet option. It is 'off' or a
+// comma separated list of names of checks.
+var vetRE = regexp.MustCompile(`^[a-z0-9]+(,[a-z0-9]+)*$`)
+
 // The `go test -c ...` command needs to see the `./` prefix in the package names to understand
 // that they are relative:
 var dotSeparator = fmt.Sprintf(".%c", filepath.Separator)
@@ -0,0 +1,646 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	projectv1 "github.com/openshift/api/project/v1"
+	projectfake "github.com/openshift/client-go/project/clientset/versioned/fake"
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/jhernand/sandbox/pkg/api"
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+// fakeSender is an implementation of the Sender interface that doesn't send anything, but returns
+// prepared responses.
+type fakeSender struct {
+	// Responses for each binary, indexed by the content of the binary. Each time that the binary
+	// is sent the first response is removed and returned. A nil response means that the sender
+	// will return an error.
+	responses map[string][]*api.Test
+
+	// Requests received:
+	requests []*api.Test
+}
+
+// Send is the implementation of the Sender interface.
+func (s *fakeSender) Send(request *api.Test) (response *api.Test, err error) {
+	s.requests = append(s.requests, request)
+	binary := string(request.Binary)
+	responses := s.responses[binary]
+	if len(responses) == 0 {
+		err = fmt.Errorf("no response for binary '%s'", binary)
+		return
+	}
+	response = responses[0]
+	s.responses[binary] = responses[1:]
+	if response == nil {
+		err = fmt.Errorf("failed to send binary '%s'", binary)
+	}
+	return
+}
+
+// SendPlan sends each step of the plan as if it was a test sent alone, stopping after the first
+// failure if requested.
+func (s *fakeSender) SendPlan(ctx context.Context, request *api.Plan) (response *api.Plan,
+	err error) {
+	response = &api.Plan{}
+	for i := range request.Steps {
+		var step *api.Test
+		step, err = s.Send(&request.Steps[i])
+		if err != nil {
+			response = nil
+			return
+		}
+		response.Steps = append(response.Steps, *step)
+		if step.Code != 0 && request.StopOnFailure {
+			break
+		}
+	}
+	return
+}
+
+var _ = Describe("Run", func() {
+	var tmp string
+	var cwd string
+	var sender *fakeSender
+	var rnnr *Runner
+
+	BeforeEach(func() {
+		var err error
+
+		// Create a temporary directory for the test binaries and change into it, as that
+		// is where the runner looks for them:
+		tmp, err = ioutil.TempDir("", "runner")
+		Expect(err).ToNot(HaveOccurred())
+		cwd, err = os.Getwd()
+		Expect(err).ToNot(HaveOccurred())
+		err = os.Chdir(tmp)
+		Expect(err).ToNot(HaveOccurred())
+
+		// Create a runner that doesn't compile and that uses the fake sender:
+		sender = &fakeSender{
+			responses: map[string][]*api.Test{},
+		}
+		rnnr = &Runner{
+			dirs:         []string{"."},
+			pattern:      defaultBinaryPattern,
+			timeout:      time.Minute,
+			failNoTests:  true,
+			sender:       sender,
+			capabilities: &api.Capabilities{},
+		}
+	})
+
+	AfterEach(func() {
+		err := os.Chdir(cwd)
+		Expect(err).ToNot(HaveOccurred())
+		err = os.RemoveAll(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// prepare creates a test binary with the given name, and prepares the responses that the
+	// sender will return for it. The content of the binary is its name, so that the sender can
+	// find the responses.
+	prepare := func(name string, responses ...*api.Test) {
+		err := ioutil.WriteFile(name+".test", []byte(name), 0755)
+		Expect(err).ToNot(HaveOccurred())
+		sender.responses[name] = responses
+	}
+
+	It("Counts passed and failed binaries", func() {
+		prepare("a", &api.Test{Code: 0})
+		prepare("b", &api.Test{Code: 1})
+		prepare("c", &api.Test{Code: 0})
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Binaries).To(Equal(3))
+		Expect(summary.Passed).To(Equal(2))
+		Expect(summary.Failed).To(Equal(1))
+		Expect(summary.Results).To(HaveLen(3))
+		Expect(summary.Results[0].Binary).To(Equal("a.test"))
+		Expect(summary.Results[0].Passed).To(BeTrue())
+		Expect(summary.Results[1].Binary).To(Equal("b.test"))
+		Expect(summary.Results[1].Passed).To(BeFalse())
+		Expect(summary.Results[1].Code).To(Equal(1))
+		Expect(summary.Results[2].Binary).To(Equal("c.test"))
+		Expect(summary.Results[2].Passed).To(BeTrue())
+	})
+
+	It("Returns the number of failed binaries from Run", func() {
+		prepare("a", &api.Test{Code: 1})
+		prepare("b", &api.Test{Code: 2})
+		failed, err := rnnr.Run()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(failed).To(Equal(2))
+	})
+
+	It("Labels the summary with the profile", func() {
+		prepare("a", &api.Test{Code: 0})
+		rnnr.profile = "myprofile"
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Profile).To(Equal("myprofile"))
+	})
+
+	It("Records the result in the project when it is kept", func() {
+		client := projectfake.NewSimpleClientset(&projectv1.Project{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "myproject",
+			},
+		})
+		rnnr.keep = true
+		rnnr.project = "myproject"
+		rnnr.projectV1 = client.ProjectV1()
+		prepare("a", &api.Test{Code: 0})
+		prepare("b", &api.Test{Code: 1})
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		project, err := client.ProjectV1().Projects().Get("myproject", metav1.GetOptions{})
+		Expect(err).ToNot(HaveOccurred())
+		annotations := project.Annotations
+		Expect(annotations).To(HaveKeyWithValue(internal.ResultAnnotation, "failed"))
+		Expect(annotations).To(HaveKeyWithValue(internal.ResultBinariesAnnotation, "2"))
+		Expect(annotations).To(HaveKeyWithValue(internal.ResultFailedAnnotation, "1"))
+		Expect(annotations).To(HaveKey(internal.ResultAtAnnotation))
+	})
+
+	It("Doesn't record the result when the project will be deleted", func() {
+		client := projectfake.NewSimpleClientset()
+		rnnr.project = "myproject"
+		rnnr.projectV1 = client.ProjectV1()
+		prepare("a", &api.Test{Code: 0})
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(client.Actions()).To(BeEmpty())
+	})
+
+	It("Sends the binary, the checksum and the arguments", func() {
+		rnnr.shuffle = "on"
+		prepare("a", &api.Test{Code: 0})
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.requests).To(HaveLen(1))
+		request := sender.requests[0]
+		Expect(string(request.Binary)).To(Equal("a"))
+		Expect(request.Checksum).To(Equal(
+			"ca978112ca1bbdcafac231b39a23dc4da786eff8147c4e72b9807785afee48bb",
+		))
+		Expect(request.Args).To(ConsistOf("-test.shuffle=on"))
+		Expect(request.Key).To(BeEmpty())
+		Expect(request.Timeout).To(BeEmpty())
+	})
+
+	It("Sends the test parallelism", func() {
+		rnnr.shuffle = "on"
+		rnnr.testParallel = 2
+		prepare("a", &api.Test{Code: 0})
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.requests).To(HaveLen(1))
+		request := sender.requests[0]
+		Expect(request.Args).To(ConsistOf("-test.shuffle=on", "-test.parallel=2"))
+	})
+
+	It("Sends key and timeout when the server supports them", func() {
+		rnnr.capabilities.Idempotency = true
+		rnnr.capabilities.Timeout = true
+		prepare("a", &api.Test{Code: 0})
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.requests).To(HaveLen(1))
+		request := sender.requests[0]
+		Expect(request.Key).ToNot(BeEmpty())
+		Expect(request.Timeout).To(Equal("54s"))
+		Expect(request.Deadline).To(BeFalse())
+	})
+
+	It("Requests the deadline when enabled", func() {
+		rnnr.deadline = true
+		rnnr.capabilities.Timeout = true
+		rnnr.capabilities.Deadline = true
+		prepare("a", &api.Test{Code: 0})
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.requests).To(HaveLen(1))
+		request := sender.requests[0]
+		Expect(request.Timeout).To(Equal("54s"))
+		Expect(request.Deadline).To(BeTrue())
+	})
+
+	It("Fails if the deadline is enabled and the server doesn't support it", func() {
+		rnnr.deadline = true
+		rnnr.capabilities.Timeout = true
+		prepare("a", &api.Test{Code: 0})
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("deadline"))
+		Expect(sender.requests).To(BeEmpty())
+	})
+
+	It("Requests JSON events when the server supports them", func() {
+		rnnr.jsonEvents = true
+		rnnr.capabilities.JSONEvents = true
+		prepare("a", &api.Test{Code: 0})
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.requests).To(HaveLen(1))
+		request := sender.requests[0]
+		Expect(request.JSONEvents).To(BeTrue())
+		Expect(request.Package).To(Equal("a"))
+	})
+
+	It("Doesn't request JSON events when the server doesn't support them", func() {
+		rnnr.jsonEvents = true
+		prepare("a", &api.Test{Code: 0})
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sender.requests).To(HaveLen(1))
+		request := sender.requests[0]
+		Expect(request.JSONEvents).To(BeFalse())
+	})
+
+	It("Retries failed binaries and detects flaky ones", func() {
+		rnnr.retryFailed = 2
+		prepare("a", &api.Test{Code: 1}, &api.Test{Code: 0})
+		prepare("b", &api.Test{Code: 1}, &api.Test{Code: 1}, &api.Test{Code: 1})
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Flaky).To(Equal(1))
+		Expect(summary.Passed).To(BeZero())
+		Expect(summary.Failed).To(Equal(2))
+		Expect(summary.Passed + summary.Failed).To(Equal(summary.Binaries))
+		Expect(summary.Results[0].Flaky).To(BeTrue())
+		Expect(summary.Results[0].Passed).To(BeFalse())
+		Expect(summary.Results[0].Attempts).To(Equal(2))
+		Expect(summary.Results[1].Flaky).To(BeFalse())
+		Expect(summary.Results[1].Passed).To(BeFalse())
+		Expect(summary.Results[1].Attempts).To(Equal(3))
+	})
+
+	It("Doesn't count flaky binaries as failed if configured", func() {
+		rnnr.retryFailed = 1
+		rnnr.flakyPass = true
+		prepare("a", &api.Test{Code: 1}, &api.Test{Code: 0})
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Flaky).To(Equal(1))
+		Expect(summary.Passed).To(Equal(1))
+		Expect(summary.Failed).To(BeZero())
+		Expect(summary.Passed + summary.Failed).To(Equal(summary.Binaries))
+		Expect(summary.Results[0].Passed).To(BeTrue())
+	})
+
+	It("Skips the remaining binaries after a flaky one with fail fast", func() {
+		rnnr.retryFailed = 1
+		rnnr.failFast = true
+		prepare("a", &api.Test{Code: 1}, &api.Test{Code: 0})
+		prepare("b", &api.Test{Code: 0})
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Failed).To(Equal(1))
+		Expect(summary.Results).To(HaveLen(2))
+		Expect(summary.Results[1].Skipped).To(BeTrue())
+	})
+
+	It("Skips the remaining binaries after a failure with fail fast", func() {
+		rnnr.failFast = true
+		prepare("a", &api.Test{Code: 1})
+		prepare("b", &api.Test{Code: 0})
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Failed).To(Equal(1))
+		Expect(summary.Results).To(HaveLen(2))
+		Expect(summary.Results[1].Skipped).To(BeTrue())
+		Expect(sender.requests).To(HaveLen(1))
+	})
+
+	It("Records the error when a binary can't be sent", func() {
+		prepare("a", nil)
+		prepare("b", &api.Test{Code: 0})
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("a.test"))
+		Expect(summary.Error).To(Equal(err.Error()))
+		Expect(summary.Passed).To(Equal(1))
+		Expect(summary.Failed).To(Equal(1))
+		Expect(summary.Results).To(HaveLen(2))
+		Expect(summary.Results[0].Error).ToNot(BeEmpty())
+		Expect(summary.Results[0].Passed).To(BeFalse())
+		Expect(summary.Results[1].Passed).To(BeTrue())
+	})
+
+	It("Returns the errors of all the binaries that can't be sent", func() {
+		prepare("a", nil)
+		prepare("b", &api.Test{Code: 0})
+		prepare("c", nil)
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("can't run 2 test binaries"))
+		Expect(err.Error()).To(ContainSubstring("a.test"))
+		Expect(err.Error()).To(ContainSubstring("c.test"))
+		Expect(summary.Passed).To(Equal(1))
+		Expect(summary.Failed).To(Equal(2))
+	})
+
+	It("Stops after a binary that can't be sent with fail fast", func() {
+		rnnr.failFast = true
+		prepare("a", nil)
+		prepare("b", &api.Test{Code: 0})
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).To(HaveOccurred())
+		Expect(summary.Failed).To(Equal(1))
+		Expect(summary.Results).To(HaveLen(2))
+		Expect(summary.Results[1].Skipped).To(BeTrue())
+		Expect(sender.requests).To(HaveLen(1))
+	})
+
+	It("Reports the details of the output", func() {
+		prepare("a", &api.Test{
+			Code:      1,
+			Out:       []byte("myoutput"),
+			Err:       []byte("myerror"),
+			TimedOut:  true,
+			Truncated: true,
+			Dir:       "/work/mytenant/mytest",
+		})
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		result := summary.Results[0]
+		Expect(result.TimedOut).To(BeTrue())
+		Expect(result.Truncated).To(BeTrue())
+		Expect(result.Dir).To(Equal("/work/mytenant/mytest"))
+		Expect(summary.Dirs).To(ConsistOf("/work/mytenant/mytest"))
+	})
+
+	It("Uses the binary pattern", func() {
+		rnnr.pattern = "*.bin"
+		prepare("a", &api.Test{Code: 0})
+		err := ioutil.WriteFile("b.bin", []byte("b"), 0755)
+		Expect(err).ToNot(HaveOccurred())
+		sender.responses["b"] = []*api.Test{{Code: 0}}
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Results).To(HaveLen(1))
+		Expect(summary.Results[0].Binary).To(Equal("b.bin"))
+	})
+
+	It("Uses the explicitly given binaries", func() {
+		prepare("a", &api.Test{Code: 0})
+		prepare("b", &api.Test{Code: 0})
+		rnnr.binaries = []string{"b.test"}
+		summary, err := rnnr.RunAll(context.Background())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(summary.Results).To(HaveLen(1))
+		Expect(summary.Results[0].Binary).To(Equal("b.test"))
+	})
+
+	It("Fails if there are no binaries", func() {
+		_, err := rnnr.RunAll(context.Background())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Skips all the binaries if the context is cancelled", func() {
+		prepare("a", &api.Test{Code: 0})
+		prepare("b", &api.Test{Code: 0})
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		summary, err := rnnr.RunAll(ctx)
+		Expect(err).To(Equal(context.Canceled))
+		Expect(summary.Results).To(HaveLen(2))
+		Expect(summary.Results[0].Skipped).To(BeTrue())
+		Expect(summary.Results[1].Skipped).To(BeTrue())
+		Expect(sender.requests).To(BeEmpty())
+	})
+
+	Describe("Quiet mode", func() {
+		var hook *logtest.Hook
+
+		BeforeEach(func() {
+			hook = logtest.NewGlobal()
+		})
+
+		AfterEach(func() {
+			log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		})
+
+		// messages returns the messages written to the log at info level or above that
+		// mention the given binary.
+		messages := func(binary string) []string {
+			var result []string
+			for _, entry := range hook.AllEntries() {
+				if entry.Level <= log.InfoLevel && strings.Contains(entry.Message, binary) {
+					result = append(result, entry.Message)
+				}
+			}
+			return result
+		}
+
+		It("Reports all the binaries by default", func() {
+			prepare("a", &api.Test{Code: 0, Out: []byte("ok")})
+			_, err := rnnr.RunAll(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(messages("a.test")).To(ContainElement("Running test binary 'a.test'"))
+			Expect(messages("a.test")).To(ContainElement("Output of test binary 'a.test' follows"))
+		})
+
+		It("Reports only the binaries that fail", func() {
+			rnnr.quiet = true
+			prepare("a", &api.Test{Code: 0, Out: []byte("ok")})
+			prepare("b", &api.Test{Code: 1, Out: []byte("fail")})
+			_, err := rnnr.RunAll(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(messages("a.test")).To(BeEmpty())
+			Expect(messages("b.test")).To(ContainElement("Output of test binary 'b.test' follows"))
+		})
+
+		It("Reports binaries that time out", func() {
+			rnnr.quiet = true
+			prepare("a", &api.Test{Code: 0, TimedOut: true})
+			_, err := rnnr.RunAll(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(messages("a.test")).ToNot(BeEmpty())
+		})
+
+		It("Writes the summary at the end", func() {
+			rnnr.quiet = true
+			prepare("a", &api.Test{Code: 0})
+			prepare("b", &api.Test{Code: 1})
+			_, err := rnnr.RunAll(context.Background())
+			Expect(err).ToNot(HaveOccurred())
+			entries := hook.AllEntries()
+			Expect(entries).ToNot(BeEmpty())
+			last := entries[len(entries)-1]
+			Expect(last.Message).To(Equal("Passed 1 of 2 test binaries, 1 failed"))
+		})
+	})
+})
+
+var _ = Describe("Images", func() {
+	AfterEach(func() {
+		os.Unsetenv(sandboxImageEnv)
+		os.Unsetenv(databaseImageEnv)
+	})
+
+	It("Uses the default server image", func() {
+		os.Unsetenv(sandboxImageEnv)
+		Expect(NewRunner().serverImage()).To(Equal(sandboxImage))
+	})
+
+	It("Uses the server image from the environment", func() {
+		os.Setenv(sandboxImageEnv, "mirror.example.com/sandbox")
+		Expect(NewRunner().serverImage()).To(Equal("mirror.example.com/sandbox"))
+	})
+
+	It("Prefers the explicit server image to the environment", func() {
+		os.Setenv(sandboxImageEnv, "mirror.example.com/sandbox")
+		builder := NewRunner().Image("other.example.com/sandbox")
+		Expect(builder.serverImage()).To(Equal("other.example.com/sandbox"))
+	})
+
+	It("Uses the default database image", func() {
+		os.Unsetenv(databaseImageEnv)
+		Expect(NewRunner().databaseImage()).To(BeEmpty())
+	})
+
+	It("Prefers the explicit database image to the environment", func() {
+		os.Setenv(databaseImageEnv, "mirror.example.com/postgresql")
+		Expect(NewRunner().databaseImage()).To(Equal("mirror.example.com/postgresql"))
+		builder := NewRunner().DatabaseImage("other.example.com/postgresql")
+		Expect(builder.databaseImage()).To(Equal("other.example.com/postgresql"))
+	})
+})
+
+// fakeKeyedServer is an HTTP handler that simulates a server where the route cuts the connection
+// while the test is still running. The response to the first request is truncated, then it
+// responds with a conflict the given number of times, and then it returns the result.
+type fakeKeyedServer struct {
+	lock      sync.Mutex
+	conflicts int
+	keys      []string
+}
+
+func (f *fakeKeyedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	request := &api.Test{}
+	err := json.NewDecoder(r.Body).Decode(request)
+	Expect(err).ToNot(HaveOccurred())
+	f.keys = append(f.keys, request.Key)
+	switch {
+	case len(f.keys) == 1:
+		w.Header().Set("Content-Type", "application/json")
+		_, err = w.Write([]byte(`{"code":`))
+		Expect(err).ToNot(HaveOccurred())
+	case len(f.keys) <= f.conflicts+1:
+		http.Error(w, "Test is still running", http.StatusConflict)
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		err = json.NewEncoder(w).Encode(&api.Test{Code: 0, Out: []byte("ok")})
+		Expect(err).ToNot(HaveOccurred())
+	}
+}
+
+var _ = Describe("Resend", func() {
+	var minDelay time.Duration
+	var maxDelay time.Duration
+	var tmp string
+	var binary string
+	var fake *fakeKeyedServer
+	var listener *httptest.Server
+	var rnnr *Runner
+
+	BeforeEach(func() {
+		var err error
+		minDelay = resendMinDelay
+		maxDelay = resendMaxDelay
+		resendMinDelay = time.Millisecond
+		resendMaxDelay = 4 * time.Millisecond
+		tmp, err = ioutil.TempDir("", "runner")
+		Expect(err).ToNot(HaveOccurred())
+		binary = filepath.Join(tmp, "a.test")
+		err = ioutil.WriteFile(binary, []byte("a"), 0755)
+		Expect(err).ToNot(HaveOccurred())
+		fake = &fakeKeyedServer{}
+		listener = httptest.NewServer(fake)
+		server, err := NewServer().
+			Address(listener.URL).
+			Token("mytoken").
+			Build()
+		Expect(err).ToNot(HaveOccurred())
+		rnnr = &Runner{
+			timeout:      time.Minute,
+			sender:       server,
+			capabilities: &api.Capabilities{Idempotency: true},
+		}
+	})
+
+	AfterEach(func() {
+		listener.Close()
+		resendMinDelay = minDelay
+		resendMaxDelay = maxDelay
+		err := os.RemoveAll(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Waits for the result if the test is still running", func() {
+		fake.conflicts = 5
+		response, err := rnnr.runBinary(context.Background(), binary, nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Code).To(Equal(0))
+		Expect(response.Out).To(Equal([]byte("ok")))
+		Expect(fake.keys).To(HaveLen(7))
+		for _, key := range fake.keys {
+			Expect(key).To(Equal(fake.keys[0]))
+		}
+	})
+
+	It("Gives up when the timeout expires", func() {
+		fake.conflicts = 1000
+		rnnr.timeout = 50 * time.Millisecond
+		_, err := rnnr.runBinary(context.Background(), binary, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("409"))
+		Expect(len(fake.keys)).To(BeNumerically("<", 100))
+	})
+
+	It("Gives up when the context is cancelled", func() {
+		fake.conflicts = 1000
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := rnnr.runBinary(ctx, binary, nil)
+		Expect(err).To(HaveOccurred())
+		Expect(len(fake.keys)).To(BeNumerically("<", 100))
+	})
+})
@@ -21,29 +21,169 @@ package runner
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 
 	"github.com/jhernand/sandbox/pkg/api"
 )
 
+// Sender is the interface of the objects that send test binaries to the server and return the
+// results. The Server type implements it, and the runner uses it instead of the Server type
+// directly, so that it can be replaced by a fake implementation in unit tests. If the object
+// also has a SendContext method, with the same signature as the one of the Server type, the
+// runner will use it so that requests are cancelled when the run is cancelled.
+type Sender interface {
+	Send(request *api.Test) (response *api.Test, err error)
+}
+
+// contextSender is the interface of the senders that support cancellation.
+type contextSender interface {
+	SendContext(ctx context.Context, request *api.Test) (response *api.Test, err error)
+}
+
+// planSender is the interface of the senders that support plans.
+type planSender interface {
+	SendPlan(ctx context.Context, request *api.Plan) (response *api.Plan, err error)
+}
+
 // Server simplifies the interaction with the server.
 type Server struct {
-	// Token and address of the server:
-	token   string
-	address string
+	// Token, address and base path of the server:
+	token    string
+	address  string
+	basePath string
 
 	// HTTP client:
 	client *http.Client
+
+	// Capabilities of the server:
+	capabilities *api.Capabilities
+}
+
+// ServerBuilder contains the information needed to create an object that interacts with a server
+// that is already running, for example one started by other means than the runner. Don't create
+// instances of this type directly; use the NewServer function instead.
+type ServerBuilder struct {
+	address  string
+	token    string
+	basePath string
+	client   *http.Client
+}
+
+// NewServer creates a new object that knows how to build objects that interact with an already
+// running server.
+func NewServer() *ServerBuilder {
+	return &ServerBuilder{}
+}
+
+// Address sets the URL of the server, for example 'http://127.0.0.1:8000'. This is mandatory.
+func (b *ServerBuilder) Address(value string) *ServerBuilder {
+	b.address = value
+	return b
+}
+
+// Token sets the authentication token that will be sent to the server. This is mandatory.
+func (b *ServerBuilder) Token(value string) *ServerBuilder {
+	b.token = value
+	return b
+}
+
+// BasePath sets the path prefix of the URLs of the server. The default is empty.
+func (b *ServerBuilder) BasePath(value string) *ServerBuilder {
+	b.basePath = value
+	return b
+}
+
+// Client sets the HTTP client that will be used to send the requests. The default is a client
+// without timeout, as the execution of tests can take a long time.
+func (b *ServerBuilder) Client(value *http.Client) *ServerBuilder {
+	b.client = value
+	return b
+}
+
+// Build uses the information stored in the builder to create the object. Note that this doesn't
+// check that the server is running, and doesn't retrieve its capabilities. Use the Capabilities
+// method for that.
+func (b *ServerBuilder) Build() (server *Server, err error) {
+	// Check parameters:
+	if b.address == "" {
+		err = fmt.Errorf("address is mandatory")
+		return
+	}
+	if b.token == "" {
+		err = fmt.Errorf("token is mandatory")
+		return
+	}
+
+	// Create the default client if needed:
+	client := b.client
+	if client == nil {
+		client = &http.Client{}
+	}
+
+	// Create and populate the object:
+	server = &Server{
+		token:        b.token,
+		address:      strings.TrimRight(b.address, "/"),
+		basePath:     strings.TrimRight(b.basePath, "/"),
+		client:       client,
+		capabilities: &api.Capabilities{},
+	}
+
+	return
 }
 
 // Send sends the test to the server, waits for it to be executed and returns the results.
 func (s *Server) Send(request *api.Test) (response *api.Test, err error) {
+	return s.SendContext(context.Background(), request)
+}
+
+// SendContext is like Send, but the request is cancelled if the given context is cancelled
+// before the response is received.
+func (s *Server) SendContext(ctx context.Context, request *api.Test) (response *api.Test,
+	err error) {
+	response = &api.Test{}
+	err = s.post(ctx, "tests", request, response)
+	if err != nil {
+		response = nil
+		return
+	}
+	return
+}
+
+// SendPlan sends a plan to the server, waits till all its steps are executed and returns the
+// results. The request is cancelled if the given context is cancelled before the response is
+// received. Note that the complete plan is executed in one request, so when the server is behind
+// a route all the steps need to finish before the timeout of the route.
+func (s *Server) SendPlan(ctx context.Context, request *api.Plan) (response *api.Plan,
+	err error) {
+	response = &api.Plan{}
+	err = s.post(ctx, "plans", request, response)
+	if err != nil {
+		response = nil
+		return
+	}
+	return
+}
+
+// post sends a POST request to the given resource of the API of the server, and decodes the
+// response body into the given object.
+func (s *Server) post(ctx context.Context, resource string, request, response interface{}) (
+	err error) {
 	// Calculate the request address:
-	httpAddress := fmt.Sprintf("%s%s/%s/tests", s.address, api.Prefix, api.Version)
+	httpAddress := fmt.Sprintf(
+		"%s%s%s/%s/%s",
+		s.address, s.basePath, api.Prefix, api.Version, resource,
+	)
 	log.Debugf("Sending POST request to '%s'", httpAddress)
 
 	// Serialize the request body:
@@ -61,26 +201,200 @@ func (s *Server) Send(request *api.Test) (response *api.Test, err error) {
 	if err != nil {
 		return
 	}
+	httpRequest = httpRequest.WithContext(ctx)
 	httpRequest.Header.Set("Authorization", httpAuthorization)
 	httpRequest.Header.Set("Content-Type", "application/json")
+	httpRequest.Header.Set("Accept-Encoding", "gzip")
+	httpResponse, err := s.client.Do(httpRequest)
+	if err != nil {
+		return
+	}
+	httpClose := func() {
+		// Make sure that the body is completely read, otherwise the connection can't be
+		// reused:
+		_, err := io.Copy(ioutil.Discard, httpResponse.Body)
+		if err != nil {
+			log.Errorf("Can't discard response body: %v", err)
+		}
+		err = httpResponse.Body.Close()
+		if err != nil {
+			log.Errorf("Can't close response body: %v", err)
+		}
+	}
+	defer httpClose()
+	if httpResponse.StatusCode != http.StatusOK {
+		err = &StatusError{
+			code: httpResponse.StatusCode,
+		}
+		return
+	}
+
+	// Decompress the response body if needed. Note that the HTTP client doesn't do this
+	// automatically because we explicitly set the Accept-Encoding header.
+	var httpReader io.Reader = httpResponse.Body
+	if httpResponse.Header.Get("Content-Encoding") == "gzip" {
+		var gzipReader *gzip.Reader
+		gzipReader, err = gzip.NewReader(httpResponse.Body)
+		if err != nil {
+			return
+		}
+		defer gzipReader.Close()
+		httpReader = gzipReader
+	}
+
+	// Deserialize the response body. If the body ends before the JSON document is complete it
+	// is very likely that the OpenShift router cut the connection because the route timeout
+	// expired, so we return an error that explains that and that can be retried.
+	err = json.NewDecoder(httpReader).Decode(response)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = &TruncatedError{
+			cause: err,
+		}
+	}
+	return
+}
+
+// TruncatedError is the error returned by the Send method when the response of the server ends
+// before the complete JSON document is received. This usually happens when the test takes longer
+// than the timeout of the route, and the OpenShift router cuts the connection. The request can be
+// retried; if the server supports idempotency keys it will then return the saved result instead
+// of running the test again.
+type TruncatedError struct {
+	cause error
+}
+
+// Error is the implementation of the error interface.
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf(
+		"server response was truncated, the test likely exceeded the route timeout: %v",
+		e.cause,
+	)
+}
+
+// IsTruncated checks if the given error is a truncated response error.
+func IsTruncated(err error) bool {
+	_, ok := err.(*TruncatedError)
+	return ok
+}
+
+// StatusError is the error returned by the Send method when the server responds with a status
+// code other than 200.
+type StatusError struct {
+	code int
+}
+
+// Error is the implementation of the error interface.
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("send failed with status code %d", e.code)
+}
+
+// Code returns the status code that the server responded with.
+func (e *StatusError) Code() int {
+	return e.code
+}
+
+// IsConflict checks if the given error is a status error with code 409. The server responds with
+// that code when a test is sent again with the key of a test that is still running.
+func IsConflict(err error) bool {
+	status, ok := err.(*StatusError)
+	return ok && status.code == http.StatusConflict
+}
+
+// PutFixture uploads the content of a fixture to the server.
+func (s *Server) PutFixture(name string, content io.Reader) (response *api.Fixture, err error) {
+	// Calculate the request address:
+	httpAddress := fmt.Sprintf(
+		"%s%s%s/%s/fixtures/%s",
+		s.address, s.basePath, api.Prefix, api.Version, url.PathEscape(name),
+	)
+	log.Debugf("Sending PUT request to '%s'", httpAddress)
+
+	// Prepare the authorization header:
+	httpAuthorization := fmt.Sprintf("Bearer %s", s.token)
+
+	// Send the HTTP request:
+	httpRequest, err := http.NewRequest(http.MethodPut, httpAddress, content)
+	if err != nil {
+		return
+	}
+	httpRequest.Header.Set("Authorization", httpAuthorization)
+	httpRequest.Header.Set("Content-Type", "application/octet-stream")
 	httpResponse, err := s.client.Do(httpRequest)
 	if err != nil {
 		return
 	}
 	httpClose := func() {
-		err := httpResponse.Body.Close()
+		_, err := io.Copy(ioutil.Discard, httpResponse.Body)
+		if err != nil {
+			log.Errorf("Can't discard response body: %v", err)
+		}
+		err = httpResponse.Body.Close()
 		if err != nil {
 			log.Errorf("Can't close response body: %v", err)
 		}
 	}
 	defer httpClose()
 	if httpResponse.StatusCode != http.StatusOK {
-		err = fmt.Errorf("send failed with status code %d", httpResponse.StatusCode)
+		err = fmt.Errorf("upload failed with status code %d", httpResponse.StatusCode)
+		return
+	}
+
+	// Deserialize the response body:
+	response = &api.Fixture{}
+	err = json.NewDecoder(httpResponse.Body).Decode(response)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// Capabilities retrieves from the server the description of the features that it supports. Servers
+// older than the capabilities endpoint respond with status code 404, and in that case it returns
+// an empty description, meaning that none of the optional features is supported.
+func (s *Server) Capabilities() (response *api.Capabilities, err error) {
+	// Calculate the request address:
+	httpAddress := fmt.Sprintf(
+		"%s%s%s/%s/capabilities",
+		s.address, s.basePath, api.Prefix, api.Version,
+	)
+	log.Debugf("Sending GET request to '%s'", httpAddress)
+
+	// Prepare the authorization header:
+	httpAuthorization := fmt.Sprintf("Bearer %s", s.token)
+
+	// Send the HTTP request:
+	httpRequest, err := http.NewRequest(http.MethodGet, httpAddress, nil)
+	if err != nil {
+		return
+	}
+	httpRequest.Header.Set("Authorization", httpAuthorization)
+	httpResponse, err := s.client.Do(httpRequest)
+	if err != nil {
+		return
+	}
+	httpClose := func() {
+		_, err := io.Copy(ioutil.Discard, httpResponse.Body)
+		if err != nil {
+			log.Errorf("Can't discard response body: %v", err)
+		}
+		err = httpResponse.Body.Close()
+		if err != nil {
+			log.Errorf("Can't close response body: %v", err)
+		}
+	}
+	defer httpClose()
+	response = &api.Capabilities{}
+	if httpResponse.StatusCode == http.StatusNotFound {
+		log.Debugf("Server doesn't support capabilities, will assume it has none")
+		return
+	}
+	if httpResponse.StatusCode != http.StatusOK {
+		err = fmt.Errorf("capabilities failed with status code %d", httpResponse.StatusCode)
 		return
 	}
 
 	// Deserialize the response body:
-	response = &api.Test{}
 	err = json.NewDecoder(httpResponse.Body).Decode(response)
 	if err != nil {
 		return
@@ -94,6 +408,17 @@ func (s *Server) Address() string {
 	return s.address
 }
 
+// Token returns the token used to authenticate to the server. Note that this is sensitive, it
+// gives access to run arbitrary code inside the project.
+func (s *Server) Token() string {
+	return s.token
+}
+
+// BasePath returns the path prefix of the URLs of the server.
+func (s *Server) BasePath() string {
+	return s.basePath
+}
+
 // Server returns the object that is used to interact with the server.
 func (r *Runner) Server() *Server {
 	return r.server
@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ = Describe("Transport", func() {
+	var tmp string
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "runner")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Uses the default pool settings", func() {
+		transport, err := NewRunner().serverTransport()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(transport.MaxIdleConns).To(Equal(defaultMaxIdleConns))
+		Expect(transport.MaxIdleConnsPerHost).To(Equal(defaultMaxIdleConns))
+		Expect(transport.IdleConnTimeout).To(Equal(defaultIdleConnTimeout))
+		Expect(transport.DisableKeepAlives).To(BeFalse())
+	})
+
+	It("Uses the configured pool settings", func() {
+		transport, err := NewRunner().
+			MaxIdleConns(3).
+			IdleConnTimeout(5 * time.Second).
+			serverTransport()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(transport.MaxIdleConns).To(Equal(3))
+		Expect(transport.MaxIdleConnsPerHost).To(Equal(3))
+		Expect(transport.IdleConnTimeout).To(Equal(5 * time.Second))
+	})
+
+	It("Can disable keep alives", func() {
+		transport, err := NewRunner().
+			KeepAlives(false).
+			serverTransport()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(transport.DisableKeepAlives).To(BeTrue())
+	})
+
+	It("Uses the proxy", func() {
+		transport, err := NewRunner().
+			Proxy("http://myproxy:3128").
+			serverTransport()
+		Expect(err).ToNot(HaveOccurred())
+		request, err := http.NewRequest(http.MethodGet, "https://myserver", nil)
+		Expect(err).ToNot(HaveOccurred())
+		proxy, err := transport.Proxy(request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(proxy.String()).To(Equal("http://myproxy:3128"))
+	})
+
+	It("Rejects negative maximum of idle connections", func() {
+		_, err := NewRunner().
+			Directory(tmp).
+			MaxIdleConns(-1).
+			Build()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("idle connections can't be negative"))
+	})
+
+	It("Rejects negative idle connection timeout", func() {
+		_, err := NewRunner().
+			Directory(tmp).
+			IdleConnTimeout(-time.Second).
+			Build()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("idle connection timeout can't be negative"))
+	})
+
+	It("Rejects negative server idle timeout", func() {
+		_, err := NewRunner().
+			Directory(tmp).
+			ServerIdleTimeout(-time.Second).
+			Build()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("server idle timeout can't be negative"))
+	})
+})
+
+// benchmarkSend sends tests in parallel to a fake server, using a transport created with the
+// given builder.
+func benchmarkSend(b *testing.B, builder *RunnerBuilder) {
+	fake := httptest.NewUnstartedServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(&api.Test{})
+		},
+	))
+	fake.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	fake.StartTLS()
+	defer fake.Close()
+	transport, err := builder.Insecure(true).serverTransport()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer transport.CloseIdleConnections()
+	server, err := NewServer().
+		Address(fake.URL).
+		Token("mytoken").
+		Client(&http.Client{Transport: transport}).
+		Build()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_, err := server.Send(&api.Test{})
+			if err != nil {
+				b.Error(err)
+				return
+			}
+		}
+	})
+}
+
+// BenchmarkSendKeepAlives measures sending tests in parallel reusing the connections.
+func BenchmarkSendKeepAlives(b *testing.B) {
+	benchmarkSend(b, NewRunner())
+}
+
+// BenchmarkSendNoKeepAlives measures sending tests in parallel opening a new connection for each
+// of them.
+func BenchmarkSendNoKeepAlives(b *testing.B) {
+	benchmarkSend(b, NewRunner().KeepAlives(false))
+}
+
+// BenchmarkSendFewIdleConns measures sending tests in parallel keeping only two idle connections,
+// which is the default of the Go HTTP transport.
+func BenchmarkSendFewIdleConns(b *testing.B) {
+	benchmarkSend(b, NewRunner().MaxIdleConns(2))
+}
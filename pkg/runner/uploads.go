@@ -0,0 +1,225 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that uploads large test binaries to the server in chunks.
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+// uploadSender is the interface of the senders that can upload binaries in chunks.
+type uploadSender interface {
+	Upload(ctx context.Context, data []byte) (id string, err error)
+}
+
+// UploadThreshold sets the size in bytes above which test binaries are uploaded to the server in
+// chunks before running them, instead of sending them inside the request. When sending a chunk
+// fails, for example because the route is flaky, the upload continues from the last byte that
+// the server received, instead of starting again. Zero means that binaries are always sent inside
+// the request. This is ignored if the server doesn't support uploads. The default is 32 MiB.
+func (b *RunnerBuilder) UploadThreshold(value int64) *RunnerBuilder {
+	b.uploadMin = value
+	return b
+}
+
+// attachBinary adds the given test binary to the request. Binaries smaller than the upload
+// threshold are added inside the request. Larger ones are uploaded in chunks first, if the server
+// supports it, and then only the identifier of the upload is added.
+func (r *Runner) attachBinary(ctx context.Context, binary string, data []byte,
+	request *api.Test) error {
+	sender, ok := r.sender.(uploadSender)
+	if !ok || !r.capabilities.Uploads || r.uploadMin <= 0 ||
+		int64(len(data)) <= r.uploadMin {
+		request.Binary = data
+		return nil
+	}
+	log.Infof("Uploading test binary '%s' of %d bytes in chunks", binary, len(data))
+	id, err := sender.Upload(ctx, data)
+	if err != nil {
+		return fmt.Errorf("can't upload test binary '%s': %v", binary, err)
+	}
+	request.UploadID = id
+	return nil
+}
+
+// Upload uploads the given binary to the server in chunks, and returns the identifier of the
+// upload, to use in the UploadID field of tests. When sending a chunk fails it retrieves from the
+// server the number of bytes that it received, and continues from there.
+func (s *Server) Upload(ctx context.Context, data []byte) (id string, err error) {
+	// Create the upload:
+	sum := sha256.Sum256(data)
+	request := &api.Upload{
+		Size:     int64(len(data)),
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+	upload := &api.Upload{}
+	err = s.post(ctx, "uploads", request, upload)
+	if err != nil {
+		return
+	}
+	log.Debugf("Created upload '%s'", upload.ID)
+
+	// Send the chunks, resuming after failures:
+	failures := 0
+	for !upload.Complete {
+		start := upload.Received
+		end := start + uploadChunkSize
+		if end > upload.Size {
+			end = upload.Size
+		}
+		var state *api.Upload
+		state, err = s.patchUpload(ctx, upload.ID, start, data[start:end], upload.Size)
+		if err == nil {
+			upload = state
+			failures = 0
+			continue
+		}
+		failures++
+		if failures > uploadRetries || ctx.Err() != nil {
+			err = fmt.Errorf(
+				"can't send chunk of upload '%s' starting at byte %d: %v",
+				upload.ID, start, err,
+			)
+			return
+		}
+		log.Warnf(
+			"Can't send chunk of upload '%s' starting at byte %d, will resume, "+
+				"attempt %d of %d: %v",
+			upload.ID, start, failures, uploadRetries, err,
+		)
+		select {
+		case <-ctx.Done():
+		case <-time.After(uploadRetryDelay):
+		}
+		state, err = s.getUpload(ctx, upload.ID)
+		if err != nil {
+			log.Warnf("Can't get state of upload '%s': %v", upload.ID, err)
+			continue
+		}
+		upload = state
+	}
+	id = upload.ID
+	err = nil
+	return
+}
+
+// getUpload retrieves the state of the given upload.
+func (s *Server) getUpload(ctx context.Context, id string) (response *api.Upload, err error) {
+	// Calculate the request address:
+	httpAddress := fmt.Sprintf(
+		"%s%s%s/%s/uploads/%s",
+		s.address, s.basePath, api.Prefix, api.Version, url.PathEscape(id),
+	)
+	log.Debugf("Sending GET request to '%s'", httpAddress)
+
+	// Send the HTTP request:
+	httpRequest, err := http.NewRequest(http.MethodGet, httpAddress, nil)
+	if err != nil {
+		return
+	}
+	httpRequest = httpRequest.WithContext(ctx)
+	return s.sendUpload(httpRequest)
+}
+
+// patchUpload sends a chunk of the given upload, starting at the given offset.
+func (s *Server) patchUpload(ctx context.Context, id string, offset int64, chunk []byte,
+	size int64) (response *api.Upload, err error) {
+	// Calculate the request address:
+	httpAddress := fmt.Sprintf(
+		"%s%s%s/%s/uploads/%s",
+		s.address, s.basePath, api.Prefix, api.Version, url.PathEscape(id),
+	)
+	log.Debugf("Sending PATCH request to '%s'", httpAddress)
+
+	// Send the HTTP request:
+	httpRequest, err := http.NewRequest(http.MethodPatch, httpAddress, bytes.NewReader(chunk))
+	if err != nil {
+		return
+	}
+	httpRequest = httpRequest.WithContext(ctx)
+	httpRequest.Header.Set("Content-Type", "application/octet-stream")
+	httpRequest.Header.Set(
+		"Content-Range",
+		fmt.Sprintf("bytes %d-%d/%d", offset, offset+int64(len(chunk))-1, size),
+	)
+	return s.sendUpload(httpRequest)
+}
+
+// sendUpload adds the authorization header to the given request, sends it, and decodes the
+// state of the upload returned by the server.
+func (s *Server) sendUpload(httpRequest *http.Request) (response *api.Upload, err error) {
+	httpRequest.Header.Set("Authorization", fmt.Sprintf("Bearer %s", s.token))
+	httpResponse, err := s.client.Do(httpRequest)
+	if err != nil {
+		return
+	}
+	httpClose := func() {
+		_, err := io.Copy(ioutil.Discard, httpResponse.Body)
+		if err != nil {
+			log.Errorf("Can't discard response body: %v", err)
+		}
+		err = httpResponse.Body.Close()
+		if err != nil {
+			log.Errorf("Can't close response body: %v", err)
+		}
+	}
+	defer httpClose()
+	if httpResponse.StatusCode != http.StatusOK {
+		err = fmt.Errorf("upload failed with status code %d", httpResponse.StatusCode)
+		return
+	}
+
+	// Deserialize the response body:
+	response = &api.Upload{}
+	err = json.NewDecoder(httpResponse.Body).Decode(response)
+	if err != nil {
+		response = nil
+		return
+	}
+
+	return
+}
+
+// Upload constants:
+const (
+	// Default size above which test binaries are uploaded in chunks:
+	defaultUploadThreshold = 32 << 20
+
+	// Size of the chunks:
+	uploadChunkSize = 8 << 20
+
+	// Number of consecutive times that sending a chunk is retried:
+	uploadRetries = 5
+)
+
+// Time to wait before retrying to send a chunk. This is a variable so that tests can change it.
+var uploadRetryDelay = time.Second
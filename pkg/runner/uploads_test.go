@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+// fakeUploads is an HTTP handler that implements the uploads endpoint in memory. The first chunk
+// that it receives is cut after the given number of bytes and answered with an error, like when a
+// flaky route closes the connection.
+type fakeUploads struct {
+	lock    sync.Mutex
+	cut     int
+	upload  *api.Upload
+	data    []byte
+	patches int
+}
+
+func (f *fakeUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	switch {
+	case r.Method == http.MethodPost:
+		f.upload = &api.Upload{}
+		err := json.NewDecoder(r.Body).Decode(f.upload)
+		Expect(err).ToNot(HaveOccurred())
+		f.upload.ID = "myupload"
+	case r.Method == http.MethodPatch:
+		f.patches++
+		var start, end, total int64
+		_, err := fmt.Sscanf(
+			r.Header.Get("Content-Range"), "bytes %d-%d/%d",
+			&start, &end, &total,
+		)
+		Expect(err).ToNot(HaveOccurred())
+		if start != int64(len(f.data)) {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		chunk, err := ioutil.ReadAll(r.Body)
+		Expect(err).ToNot(HaveOccurred())
+		if f.patches == 1 {
+			f.data = append(f.data, chunk[:f.cut]...)
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		f.data = append(f.data, chunk...)
+	}
+	f.upload.Received = int64(len(f.data))
+	f.upload.Complete = f.upload.Received == f.upload.Size
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(f.upload)
+	Expect(err).ToNot(HaveOccurred())
+}
+
+var _ = Describe("Uploads", func() {
+	var delay time.Duration
+	var fake *fakeUploads
+	var listener *httptest.Server
+	var server *Server
+
+	BeforeEach(func() {
+		var err error
+		delay = uploadRetryDelay
+		uploadRetryDelay = time.Millisecond
+		fake = &fakeUploads{
+			cut: 10,
+		}
+		listener = httptest.NewServer(fake)
+		server, err = NewServer().
+			Address(listener.URL).
+			Token("mytoken").
+			Build()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		listener.Close()
+		uploadRetryDelay = delay
+	})
+
+	It("Resumes the upload after a failed chunk", func() {
+		data := []byte(strings.Repeat("0123456789", 10))
+		id, err := server.Upload(context.Background(), data)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(id).To(Equal("myupload"))
+		Expect(fake.patches).To(Equal(2))
+		Expect(fake.data).To(Equal(data))
+	})
+
+	It("Sends small binaries inside the request", func() {
+		rnnr := &Runner{
+			sender:       server,
+			capabilities: &api.Capabilities{Uploads: true},
+			uploadMin:    1000,
+		}
+		request := &api.Test{}
+		err := rnnr.attachBinary(context.Background(), "a.test", []byte("small"), request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(request.Binary).To(Equal([]byte("small")))
+		Expect(request.UploadID).To(BeEmpty())
+		Expect(fake.upload).To(BeNil())
+	})
+
+	It("Uploads large binaries if the server supports it", func() {
+		rnnr := &Runner{
+			sender:       server,
+			capabilities: &api.Capabilities{Uploads: true},
+			uploadMin:    10,
+		}
+		data := bytes.Repeat([]byte("x"), 100)
+		request := &api.Test{}
+		err := rnnr.attachBinary(context.Background(), "a.test", data, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(request.Binary).To(BeNil())
+		Expect(request.UploadID).To(Equal("myupload"))
+		Expect(fake.data).To(Equal(data))
+	})
+
+	It("Sends large binaries inside the request if the server doesn't support uploads", func() {
+		rnnr := &Runner{
+			sender:       server,
+			capabilities: &api.Capabilities{},
+			uploadMin:    10,
+		}
+		data := bytes.Repeat([]byte("x"), 100)
+		request := &api.Test{}
+		err := rnnr.attachBinary(context.Background(), "a.test", data, request)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(request.Binary).To(Equal(data))
+		Expect(request.UploadID).To(BeEmpty())
+	})
+})
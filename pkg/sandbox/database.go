@@ -22,9 +22,9 @@ import (
 	"database/sql"
 	"fmt"
 	"net/url"
+	"time"
 
 	"github.com/google/uuid"
-	_ "github.com/lib/pq"
 	log "github.com/sirupsen/logrus"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -34,34 +34,263 @@ import (
 	"github.com/jhernand/sandbox/pkg/internal"
 )
 
-// Database represents a the PostgreSQL database.
+// Engine is the type of a database server.
+type Engine string
+
+const (
+	// Postgres is the PostgreSQL database engine. This is the engine used by the Database method.
+	Postgres Engine = "postgres"
+)
+
+// dbEngine contains the details that are specific to each database engine.
+type dbEngine struct {
+	// Name of the pod and the service, also used as the value of the application label:
+	app string
+
+	// Image used to run the server unless a different one is given to the sandbox builder or in
+	// the environment:
+	image string
+
+	// Name of the driver, also used as the scheme of the connection URLs:
+	driver string
+
+	// Port where the server listens unless a different one is given to the sandbox builder:
+	port int
+
+	// Database and user used for administrative tasks:
+	adminDatabase string
+	adminUser     string
+
+	// Names of the secrets that contain the administrator credentials and the TLS certificates:
+	adminSecretName string
+	tlsSecretName   string
+
+	// Name of the connection option used to set the connection timeout:
+	timeoutOption string
+
+	// Statements executed once when the server is ready:
+	setup []string
+
+	// Query that returns the next value of the sequence used to generate unique names:
+	nextValue string
+
+	// Formats of the statements used to create and drop users and databases:
+	createUser     string
+	createDatabase string
+	dropDatabase   string
+	dropUser       string
+
+	// Format of the statement used to create a database as a copy of another one. If empty the
+	// engine doesn't support snapshots.
+	copyDatabase string
+
+	// Name of the configuration map that contains the scripts that initialize the server:
+	initMapName string
+
+	// Function that generates the configuration map containing the given initialization
+	// scripts, in the format expected by the image:
+	initMap func(e *dbEngine, scripts []string) (*corev1.ConfigMap, error)
+
+	// Function that generates the specification of the pod that runs the server:
+	pod func(e *dbEngine, options dbPodOptions) (*corev1.Pod, error)
+
+	// Function that checks if a configuration parameter is valid for this engine:
+	checkParam func(param DatabaseParam) error
+
+	// Function that checks if an error means that the object being created already exists,
+	// usually because other sandbox using the same server created it at the same time:
+	isConflict func(err error) bool
+}
+
+// dbPodOptions contains the settings of the sandbox that the engine uses to generate the pod that
+// runs the server.
+type dbPodOptions struct {
+	// Image and port of the server:
+	image string
+	port  int
+
+	// Configuration parameters:
+	params []DatabaseParam
+
+	// Flag indicating if the pod should mount the configuration map that contains the
+	// initialization scripts:
+	init bool
+}
+
+// DatabaseParam is a configuration parameter of the database server.
+type DatabaseParam struct {
+	Name  string
+	Value string
+}
+
+// dbEngines contains the supported database engines, indexed by name:
+var dbEngines = map[Engine]*dbEngine{
+	Postgres: postgresEngine,
+}
+
+// dbServer contains the state of one of the database servers of the sandbox.
+type dbServer struct {
+	engine        *dbEngine
+	ready         bool
+	adminUser     string
+	adminPassword string
+	image         string
+	port          int
+	address       string
+}
+
+// Database represents a database created in one of the database servers of the sandbox.
 type Database struct {
-	// Reference to the sandbox that created this database:
-	sb *Sandbox
+	// Sandbox and server that contain this database:
+	sb     *Sandbox
+	server *dbServer
+
+	// Flag indicating if this database belongs to the pool, and should be returned to it instead
+	// of deleted when it is destroyed:
+	pooled bool
 
 	// Database connection details:
 	user     string
 	password string
 	name     string
+
+	// Snapshots taken of this database:
+	snapshots []SnapshotID
+
+	// Proxy that adds latency to the connections to this database, if it was requested with
+	// the DatabaseWithLatency method:
+	proxy *latencyProxy
+}
+
+// SnapshotID is the identifier of a snapshot of a database.
+type SnapshotID string
+
+// Engine returns the engine of the database.
+func (d *Database) Engine() Engine {
+	for name, engine := range dbEngines {
+		if engine == d.server.engine {
+			return name
+		}
+	}
+	return ""
 }
 
-// Source returns the database connection string.
+// Driver returns the name of the SQL driver that should be used to connect to the database, for
+// example 'postgres'.
+func (d *Database) Driver() string {
+	return d.server.engine.driver
+}
+
+// Source returns the database connection string. For databases created with the
+// DatabaseWithLatency method it points to the proxy that adds the latency.
 func (d *Database) Source() string {
-	return d.sb.dbURL(d.user, d.password, d.sb.dbAddress, d.name, nil).String()
+	source := d.server.url(d.user, d.password, d.name, nil)
+	if d.proxy != nil {
+		source.Host = d.proxy.address()
+	}
+	return source.String()
 }
 
-// Destroy deletes the database and the user associated to this database.
+// Snapshot takes a snapshot of the current content of the database, that can later be used to
+// return the database to that state using the Restore method. The snapshot is a copy of the
+// database created by the server, so all the connections to the database must be closed before
+// calling this method, otherwise the server will refuse to copy it.
+func (d *Database) Snapshot() (id SnapshotID, err error) {
+	engine := d.server.engine
+	if engine.copyDatabase == "" {
+		err = fmt.Errorf("database engine doesn't support snapshots")
+		return
+	}
+	dbAdminHandle, err := d.server.adminHandle()
+	if err != nil {
+		return
+	}
+	dbAdminClose := func() {
+		err := dbAdminHandle.Close()
+		if err != nil {
+			log.Errorf("Can't close database handle: %v", err)
+		}
+	}
+	defer dbAdminClose()
+	var nextVal int
+	err = dbAdminHandle.QueryRow(engine.nextValue).Scan(&nextVal)
+	if err != nil {
+		return
+	}
+	name := fmt.Sprintf("%s_snapshot%d", d.name, nextVal)
+	_, err = dbAdminHandle.Exec(fmt.Sprintf(engine.copyDatabase, name, d.name, d.user))
+	if err != nil {
+		return
+	}
+	id = SnapshotID(name)
+	d.snapshots = append(d.snapshots, id)
+	return
+}
+
+// Restore returns the database to the state that it had when the given snapshot was taken. The
+// database is dropped and created again as a copy of the snapshot, so all the connections to the
+// database must be closed before calling this method. The snapshot isn't modified, so it can be
+// restored multiple times.
+func (d *Database) Restore(id SnapshotID) error {
+	engine := d.server.engine
+	if engine.copyDatabase == "" {
+		return fmt.Errorf("database engine doesn't support snapshots")
+	}
+	found := false
+	for _, snapshot := range d.snapshots {
+		if snapshot == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("snapshot '%s' doesn't exist", id)
+	}
+	dbAdminHandle, err := d.server.adminHandle()
+	if err != nil {
+		return err
+	}
+	dbAdminClose := func() {
+		err := dbAdminHandle.Close()
+		if err != nil {
+			log.Errorf("Can't close database handle: %v", err)
+		}
+	}
+	defer dbAdminClose()
+	_, err = dbAdminHandle.Exec(fmt.Sprintf(engine.dropDatabase, d.name))
+	if err != nil {
+		return err
+	}
+	_, err = dbAdminHandle.Exec(fmt.Sprintf(engine.copyDatabase, d.name, id, d.user))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Destroy deletes the database, its snapshots and the user associated to this database. If the
+// database was taken from the pool it is instead emptied and returned to the pool. If the
+// database was created with the DatabaseWithLatency method the proxy is stopped first, closing
+// the connections that still use it.
 func (d *Database) Destroy() error {
+	if d.proxy != nil {
+		err := d.proxy.close()
+		if err != nil {
+			log.Errorf("Can't stop database proxy: %v", err)
+		}
+		d.proxy = nil
+	}
+	if d.pooled {
+		return d.sb.releaseDatabase(d)
+	}
+	return d.drop()
+}
+
+// drop deletes the database, its snapshots and the user associated to this database.
+func (d *Database) drop() error {
 	// Create a connection to the database server using the administrators credentials and use
 	// it to drop the database and the user:
-	dbAdminURL := d.sb.dbURL(
-		d.sb.dbAdminUser,
-		d.sb.dbAdminPassword,
-		d.sb.dbAddress,
-		dbAdminDatabase,
-		nil,
-	)
-	dbAdminHandle, err := sql.Open(dbDriver, dbAdminURL.String())
+	dbAdminHandle, err := d.server.adminHandle()
 	if err != nil {
 		return err
 	}
@@ -72,13 +301,18 @@ func (d *Database) Destroy() error {
 		}
 	}
 	defer dbAdminClose()
-	_, err = dbAdminHandle.Exec(fmt.Sprintf("DROP DATABASE %s", d.name))
+	engine := d.server.engine
+	for _, snapshot := range d.snapshots {
+		_, err = dbAdminHandle.Exec(fmt.Sprintf(engine.dropDatabase, snapshot))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = dbAdminHandle.Exec(fmt.Sprintf(engine.dropDatabase, d.name))
 	if err != nil {
 		return err
 	}
-	_, err = dbAdminHandle.Exec(
-		fmt.Sprintf("DROP USER %s", d.user),
-	)
+	_, err = dbAdminHandle.Exec(fmt.Sprintf(engine.dropUser, d.user))
 	if err != nil {
 		return err
 	}
@@ -89,21 +323,70 @@ func (d *Database) Destroy() error {
 // Database creates a new user and database in the PostgreSQL server of the sandbox and returns
 // an object that can be used to interact with it.
 func (s *Sandbox) Database() (database *Database, err error) {
-	// Make sure that the database exists:
-	err = s.ensureDBServer()
+	return s.EngineDatabase(Postgres)
+}
+
+// EngineDatabase creates a new user and database in the database server of the sandbox that
+// uses the given engine, and returns an object that can be used to interact with it. The server
+// is started the first time that a database is requested for each engine, so a sandbox can
+// contain servers with different engines running at the same time.
+func (s *Sandbox) EngineDatabase(engine Engine) (database *Database, err error) {
+	// Try to take the database from the pool:
+	if engine == Postgres {
+		database = s.acquireDatabase()
+		if database != nil {
+			return
+		}
+	}
+	database, err = s.createDatabase(engine)
+	return
+}
+
+// DatabaseWithLatency is like the Database method, but the connection string returned by the
+// Source method of the database points to a proxy that delays by the given latency the data sent
+// in each direction, so a round trip takes at least twice that time. This is intended to check
+// how the code under test behaves when the database is slow, for example that its timeouts are
+// handled correctly. The proxy runs inside the test process, and it is stopped when the database
+// or the sandbox is destroyed. Operations of the sandbox itself, like taking snapshots, don't go
+// through the proxy.
+func (s *Sandbox) DatabaseWithLatency(latency time.Duration) (database *Database, err error) {
+	if latency < 0 {
+		err = fmt.Errorf("database latency can't be negative, but it is %s", latency)
+		return
+	}
+	database, err = s.Database()
+	if err != nil {
+		return
+	}
+	proxy, err := newLatencyProxy(database.server.address, latency)
+	if err != nil {
+		destroyErr := database.Destroy()
+		if destroyErr != nil {
+			log.Errorf("Can't destroy database: %v", destroyErr)
+		}
+		database = nil
+		return
+	}
+	database.proxy = proxy
+	s.dbLock.Lock()
+	s.dbProxies = append(s.dbProxies, proxy)
+	s.dbLock.Unlock()
+	return
+}
+
+// createDatabase creates a new user and database in the database server that uses the given
+// engine.
+func (s *Sandbox) createDatabase(engine Engine) (database *Database, err error) {
+	// Make sure that the database server exists:
+	s.dbLock.Lock()
+	server, err := s.ensureDBServer(engine)
+	s.dbLock.Unlock()
 	if err != nil {
 		return
 	}
 
 	// Create a connection to the database server using the administrators credentials:
-	dbAdminURL := s.dbURL(
-		s.dbAdminUser,
-		s.dbAdminPassword,
-		s.dbAddress,
-		dbAdminDatabase,
-		nil,
-	)
-	dbAdminHandle, err := sql.Open(dbDriver, dbAdminURL.String())
+	dbAdminHandle, err := server.adminHandle()
 	if err != nil {
 		return
 	}
@@ -115,218 +398,192 @@ func (s *Sandbox) Database() (database *Database, err error) {
 	}
 	defer dbAdminClose()
 
-	// Create the user and database name using the sequence:
+	// Create the user and the database. Other sandboxes may be using the same database server
+	// at the same time, so if the names conflict with objects created by them we try again
+	// with new names:
+	var dbUser, dbName, dbPassword string
+	for attempt := 1; ; attempt++ {
+		dbUser, dbName, dbPassword, err = createUserAndDatabase(server, dbAdminHandle)
+		if err == nil {
+			break
+		}
+		if !server.engine.isConflict(err) || attempt == conflictAttempts {
+			return
+		}
+		log.Warnf(
+			"Attempt %d of %d to create database conflicts with other sandbox, will "+
+				"retry with new names: %v",
+			attempt, conflictAttempts, err,
+		)
+	}
+
+	// Create and populate the object:
+	database = &Database{
+		sb:       s,
+		server:   server,
+		user:     dbUser,
+		password: dbPassword,
+		name:     dbName,
+	}
+
+	return
+}
+
+// createUserAndDatabase generates a new name using the sequence and creates a user and a database
+// with that name. If the user is created but the database isn't, the user is dropped, so that the
+// caller can safely try again.
+func createUserAndDatabase(server *dbServer, handle *sql.DB) (user, name, password string,
+	err error) {
+	// Calculate the user and database name using the sequence:
 	var nextVal int
-	err = dbAdminHandle.QueryRow("SELECT nextval('sandbox')").Scan(&nextVal)
+	err = handle.QueryRow(server.engine.nextValue).Scan(&nextVal)
 	if err != nil {
 		return
 	}
-	dbUser := fmt.Sprintf("sandbox%d", nextVal)
-	dbName := fmt.Sprintf("sandbox%d", nextVal)
+	user = fmt.Sprintf("sandbox%d", nextVal)
+	name = fmt.Sprintf("sandbox%d", nextVal)
 
 	// Create a random password:
 	randomUUID, err := uuid.NewRandom()
 	if err != nil {
 		return
 	}
-	dbPassword := randomUUID.String()
+	password = randomUUID.String()
 
-	// Create the user and the database:
-	_, err = dbAdminHandle.Exec(
-		fmt.Sprintf("CREATE USER %s WITH PASSWORD '%s'", dbUser, dbPassword),
-	)
+	// Create the user and the database. Note that the database can't be created inside a
+	// transaction, so if that fails we need to drop the user explicitly:
+	_, err = handle.Exec(fmt.Sprintf(server.engine.createUser, user, password))
 	if err != nil {
 		return
 	}
-	_, err = dbAdminHandle.Exec(
-		fmt.Sprintf("CREATE DATABASE %s OWNER %s", dbName, dbUser),
-	)
+	_, err = handle.Exec(fmt.Sprintf(server.engine.createDatabase, name, user))
 	if err != nil {
+		_, dropErr := handle.Exec(fmt.Sprintf(server.engine.dropUser, user))
+		if dropErr != nil {
+			log.Errorf("Can't drop database user '%s': %v", user, dropErr)
+		}
 		return
 	}
 
-	// Create and populate the object:
-	database = &Database{
-		sb:       s,
-		user:     dbUser,
-		password: dbPassword,
-		name:     dbName,
-	}
-
 	return
 }
 
-func (s *Sandbox) ensureDBServer() error {
+func (s *Sandbox) ensureDBServer(name Engine) (server *dbServer, err error) {
 	// Nothing to do if the database server is ready:
-	if s.dbReady {
-		return nil
+	server, ok := s.dbServers[name]
+	if ok && server.ready {
+		return
 	}
+	engine, ok := dbEngines[name]
+	if !ok {
+		err = fmt.Errorf("database engine '%s' isn't supported", name)
+		return
+	}
+	server = s.newDBServer(engine)
 
 	// Make sure that the database administrator password has been generated:
-	err := s.ensureDBCredentials()
+	err = s.ensureDBCredentials(server)
 	if err != nil {
-		return err
+		return
 	}
 
-	// Generate the script that will be executed by the initialization container to configure
-	// the PostgreSQL server:
-	initScript, err := internal.Template(
-		dbInitScriptTemplate,
-		"TLSDir", dbTLSDir,
-		"ConfigDir", dbConfigDir,
-		"DataDir", dbDataDir,
-	)
-	if err != nil {
-		return err
+	// Create the configuration map that contains the initialization scripts:
+	init := len(s.dbInit) > 0
+	if init {
+		var configMap *corev1.ConfigMap
+		configMap, err = engine.initMap(engine, s.dbInit)
+		if err != nil {
+			return
+		}
+		_, err = s.coreV1.ConfigMaps(s.project).Create(configMap)
+		if errors.IsAlreadyExists(err) {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
 	}
 
-	// Create the specifications of the volumes that will be used by the PostgreSQL server:
-	tlsVolume := internal.SecretVolume("tls", dbTLSSecretName)
-	configVolume := internal.EmptyDirVolume("config")
-	dataVolume := internal.EmptyDirVolume("data")
-
 	// Create the pod:
-	podLabels := map[string]string{
-		internal.AppLabel: dbApp,
-	}
-	podEnv := []corev1.EnvVar{
-		internal.SecretEnvVar(
-			"POSTGRESQL_ADMIN_PASSWORD",
-			dbAdminSecretName,
-			corev1.BasicAuthPasswordKey,
-		),
-	}
-	pod := &corev1.Pod{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:   dbApp,
-			Labels: podLabels,
-		},
-		Spec: corev1.PodSpec{
-			Volumes: []corev1.Volume{
-				tlsVolume,
-				configVolume,
-				dataVolume,
-			},
-			InitContainers: []corev1.Container{
-				{
-					Name: "init",
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      tlsVolume.Name,
-							MountPath: dbTLSDir,
-						},
-						{
-							Name:      configVolume.Name,
-							MountPath: dbConfigDir,
-						},
-						{
-							Name:      dataVolume.Name,
-							MountPath: dbDataDir,
-						},
-					},
-					Image: dbImage,
-					Command: []string{
-						"/bin/bash",
-						"-c",
-						initScript,
-					},
-				},
-			},
-			Containers: []corev1.Container{
-				{
-					Name: "server",
-					VolumeMounts: []corev1.VolumeMount{
-						{
-							Name:      configVolume.Name,
-							MountPath: dbConfigDir,
-						},
-						{
-							Name:      dataVolume.Name,
-							MountPath: dbDataDir,
-						},
-					},
-					Image: dbImage,
-					Env:   podEnv,
-					Ports: []corev1.ContainerPort{
-						{
-							ContainerPort: dbPort,
-							Protocol:      corev1.ProtocolTCP,
-						},
-					},
-				},
-			},
-		},
+	pod, err := engine.pod(engine, dbPodOptions{
+		image:  server.image,
+		port:   server.port,
+		params: s.dbParams,
+		init:   init,
+	})
+	if err != nil {
+		return
 	}
+	internal.SetDNS(pod, s.dnsPolicy, s.dnsConfig)
 	_, err = s.coreV1.Pods(s.project).Create(pod)
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
 	if err != nil {
-		return err
+		return
 	}
 
 	// Create the service:
 	serviceLabels := map[string]string{
-		internal.AppLabel: dbApp,
+		internal.AppLabel: engine.app,
 	}
 	serviceAnnotations := map[string]string{
-		"service.alpha.openshift.io/serving-cert-secret-name": dbTLSSecretName,
+		"service.alpha.openshift.io/serving-cert-secret-name": engine.tlsSecretName,
 	}
 	service := &corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:        dbApp,
+			Name:        engine.app,
 			Labels:      serviceLabels,
 			Annotations: serviceAnnotations,
 		},
 		Spec: corev1.ServiceSpec{
 			Selector: map[string]string{
-				internal.AppLabel: dbApp,
+				internal.AppLabel: engine.app,
 			},
 			Ports: []corev1.ServicePort{
 				{
-					Port:       dbPort,
-					TargetPort: intstr.FromInt(dbPort),
+					Port:       int32(server.port),
+					TargetPort: intstr.FromInt(server.port),
 				},
 			},
 		},
 	}
-	service, err = s.coreV1.Services(s.project).Create(service)
+	_, err = s.coreV1.Services(s.project).Create(service)
 	if errors.IsAlreadyExists(err) {
 		err = nil
 	}
 	if err != nil {
-		return err
+		return
 	}
 
-	// Wait till the pod is ready:
-	pod, err = internal.WaitForPod(s.coreV1, s.project, pod.Name)
+	// Wait till the pod is ready. Note that if it isn't ready in time the error already
+	// contains the problems of the containers:
+	_, err = internal.WaitForPod(s.coreV1, s.project, pod.Name)
 	if err != nil {
-		return err
+		return
 	}
 
-	// Calculate the database address:
-	s.dbAddress = fmt.Sprintf("%s.%s.svc:%d", dbApp, s.project, dbPort)
-
 	// In order to wait for the database to respond we need to create a connection with a short
 	// timeout, otherwise it takes very long to respond:
-	adminURL := s.dbURL(
-		s.dbAdminUser,
-		s.dbAdminPassword,
-		s.dbAddress,
-		dbAdminDatabase,
+	adminURL := server.url(
+		server.adminUser,
+		server.adminPassword,
+		engine.adminDatabase,
 		map[string]string{
-			"connect_timeout": "1",
+			engine.timeoutOption: "1",
 		},
 	)
 	err = internal.WaitForDB(adminURL)
 	if err != nil {
-		return err
+		err = s.explainDBServer(pod.Name, err)
+		return
 	}
 
-	// Create the sequence that will be used to generate unique user and database names:
-	adminHandle, err := sql.Open(dbDriver, adminURL.String())
+	// Run the statements that prepare the server, for example creating the sequence that will
+	// be used to generate unique user and database names:
+	adminHandle, err := server.adminHandle()
 	if err != nil {
-		return err
+		return
 	}
 	adminClose := func() {
 		err := adminHandle.Close()
@@ -335,47 +592,105 @@ func (s *Sandbox) ensureDBServer() error {
 		}
 	}
 	defer adminClose()
-	_, err = adminHandle.Exec("CREATE SEQUENCE IF NOT EXISTS sandbox")
-	if err != nil {
-		return err
+	for _, statement := range engine.setup {
+		err = runSetup(engine, adminHandle, statement)
+		if err != nil {
+			return
+		}
 	}
 
 	// The database server is now ready:
-	s.dbReady = true
+	server.ready = true
+	s.dbServers[name] = server
 
-	return nil
+	return
+}
+
+// newDBServer creates the object that describes the database server that uses the given engine,
+// calculating the image, the port and the address that will be used to connect to it. It doesn't
+// create the server.
+func (s *Sandbox) newDBServer(engine *dbEngine) *dbServer {
+	image := engine.image
+	if s.dbImage != "" {
+		image = s.dbImage
+	}
+	port := engine.port
+	if s.dbPort != 0 {
+		port = s.dbPort
+	}
+	return &dbServer{
+		engine:  engine,
+		image:   image,
+		port:    port,
+		address: fmt.Sprintf("%s.%s.svc:%d", engine.app, s.project, port),
+	}
+}
+
+// runSetup runs one of the statements that prepare the database server. Statements like 'CREATE
+// SEQUENCE IF NOT EXISTS' can still fail when other sandbox runs them at the same time, because
+// both see that the object doesn't exist yet. When that happens the statement is executed again,
+// and then it will find the object created by the other sandbox.
+func runSetup(engine *dbEngine, handle *sql.DB, statement string) (err error) {
+	for attempt := 1; ; attempt++ {
+		_, err = handle.Exec(statement)
+		if err == nil || !engine.isConflict(err) || attempt == conflictAttempts {
+			return
+		}
+		log.Warnf(
+			"Attempt %d of %d to prepare database server conflicts with other sandbox, "+
+				"will retry: %v",
+			attempt, conflictAttempts, err,
+		)
+	}
+}
+
+// explainDBServer adds to the given error the description of the problems of the containers of
+// the database server pod, if any, so that it is easier to understand why the server isn't
+// responding even if the pod is ready. For example, if the database crashes after starting the
+// container is restarted.
+func (s *Sandbox) explainDBServer(name string, err error) error {
+	pod, getErr := s.coreV1.Pods(s.project).Get(name, metav1.GetOptions{})
+	if getErr != nil {
+		log.Errorf("Can't get database server pod '%s': %v", name, getErr)
+		return err
+	}
+	problems := internal.PodProblems(pod)
+	if problems == "" {
+		return err
+	}
+	return fmt.Errorf("%v: %s", err, problems)
 }
 
-func (s *Sandbox) ensureDBCredentials() error {
+func (s *Sandbox) ensureDBCredentials(server *dbServer) error {
 	// Generate a random password for the database administrator:
 	id, err := uuid.NewRandom()
 	if err != nil {
 		return err
 	}
-	s.dbAdminUser = dbAdminUser
-	s.dbAdminPassword = id.String()
+	server.adminUser = server.engine.adminUser
+	server.adminPassword = id.String()
 
 	// Try to save the generated administrator password to a secret. If this fails because the
 	// secret already exists then we discard the password that we generated and use the one in
 	// the existing secret instead.
 	labels := map[string]string{
-		internal.AppLabel: dbApp,
+		internal.AppLabel: server.engine.app,
 	}
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:   dbAdminSecretName,
+			Name:   server.engine.adminSecretName,
 			Labels: labels,
 		},
 		Type: corev1.SecretTypeBasicAuth,
 		Data: map[string][]byte{
-			corev1.BasicAuthUsernameKey: []byte(s.dbAdminUser),
-			corev1.BasicAuthPasswordKey: []byte(s.dbAdminPassword),
+			corev1.BasicAuthUsernameKey: []byte(server.adminUser),
+			corev1.BasicAuthPasswordKey: []byte(server.adminPassword),
 		},
 	}
 	secrets := s.coreV1.Secrets(s.project)
 	secret, err = secrets.Create(secret)
 	if errors.IsAlreadyExists(err) {
-		secret, err = secrets.Get(dbAdminSecretName, metav1.GetOptions{})
+		secret, err = secrets.Get(server.engine.adminSecretName, metav1.GetOptions{})
 		if err != nil {
 			return err
 		}
@@ -396,7 +711,7 @@ func (s *Sandbox) ensureDBCredentials() error {
 				secret.Name, corev1.BasicAuthUsernameKey,
 			)
 		}
-		s.dbAdminUser = string(data)
+		server.adminUser = string(data)
 		data, ok = secret.Data[corev1.BasicAuthPasswordKey]
 		if !ok {
 			return fmt.Errorf(
@@ -412,7 +727,7 @@ func (s *Sandbox) ensureDBCredentials() error {
 				secret.Name, corev1.BasicAuthPasswordKey,
 			)
 		}
-		s.dbAdminPassword = string(data)
+		server.adminPassword = string(data)
 		err = nil
 	}
 	if err != nil {
@@ -422,69 +737,43 @@ func (s *Sandbox) ensureDBCredentials() error {
 	return nil
 }
 
-// dbURL makes a database connection URL string from a set connection details.
-func (s *Sandbox) dbURL(user, password, address, name string,
-	options map[string]string) *url.URL {
+// adminHandle creates a connection to the database server using the administrator credentials.
+// Note that sql.Open doesn't connect to the server, so the first query could fail transiently,
+// for example if the server is still starting even if the pod is already ready. To avoid that
+// this pings the server, retrying with backoff, before returning the handle. The caller is
+// responsible for closing it.
+func (s *dbServer) adminHandle() (handle *sql.DB, err error) {
+	adminURL := s.url(s.adminUser, s.adminPassword, s.engine.adminDatabase, nil)
+	handle, err = sql.Open(s.engine.driver, adminURL.String())
+	if err != nil {
+		return
+	}
+	err = withRetry("connect to database server", handle.Ping)
+	if err != nil {
+		closeErr := handle.Close()
+		if closeErr != nil {
+			log.Errorf("Can't close database handle: %v", closeErr)
+		}
+		handle = nil
+	}
+	return
+}
+
+// url makes a database connection URL string from a set connection details.
+func (s *dbServer) url(user, password, name string, options map[string]string) *url.URL {
 	query := url.Values{}
 	for name, value := range options {
 		query.Set(name, value)
 	}
 	return &url.URL{
-		Scheme:   dbDriver,
+		Scheme:   s.engine.driver,
 		User:     url.UserPassword(user, password),
-		Host:     address,
+		Host:     s.address,
 		Path:     name,
 		RawQuery: query.Encode(),
 	}
 }
 
-// Values labels specific to the database:
-const (
-	dbApp = "database"
-)
-
-// Names of secrets specific to the database:
-const (
-	dbImage           = "centos/postgresql-10-centos7"
-	dbTLSSecretName   = "database-tls"
-	dbAdminSecretName = "database-admin"
-)
-
-// Connection details:
-const (
-	dbDriver        = "postgres"
-	dbAdminDatabase = "postgres"
-	dbAdminUser     = "postgres"
-	dbPort          = 5432
-)
-
-// Directory names:
-const (
-	dbTLSDir    = "/etc/pki/tls/pgsql"
-	dbConfigDir = "/opt/app-root/src/postgresql-cfg"
-	dbDataDir   = "/var/lib/pgsql/data"
-)
-
-// Template used to generate the script that generates the configuration for the PostgreSQL server:
-var dbInitScriptTemplate = `
-# Install the TLS certificates:
-install \
---mode=0600 \
-{{ .TLSDir }}/tls.crt \
-{{ .TLSDir }}/tls.key \
-{{ .DataDir }}
-
-# Create the TLS configuration:
-cat > {{ .ConfigDir }}/tls.conf <<.
-ssl = on
-ssl_cert_file = '{{ .DataDir }}/tls.crt'
-ssl_key_file = '{{ .DataDir }}/tls.key'
-.
-
-# Enable the query log:
-cat > {{ .ConfigDir }}/log.conf <<.
-log_destination = 'stderr'
-log_statement = 'all'
-logging_collector = off
-.
-`
+// Maximum number of times that the creation of database objects is attempted when it conflicts
+// with other sandboxes:
+const conflictAttempts = 5
@@ -0,0 +1,147 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sandbox
+
+import (
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+var _ = Describe("Database port", func() {
+	// source returns the connection string of a database of the given sandbox, without
+	// creating the database server.
+	source := func(s *Sandbox) *url.URL {
+		database := &Database{
+			sb:       s,
+			server:   s.newDBServer(postgresEngine),
+			user:     "myuser",
+			password: "mypassword",
+			name:     "mydb",
+		}
+		result, err := url.Parse(database.Source())
+		Expect(err).ToNot(HaveOccurred())
+		return result
+	}
+
+	It("Uses the standard port by default", func() {
+		s := &Sandbox{
+			project: "myproject",
+		}
+		Expect(source(s).Host).To(Equal("database.myproject.svc:5432"))
+	})
+
+	It("Uses the port given to the builder", func() {
+		s := &Sandbox{
+			project: "myproject",
+			dbPort:  5433,
+		}
+		Expect(source(s).Host).To(Equal("database.myproject.svc:5433"))
+	})
+
+	It("Uses the port given to the builder in the pod", func() {
+		pod, err := postgresPod(postgresEngine, dbPodOptions{
+			image: postgresEngine.image,
+			port:  5433,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		container := pod.Spec.Containers[0]
+		Expect(container.Ports).To(HaveLen(1))
+		Expect(container.Ports[0].ContainerPort).To(BeEquivalentTo(5433))
+		script := pod.Spec.InitContainers[0].Command[2]
+		Expect(script).To(ContainSubstring("port = 5433\n"))
+	})
+
+	It("Rejects invalid ports", func() {
+		_, err := NewSandbox().DatabasePort(70000).Build()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("70000"))
+	})
+})
+
+var _ = Describe("Database image", func() {
+	It("Uses the default image of the engine", func() {
+		s := &Sandbox{}
+		Expect(s.newDBServer(postgresEngine).image).To(Equal(postgresEngine.image))
+	})
+
+	It("Uses the image given to the sandbox", func() {
+		s := &Sandbox{
+			dbImage: "mirror.example.com/postgresql",
+		}
+		Expect(s.newDBServer(postgresEngine).image).To(Equal("mirror.example.com/postgresql"))
+	})
+
+	It("Uses the image in the pod", func() {
+		pod, err := postgresPod(postgresEngine, dbPodOptions{
+			image: "mirror.example.com/postgresql",
+			port:  5432,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(pod.Spec.InitContainers[0].Image).To(Equal("mirror.example.com/postgresql"))
+		Expect(pod.Spec.Containers[0].Image).To(Equal("mirror.example.com/postgresql"))
+	})
+})
+
+var _ = Describe("Database initialization", func() {
+	It("Puts the scripts in the configuration map", func() {
+		configMap, err := postgresInitMap(postgresEngine, []string{
+			"CREATE TABLE first (id integer)",
+			"CREATE TABLE second (id integer)",
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(configMap.Name).To(Equal("database-init"))
+		Expect(configMap.Data).To(HaveKeyWithValue("000.sql", "CREATE TABLE first (id integer)"))
+		Expect(configMap.Data).To(HaveKeyWithValue("001.sql", "CREATE TABLE second (id integer)"))
+		Expect(configMap.Data).To(HaveKey("sandbox.sh"))
+		Expect(configMap.Data["sandbox.sh"]).To(ContainSubstring("--dbname=template1"))
+	})
+
+	It("Mounts the configuration map in the init directory", func() {
+		pod, err := postgresPod(postgresEngine, dbPodOptions{
+			image: postgresEngine.image,
+			port:  5432,
+			init:  true,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		var volume string
+		for _, candidate := range pod.Spec.Volumes {
+			if candidate.ConfigMap != nil && candidate.ConfigMap.Name == "database-init" {
+				volume = candidate.Name
+			}
+		}
+		Expect(volume).ToNot(BeEmpty())
+		Expect(pod.Spec.Containers[0].VolumeMounts).To(ContainElement(corev1.VolumeMount{
+			Name:      volume,
+			MountPath: postgresInitDir,
+		}))
+	})
+
+	It("Doesn't mount the configuration map if there are no scripts", func() {
+		pod, err := postgresPod(postgresEngine, dbPodOptions{
+			image: postgresEngine.image,
+			port:  5432,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		for _, volume := range pod.Spec.Volumes {
+			Expect(volume.ConfigMap).To(BeNil())
+		}
+	})
+})
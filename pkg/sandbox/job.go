@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the jobs that can be executed inside the sandbox.
+
+package sandbox
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+// JobResult contains the results of the execution of a job.
+type JobResult struct {
+	// Succeeded indicates if the job finished successfully.
+	Succeeded bool
+
+	// Code is the exit code of the first container of the last pod of the job.
+	Code int
+
+	// Logs contains the logs of the first container of the last pod of the job.
+	Logs []byte
+}
+
+// RunJob creates a job in the project of the sandbox, using the given name and specification,
+// waits till it finishes and returns the results. This is intended for one-shot containers that
+// need to run before the tests, for example to apply database migrations. If the restart policy
+// of the pod template isn't set it will be set to Never, and if the backoff limit isn't set it
+// will be set to zero, so that failed jobs aren't retried. A job that fails isn't considered an
+// error, check the Succeeded field of the result instead.
+func (s *Sandbox) RunJob(name string, spec batchv1.JobSpec) (result *JobResult, err error) {
+	// Complete the specification:
+	if spec.Template.Spec.RestartPolicy == "" {
+		spec.Template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	}
+	if spec.BackoffLimit == nil {
+		spec.BackoffLimit = pointer.Int32Ptr(0)
+	}
+
+	// Create the job:
+	log.Infof("Running job '%s'", name)
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: spec,
+	}
+	_, err = s.batchV1.Jobs(s.project).Create(job)
+	if err != nil {
+		return
+	}
+
+	// Wait till it finishes. Note that when the job fails the wait function returns both the
+	// job and an error, but for us that isn't an error, it is a result that we report to the
+	// caller.
+	job, err = internal.WaitForJob(s.batchV1, s.project, name, jobTimeout)
+	if err != nil && job == nil {
+		return
+	}
+	err = nil
+	result = &JobResult{
+		Succeeded: job.Status.Succeeded > 0,
+	}
+
+	// Find the last pod of the job:
+	pods, err := s.coreV1.Pods(s.project).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("job-name=%s", name),
+	})
+	if err != nil {
+		return
+	}
+	var pod *corev1.Pod
+	for i := range pods.Items {
+		tmp := &pods.Items[i]
+		if pod == nil || tmp.CreationTimestamp.After(pod.CreationTimestamp.Time) {
+			pod = tmp
+		}
+	}
+	if pod == nil {
+		err = fmt.Errorf("can't find pod for job '%s'", name)
+		return
+	}
+
+	// Get the exit code and the logs of the first container:
+	if len(pod.Status.ContainerStatuses) > 0 {
+		terminated := pod.Status.ContainerStatuses[0].State.Terminated
+		if terminated != nil {
+			result.Code = int(terminated.ExitCode)
+		}
+	}
+	result.Logs, err = s.coreV1.Pods(s.project).GetLogs(pod.Name, &corev1.PodLogOptions{}).DoRaw()
+	if err != nil {
+		err = fmt.Errorf("can't get logs of pod '%s' of job '%s': %v", pod.Name, name, err)
+		return
+	}
+	log.Infof(
+		"Job '%s' finished, succeeded: %t, exit code: %d",
+		name, result.Succeeded, result.Code,
+	)
+
+	return
+}
+
+// Maximum time to wait for jobs to finish:
+const jobTimeout = 10 * time.Minute
@@ -0,0 +1,213 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the proxy that adds latency to the connections to the
+// database servers, so that tests can check how the code behaves when the database is slow.
+
+package sandbox
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// latencyProxy is a TCP proxy that forwards connections to a target address, delaying the data
+// sent in each direction by a fixed latency. It runs inside the process of the test, listening in
+// the loopback interface, so it doesn't need additional pods, and it only affects the
+// connections of the databases that use it.
+type latencyProxy struct {
+	target   string
+	latency  time.Duration
+	listener net.Listener
+	lock     sync.Mutex
+	conns    map[net.Conn]bool
+	closed   bool
+	wait     sync.WaitGroup
+}
+
+// latencyChunk is a block of data read from one side of a connection, together with the time
+// when it should be written to the other side.
+type latencyChunk struct {
+	data []byte
+	due  time.Time
+}
+
+// newLatencyProxy creates a proxy that forwards connections to the given target address, adding
+// the given latency, and starts accepting connections.
+func newLatencyProxy(target string, latency time.Duration) (proxy *latencyProxy, err error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+	proxy = &latencyProxy{
+		target:   target,
+		latency:  latency,
+		listener: listener,
+		conns:    map[net.Conn]bool{},
+	}
+	proxy.wait.Add(1)
+	go proxy.accept()
+	log.Infof(
+		"Started proxy from '%s' to '%s' with latency %s",
+		proxy.address(), target, latency,
+	)
+	return
+}
+
+// address returns the address where the proxy accepts connections.
+func (p *latencyProxy) address() string {
+	return p.listener.Addr().String()
+}
+
+// accept accepts connections till the listener is closed.
+func (p *latencyProxy) accept() {
+	defer p.wait.Done()
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		p.wait.Add(1)
+		go p.serve(conn)
+	}
+}
+
+// serve connects to the target and forwards the data of the given connection in both
+// directions, till one of the sides closes it.
+func (p *latencyProxy) serve(conn net.Conn) {
+	defer p.wait.Done()
+	target, err := net.Dial("tcp", p.target)
+	if err != nil {
+		log.Errorf("Proxy can't connect to '%s': %v", p.target, err)
+		conn.Close()
+		return
+	}
+	if !p.track(conn, target) {
+		conn.Close()
+		target.Close()
+		return
+	}
+	var pipes sync.WaitGroup
+	pipes.Add(2)
+	go p.pipe(target, conn, &pipes)
+	go p.pipe(conn, target, &pipes)
+	pipes.Wait()
+	p.untrack(conn, target)
+	conn.Close()
+	target.Close()
+}
+
+// pipe copies the data read from the source connection to the destination connection, writing
+// each block when the latency has passed since it was read. Reading and writing are done by
+// different goroutines, so the latency doesn't reduce the throughput.
+func (p *latencyProxy) pipe(dst, src net.Conn, done *sync.WaitGroup) {
+	defer done.Done()
+	chunks := make(chan latencyChunk, latencyChunks)
+	go func() {
+		defer close(chunks)
+		for {
+			buffer := make([]byte, latencyBuffer)
+			n, err := src.Read(buffer)
+			if n > 0 {
+				chunks <- latencyChunk{
+					data: buffer[:n],
+					due:  time.Now().Add(p.latency),
+				}
+			}
+			if err != nil {
+				if err != io.EOF {
+					log.Debugf("Proxy can't read from connection: %v", err)
+				}
+				return
+			}
+		}
+	}()
+	for chunk := range chunks {
+		time.Sleep(time.Until(chunk.due))
+		_, err := dst.Write(chunk.data)
+		if err != nil {
+			// Close both connections so that the reader stops, and discard what it still
+			// sends:
+			log.Debugf("Proxy can't write to connection: %v", err)
+			src.Close()
+			dst.Close()
+			for range chunks {
+			}
+			return
+		}
+	}
+
+	// The source has been closed, so tell the destination that no more data will be sent:
+	tcp, ok := dst.(*net.TCPConn)
+	if ok {
+		tcp.CloseWrite()
+	} else {
+		dst.Close()
+	}
+}
+
+// track remembers the given connections, so that they are closed when the proxy is closed. It
+// returns false if the proxy has already been closed.
+func (p *latencyProxy) track(conns ...net.Conn) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return false
+	}
+	for _, conn := range conns {
+		p.conns[conn] = true
+	}
+	return true
+}
+
+// untrack forgets the given connections.
+func (p *latencyProxy) untrack(conns ...net.Conn) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for _, conn := range conns {
+		delete(p.conns, conn)
+	}
+}
+
+// close stops accepting connections, closes the ones that are open, and waits till all the
+// goroutines of the proxy finish. It is safe to call it multiple times.
+func (p *latencyProxy) close() error {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil
+	}
+	p.closed = true
+	err := p.listener.Close()
+	for conn := range p.conns {
+		conn.Close()
+	}
+	p.lock.Unlock()
+	p.wait.Wait()
+	log.Infof("Stopped proxy to '%s' with latency %s", p.target, p.latency)
+	return err
+}
+
+// Number of blocks of data that can be waiting to be written by each direction of a connection of
+// the proxy, and size of those blocks:
+const (
+	latencyChunks = 64
+	latencyBuffer = 32 * 1024
+)
@@ -0,0 +1,110 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sandbox
+
+import (
+	"io"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Latency proxy", func() {
+	var echo net.Listener
+	var proxy *latencyProxy
+
+	BeforeEach(func() {
+		var err error
+
+		// Start a server that sends back everything that it receives:
+		echo, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		go func() {
+			for {
+				conn, err := echo.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					io.Copy(conn, conn)
+				}()
+			}
+		}()
+
+		// Start the proxy:
+		proxy, err = newLatencyProxy(echo.Addr().String(), 50*time.Millisecond)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := proxy.close()
+		Expect(err).ToNot(HaveOccurred())
+		err = echo.Close()
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Forwards the data adding the latency in both directions", func() {
+		conn, err := net.Dial("tcp", proxy.address())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		start := time.Now()
+		_, err = conn.Write([]byte("hello"))
+		Expect(err).ToNot(HaveOccurred())
+		buffer := make([]byte, 5)
+		_, err = io.ReadFull(conn, buffer)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(buffer)).To(Equal("hello"))
+		Expect(time.Since(start)).To(BeNumerically(">=", 100*time.Millisecond))
+	})
+
+	It("Closes the connections when it is closed", func() {
+		conn, err := net.Dial("tcp", proxy.address())
+		Expect(err).ToNot(HaveOccurred())
+		defer conn.Close()
+		_, err = conn.Write([]byte("hello"))
+		Expect(err).ToNot(HaveOccurred())
+		buffer := make([]byte, 5)
+		_, err = io.ReadFull(conn, buffer)
+		Expect(err).ToNot(HaveOccurred())
+		err = proxy.close()
+		Expect(err).ToNot(HaveOccurred())
+		err = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		Expect(err).ToNot(HaveOccurred())
+		_, err = conn.Read(buffer)
+		Expect(err).To(Equal(io.EOF))
+		_, err = net.Dial("tcp", proxy.address())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Points the source of the database to the proxy", func() {
+		s := &Sandbox{
+			project: "myproject",
+		}
+		database := &Database{
+			sb:       s,
+			server:   s.newDBServer(postgresEngine),
+			user:     "myuser",
+			password: "mypassword",
+			name:     "mydb",
+			proxy:    proxy,
+		}
+		Expect(database.Source()).To(ContainSubstring("@" + proxy.address() + "/mydb"))
+	})
+})
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the pool of databases.
+
+package sandbox
+
+import (
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// acquireDatabase takes a database from the pool. It returns nil if the pool is empty.
+func (s *Sandbox) acquireDatabase() *Database {
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+	count := len(s.dbPool)
+	if count == 0 {
+		return nil
+	}
+	database := s.dbPool[count-1]
+	s.dbPool = s.dbPool[0 : count-1]
+	return database
+}
+
+// releaseDatabase empties the given database, dropping it and creating it again, and returns it
+// to the pool. The user and the password of the database don't change.
+func (s *Sandbox) releaseDatabase(d *Database) error {
+	dbAdminHandle, err := d.server.adminHandle()
+	if err != nil {
+		return err
+	}
+	dbAdminClose := func() {
+		err := dbAdminHandle.Close()
+		if err != nil {
+			log.Errorf("Can't close database handle: %v", err)
+		}
+	}
+	defer dbAdminClose()
+	engine := d.server.engine
+	for _, snapshot := range d.snapshots {
+		_, err = dbAdminHandle.Exec(fmt.Sprintf(engine.dropDatabase, snapshot))
+		if err != nil {
+			return err
+		}
+	}
+	d.snapshots = nil
+	_, err = dbAdminHandle.Exec(fmt.Sprintf(engine.dropDatabase, d.name))
+	if err != nil {
+		return err
+	}
+	_, err = dbAdminHandle.Exec(fmt.Sprintf(engine.createDatabase, d.name, d.user))
+	if err != nil {
+		return err
+	}
+	s.dbLock.Lock()
+	s.dbPool = append(s.dbPool, d)
+	s.dbLock.Unlock()
+	return nil
+}
+
+// dropPool drops all the databases of the pool and empties it. It is used when the pool can't be
+// filled completely, so it doesn't stop when a database can't be dropped; it writes the error to
+// the log and continues with the rest.
+func (s *Sandbox) dropPool() {
+	s.dbLock.Lock()
+	pool := s.dbPool
+	s.dbPool = nil
+	s.dbLock.Unlock()
+	for _, database := range pool {
+		err := database.drop()
+		if err != nil {
+			log.Errorf("Can't drop database '%s' of the pool: %v", database.name, err)
+		}
+	}
+}
@@ -0,0 +1,332 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the details of the PostgreSQL database engine.
+
+package sandbox
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+// postgresEngine contains the details of the PostgreSQL engine:
+var postgresEngine = &dbEngine{
+	app:             "database",
+	image:           "centos/postgresql-10-centos7",
+	driver:          "postgres",
+	port:            5432,
+	adminDatabase:   "postgres",
+	adminUser:       "postgres",
+	adminSecretName: "database-admin",
+	tlsSecretName:   "database-tls",
+	initMapName:     "database-init",
+	timeoutOption:   "connect_timeout",
+	setup: []string{
+		"CREATE SEQUENCE IF NOT EXISTS sandbox",
+	},
+	nextValue:      "SELECT nextval('sandbox')",
+	createUser:     "CREATE USER %s WITH PASSWORD '%s'",
+	createDatabase: "CREATE DATABASE %s OWNER %s",
+	dropDatabase:   "DROP DATABASE %s",
+	dropUser:       "DROP USER %s",
+	copyDatabase:   "CREATE DATABASE %s WITH TEMPLATE %s OWNER %s",
+	initMap:        postgresInitMap,
+	pod:            postgresPod,
+	checkParam:     postgresCheckParam,
+	isConflict:     postgresIsConflict,
+}
+
+// postgresInitMap generates the configuration map that contains the initialization scripts. The
+// image runs the '*.sh' files of the init directory once the server has been initialized and
+// started locally, but before it accepts remote connections, so the map contains a shell script
+// that runs the SQL scripts with 'psql'.
+func postgresInitMap(e *dbEngine, scripts []string) (configMap *corev1.ConfigMap, err error) {
+	hook, err := internal.Template(
+		postgresInitHookTemplate,
+		"InitDir", postgresInitDir,
+	)
+	if err != nil {
+		return
+	}
+	data := map[string]string{
+		"sandbox.sh": hook,
+	}
+	for i, script := range scripts {
+		data[fmt.Sprintf("%03d.sql", i)] = script
+	}
+	configMap = &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: e.initMapName,
+			Labels: map[string]string{
+				internal.AppLabel: e.app,
+			},
+		},
+		Data: data,
+	}
+	return
+}
+
+// postgresPod generates the specification of the pod that runs the PostgreSQL server.
+func postgresPod(e *dbEngine, options dbPodOptions) (pod *corev1.Pod, err error) {
+	// Quote the values of the parameters, doubling the single quotes, as required by the
+	// syntax of the PostgreSQL configuration files:
+	quoted := make([]DatabaseParam, len(options.params))
+	for i, param := range options.params {
+		quoted[i] = DatabaseParam{
+			Name:  param.Name,
+			Value: strings.Replace(param.Value, "'", "''", -1),
+		}
+	}
+
+	// Generate the script that will be executed by the initialization container to configure
+	// the PostgreSQL server:
+	initScript, err := internal.Template(
+		postgresInitScriptTemplate,
+		"TLSDir", postgresTLSDir,
+		"ConfigDir", postgresConfigDir,
+		"DataDir", postgresDataDir,
+		"Port", options.port,
+		"Params", quoted,
+	)
+	if err != nil {
+		return
+	}
+
+	// Create the specifications of the volumes that will be used by the PostgreSQL server:
+	tlsVolume := internal.SecretVolume("tls", e.tlsSecretName)
+	configVolume := internal.EmptyDirVolume("config")
+	dataVolume := internal.EmptyDirVolume("data")
+	volumes := []corev1.Volume{
+		tlsVolume,
+		configVolume,
+		dataVolume,
+	}
+
+	// Mount the initialization scripts in the directory where the image looks for them:
+	serverVolumeMounts := []corev1.VolumeMount{
+		{
+			Name:      configVolume.Name,
+			MountPath: postgresConfigDir,
+		},
+		{
+			Name:      dataVolume.Name,
+			MountPath: postgresDataDir,
+		},
+	}
+	if options.init {
+		initVolume := internal.ConfigMapVolume("init", e.initMapName)
+		volumes = append(volumes, initVolume)
+		serverVolumeMounts = append(serverVolumeMounts, corev1.VolumeMount{
+			Name:      initVolume.Name,
+			MountPath: postgresInitDir,
+		})
+	}
+
+	// Create the pod:
+	podLabels := map[string]string{
+		internal.AppLabel: e.app,
+	}
+	podEnv := []corev1.EnvVar{
+		internal.SecretEnvVar(
+			"POSTGRESQL_ADMIN_PASSWORD",
+			e.adminSecretName,
+			corev1.BasicAuthPasswordKey,
+		),
+		// The scripts of the image use the client tools to configure the server, and they
+		// need to know the port as well:
+		{
+			Name:  "PGPORT",
+			Value: strconv.Itoa(options.port),
+		},
+	}
+	pod = &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   e.app,
+			Labels: podLabels,
+		},
+		Spec: corev1.PodSpec{
+			Volumes: volumes,
+			InitContainers: []corev1.Container{
+				{
+					Name: "init",
+					VolumeMounts: []corev1.VolumeMount{
+						{
+							Name:      tlsVolume.Name,
+							MountPath: postgresTLSDir,
+						},
+						{
+							Name:      configVolume.Name,
+							MountPath: postgresConfigDir,
+						},
+						{
+							Name:      dataVolume.Name,
+							MountPath: postgresDataDir,
+						},
+					},
+					Image: options.image,
+					Command: []string{
+						"/bin/bash",
+						"-c",
+						initScript,
+					},
+				},
+			},
+			Containers: []corev1.Container{
+				{
+					Name:         "server",
+					VolumeMounts: serverVolumeMounts,
+					Image:        options.image,
+					Env:          podEnv,
+					Ports: []corev1.ContainerPort{
+						{
+							ContainerPort: int32(options.port),
+							Protocol:      corev1.ProtocolTCP,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return
+}
+
+// postgresCheckParam checks that the given parameter is in the list of parameters that can be
+// changed, and that its value can be safely written to the configuration file.
+func postgresCheckParam(param DatabaseParam) error {
+	if !postgresParams[param.Name] {
+		return fmt.Errorf("database parameter '%s' isn't supported", param.Name)
+	}
+	if strings.ContainsAny(param.Value, "\r\n") {
+		return fmt.Errorf(
+			"value of database parameter '%s' can't contain line breaks",
+			param.Name,
+		)
+	}
+	return nil
+}
+
+// postgresIsConflict checks if the given error was returned by the PostgreSQL server because the
+// object being created already exists. Note that 'CREATE ... IF NOT EXISTS' statements executed
+// concurrently fail with an unique violation of the system catalogs instead of a duplicate object
+// error, so that is also considered a conflict.
+func postgresIsConflict(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return false
+	}
+	return postgresConflictCodes[pqErr.Code]
+}
+
+// postgresConflictCodes contains the PostgreSQL error codes that indicate that an object already
+// exists:
+var postgresConflictCodes = map[pq.ErrorCode]bool{
+	"23505": true, // unique_violation
+	"42710": true, // duplicate_object
+	"42P04": true, // duplicate_database
+	"42P07": true, // duplicate_table
+}
+
+// postgresParams contains the names of the PostgreSQL parameters that can be changed. Parameters
+// that could prevent the server from starting, or that are already set by the sandbox, like the
+// TLS parameters, aren't included.
+var postgresParams = map[string]bool{
+	"checkpoint_timeout":                  true,
+	"default_transaction_isolation":       true,
+	"effective_cache_size":                true,
+	"fsync":                               true,
+	"full_page_writes":                    true,
+	"idle_in_transaction_session_timeout": true,
+	"lock_timeout":                        true,
+	"log_min_duration_statement":          true,
+	"log_statement":                       true,
+	"maintenance_work_mem":                true,
+	"max_connections":                     true,
+	"max_locks_per_transaction":           true,
+	"max_prepared_transactions":           true,
+	"max_wal_size":                        true,
+	"random_page_cost":                    true,
+	"shared_buffers":                      true,
+	"statement_timeout":                   true,
+	"synchronous_commit":                  true,
+	"temp_buffers":                        true,
+	"timezone":                            true,
+	"work_mem":                            true,
+}
+
+// Directory names:
+const (
+	postgresTLSDir    = "/etc/pki/tls/pgsql"
+	postgresConfigDir = "/opt/app-root/src/postgresql-cfg"
+	postgresDataDir   = "/var/lib/pgsql/data"
+	postgresInitDir   = "/opt/app-root/src/postgresql-init"
+)
+
+// Template used to generate the script that generates the configuration for the PostgreSQL server:
+var postgresInitScriptTemplate = `
+# Install the TLS certificates:
+install \
+--mode=0600 \
+{{ .TLSDir }}/tls.crt \
+{{ .TLSDir }}/tls.key \
+{{ .DataDir }}
+
+# Create the TLS configuration:
+cat > {{ .ConfigDir }}/tls.conf <<.
+ssl = on
+ssl_cert_file = '{{ .DataDir }}/tls.crt'
+ssl_key_file = '{{ .DataDir }}/tls.key'
+.
+
+# Set the port:
+cat > {{ .ConfigDir }}/port.conf <<.
+port = {{ .Port }}
+.
+
+# Enable the query log:
+cat > {{ .ConfigDir }}/log.conf <<.
+log_destination = 'stderr'
+log_statement = 'all'
+logging_collector = off
+.
+
+# Set the parameters requested by the user. Note that this uses a quoted delimiter so that the
+# shell doesn't expand the values.
+cat > {{ .ConfigDir }}/tuning.conf <<'.'
+{{ range .Params }}{{ .Name }} = '{{ .Value }}'
+{{ end }}.
+`
+
+// Template used to generate the script that the image runs when the server is initialized. The
+// image sources this script, so it uses 'exit' to stop the server when a SQL script fails. The
+// SQL scripts run in the 'template1' database so that all the databases created later, which use
+// it as template, contain the objects that they create.
+var postgresInitHookTemplate = `
+for file in {{ .InitDir }}/*.sql; do
+  if ! psql --set=ON_ERROR_STOP=1 --dbname=template1 --file="${file}"; then
+    echo "Initialization script '${file}' failed"
+    exit 1
+  fi
+done
+`
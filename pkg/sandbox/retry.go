@@ -0,0 +1,54 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains a helper to retry operations that can fail transiently.
+
+package sandbox
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// withRetry calls the given function till it succeeds or the maximum number of attempts is
+// reached, doubling the delay between attempts each time. It returns the error of the last
+// attempt.
+func withRetry(what string, f func() error) (err error) {
+	delay := retryInitialDelay
+	for attempt := 1; ; attempt++ {
+		err = f()
+		if err == nil || attempt == retryAttempts {
+			return
+		}
+		log.Warnf(
+			"Attempt %d of %d to %s failed, will retry in %s: %v",
+			attempt, retryAttempts, what, delay, err,
+		)
+		time.Sleep(delay)
+		delay *= 2
+		if delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+// Retry constants:
+const (
+	retryAttempts     = 6
+	retryInitialDelay = 100 * time.Millisecond
+	retryMaxDelay     = 2 * time.Second
+)
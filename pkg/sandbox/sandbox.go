@@ -19,16 +19,31 @@ limitations under the License.
 package sandbox
 
 import (
+	"fmt"
 	"io/ioutil"
+	"os"
+	"sync"
 
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	batchv1client "k8s.io/client-go/kubernetes/typed/batch/v1"
 	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	rbacv1client "k8s.io/client-go/kubernetes/typed/rbac/v1"
 	"k8s.io/client-go/rest"
+
+	"github.com/jhernand/sandbox/pkg/internal"
 )
 
 // SandboxBuilder is an object that contains the data and the logic needed to build a sandbox
 // environment. Do not create instances of this type directly, use the NewSandbox function instead.
 type SandboxBuilder struct {
+	dbPool    int
+	dbParams  []DatabaseParam
+	dbPort    int
+	dbImage   string
+	dbInit    []string
+	dnsPolicy corev1.DNSPolicy
+	dnsConfig *corev1.PodDNSConfig
 }
 
 // Sandbox is the implementation of the sandbox.
@@ -37,14 +52,25 @@ type Sandbox struct {
 	project string
 
 	// Kubernetes API clients:
-	coreV1 *corev1client.CoreV1Client
-	rbacV1 *rbacv1client.RbacV1Client
-
-	// Details of the database administrator:
-	dbReady         bool
-	dbAdminUser     string
-	dbAdminPassword string
-	dbAddress       string
+	coreV1  *corev1client.CoreV1Client
+	rbacV1  *rbacv1client.RbacV1Client
+	batchV1 *batchv1client.BatchV1Client
+
+	// Database servers, indexed by engine, and pool of databases ready to be used:
+	dbLock    sync.Mutex
+	dbServers map[Engine]*dbServer
+	dbPool    []*Database
+	dbParams  []DatabaseParam
+	dbPort    int
+	dbImage   string
+	dbInit    []string
+
+	// Proxies that add latency to the connections to databases:
+	dbProxies []*latencyProxy
+
+	// DNS settings of the pods of the database servers:
+	dnsPolicy corev1.DNSPolicy
+	dnsConfig *corev1.PodDNSConfig
 }
 
 // NewSandbox creates a new builder that knows how to create a sandbox. The sandbox will be created
@@ -54,8 +80,106 @@ func NewSandbox() *SandboxBuilder {
 	return &SandboxBuilder{}
 }
 
+// DatabasePool sets the number of PostgreSQL databases that will be created when the sandbox is
+// built. The Database method takes databases from this pool, and their Destroy method empties
+// them and returns them to the pool, which is faster than creating and deleting them. When the
+// pool is exhausted the Database method doesn't wait, it creates a new database that isn't part
+// of the pool. The default is to not create a pool.
+func (b *SandboxBuilder) DatabasePool(value int) *SandboxBuilder {
+	b.dbPool = value
+	return b
+}
+
+// DatabaseParam adds a configuration parameter for the PostgreSQL server. Only the parameters
+// that can't break the server are supported, for example max_connections, shared_buffers or
+// work_mem. Setting fsync to off makes the server much faster, at the cost of losing the data
+// if the server crashes, and that is usually acceptable for tests.
+func (b *SandboxBuilder) DatabaseParam(name, value string) *SandboxBuilder {
+	b.dbParams = append(b.dbParams, DatabaseParam{
+		Name:  name,
+		Value: value,
+	})
+	return b
+}
+
+// DatabasePort sets the port where the database servers will listen, useful when the policy of
+// the cluster forbids the standard ports. The port is used by the pod, the service and the
+// connection strings returned by the Source method of the databases. The database servers are
+// shared by all the sandboxes of the project, so all of them should use the same port. The
+// default is to use the standard port of each engine, 5432 for PostgreSQL.
+func (b *SandboxBuilder) DatabasePort(value int) *SandboxBuilder {
+	b.dbPort = value
+	return b
+}
+
+// DatabaseImage sets the image used to run the database servers, for example when the cluster can
+// only pull images from a mirror registry. The image must be compatible with the default one, the
+// 'centos/postgresql-10-centos7' image for PostgreSQL. If not set the value of the
+// 'SANDBOX_DB_IMAGE' environment variable is used, and if that isn't set either the default image
+// of the engine.
+func (b *SandboxBuilder) DatabaseImage(value string) *SandboxBuilder {
+	b.dbImage = value
+	return b
+}
+
+// DatabaseInit adds an SQL script that the PostgreSQL server will run when it is initialized,
+// before it accepts connections from the sandboxes. The scripts run in the 'template1' database,
+// so the objects that they create will be part of all the databases created by the sandbox,
+// including the ones that are emptied and returned to the pool. The objects are owned by the
+// administrator, so the scripts should grant the privileges that the tests need, for example to
+// PUBLIC. This method can be called multiple times, and the scripts will run in that order. If a
+// script fails the server will not start.
+func (b *SandboxBuilder) DatabaseInit(sql string) *SandboxBuilder {
+	b.dbInit = append(b.dbInit, sql)
+	return b
+}
+
+// DNSPolicy sets the DNS policy of the pods of the database servers, for example 'None' when the
+// name servers are given explicitly with the DNSConfig method. The default is to use the default
+// policy of the cluster.
+func (b *SandboxBuilder) DNSPolicy(value corev1.DNSPolicy) *SandboxBuilder {
+	b.dnsPolicy = value
+	return b
+}
+
+// DNSConfig sets the DNS configuration, like name servers, search domains and resolver options,
+// of the pods of the database servers. The default is to use the default configuration of the
+// cluster.
+func (b *SandboxBuilder) DNSConfig(value corev1.PodDNSConfig) *SandboxBuilder {
+	b.dnsConfig = value.DeepCopy()
+	return b
+}
+
 // Build uses the information stored inside the builder to create a new sandbox.
 func (b *SandboxBuilder) Build() (s *Sandbox, err error) {
+	// Check the database parameters:
+	for _, param := range b.dbParams {
+		err = postgresEngine.checkParam(param)
+		if err != nil {
+			return
+		}
+	}
+	dbParams := make([]DatabaseParam, len(b.dbParams))
+	copy(dbParams, b.dbParams)
+	dbImage := b.dbImage
+	if dbImage == "" {
+		dbImage = os.Getenv(dbImageEnv)
+	}
+	dbInit := make([]string, len(b.dbInit))
+	copy(dbInit, b.dbInit)
+
+	// Check the database port:
+	if b.dbPort < 0 || b.dbPort > 65535 {
+		err = fmt.Errorf("database port %d isn't valid, it should be between 1 and 65535", b.dbPort)
+		return
+	}
+
+	// Check the DNS settings:
+	err = internal.CheckDNS(b.dnsPolicy, b.dnsConfig)
+	if err != nil {
+		return
+	}
+
 	// Get the name of the project from the file where the cluster writes it:
 	data, err := ioutil.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
 	if err != nil {
@@ -78,12 +202,37 @@ func (b *SandboxBuilder) Build() (s *Sandbox, err error) {
 	if err != nil {
 		return
 	}
+	batchV1, err := batchv1client.NewForConfig(config)
+	if err != nil {
+		return
+	}
 
 	// Create and populate the sandbox:
 	s = &Sandbox{
-		project: project,
-		coreV1:  coreV1,
-		rbacV1:  rbacV1,
+		project:   project,
+		coreV1:    coreV1,
+		rbacV1:    rbacV1,
+		batchV1:   batchV1,
+		dbServers: map[Engine]*dbServer{},
+		dbParams:  dbParams,
+		dbPort:    b.dbPort,
+		dbImage:   dbImage,
+		dbInit:    dbInit,
+		dnsPolicy: b.dnsPolicy,
+		dnsConfig: b.dnsConfig,
+	}
+
+	// Fill the pool of databases:
+	for i := 0; i < b.dbPool; i++ {
+		var database *Database
+		database, err = s.createDatabase(Postgres)
+		if err != nil {
+			err = fmt.Errorf("can't create database for the pool: %v", err)
+			s.dropPool()
+			return
+		}
+		database.pooled = true
+		s.dbPool = append(s.dbPool, database)
 	}
 
 	return
@@ -96,5 +245,33 @@ func (s *Sandbox) Project() string {
 
 // Destroy destroys the sandbox and all the associated resources.
 func (s *Sandbox) Destroy() error {
+	// Stop the proxies that add latency to the databases, including those of databases that
+	// weren't destroyed explicitly:
+	s.dbLock.Lock()
+	proxies := s.dbProxies
+	s.dbProxies = nil
+	s.dbLock.Unlock()
+	for _, proxy := range proxies {
+		err := proxy.close()
+		if err != nil {
+			log.Errorf("Can't stop database proxy: %v", err)
+		}
+	}
+
+	// Delete the databases that are in the pool:
+	s.dbLock.Lock()
+	pool := s.dbPool
+	s.dbPool = nil
+	s.dbLock.Unlock()
+	for _, database := range pool {
+		err := database.drop()
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+// Name of the environment variable that contains the image used to run the database servers:
+const dbImageEnv = "SANDBOX_DB_IMAGE"
@@ -20,6 +20,7 @@ package server
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
@@ -38,8 +39,65 @@ func (h *accessLogHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Print some details of the request:
 	log.Infof("Received %s request for '%s' from '%s'", r.Method, r.URL.Path, r.RemoteAddr)
 
-	// Call the next handler.
-	h.next.ServeHTTP(w, r)
+	// Call the next handler, using a response writer that remembers the status code and the
+	// number of bytes written:
+	start := time.Now()
+	sw := &statusWriter{
+		next:   w,
+		status: http.StatusOK,
+	}
+	h.next.ServeHTTP(sw, r)
+	elapsed := time.Since(start)
+
+	// Print the details of the response:
+	log.WithFields(log.Fields{
+		"method":  r.Method,
+		"path":    r.URL.Path,
+		"address": r.RemoteAddr,
+		"status":  sw.status,
+		"bytes":   sw.bytes,
+		"latency": elapsed.Seconds(),
+	}).Infof(
+		"Sent response with status code %d for %s request for '%s' in %s",
+		sw.status, r.Method, r.URL.Path, elapsed,
+	)
+}
+
+// statusWriter is a response writer that remembers the status code and the number of bytes
+// written.
+type statusWriter struct {
+	next   http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// Make sure that the writer implements the response writer interface:
+var _ http.ResponseWriter = &statusWriter{}
+
+// Header is the implementation of the response writer interface.
+func (w *statusWriter) Header() http.Header {
+	return w.next.Header()
+}
+
+// WriteHeader is the implementation of the response writer interface.
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.next.WriteHeader(status)
+}
+
+// Write is the implementation of the response writer interface.
+func (w *statusWriter) Write(data []byte) (count int, err error) {
+	count, err = w.next.Write(data)
+	w.bytes += int64(count)
+	return
+}
+
+// Flush sends any buffered data to the client, if the underlying writer supports it.
+func (w *statusWriter) Flush() {
+	flusher, ok := w.next.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
 }
 
 // accessLogMiddleware receives a handler and wraps it with another that writes the request to the
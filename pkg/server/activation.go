@@ -0,0 +1,72 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the support for systemd socket activation.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+)
+
+// ActivationListener returns the listener passed by systemd when the server is started with
+// socket activation. Systemd indicates that with the LISTEN_PID and LISTEN_FDS environment
+// variables, and passes the socket as file descriptor 3. If those variables aren't set, or if they
+// are meant for a different process, it returns nil and no error. The variables are removed from
+// the environment, so that they aren't passed to the tests.
+func ActivationListener() (listener net.Listener, err error) {
+	pid := os.Getenv(listenPIDEnv)
+	fds := os.Getenv(listenFDsEnv)
+	os.Unsetenv(listenPIDEnv)
+	os.Unsetenv(listenFDsEnv)
+	os.Unsetenv(listenFDNamesEnv)
+	if pid == "" || fds == "" {
+		return
+	}
+	if pid != strconv.Itoa(os.Getpid()) {
+		return
+	}
+	count, err := strconv.Atoi(fds)
+	if err != nil {
+		err = fmt.Errorf("value '%s' of variable '%s' isn't valid: %v", fds, listenFDsEnv, err)
+		return
+	}
+	if count != 1 {
+		err = fmt.Errorf("expected exactly one socket from systemd, but got %d", count)
+		return
+	}
+	file := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer file.Close()
+	listener, err = net.FileListener(file)
+	if err != nil {
+		err = fmt.Errorf("can't create listener from socket passed by systemd: %v", err)
+		return
+	}
+	return
+}
+
+// Names of the environment variables used by systemd for socket activation:
+const (
+	listenPIDEnv     = "LISTEN_PID"
+	listenFDsEnv     = "LISTEN_FDS"
+	listenFDNamesEnv = "LISTEN_FDNAMES"
+)
+
+// First file descriptor passed by systemd:
+const listenFDsStart = 3
@@ -0,0 +1,162 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the functions that collect the files produced by the tests.
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// checkOutputGlob checks that the given glob is syntactically valid and that it can only match
+// files inside the directory of the test: it can't be absolute and it can't contain '..'
+// elements.
+func checkOutputGlob(glob string) error {
+	if glob == "" {
+		return fmt.Errorf("glob is empty")
+	}
+	if filepath.IsAbs(glob) || strings.HasPrefix(glob, "/") {
+		return fmt.Errorf("glob '%s' is absolute", glob)
+	}
+	for _, element := range strings.Split(filepath.ToSlash(glob), "/") {
+		if element == ".." {
+			return fmt.Errorf("glob '%s' points outside of the test directory", glob)
+		}
+	}
+	_, err := filepath.Match(glob, "")
+	if err != nil {
+		return fmt.Errorf("glob '%s' isn't valid: %v", glob, err)
+	}
+	return nil
+}
+
+// collectArtifacts reads the regular files inside the given directory that match the given globs,
+// and returns them indexed by their path relative to the directory, using slashes as separators.
+// Matches that are symbolic links are followed only if the target is also inside the directory.
+// Files that would make the total size exceed the given limit aren't read, and their paths are
+// returned in the omitted list, together with the files that can't be read. If the limit is zero
+// there is no limit.
+func collectArtifacts(testID, dir string, globs []string, limit int64) (
+	artifacts map[string][]byte, omitted []string) {
+	// Find the real path of the directory, as the matches will be compared to it after
+	// resolving the symbolic links:
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		log.Errorf("Can't resolve directory '%s' of test '%s': %v", dir, testID, err)
+		return
+	}
+
+	// Find the matching files, removing duplicates and sorting them so that the files that are
+	// omitted when the limit is exceeded are always the same:
+	set := map[string]bool{}
+	for _, glob := range globs {
+		matches, err := filepath.Glob(filepath.Join(dir, glob))
+		if err != nil {
+			log.Errorf("Can't evaluate glob '%s' for test '%s': %v", glob, testID, err)
+			continue
+		}
+		for _, match := range matches {
+			set[match] = true
+		}
+	}
+	paths := make([]string, 0, len(set))
+	for path := range set {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	// Read the files:
+	var total int64
+	for _, path := range paths {
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			continue
+		}
+		name = filepath.ToSlash(name)
+		real, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			log.Errorf("Can't resolve artifact '%s' of test '%s': %v", name, testID, err)
+			omitted = append(omitted, name)
+			continue
+		}
+		if !insideDir(root, real) {
+			log.Warnf(
+				"Artifact '%s' of test '%s' is outside of the test directory, will "+
+					"not return it",
+				name, testID,
+			)
+			omitted = append(omitted, name)
+			continue
+		}
+		info, err := os.Stat(real)
+		if err != nil {
+			log.Errorf("Can't check artifact '%s' of test '%s': %v", name, testID, err)
+			omitted = append(omitted, name)
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+		if limit > 0 && total+info.Size() > limit {
+			log.Warnf(
+				"Artifact '%s' of test '%s' has %d bytes and the total would exceed "+
+					"the limit of %d bytes, will not return it",
+				name, testID, info.Size(), limit,
+			)
+			omitted = append(omitted, name)
+			continue
+		}
+		data, err := ioutil.ReadFile(real)
+		if err != nil {
+			log.Errorf("Can't read artifact '%s' of test '%s': %v", name, testID, err)
+			omitted = append(omitted, name)
+			continue
+		}
+		if artifacts == nil {
+			artifacts = map[string][]byte{}
+		}
+		artifacts[name] = data
+		total += int64(len(data))
+	}
+	if len(artifacts) > 0 {
+		log.Infof(
+			"Collected %d artifacts with %d bytes for test '%s'",
+			len(artifacts), total, testID,
+		)
+	}
+
+	return
+}
+
+// insideDir checks if the given path is the given directory or is inside it.
+func insideDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Default maximum total size of the artifacts returned for each test:
+const defaultMaxArtifacts = 10 * 1024 * 1024
@@ -0,0 +1,146 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Output globs", func() {
+	It("Accepts relative globs", func() {
+		Expect(checkOutputGlob("cpu.prof")).To(Succeed())
+		Expect(checkOutputGlob("testdata/*.golden")).To(Succeed())
+		Expect(checkOutputGlob("out/[a-z]*.png")).To(Succeed())
+	})
+
+	It("Rejects absolute globs", func() {
+		Expect(checkOutputGlob("/etc/passwd")).ToNot(Succeed())
+		Expect(checkOutputGlob("/*")).ToNot(Succeed())
+	})
+
+	It("Rejects globs that escape the test directory", func() {
+		Expect(checkOutputGlob("..")).ToNot(Succeed())
+		Expect(checkOutputGlob("../*")).ToNot(Succeed())
+		Expect(checkOutputGlob("out/../../secret")).ToNot(Succeed())
+	})
+
+	It("Rejects empty and malformed globs", func() {
+		Expect(checkOutputGlob("")).ToNot(Succeed())
+		Expect(checkOutputGlob("[")).ToNot(Succeed())
+	})
+})
+
+var _ = Describe("Artifacts", func() {
+	var tmp string
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		tmp, err = ioutil.TempDir("", "artifacts")
+		Expect(err).ToNot(HaveOccurred())
+		dir = filepath.Join(tmp, "test")
+		err = os.Mkdir(dir, 0700)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(tmp)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// writeFile writes the given text to a file inside the given directory, creating the
+	// intermediate directories.
+	writeFile := func(parent, name, text string) {
+		path := filepath.Join(parent, name)
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		Expect(err).ToNot(HaveOccurred())
+		err = ioutil.WriteFile(path, []byte(text), 0600)
+		Expect(err).ToNot(HaveOccurred())
+	}
+
+	It("Collects the matching files with relative paths", func() {
+		writeFile(dir, "cpu.prof", "profile")
+		writeFile(dir, "testdata/a.golden", "a")
+		writeFile(dir, "testdata/b.golden", "b")
+		writeFile(dir, "testdata/c.txt", "c")
+		artifacts, omitted := collectArtifacts(
+			"test", dir, []string{"cpu.prof", "testdata/*.golden"}, 0,
+		)
+		Expect(omitted).To(BeEmpty())
+		Expect(artifacts).To(HaveLen(3))
+		Expect(string(artifacts["cpu.prof"])).To(Equal("profile"))
+		Expect(string(artifacts["testdata/a.golden"])).To(Equal("a"))
+		Expect(string(artifacts["testdata/b.golden"])).To(Equal("b"))
+	})
+
+	It("Ignores directories", func() {
+		writeFile(dir, "testdata/a.golden", "a")
+		artifacts, omitted := collectArtifacts("test", dir, []string{"*"}, 0)
+		Expect(omitted).To(BeEmpty())
+		Expect(artifacts).To(BeEmpty())
+	})
+
+	It("Doesn't follow symbolic links to files outside of the directory", func() {
+		writeFile(tmp, "secret.txt", "secret")
+		err := os.Symlink(filepath.Join(tmp, "secret.txt"), filepath.Join(dir, "link.txt"))
+		Expect(err).ToNot(HaveOccurred())
+		artifacts, omitted := collectArtifacts("test", dir, []string{"*.txt"}, 0)
+		Expect(artifacts).To(BeEmpty())
+		Expect(omitted).To(ConsistOf("link.txt"))
+	})
+
+	It("Doesn't follow symbolic links to directories outside of the directory", func() {
+		writeFile(tmp, "outside/secret.txt", "secret")
+		err := os.Symlink(filepath.Join(tmp, "outside"), filepath.Join(dir, "link"))
+		Expect(err).ToNot(HaveOccurred())
+		artifacts, omitted := collectArtifacts("test", dir, []string{"link/*"}, 0)
+		Expect(artifacts).To(BeEmpty())
+		Expect(omitted).To(ConsistOf("link/secret.txt"))
+	})
+
+	It("Follows symbolic links inside the directory", func() {
+		writeFile(dir, "real.txt", "real")
+		err := os.Symlink("real.txt", filepath.Join(dir, "link.txt"))
+		Expect(err).ToNot(HaveOccurred())
+		artifacts, omitted := collectArtifacts("test", dir, []string{"link.txt"}, 0)
+		Expect(omitted).To(BeEmpty())
+		Expect(string(artifacts["link.txt"])).To(Equal("real"))
+	})
+
+	It("Omits the files that exceed the limit", func() {
+		writeFile(dir, "a.txt", "aaaa")
+		writeFile(dir, "b.txt", "bbbb")
+		writeFile(dir, "c.txt", "c")
+		artifacts, omitted := collectArtifacts("test", dir, []string{"*.txt"}, 5)
+		Expect(artifacts).To(HaveLen(2))
+		Expect(artifacts).To(HaveKey("a.txt"))
+		Expect(artifacts).To(HaveKey("c.txt"))
+		Expect(omitted).To(ConsistOf("b.txt"))
+	})
+
+	It("Returns each file once when several globs match it", func() {
+		writeFile(dir, "a.txt", "a")
+		artifacts, omitted := collectArtifacts("test", dir, []string{"*.txt", "a.*"}, 1)
+		Expect(omitted).To(BeEmpty())
+		Expect(artifacts).To(HaveLen(1))
+	})
+})
@@ -0,0 +1,85 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the audit log that records every test binary executed
+// by the server.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditRecord is the description of one execution of a test binary, as written to the audit log.
+type auditRecord struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Test   string    `json:"test"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"`
+	Args   []string  `json:"args,omitempty"`
+	Code   int       `json:"code"`
+	Signal string    `json:"signal,omitempty"`
+}
+
+// auditLog writes audit records to a file, one JSON document per line. The file is opened in
+// append mode, so existing records are never modified.
+type auditLog struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// openAuditLog opens the audit log file with the given path, creating it if it doesn't exist.
+func openAuditLog(path string) (audit *auditLog, err error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	audit = &auditLog{
+		file: file,
+	}
+	return
+}
+
+// Write writes the given record to the audit log.
+func (a *auditLog) Write(record *auditRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, err = a.file.Write(data)
+	return err
+}
+
+// Close closes the audit log file.
+func (a *auditLog) Close() error {
+	return a.file.Close()
+}
+
+// tokenFingerprint calculates the fingerprint of the given token that is written to the audit
+// log. The raw token is never written.
+func tokenFingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
@@ -19,6 +19,7 @@ limitations under the License.
 package server
 
 import (
+	"context"
 	"net/http"
 	"strings"
 
@@ -83,8 +84,17 @@ func (h *authHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Everything is OK; call the next handler.
-	h.next.ServeHTTP(w, r)
+	// Everything is OK; save the token in the context, so that handlers can use it, and call
+	// the next handler.
+	ctx := context.WithValue(r.Context(), tokenKey, token)
+	h.next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// requestToken returns the authentication token that was used in the given request, or an empty
+// string if the request hasn't been authenticated.
+func requestToken(r *http.Request) string {
+	token, _ := r.Context().Value(tokenKey).(string)
+	return token
 }
 
 // authMiddleware receives a handler and wraps it with another that performs authentication using
@@ -97,3 +107,12 @@ func authMiddleware(token string) mux.MiddlewareFunc {
 		}
 	}
 }
+
+// contextKey is the type used for the keys of the values that the middleware adds to the request
+// context.
+type contextKey int
+
+// Keys of the values stored in the request context:
+const (
+	tokenKey contextKey = iota
+)
@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the memory budget that limits the test binaries that run at the same time.
+
+package server
+
+import (
+	"context"
+	"sync"
+)
+
+// memoryBudget limits the total size of the test binaries that are running at the same time. The
+// size of a binary is used as an estimate of the memory that it will need, which is a better
+// measure than the number of binaries because binaries built with the race detector, for
+// example, can be much larger than others. Binaries that don't fit are queued, not rejected, and
+// started in the order that they arrived when enough of the budget is released. A binary larger
+// than the whole budget is started when no other binary is running, otherwise it would never
+// run.
+type memoryBudget struct {
+	lock    sync.Mutex
+	limit   int64
+	used    int64
+	running int
+	waiters []*budgetWaiter
+}
+
+// budgetWaiter is a binary waiting for its part of the budget. The channel is closed when the
+// part is granted.
+type budgetWaiter struct {
+	size    int64
+	granted chan struct{}
+}
+
+// newMemoryBudget creates a budget with the given limit in bytes.
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{
+		limit: limit,
+	}
+}
+
+// acquire waits till there is enough budget for a binary of the given size and then reserves it.
+// If the context is cancelled while waiting it returns the error of the context and nothing is
+// reserved. When the binary finishes the caller must call the release method with the same size.
+func (b *memoryBudget) acquire(ctx context.Context, size int64) error {
+	b.lock.Lock()
+	waiter := &budgetWaiter{
+		size:    size,
+		granted: make(chan struct{}),
+	}
+	b.waiters = append(b.waiters, waiter)
+	b.grant()
+	b.lock.Unlock()
+
+	select {
+	case <-waiter.granted:
+		return nil
+	case <-ctx.Done():
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		select {
+		case <-waiter.granted:
+			// The budget was granted at the same time that the context was cancelled, so
+			// we need to give it back:
+			b.free(size)
+		default:
+			b.remove(waiter)
+		}
+		b.grant()
+		return ctx.Err()
+	}
+}
+
+// release gives back the part of the budget reserved for a binary of the given size, and starts
+// the binaries that were waiting for it, if possible.
+func (b *memoryBudget) release(size int64) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.free(size)
+	b.grant()
+}
+
+// grant reserves the budget for the waiters at the beginning of the queue, as long as it is
+// enough. Note that it stops at the first waiter that doesn't fit, so that small binaries don't
+// starve large ones. Must be called with the lock held.
+func (b *memoryBudget) grant() {
+	for len(b.waiters) > 0 {
+		waiter := b.waiters[0]
+		if b.running > 0 && b.used+waiter.size > b.limit {
+			return
+		}
+		b.waiters = b.waiters[1:]
+		b.used += waiter.size
+		b.running++
+		close(waiter.granted)
+	}
+}
+
+// free gives back the budget reserved for a binary. Must be called with the lock held.
+func (b *memoryBudget) free(size int64) {
+	b.used -= size
+	b.running--
+}
+
+// remove removes the given waiter from the queue. Must be called with the lock held.
+func (b *memoryBudget) remove(waiter *budgetWaiter) {
+	for i, current := range b.waiters {
+		if current == waiter {
+			b.waiters = append(b.waiters[:i], b.waiters[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,91 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Memory budget", func() {
+	// acquire starts acquiring the given size in the background and returns a channel that
+	// receives the result when it finishes.
+	acquire := func(ctx context.Context, budget *memoryBudget, size int64) chan error {
+		result := make(chan error, 1)
+		go func() {
+			result <- budget.acquire(ctx, size)
+		}()
+		return result
+	}
+
+	It("Doesn't wait while there is budget", func() {
+		budget := newMemoryBudget(100)
+		Expect(budget.acquire(context.Background(), 60)).To(Succeed())
+		Expect(budget.acquire(context.Background(), 40)).To(Succeed())
+	})
+
+	It("Waits till enough budget is released", func() {
+		budget := newMemoryBudget(100)
+		Expect(budget.acquire(context.Background(), 60)).To(Succeed())
+		done := acquire(context.Background(), budget, 50)
+		Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+		budget.release(60)
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("Runs a binary larger than the budget when nothing else runs", func() {
+		budget := newMemoryBudget(100)
+		Expect(budget.acquire(context.Background(), 10)).To(Succeed())
+		done := acquire(context.Background(), budget, 200)
+		Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+		budget.release(10)
+		Eventually(done).Should(Receive(BeNil()))
+	})
+
+	It("Starts waiting binaries in order", func() {
+		budget := newMemoryBudget(100)
+		Expect(budget.acquire(context.Background(), 100)).To(Succeed())
+		large := acquire(context.Background(), budget, 80)
+		Eventually(func() int {
+			budget.lock.Lock()
+			defer budget.lock.Unlock()
+			return len(budget.waiters)
+		}).Should(Equal(1))
+		small := acquire(context.Background(), budget, 10)
+		Consistently(small, 100*time.Millisecond).ShouldNot(Receive())
+		budget.release(100)
+		Eventually(large).Should(Receive(BeNil()))
+		Eventually(small).Should(Receive(BeNil()))
+	})
+
+	It("Stops waiting when the context is cancelled", func() {
+		budget := newMemoryBudget(100)
+		Expect(budget.acquire(context.Background(), 100)).To(Succeed())
+		ctx, cancel := context.WithCancel(context.Background())
+		done := acquire(ctx, budget, 50)
+		Consistently(done, 100*time.Millisecond).ShouldNot(Receive())
+		cancel()
+		Eventually(done).Should(Receive(Equal(context.Canceled)))
+		budget.lock.Lock()
+		defer budget.lock.Unlock()
+		Expect(budget.waiters).To(BeEmpty())
+		Expect(budget.used).To(BeNumerically("==", 100))
+	})
+})
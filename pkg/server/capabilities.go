@@ -0,0 +1,68 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the handler that tells clients what features the server supports.
+
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ http.Handler = &getCapabilitiesHandler{}
+
+// getCapabilitiesHandler is the handler that returns the capabilities of the server.
+type getCapabilitiesHandler struct {
+	capabilities *api.Capabilities
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *getCapabilitiesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(h.capabilities)
+	if err != nil {
+		log.Errorf("Can't send response body for capabilities")
+		return
+	}
+}
+
+// capabilities calculates the capabilities of the server from its configuration.
+func (s *Server) capabilities() *api.Capabilities {
+	return &api.Capabilities{
+		Compression:    true,
+		Fetch:          len(s.fetcher.allowed) > 0,
+		Fixtures:       true,
+		EnvFile:        true,
+		RunAsUser:      s.runAs != nil,
+		Timeout:        true,
+		Deadline:       true,
+		JSONEvents:     s.test2json != "",
+		Idempotency:    s.results != nil,
+		Plans:          true,
+		Once:           true,
+		Artifacts:      true,
+		Uploads:        true,
+		MaxOutputBytes: s.maxOutput,
+	}
+}
@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that compresses the files of the test directories that are
+// preserved.
+
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// compressedFiles are the names of the files of the test directories that are compressed when the
+// directories are preserved. Only these are compressed, as the rest of the files are created by
+// the tests, and they may need to be examined as they are.
+var compressedFiles = []string{
+	"binary",
+	"stdout",
+	"stderr",
+}
+
+// compressDir replaces the binary and the output files of the given test directory, and of the
+// directories of the steps when it is the directory of a plan, with compressed copies that have
+// the same name and the '.gz' extension.
+func compressDir(dir string) error {
+	dirs := []string{dir}
+	steps, err := filepath.Glob(filepath.Join(dir, "steps", "*"))
+	if err != nil {
+		return err
+	}
+	dirs = append(dirs, steps...)
+	for _, current := range dirs {
+		for _, name := range compressedFiles {
+			path := filepath.Join(current, name)
+			info, err := os.Lstat(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				continue
+			}
+			err = compressFile(path, info.Mode().Perm()&^0111)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compressFile replaces the given file with a compressed copy that has the same name, the '.gz'
+// extension and the given permissions. The permissions of the original file aren't used directly
+// because the compressed copy of the binary shouldn't be executable. If the copy can't be created
+// the original file is preserved.
+func compressFile(path string, mode os.FileMode) (err error) {
+	source, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer source.Close()
+	target, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			target.Close()
+			os.Remove(target.Name())
+		}
+	}()
+	writer := gzip.NewWriter(target)
+	_, err = io.Copy(writer, source)
+	if err != nil {
+		return
+	}
+	err = writer.Close()
+	if err != nil {
+		return
+	}
+	err = target.Close()
+	if err != nil {
+		return
+	}
+	err = os.Remove(path)
+	return
+}
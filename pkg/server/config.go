@@ -0,0 +1,106 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the handler that returns the effective configuration of the server.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ http.Handler = &getConfigHandler{}
+
+// getConfigHandler is the handler that returns the configuration of the server.
+type getConfigHandler struct {
+	config *api.Config
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *getConfigHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(h.config)
+	if err != nil {
+		log.Errorf("Can't send response body for configuration")
+		return
+	}
+}
+
+// config calculates the configuration that the server reports to clients. Secrets must never be
+// added here: the token isn't included, and the user information of the URLs that binaries can be
+// downloaded from, which may contain passwords, is removed.
+func (s *Server) config() *api.Config {
+	config := &api.Config{
+		Work:             s.work,
+		BasePath:         s.basePath,
+		TLSCert:          s.tlsCert,
+		TLSKey:           s.tlsKey,
+		KeepOnFailure:    s.keepOnFailure,
+		CompressKept:     s.compressKept,
+		KeepAge:          configDuration(s.keepAge),
+		SweepAge:         configDuration(s.sweepAge),
+		EnvAllow:         s.envAllow,
+		EnvDeny:          s.envDeny,
+		CleanEnv:         s.cleanEnv,
+		FixtureTTL:       configDuration(s.fixtureTTL),
+		OnceTTL:          configDuration(s.once.ttl),
+		UploadTTL:        configDuration(s.uploads.ttl),
+		TeeOutput:        s.teeOutput,
+		MaxOutputBytes:   s.maxOutput,
+		MaxArtifactBytes: s.maxArtifacts,
+		AccessLog:        s.accessLog,
+	}
+	if s.audit != nil {
+		config.Audit = s.audit.file.Name()
+	}
+	for _, allowed := range s.fetcher.allowed {
+		location := *allowed
+		location.User = nil
+		config.FetchFrom = append(config.FetchFrom, location.String())
+	}
+	if s.runAs != nil {
+		config.RunAsRange = fmt.Sprintf("%d-%d", s.runAs.min, s.runAs.max)
+	}
+	if s.results != nil {
+		config.ResultTTL = configDuration(s.results.ttl)
+	}
+	if s.budget != nil {
+		config.MemoryBudgetBytes = s.budget.limit
+	}
+	if s.idle != nil {
+		config.IdleTimeout = configDuration(s.idle.timeout)
+	}
+	return config
+}
+
+// configDuration converts the given duration to the representation used in the configuration,
+// which is empty for zero.
+func configDuration(value time.Duration) string {
+	if value == 0 {
+		return ""
+	}
+	return value.String()
+}
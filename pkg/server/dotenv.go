@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the parser for the environment files sent by clients.
+
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// envVar is a variable read from an environment file.
+type envVar struct {
+	name  string
+	value string
+}
+
+// parseEnvFile parses the content of a dotenv style environment file. Each line contains a
+// variable, in the form NAME=VALUE, optionally preceded by the export keyword. Empty lines and
+// lines starting with # are ignored. Values can be enclosed in double quotes, and then they can
+// contain the escape sequences \n, \r, \t, \", \$ and \\, or in single quotes, and then they
+// are used literally. Unquoted values end at the first # preceded by white space, and white space
+// around them is removed. The variables are returned in the same order that they appear in the
+// file.
+func parseEnvFile(data []byte) (vars []envVar, err error) {
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		var v *envVar
+		v, err = parseEnvLine(strings.TrimSuffix(line, "\r"))
+		if err != nil {
+			err = fmt.Errorf("line %d: %v", i+1, err)
+			return
+		}
+		if v != nil {
+			vars = append(vars, *v)
+		}
+	}
+	return
+}
+
+// parseEnvLine parses one line of an environment file. It returns nil if the line is empty or
+// contains only a comment.
+func parseEnvLine(line string) (v *envVar, err error) {
+	// Skip empty lines and comments:
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+
+	// Extract the name:
+	line = strings.TrimPrefix(line, "export ")
+	index := strings.Index(line, "=")
+	if index < 0 {
+		err = fmt.Errorf("expected 'NAME=VALUE' but found '%s'", line)
+		return
+	}
+	name := strings.TrimSpace(line[0:index])
+	if !envNameRE.MatchString(name) {
+		err = fmt.Errorf("variable name '%s' isn't valid", name)
+		return
+	}
+
+	// Extract the value:
+	rest := strings.TrimSpace(line[index+1:])
+	var value string
+	switch {
+	case strings.HasPrefix(rest, `"`):
+		value, rest, err = parseEnvDoubleQuoted(rest[1:])
+	case strings.HasPrefix(rest, `'`):
+		index = strings.Index(rest[1:], `'`)
+		if index < 0 {
+			err = fmt.Errorf("missing closing single quote")
+		} else {
+			value = rest[1 : index+1]
+			rest = rest[index+2:]
+		}
+	default:
+		value = rest
+		rest = ""
+		for i := 1; i < len(value); i++ {
+			if value[i] == '#' && (value[i-1] == ' ' || value[i-1] == '\t') {
+				value = value[0:i]
+				break
+			}
+		}
+		value = strings.TrimSpace(value)
+	}
+	if err != nil {
+		err = fmt.Errorf("value of variable '%s' isn't valid: %v", name, err)
+		return
+	}
+
+	// After a quoted value only white space or a comment is allowed:
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		err = fmt.Errorf(
+			"unexpected text '%s' after value of variable '%s'",
+			rest, name,
+		)
+		return
+	}
+
+	v = &envVar{
+		name:  name,
+		value: value,
+	}
+	return
+}
+
+// parseEnvDoubleQuoted parses a double quoted value, starting after the opening quote. It returns
+// the value and the text that follows the closing quote.
+func parseEnvDoubleQuoted(text string) (value, rest string, err error) {
+	buffer := &strings.Builder{}
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch c {
+		case '"':
+			value = buffer.String()
+			rest = text[i+1:]
+			return
+		case '\\':
+			i++
+			if i == len(text) {
+				err = fmt.Errorf("incomplete escape sequence")
+				return
+			}
+			switch text[i] {
+			case 'n':
+				buffer.WriteByte('\n')
+			case 'r':
+				buffer.WriteByte('\r')
+			case 't':
+				buffer.WriteByte('\t')
+			case '"', '\\', '$':
+				buffer.WriteByte(text[i])
+			default:
+				err = fmt.Errorf("unknown escape sequence '\\%c'", text[i])
+				return
+			}
+		default:
+			buffer.WriteByte(c)
+		}
+	}
+	err = fmt.Errorf("missing closing double quote")
+	return
+}
+
+// envNameRE is the regular expression used to check the names of environment variables.
+var envNameRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
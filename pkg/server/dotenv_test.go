@@ -0,0 +1,75 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Environment file parser", func() {
+	DescribeTable(
+		"Parses valid files",
+		func(text string, expected []envVar) {
+			vars, err := parseEnvFile([]byte(text))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(vars).To(Equal(expected))
+		},
+		Entry("Empty", "", nil),
+		Entry("Simple", "A=x", []envVar{{"A", "x"}}),
+		Entry("Multiple", "A=x\nB=y", []envVar{{"A", "x"}, {"B", "y"}}),
+		Entry("Windows line ends", "A=x\r\nB=y\r\n", []envVar{{"A", "x"}, {"B", "y"}}),
+		Entry("Empty value", "A=", []envVar{{"A", ""}}),
+		Entry("Export", "export A=x", []envVar{{"A", "x"}}),
+		Entry("Spaces around equals", "A = x", []envVar{{"A", "x"}}),
+		Entry("Equals in value", "A=x=y", []envVar{{"A", "x=y"}}),
+		Entry("Comment line", "# A=x\nB=y", []envVar{{"B", "y"}}),
+		Entry("Indented comment", "  # A=x", nil),
+		Entry("Empty lines", "\n\nA=x\n\n", []envVar{{"A", "x"}}),
+		Entry("Inline comment", "A=x # y", []envVar{{"A", "x"}}),
+		Entry("Hash without space", "A=x#y", []envVar{{"A", "x#y"}}),
+		Entry("Double quotes", `A="x y"`, []envVar{{"A", "x y"}}),
+		Entry("Double quotes with hash", `A="x # y"`, []envVar{{"A", "x # y"}}),
+		Entry("Double quotes with comment", `A="x" # y`, []envVar{{"A", "x"}}),
+		Entry("Escaped new line", `A="x\ny"`, []envVar{{"A", "x\ny"}}),
+		Entry("Escaped quote", `A="x\"y"`, []envVar{{"A", `x"y`}}),
+		Entry("Escaped backslash", `A="x\\y"`, []envVar{{"A", `x\y`}}),
+		Entry("Single quotes", `A='x y'`, []envVar{{"A", "x y"}}),
+		Entry("Single quotes are literal", `A='x\ny'`, []envVar{{"A", `x\ny`}}),
+		Entry("Double quotes inside single", `A='x"y'`, []envVar{{"A", `x"y`}}),
+		Entry("Single quotes inside double", `A="x'y"`, []envVar{{"A", "x'y"}}),
+		Entry("Empty quotes", `A=""`, []envVar{{"A", ""}}),
+	)
+
+	DescribeTable(
+		"Rejects invalid files",
+		func(text string, message string) {
+			_, err := parseEnvFile([]byte(text))
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(message))
+		},
+		Entry("Missing equals", "A", "line 1: expected 'NAME=VALUE'"),
+		Entry("Line number", "A=x\nB", "line 2:"),
+		Entry("Empty name", "=x", "variable name '' isn't valid"),
+		Entry("Invalid name", "1A=x", "variable name '1A' isn't valid"),
+		Entry("Unterminated double quote", `A="x`, "missing closing double quote"),
+		Entry("Unterminated single quote", `A='x`, "missing closing single quote"),
+		Entry("Unknown escape", `A="\q"`, "unknown escape sequence"),
+		Entry("Text after quotes", `A="x" y`, "unexpected text 'y'"),
+	)
+})
@@ -0,0 +1,101 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the functions used to calculate the environment variables that are passed
+// to the tests.
+
+package server
+
+import (
+	"strings"
+)
+
+// defaultEnvDeny is the list of prefixes of the names of environment variables of the server that
+// are never passed to the tests, because they are known to contain sensitive information.
+var defaultEnvDeny = []string{
+	"AWS_",
+	"AZURE_",
+	"GOOGLE_",
+	"POSTGRESQL_",
+}
+
+// baseEnv returns the minimal environment that is used for the tests when the server is
+// configured to not pass its own environment. It contains only the PATH variable, with the
+// standard locations of binaries, and the HOME variable, pointing to the given directory.
+func baseEnv(home string) []string {
+	return []string{
+		"PATH=" + baseEnvPath,
+		"HOME=" + home,
+	}
+}
+
+// filterEnv removes from the given list of environment variables the ones that don't match any of
+// the allowed prefixes and the ones that match any of the denied prefixes. If the list of allowed
+// prefixes is empty then all the variables are allowed.
+func filterEnv(env []string, allow, deny []string) []string {
+	result := make([]string, 0, len(env))
+	for _, item := range env {
+		name := item
+		index := strings.Index(item, "=")
+		if index >= 0 {
+			name = item[0:index]
+		}
+		if len(allow) > 0 && !hasAnyPrefix(name, allow) {
+			continue
+		}
+		if hasAnyPrefix(name, deny) {
+			continue
+		}
+		result = append(result, item)
+	}
+	return result
+}
+
+// dedupEnv removes from the given list of environment variables the duplicated names, so that the
+// result contains only one entry for each name. The value that is kept is the last one, as that is
+// the one that should override the others, but it is placed where the name first appeared.
+func dedupEnv(env []string) []string {
+	positions := make(map[string]int, len(env))
+	result := make([]string, 0, len(env))
+	for _, item := range env {
+		name := item
+		index := strings.Index(item, "=")
+		if index >= 0 {
+			name = item[0:index]
+		}
+		position, ok := positions[name]
+		if ok {
+			result[position] = item
+			continue
+		}
+		positions[name] = len(result)
+		result = append(result, item)
+	}
+	return result
+}
+
+// hasAnyPrefix checks if the given name starts with any of the given prefixes.
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// baseEnvPath is the value of the PATH environment variable of the minimal environment:
+const baseEnvPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
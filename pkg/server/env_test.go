@@ -0,0 +1,84 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Environment", func() {
+	It("Keeps the last value of duplicated variables", func() {
+		env := dedupEnv([]string{
+			"A=1",
+			"B=1",
+			"A=2",
+			"C=1",
+			"B=2",
+			"A=3",
+		})
+		Expect(env).To(Equal([]string{
+			"A=3",
+			"B=2",
+			"C=1",
+		}))
+	})
+
+	It("Doesn't change variables without duplicates", func() {
+		env := dedupEnv([]string{
+			"A=1",
+			"B=",
+			"C=x=y",
+		})
+		Expect(env).To(Equal([]string{
+			"A=1",
+			"B=",
+			"C=x=y",
+		}))
+	})
+
+	It("Removes denied variables", func() {
+		env := filterEnv(
+			[]string{
+				"AWS_KEY=secret",
+				"MY_VAR=1",
+				"OTHER=2",
+			},
+			nil,
+			defaultEnvDeny,
+		)
+		Expect(env).To(Equal([]string{
+			"MY_VAR=1",
+			"OTHER=2",
+		}))
+	})
+
+	It("Keeps only allowed variables", func() {
+		env := filterEnv(
+			[]string{
+				"MY_VAR=1",
+				"MY_AWS_KEY=secret",
+				"OTHER=2",
+			},
+			[]string{"MY_"},
+			[]string{"MY_AWS_"},
+		)
+		Expect(env).To(Equal([]string{
+			"MY_VAR=1",
+		}))
+	})
+})
@@ -82,3 +82,36 @@ func sendPanic(w http.ResponseWriter, r *http.Request) {
 		log.Errorf("Can't send panic response for request '%s': %s", r.URL.Path, err)
 	}
 }
+
+// requestError is an error that contains the status code and the reason that should be sent to
+// the client. It is used by the functions that do part of the work of a handler, so that the
+// handler can send the right response.
+type requestError struct {
+	status int
+	reason string
+}
+
+// newRequestError creates a new request error with the given status code and reason.
+func newRequestError(status int, format string, a ...interface{}) error {
+	return &requestError{
+		status: status,
+		reason: fmt.Sprintf(format, a...),
+	}
+}
+
+// Error is the implementation of the error interface.
+func (e *requestError) Error() string {
+	return e.reason
+}
+
+// sendRequestError sends an error response to the client using the status code and the reason
+// of the given error. If it isn't a request error it sends an internal server error.
+func sendRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	requestErr, ok := err.(*requestError)
+	if !ok {
+		log.Errorf("Unexpected error for request '%s': %v", r.URL.Path, err)
+		sendError(w, r, http.StatusInternalServerError, "Unexpected error")
+		return
+	}
+	sendError(w, r, requestErr.status, "%s", requestErr.reason)
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the functions used to convert the output of test binaries to the JSON events
+// generated by the 'go test -json' command.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// Name of the tool that converts the output of test binaries to JSON events. It is part of the Go
+// distribution, but usually it isn't in the path, so it needs to be copied to the image of the
+// server.
+const test2jsonTool = "test2json"
+
+// testEvent is the subset of the fields of a JSON event that the server needs to read or write.
+type testEvent struct {
+	Time    *time.Time `json:"Time,omitempty"`
+	Action  string     `json:"Action"`
+	Package string     `json:"Package,omitempty"`
+	Test    string     `json:"Test,omitempty"`
+}
+
+// jsonEvents converts the given output of a test binary, generated with the -test.v flag, to JSON
+// events, using the given test2json tool. The package is added to the events if it isn't empty.
+// The tool reports the result of the binary only when the output contains it, so if the binary
+// failed without writing it, for example because it was killed, this adds the final event that
+// indicates the failure.
+func jsonEvents(tool, pkg string, out []byte, failed bool) (events []byte, err error) {
+	args := []string{"-t"}
+	if pkg != "" {
+		args = append(args, "-p", pkg)
+	}
+	cmd := exec.Command(tool, args...)
+	cmd.Stdin = bytes.NewReader(out)
+	events, err = cmd.Output()
+	if err != nil {
+		err = fmt.Errorf("can't run '%s': %v", tool, err)
+		return
+	}
+	if failed && !hasResultEvent(events) {
+		now := time.Now()
+		var data []byte
+		data, err = json.Marshal(&testEvent{
+			Time:    &now,
+			Action:  "fail",
+			Package: pkg,
+		})
+		if err != nil {
+			return
+		}
+		events = append(events, data...)
+		events = append(events, '\n')
+	}
+	return
+}
+
+// hasResultEvent checks if the given stream of JSON events contains the event that reports the
+// result of the complete binary, which is a pass, fail or skip event without a test name.
+func hasResultEvent(events []byte) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(events))
+	scanner.Buffer(nil, len(events)+1)
+	for scanner.Scan() {
+		event := &testEvent{}
+		err := json.Unmarshal(scanner.Bytes(), event)
+		if err != nil || event.Test != "" {
+			continue
+		}
+		switch event.Action {
+		case "pass", "fail", "skip":
+			return true
+		}
+	}
+	return false
+}
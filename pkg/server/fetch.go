@@ -0,0 +1,148 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the logic used to fetch test binaries from the URLs sent by the client.
+
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// fetcher knows how to download test binaries from a set of allowed locations.
+type fetcher struct {
+	allowed []*url.URL
+	client  *http.Client
+}
+
+// newFetcher creates a fetcher that will only download binaries from URLs that have the same
+// scheme and host than one of the given URLs, and whose path starts with the path of that URL.
+func newFetcher(allowed []string) (result *fetcher, err error) {
+	urls := make([]*url.URL, len(allowed))
+	for i, value := range allowed {
+		var parsed *url.URL
+		parsed, err = url.Parse(value)
+		if err != nil {
+			err = fmt.Errorf("can't parse allowed URL '%s': %v", value, err)
+			return
+		}
+		if parsed.Scheme != "http" && parsed.Scheme != "https" {
+			err = fmt.Errorf(
+				"scheme of allowed URL '%s' should be 'http' or 'https'",
+				value,
+			)
+			return
+		}
+		if parsed.Host == "" {
+			err = fmt.Errorf("allowed URL '%s' doesn't contain a host", value)
+			return
+		}
+		urls[i] = parsed
+	}
+	result = &fetcher{
+		allowed: urls,
+	}
+	result.client = &http.Client{
+		Timeout: fetchTimeout,
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if len(via) >= fetchMaxRedirects {
+				return fmt.Errorf("stopped after %d redirects", len(via))
+			}
+			if !result.allows(r.URL) {
+				return fmt.Errorf("redirect to '%s' isn't allowed", r.URL)
+			}
+			return nil
+		},
+	}
+	return
+}
+
+// allows checks if the given URL is allowed. URLs whose path contains dot segments are always
+// rejected, as the server that receives them may resolve them to a location outside of the
+// allowed prefix. Note that the path has already been decoded, so this also rejects segments
+// written with escapes, like '%2e%2e'.
+func (f *fetcher) allows(u *url.URL) bool {
+	if hasDotSegments(u.Path) {
+		return false
+	}
+	for _, allowed := range f.allowed {
+		if u.Scheme != allowed.Scheme || u.Host != allowed.Host {
+			continue
+		}
+		prefix := allowed.Path
+		if prefix == "" || strings.HasSuffix(prefix, "/") {
+			if strings.HasPrefix(u.Path, prefix) {
+				return true
+			}
+		} else if u.Path == prefix || strings.HasPrefix(u.Path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDotSegments checks if the given path contains '.' or '..' segments. Backslashes are also
+// considered separators, as some servers treat them like slashes.
+func hasDotSegments(path string) bool {
+	segments := strings.FieldsFunc(path, func(r rune) bool {
+		return r == '/' || r == '\\'
+	})
+	for _, segment := range segments {
+		if segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch downloads the binary from the given URL. The caller is responsible for closing the
+// returned reader.
+func (f *fetcher) fetch(address string) (body io.ReadCloser, err error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return
+	}
+	if !f.allows(parsed) {
+		err = errFetchNotAllowed
+		return
+	}
+	response, err := f.client.Get(parsed.String())
+	if err != nil {
+		return
+	}
+	if response.StatusCode != http.StatusOK {
+		response.Body.Close()
+		err = fmt.Errorf("fetch failed with status code %d", response.StatusCode)
+		return
+	}
+	body = response.Body
+	return
+}
+
+// errFetchNotAllowed is the error returned when the client requests to fetch a binary from a URL
+// that isn't allowed.
+var errFetchNotAllowed = fmt.Errorf("URL isn't allowed")
+
+// Fetch constants:
+const (
+	fetchTimeout      = 10 * time.Minute
+	fetchMaxRedirects = 10
+)
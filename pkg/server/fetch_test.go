@@ -0,0 +1,60 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fetcher", func() {
+	var ftchr *fetcher
+
+	BeforeEach(func() {
+		var err error
+		ftchr, err = newFetcher([]string{
+			"https://example.com/allowed",
+			"https://example.com/dir/",
+		})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	DescribeTable(
+		"Checks if URLs are allowed",
+		func(address string, expected bool) {
+			parsed, err := url.Parse(address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(ftchr.allows(parsed)).To(Equal(expected))
+		},
+		Entry("Exact path", "https://example.com/allowed", true),
+		Entry("Inside path", "https://example.com/allowed/my.test", true),
+		Entry("Inside directory", "https://example.com/dir/my.test", true),
+		Entry("Sibling with same prefix", "https://example.com/allowedx/my.test", false),
+		Entry("Different host", "https://other.com/allowed/my.test", false),
+		Entry("Different scheme", "http://example.com/allowed/my.test", false),
+		Entry("Parent segment", "https://example.com/allowed/../secret", false),
+		Entry("Parent segment in directory", "https://example.com/dir/../secret", false),
+		Entry("Escaped parent segment", "https://example.com/allowed/%2e%2e/secret", false),
+		Entry("Escaped slashes", "https://example.com/allowed%2f..%2fsecret", false),
+		Entry("Backslash", "https://example.com/allowed/..\\secret", false),
+		Entry("Current segment", "https://example.com/allowed/./my.test", false),
+		Entry("Dots inside name", "https://example.com/allowed/my..test", true),
+	)
+})
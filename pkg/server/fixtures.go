@@ -0,0 +1,262 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the store of fixtures, large files that are uploaded
+// once and then shared by multiple tests.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+// fixtureStore manages the fixtures. Fixtures are stored in a hidden sub-directory of the
+// directory of each tenant, so fixtures uploaded with different tokens are isolated from each
+// other. Fixtures that haven't been used for longer than the configured TTL are periodically
+// removed, unless they are in use by a running test.
+type fixtureStore struct {
+	work     string
+	ttl      time.Duration
+	active   *activeSet
+	interval time.Duration
+	stop     chan bool
+	done     chan bool
+}
+
+// newFixtureStore creates a fixture store that keeps the fixtures inside the given work
+// directory. If the TTL is zero fixtures will never be removed.
+func newFixtureStore(work string, ttl time.Duration, active *activeSet) *fixtureStore {
+	interval := ttl / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	return &fixtureStore{
+		work:     work,
+		ttl:      ttl,
+		active:   active,
+		interval: interval,
+	}
+}
+
+// path returns the path of the file that contains the given fixture.
+func (s *fixtureStore) path(tenant, name string) string {
+	return filepath.Join(s.work, tenant, fixturesDir, name)
+}
+
+// put stores the fixture, replacing the previous one with the same name if it exists. The data is
+// first written to a temporary file and then renamed, so that tests that are already using the
+// previous version of the fixture aren't affected. Fixtures are readable by all users, so that
+// tests that run as a different user can use them. It returns the number of bytes written.
+func (s *fixtureStore) put(tenant, name string, data io.Reader, hash io.Writer) (size int64,
+	err error) {
+	dir := filepath.Join(s.work, tenant, fixturesDir)
+	err = os.MkdirAll(dir, 0711)
+	if err != nil {
+		return
+	}
+	file, err := ioutil.TempFile(dir, ".upload")
+	if err != nil {
+		return
+	}
+	size, err = io.Copy(io.MultiWriter(file, hash), data)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	err = os.Chmod(file.Name(), 0644)
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	err = os.Rename(file.Name(), s.path(tenant, name))
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	return
+}
+
+// acquire marks the fixture as in use, so that it isn't removed while a test is using it, and
+// returns its path. The caller must call the release method when the test finishes.
+func (s *fixtureStore) acquire(tenant, name string) (path string, err error) {
+	path = s.path(tenant, name)
+	s.active.add(path)
+	now := time.Now()
+	err = os.Chtimes(path, now, now)
+	if err != nil {
+		s.active.remove(path)
+		path = ""
+	}
+	return
+}
+
+// release marks the fixture as no longer used by the test.
+func (s *fixtureStore) release(path string) {
+	s.active.remove(path)
+}
+
+// start starts the goroutine that periodically removes the unused fixtures. It does nothing if
+// the TTL is zero.
+func (s *fixtureStore) start() {
+	if s.ttl <= 0 {
+		return
+	}
+	s.stop = make(chan bool)
+	s.done = make(chan bool)
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.clean()
+			}
+		}
+	}()
+}
+
+// halt stops the goroutine that removes unused fixtures and waits till it finishes.
+func (s *fixtureStore) halt() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// clean removes the fixtures that haven't been used for longer than the TTL.
+func (s *fixtureStore) clean() {
+	paths, err := filepath.Glob(filepath.Join(s.work, "*", fixturesDir, "*"))
+	if err != nil {
+		log.Errorf("Can't find fixtures in work directory '%s': %v", s.work, err)
+		return
+	}
+	limit := time.Now().Add(-s.ttl)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Errorf("Can't check fixture '%s': %v", path, err)
+			continue
+		}
+		if info.ModTime().After(limit) {
+			continue
+		}
+		if s.active.contains(path) {
+			log.Debugf("Fixture '%s' is old but still in use", path)
+			continue
+		}
+		err = os.Remove(path)
+		if err != nil {
+			log.Errorf("Can't remove unused fixture '%s': %v", path, err)
+			continue
+		}
+		log.Infof(
+			"Removed fixture '%s' because it wasn't used for more than %s",
+			path, s.ttl,
+		)
+	}
+}
+
+// Make sure that the handler implements the HTTP handler interface:
+var _ http.Handler = &putFixtureHandler{}
+
+// putFixtureHandler is the handler that receives a PUT containing the content of a fixture and
+// stores it.
+type putFixtureHandler struct {
+	fixtures *fixtureStore
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *putFixtureHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Check the name of the fixture:
+	name := mux.Vars(r)["name"]
+	err := checkFixtureName(name)
+	if err != nil {
+		sendError(w, r, http.StatusBadRequest, "Fixture name '%s' isn't valid", name)
+		return
+	}
+
+	// Store the fixture:
+	tenant := tokenFingerprint(requestToken(r))
+	hash := sha256.New()
+	size, err := h.fixtures.put(tenant, name, r.Body, hash)
+	if err != nil {
+		log.Errorf("Can't store fixture '%s' for tenant '%s': %v", name, tenant, err)
+		sendError(w, r, http.StatusInternalServerError, "Can't store fixture '%s'", name)
+		return
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	log.Infof(
+		"Stored fixture '%s' for tenant '%s' with size %d and checksum '%s'",
+		name, tenant, size, sum,
+	)
+
+	// Send the response:
+	body := &api.Fixture{
+		Name:     name,
+		Size:     size,
+		Checksum: sum,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(body)
+	if err != nil {
+		log.Errorf("Can't send response body for fixture '%s'", name)
+		return
+	}
+}
+
+// checkFixtureName checks that the given fixture name is valid.
+func checkFixtureName(name string) error {
+	if !fixtureNameRE.MatchString(name) {
+		return fmt.Errorf("fixture name '%s' isn't valid", name)
+	}
+	return nil
+}
+
+// fixtureNameRE is the regular expression used to check fixture names. Names can't contain
+// slashes and can't start with a dot, so they can't be used to access files outside of the
+// fixtures directory.
+var fixtureNameRE = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// Name of the sub-directory of each tenant directory that contains the fixtures:
+const fixturesDir = ".fixtures"
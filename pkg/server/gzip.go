@@ -0,0 +1,144 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the middleware that compresses responses.
+
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Make sure that the handler implements the HTTP handler interface:
+var _ http.Handler = &gzipHandler{}
+
+// gzipHandler is the handler that compresses the responses when the client indicates that it
+// accepts the gzip encoding.
+type gzipHandler struct {
+	next http.Handler
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *gzipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if !acceptsGzip(r) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	gw := &gzipWriter{
+		next:   w,
+		status: http.StatusOK,
+	}
+	h.next.ServeHTTP(gw, r)
+	err := gw.close()
+	if err != nil {
+		log.Errorf("Can't finish compressed response for request '%s': %v", r.URL.Path, err)
+	}
+}
+
+// acceptsGzip checks if the Accept-Encoding header of the request contains the gzip encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, header := range r.Header["Accept-Encoding"] {
+		for _, item := range strings.Split(header, ",") {
+			coding := strings.TrimSpace(item)
+			index := strings.Index(coding, ";")
+			if index >= 0 {
+				if strings.TrimSpace(coding[index+1:]) == "q=0" {
+					continue
+				}
+				coding = strings.TrimSpace(coding[0:index])
+			}
+			if strings.EqualFold(coding, "gzip") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gzipWriter is a response writer that compresses the data written. Note that sending the status
+// code is delayed till the first write, so that responses without a body are sent without the
+// Content-Encoding header.
+type gzipWriter struct {
+	next   http.ResponseWriter
+	status int
+	header bool
+	gz     *gzip.Writer
+}
+
+// Make sure that the writer implements the response writer interface:
+var _ http.ResponseWriter = &gzipWriter{}
+
+// Header is the implementation of the response writer interface.
+func (w *gzipWriter) Header() http.Header {
+	return w.next.Header()
+}
+
+// WriteHeader is the implementation of the response writer interface.
+func (w *gzipWriter) WriteHeader(status int) {
+	if w.header {
+		return
+	}
+	w.status = status
+	w.header = true
+}
+
+// Write is the implementation of the response writer interface.
+func (w *gzipWriter) Write(data []byte) (count int, err error) {
+	if w.gz == nil {
+		header := w.next.Header()
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+		w.header = true
+		w.next.WriteHeader(w.status)
+		w.gz = gzip.NewWriter(w.next)
+	}
+	return w.gz.Write(data)
+}
+
+// Flush sends any buffered data to the client, if the underlying writer supports it.
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		err := w.gz.Flush()
+		if err != nil {
+			log.Errorf("Can't flush compressed response: %v", err)
+		}
+	}
+	flusher, ok := w.next.(http.Flusher)
+	if ok {
+		flusher.Flush()
+	}
+}
+
+// close writes the remaining compressed data, or the status code if nothing was written.
+func (w *gzipWriter) close() error {
+	if w.gz == nil {
+		w.next.WriteHeader(w.status)
+		return nil
+	}
+	return w.gz.Close()
+}
+
+// gzipMiddleware receives a handler and wraps it with another that compresses the responses.
+func gzipMiddleware(handler http.Handler) http.Handler {
+	return &gzipHandler{
+		next: handler,
+	}
+}
@@ -19,13 +19,22 @@ limitations under the License.
 package server
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/google/uuid"
 	log "github.com/sirupsen/logrus"
@@ -55,7 +64,47 @@ func (h *notFoundHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 // postTestHandler is the handler that receives a POST containing a task description, runs it and
 // returns the results.
 type postTestHandler struct {
-	work string
+	work          string
+	audit         *auditLog
+	keepOnFailure bool
+	compressKept  bool
+	active        *activeSet
+	envAllow      []string
+	envDeny       []string
+	fetcher       *fetcher
+	fixtures      *fixtureStore
+	uploads       *uploadStore
+	runAs         *uidRange
+	teeOutput     bool
+	results       *resultCache
+	maxOutput     int64
+	maxArtifacts  int64
+	budget        *memoryBudget
+	cleanEnv      bool
+	test2json     string
+}
+
+// testRun contains the details needed to run a test binary, either a test sent alone or a step of
+// a plan.
+type testRun struct {
+	// Identifiers of the tenant and of the test, the later only used in log messages:
+	tenant string
+	id     string
+
+	// Directory where the binary runs. It is also the home directory of the test, and contains
+	// the temporary directory. For the steps of a plan this directory is shared by all the
+	// steps.
+	dir string
+
+	// Directory where the binary, the output and errors files, the environment file and the
+	// fixtures of this execution are created. For a test sent alone this is the same than the
+	// previous directory.
+	files string
+
+	// Request sent by the client, and the results of parsing its timeout and environment file:
+	request *api.Test
+	timeout time.Duration
+	env     []envVar
 }
 
 // ServeHTTP is the implementation of the HTTP handler interface.
@@ -70,6 +119,13 @@ func (h *postTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Check the request:
+	run, err := h.prepare(requestBody)
+	if err != nil {
+		sendRequestError(w, r, err)
+		return
+	}
+
 	// Calculate an identifier for the test:
 	testUUID, err := uuid.NewRandom()
 	if err != nil {
@@ -80,26 +136,290 @@ func (h *postTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	testID := testUUID.String()
 	log.Infof("Assigned test identifier '%s'", testID)
 
+	// Create the directory of the tenant:
+	tenantID, tenantDir, err := h.ensureTenant(r)
+	if err != nil {
+		sendRequestError(w, r, err)
+		return
+	}
+
+	// If the client sent an idempotency key and we already ran a test with that key then
+	// return the saved result instead of running the binary again:
+	testKey := requestBody.Key
+	if testKey != "" && h.results != nil {
+		testResult, testBusy := h.results.begin(tenantID, testKey)
+		if testBusy {
+			log.Infof("Rejected request with key '%s' because it is still running", testKey)
+			sendError(
+				w, r,
+				http.StatusConflict,
+				"Test with key '%s' is still running",
+				testKey,
+			)
+			return
+		}
+		if testResult != nil {
+			log.Infof("Returning saved result for test with key '%s'", testKey)
+			h.sendResult(w, testResult)
+			return
+		}
+		defer h.results.abort(tenantID, testKey)
+	}
+
+	// Run the test:
+	run.tenant = tenantID
+	run.id = testID
+	responseBody, err := h.runAlone(r.Context(), run, tenantDir)
+	if err != nil {
+		sendRequestError(w, r, err)
+		return
+	}
+
+	// Send the response:
+	if testKey != "" && h.results != nil {
+		h.results.finish(tenantID, testKey, responseBody)
+	}
+	err = h.sendResult(w, responseBody)
+	if err != nil {
+		log.Errorf("Can't send response body for test '%s'", testID)
+		return
+	}
+}
+
+// runAlone runs a test that isn't part of a plan. It creates the directory of the test inside the
+// given tenant directory, runs the binary there, and then removes the directory if the test
+// succeeded, or reports where it has been preserved if it failed. The tenant and the identifier of
+// the test must already be set in the run object.
+func (h *postTestHandler) runAlone(ctx context.Context, run *testRun,
+	tenantDir string) (response *api.Test, err error) {
 	// Create the test directory:
-	testDir := filepath.Join(h.work, testID)
+	testID := run.id
+	testDir := filepath.Join(tenantDir, testID)
 	err = os.Mkdir(testDir, 0700)
 	if err != nil {
 		log.Errorf("Can't create directory for test '%s': %v", testID, err)
-		sendError(w, r, http.StatusInternalServerError, "Can't generate test directory")
+		err = newRequestError(http.StatusInternalServerError, "Can't generate test directory")
 		return
 	}
-	log.Infof("Created test directory '%s' for test '%d'", testDir, testID)
+	log.Infof("Created test directory '%s' for test '%s'", testDir, testID)
 
-	// Write the binary to the test directory:
-	testBinary := filepath.Join(testDir, "binary")
-	err = ioutil.WriteFile(testBinary, requestBody.Binary, 0700)
+	// Make sure that the sweeper doesn't remove the test directory while the test is running:
+	h.active.add(testDir)
+	defer h.active.remove(testDir)
+
+	// Run the test. If it can't run there is no response that could report where the test
+	// directory has been preserved, so handle it as the directory of a test that passed:
+	run.dir = testDir
+	run.files = testDir
+	response, err = h.run(ctx, run)
+	if err != nil {
+		h.cleanup(testID, testDir, true)
+		return
+	}
+
+	// Remove the test directory if the test succeeded, or report where it has been preserved
+	// if it failed:
+	response.Dir = h.cleanup(testID, testDir, response.Code == 0)
+
+	return
+}
+
+// prepare checks that the given test request is valid, and returns the object that will be used
+// to run it, with the timeout and the environment file already parsed. If the request isn't valid
+// it returns a request error.
+func (h *postTestHandler) prepare(request *api.Test) (run *testRun, err error) {
+	// Check that the requested user is allowed:
+	if request.RunAsUser != nil && !h.runAs.contains(*request.RunAsUser) {
+		log.Infof("Rejected request to run test as user %d", *request.RunAsUser)
+		err = newRequestError(
+			http.StatusBadRequest,
+			"Running tests as user %d isn't allowed",
+			*request.RunAsUser,
+		)
+		return
+	}
+
+	// Check that we can generate the JSON events, if requested:
+	if request.JSONEvents && h.test2json == "" {
+		log.Infof("Rejected request for JSON events because test2json isn't available")
+		err = newRequestError(
+			http.StatusBadRequest,
+			"JSON events aren't supported because test2json isn't available",
+		)
+		return
+	}
+
+	// Check the identifier of the upload that contains the binary, if any:
+	if request.UploadID != "" && !fixtureNameRE.MatchString(request.UploadID) {
+		log.Infof("Rejected request with invalid upload identifier '%s'", request.UploadID)
+		err = newRequestError(
+			http.StatusBadRequest,
+			"Upload identifier '%s' isn't valid",
+			request.UploadID,
+		)
+		return
+	}
+
+	// Check that the output globs can't match files outside of the test directory:
+	for _, glob := range request.OutputGlobs {
+		err = checkOutputGlob(glob)
+		if err != nil {
+			log.Infof("Rejected request with invalid output glob: %v", err)
+			err = newRequestError(
+				http.StatusBadRequest,
+				"Output glob '%s' isn't valid",
+				glob,
+			)
+			return
+		}
+	}
+
+	// Parse the timeout:
+	var timeout time.Duration
+	if request.Timeout != "" {
+		timeout, err = time.ParseDuration(request.Timeout)
+		if err != nil || timeout <= 0 {
+			log.Infof("Rejected request with invalid timeout '%s'", request.Timeout)
+			err = newRequestError(
+				http.StatusBadRequest,
+				"Timeout '%s' isn't a valid positive duration",
+				request.Timeout,
+			)
+			return
+		}
+	}
+
+	// Parse the environment file:
+	env, err := parseEnvFile(request.EnvFile)
+	if err != nil {
+		log.Infof("Rejected request with invalid environment file: %v", err)
+		err = newRequestError(
+			http.StatusBadRequest,
+			"Environment file isn't valid: %v",
+			err,
+		)
+		return
+	}
+
+	// Create and populate the object:
+	run = &testRun{
+		request: request,
+		timeout: timeout,
+		env:     env,
+	}
+
+	return
+}
+
+// ensureTenant creates the directory of the tenant that sent the given request, if it doesn't
+// exist yet. The name of this directory is calculated from the token, so that tests submitted
+// with different tokens are isolated from each other. Other users can traverse it, so that tests
+// that run as a different user can access their own directory.
+func (h *postTestHandler) ensureTenant(r *http.Request) (tenantID, tenantDir string, err error) {
+	tenantID = tokenFingerprint(requestToken(r))
+	tenantDir = filepath.Join(h.work, tenantID)
+	err = os.MkdirAll(tenantDir, 0711)
+	if err == nil {
+		err = markTenant(tenantDir)
+	}
+	if err != nil {
+		log.Errorf("Can't create directory for tenant '%s': %v", tenantID, err)
+		err = newRequestError(http.StatusInternalServerError, "Can't generate tenant directory")
+		return
+	}
+	return
+}
+
+// run runs a test binary and returns the results. The directories of the test must already
+// exist. If something fails it returns a request error containing the status code and the reason
+// that should be sent to the client.
+func (h *postTestHandler) run(ctx context.Context, run *testRun) (response *api.Test, err error) {
+	testID := run.id
+	testDir := run.dir
+	requestBody := run.request
+
+	// Get the binary, either from an upload, from the request body or downloading it from the
+	// URL given by the client:
+	var testSource io.Reader
+	if requestBody.UploadID != "" {
+		uploadPath, err := h.uploads.acquire(run.tenant, requestBody.UploadID)
+		if err == errUploadNotFound || err == errUploadIncomplete {
+			log.Errorf(
+				"Upload '%s' for test '%s' doesn't exist or isn't complete",
+				requestBody.UploadID, testID,
+			)
+			return nil, newRequestError(
+				http.StatusBadRequest,
+				"Upload '%s' doesn't exist or isn't complete",
+				requestBody.UploadID,
+			)
+		}
+		if err != nil {
+			log.Errorf(
+				"Can't use upload '%s' for test '%s': %v",
+				requestBody.UploadID, testID, err,
+			)
+			return nil, newRequestError(
+				http.StatusInternalServerError,
+				"Can't use upload '%s'",
+				requestBody.UploadID,
+			)
+		}
+		defer h.uploads.release(uploadPath)
+		uploadFile, err := os.Open(uploadPath)
+		if err != nil {
+			log.Errorf(
+				"Can't open upload file '%s' for test '%s': %v",
+				uploadPath, testID, err,
+			)
+			return nil, newRequestError(
+				http.StatusInternalServerError,
+				"Can't use upload '%s'",
+				requestBody.UploadID,
+			)
+		}
+		defer uploadFile.Close()
+		testSource = uploadFile
+	} else if requestBody.BinaryURL != "" {
+		testBody, err := h.fetcher.fetch(requestBody.BinaryURL)
+		if err == errFetchNotAllowed {
+			log.Errorf(
+				"Binary URL '%s' for test '%s' isn't allowed",
+				requestBody.BinaryURL, testID,
+			)
+			return nil, newRequestError(
+				http.StatusForbidden,
+				"Binary URL '%s' isn't allowed",
+				requestBody.BinaryURL,
+			)
+		}
+		if err != nil {
+			log.Errorf(
+				"Can't fetch binary from '%s' for test '%s': %v",
+				requestBody.BinaryURL, testID, err,
+			)
+			return nil, newRequestError(
+				http.StatusBadGateway,
+				"Can't fetch binary from '%s'",
+				requestBody.BinaryURL,
+			)
+		}
+		defer testBody.Close()
+		testSource = testBody
+	} else {
+		testSource = bytes.NewReader(requestBody.Binary)
+	}
+
+	// Write the binary to the test directory, calculating the SHA-256 at the same time:
+	testBinary := filepath.Join(run.files, "binary")
+	testHash := sha256.New()
+	testSize, err := h.writeBinary(testBinary, testSource, testHash)
 	if err != nil {
 		log.Errorf(
-			"Can't create binary file '%s' for test '%s'",
-			testBinary, testID,
+			"Can't create binary file '%s' for test '%s': %v",
+			testBinary, testID, err,
 		)
-		sendError(
-			w, r,
+		err = newRequestError(
 			http.StatusInternalServerError,
 			"Can't create test binary file",
 		)
@@ -107,12 +427,28 @@ func (h *postTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Infof("Created binary file '%s' for test '%s'", testBinary, testID)
 
+	// Check that the binary written to disk matches the checksum sent by the client:
+	testSum := hex.EncodeToString(testHash.Sum(nil))
+	if requestBody.Checksum != "" && !strings.EqualFold(requestBody.Checksum, testSum) {
+		log.Errorf(
+			"Checksum of binary for test '%s' is '%s' but client sent '%s'",
+			testID, testSum, requestBody.Checksum,
+		)
+		err = newRequestError(
+			http.StatusBadRequest,
+			"Checksum of test binary is '%s' but expected '%s', the binary may "+
+				"have been truncated or corrupted",
+			testSum, requestBody.Checksum,
+		)
+		return
+	}
+
 	// Create the standard output file:
-	testOutPath := filepath.Join(testDir, "stdout")
+	testOutPath := filepath.Join(run.files, "stdout")
 	testOutFile, err := os.OpenFile(testOutPath, os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		log.Errorf("Can't create out file '%s' for test '%s': %v", testOutPath, testID, err)
-		sendError(w, r, http.StatusInternalServerError, "Can't create output file")
+		err = newRequestError(http.StatusInternalServerError, "Can't create output file")
 		return
 	}
 	closeOutFile := func() {
@@ -128,14 +464,14 @@ func (h *postTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	log.Infof("Created output file '%s' for test '%s'", testOutPath, testID)
 
 	// Create the standard error file:
-	testErrPath := filepath.Join(testDir, "stderr")
+	testErrPath := filepath.Join(run.files, "stderr")
 	testErrFile, err := os.OpenFile(testErrPath, os.O_WRONLY|os.O_CREATE, 0600)
 	if err != nil {
 		log.Errorf(
-			"Can't create errors file '%s' for test '%s': v",
+			"Can't create errors file '%s' for test '%s': %v",
 			testErrPath, testID, err,
 		)
-		sendError(w, r, http.StatusInternalServerError, "Can't open standard error file")
+		err = newRequestError(http.StatusInternalServerError, "Can't open standard error file")
 		return
 	}
 	closeErrFile := func() {
@@ -150,33 +486,319 @@ func (h *postTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	defer closeErrFile()
 	log.Infof("Created errors file '%s' for test '%s'", testErrPath, testID)
 
-	// Prepare the environment variables for the test:
-	testEnv := os.Environ()
-	for name, value := range requestBody.Env {
-		h.addEnv(&testEnv, name, value)
+	// Create the temporary directory for the test, so that temporary files created by the test
+	// are also inside the working directory. Note that it may already exist if it was created
+	// by a previous step of the same plan.
+	testTmp := filepath.Join(testDir, "tmp")
+	err = os.Mkdir(testTmp, 0700)
+	if err != nil && !os.IsExist(err) {
+		log.Errorf(
+			"Can't create temporary directory '%s' for test '%s': %v",
+			testTmp, testID, err,
+		)
+		err = newRequestError(http.StatusInternalServerError, "Can't create temporary directory")
+		return
+	}
+
+	// Make the fixtures available to the test, creating links in the fixtures directory of the
+	// test:
+	testFixtures := filepath.Join(run.files, "fixtures")
+	if len(requestBody.Fixtures) > 0 {
+		err = os.Mkdir(testFixtures, 0700)
+		if err != nil {
+			log.Errorf(
+				"Can't create fixtures directory '%s' for test '%s': %v",
+				testFixtures, testID, err,
+			)
+			err = newRequestError(
+				http.StatusInternalServerError,
+				"Can't create fixtures directory",
+			)
+			return
+		}
+	}
+	for _, fixtureName := range requestBody.Fixtures {
+		err = checkFixtureName(fixtureName)
+		if err != nil {
+			err = newRequestError(
+				http.StatusBadRequest,
+				"Fixture name '%s' isn't valid",
+				fixtureName,
+			)
+			return
+		}
+		fixturePath, err := h.fixtures.acquire(run.tenant, fixtureName)
+		if os.IsNotExist(err) {
+			return nil, newRequestError(
+				http.StatusBadRequest,
+				"Fixture '%s' doesn't exist",
+				fixtureName,
+			)
+		}
+		if err != nil {
+			log.Errorf(
+				"Can't acquire fixture '%s' for test '%s': %v",
+				fixtureName, testID, err,
+			)
+			return nil, newRequestError(
+				http.StatusInternalServerError,
+				"Can't acquire fixture '%s'",
+				fixtureName,
+			)
+		}
+		defer h.fixtures.release(fixturePath)
+		err = os.Symlink(fixturePath, filepath.Join(testFixtures, fixtureName))
+		if err != nil {
+			log.Errorf(
+				"Can't link fixture '%s' for test '%s': %v",
+				fixtureName, testID, err,
+			)
+			return nil, newRequestError(
+				http.StatusInternalServerError,
+				"Can't link fixture '%s'",
+				fixtureName,
+			)
+		}
+	}
+
+	// Write the environment file:
+	testEnvFile := filepath.Join(run.files, ".env")
+	if requestBody.EnvFile != nil {
+		err = ioutil.WriteFile(testEnvFile, requestBody.EnvFile, 0600)
+		if err != nil {
+			log.Errorf(
+				"Can't write environment file '%s' for test '%s': %v",
+				testEnvFile, testID, err,
+			)
+			err = newRequestError(
+				http.StatusInternalServerError,
+				"Can't write environment file",
+			)
+			return
+		}
+	}
+
+	// Prepare the environment variables for the test, starting with the environment of the
+	// server but removing the variables that the test shouldn't see, then the variables from
+	// the environment file and finally the variables explicitly sent in the request. If the
+	// clean environment is enabled we start with the minimal environment instead, and only add
+	// the variables of the server that have been explicitly allowed.
+	var testEnv []string
+	if h.cleanEnv {
+		testEnv = baseEnv(testDir)
+		if len(h.envAllow) > 0 {
+			testEnv = append(testEnv, filterEnv(os.Environ(), h.envAllow, h.envDeny)...)
+		}
+	} else {
+		testEnv = filterEnv(os.Environ(), h.envAllow, h.envDeny)
+	}
+	h.addEnv(&testEnv, "TMPDIR", testTmp)
+	if len(requestBody.Fixtures) > 0 {
+		h.addEnv(&testEnv, "SANDBOX_FIXTURES", testFixtures)
+	}
+	if requestBody.EnvFile != nil {
+		h.addEnv(&testEnv, "SANDBOX_ENV_FILE", testEnvFile)
+		for _, v := range run.env {
+			h.addEnv(&testEnv, v.name, v.value)
+		}
+	}
+	requestNames := make([]string, 0, len(requestBody.Env))
+	for name := range requestBody.Env {
+		requestNames = append(requestNames, name)
+	}
+	sort.Strings(requestNames)
+	for _, name := range requestNames {
+		h.addEnv(&testEnv, name, requestBody.Env[name])
+	}
+
+	// Remove the duplicated variables, so that the value that the test sees doesn't depend on
+	// how the operating system handles them:
+	testEnv = dedupEnv(testEnv)
+
+	// Wait till the memory budget allows running the binary. The budget is released when the
+	// request finishes, as till then the output of the binary is also using memory.
+	if h.budget != nil {
+		err = h.budget.acquire(ctx, testSize)
+		if err != nil {
+			log.Infof(
+				"Request for test '%s' was cancelled while waiting for memory budget: %v",
+				testID, err,
+			)
+			err = newRequestError(
+				http.StatusServiceUnavailable,
+				"Request was cancelled while waiting for memory budget",
+			)
+			return
+		}
+		defer h.budget.release(testSize)
+	}
+
+	// Pass the deadline to the test binary, if requested. It is calculated now, after waiting
+	// for the memory budget, so that it is close to the start of the timer that enforces it.
+	// The flag goes before the arguments of the request, so that an explicit '-test.timeout'
+	// sent by the client takes precedence.
+	testArgs := requestBody.Args
+	if requestBody.Deadline && run.timeout > 0 {
+		testDeadline := time.Now().Add(run.timeout).UTC().Format(time.RFC3339)
+		testArgs = append([]string{"-test.timeout=" + run.timeout.String()}, testArgs...)
+		h.addEnv(&testEnv, "TEST_DEADLINE", testDeadline)
+		testEnv = dedupEnv(testEnv)
+		log.Infof("Deadline of test '%s' is %s", testID, testDeadline)
+	}
+
+	// The JSON events are generated from the verbose output, so request it:
+	if requestBody.JSONEvents {
+		testArgs = append([]string{"-test.v"}, testArgs...)
 	}
 
 	// Run the binary:
 	testCommand := exec.Command(
 		testBinary,
-		requestBody.Args...,
+		testArgs...,
 	)
 	testCommand.Env = testEnv
-	testCommand.Stdout = testOutFile
-	testCommand.Stderr = testErrFile
-	err = testCommand.Run()
+
+	// Run the binary inside the test directory, otherwise it would inherit the current
+	// directory of the server, and relative paths used by the test would point there:
+	testCommand.Dir = testDir
+
+	// Change the owner of the test directory and the identity of the process, if requested:
+	if requestBody.RunAsUser != nil {
+		testUser := *requestBody.RunAsUser
+		err = chownTree(testDir, testUser, testUser)
+		if err != nil {
+			log.Errorf(
+				"Can't change owner of directory '%s' for test '%s' to user %d: %v",
+				testDir, testID, testUser, err,
+			)
+			err = newRequestError(
+				http.StatusInternalServerError,
+				"Can't change owner of test directory to user %d",
+				testUser,
+			)
+			return
+		}
+		testCommand.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid: uint32(testUser),
+				Gid: uint32(testUser),
+			},
+		}
+		log.Infof("Test binary for test '%s' will run as user %d", testID, testUser)
+	}
+	var testStdout io.Writer = testOutFile
+	var testStderr io.Writer = testErrFile
+	var testOutTee, testErrTee *teeWriter
+	if h.teeOutput && log.IsLevelEnabled(log.DebugLevel) {
+		testOutTee = newTeeWriter(testID, "stdout")
+		testErrTee = newTeeWriter(testID, "stderr")
+		testStdout = io.MultiWriter(testStdout, testOutTee)
+		testStderr = io.MultiWriter(testStderr, testErrTee)
+	}
+	var testOutLimit, testErrLimit *limitWriter
+	if h.maxOutput > 0 {
+		testOutLimit = newLimitWriter(testStdout, h.maxOutput)
+		testErrLimit = newLimitWriter(testStderr, h.maxOutput)
+		testStdout = testOutLimit
+		testStderr = testErrLimit
+	}
+	testCommand.Stdout = testStdout
+	testCommand.Stderr = testStderr
+
+	// Start the binary in its own process group, so that when the timeout expires we can kill
+	// it together with all the processes that it started. Otherwise those processes could keep
+	// running after the test finished.
+	if testCommand.SysProcAttr == nil {
+		testCommand.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	testCommand.SysProcAttr.Setpgid = true
+	err = testCommand.Start()
+	if err != nil {
+		log.Errorf("Can't execute test binary for test '%s': %v", testID, err)
+		err = newRequestError(http.StatusInternalServerError, "Can't execute test binary")
+		return
+	}
+
+	// Wait till the binary finishes or the timeout expires. Note that when the timeout expires
+	// we don't return immediately: the output that the binary generated till then is in the
+	// output and errors files, and we want to return it to the client, as it is the only way
+	// to find out where the test was stuck.
+	testDone := make(chan error, 1)
+	go func() {
+		testDone <- testCommand.Wait()
+	}()
+	var testTimer <-chan time.Time
+	if run.timeout > 0 {
+		timer := time.NewTimer(run.timeout)
+		defer timer.Stop()
+		testTimer = timer.C
+	}
+	testTimedOut := false
+	select {
+	case err = <-testDone:
+	case <-testTimer:
+		log.Infof(
+			"Test binary for test '%s' didn't finish after %s, will kill it",
+			testID, run.timeout,
+		)
+		testTimedOut = true
+		err = syscall.Kill(-testCommand.Process.Pid, syscall.SIGKILL)
+		if err != nil {
+			log.Errorf("Can't kill test binary for test '%s': %v", testID, err)
+		}
+		err = <-testDone
+	}
+	if testOutTee != nil {
+		testOutTee.Flush()
+		testErrTee.Flush()
+	}
 	testCode := 0
+	testSignal := ""
 	if err != nil {
 		testStatus, ok := err.(*exec.ExitError)
 		if ok {
 			testCode = testStatus.ExitCode()
+			testSignal = exitSignal(testStatus.ProcessState)
 		} else {
 			log.Errorf("Can't execute test binary for test '%s': %v", testID, err)
-			sendError(w, r, http.StatusInternalServerError, "Can't execute test binary")
+			err = newRequestError(http.StatusInternalServerError, "Can't execute test binary")
 			return
 		}
 	}
-	log.Infof("Test binary for test '%s' finished with exit code %d", testID, testCode)
+	if testSignal != "" {
+		log.Infof("Test binary for test '%s' was killed by signal %s", testID, testSignal)
+	} else {
+		log.Infof("Test binary for test '%s' finished with exit code %d", testID, testCode)
+	}
+
+	// If the output was truncated add a marker at the end, so that whoever reads it knows that
+	// it isn't complete:
+	testTruncated := false
+	if testOutLimit != nil && testOutLimit.Truncated() {
+		testTruncated = true
+		h.markTruncated(testID, testOutFile)
+	}
+	if testErrLimit != nil && testErrLimit.Truncated() {
+		testTruncated = true
+		h.markTruncated(testID, testErrFile)
+	}
+
+	// Write the audit record:
+	if h.audit != nil {
+		err = h.audit.Write(&auditRecord{
+			Time:   time.Now().UTC(),
+			Client: run.tenant,
+			Test:   testID,
+			Size:   testSize,
+			SHA256: testSum,
+			Args:   requestBody.Args,
+			Code:   testCode,
+			Signal: testSignal,
+		})
+		if err != nil {
+			log.Errorf("Can't write audit record for test '%s': %v", testID, err)
+		}
+	}
 
 	// Read the standard output file:
 	testOut, err := ioutil.ReadFile(testOutPath)
@@ -185,7 +807,7 @@ func (h *postTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"Can't read output file '%s' for test '%s': %v",
 			testOutPath, testID, err,
 		)
-		sendError(w, r, http.StatusInternalServerError, "Can't read output file")
+		err = newRequestError(http.StatusInternalServerError, "Can't read output file")
 		return
 	}
 
@@ -196,25 +818,126 @@ func (h *postTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			"Can't read errors file '%s' for test '%s': %v",
 			testErrPath, testID, err,
 		)
-		sendError(w, r, http.StatusInternalServerError, "Can't read errors file")
+		err = newRequestError(http.StatusInternalServerError, "Can't read errors file")
 		return
 	}
 
-	// Send the response:
-	responseBody := &api.Test{
-		Out:  testOut,
-		Err:  testErr,
-		Code: testCode,
+	// Convert the output to JSON events, if requested. Failing to do so isn't fatal, as the
+	// client still gets the output.
+	var testEvents []byte
+	if requestBody.JSONEvents {
+		testEvents, err = jsonEvents(h.test2json, requestBody.Package, testOut, testCode != 0)
+		if err != nil {
+			log.Errorf("Can't generate JSON events for test '%s': %v", testID, err)
+			testEvents = nil
+			err = nil
+		}
 	}
+
+	// Collect the files produced by the test binary, if requested:
+	var testArtifacts map[string][]byte
+	var testOmitted []string
+	if len(requestBody.OutputGlobs) > 0 {
+		testArtifacts, testOmitted = collectArtifacts(
+			testID, testDir, requestBody.OutputGlobs, h.maxArtifacts,
+		)
+	}
+
+	// Create and populate the response:
+	response = &api.Test{
+		Out:              testOut,
+		Err:              testErr,
+		Events:           testEvents,
+		Code:             testCode,
+		TimedOut:         testTimedOut,
+		Signal:           testSignal,
+		Truncated:        testTruncated,
+		Artifacts:        testArtifacts,
+		OmittedArtifacts: testOmitted,
+	}
+
+	return
+}
+
+// cleanup removes the given test directory if the test succeeded, when the server is configured
+// to keep the directories of failed tests. It returns the directory if it has been preserved, or
+// an empty string if it hasn't. Directories that aren't removed are compressed if the server is
+// configured to do so.
+func (h *postTestHandler) cleanup(testID, testDir string, succeeded bool) string {
+	if !h.keepOnFailure {
+		h.compress(testID, testDir)
+		return ""
+	}
+	if !succeeded {
+		log.Infof("Preserved directory '%s' for failed test '%s'", testDir, testID)
+		h.compress(testID, testDir)
+		return testDir
+	}
+	err := os.RemoveAll(testDir)
+	if err != nil {
+		log.Errorf(
+			"Can't remove directory '%s' for test '%s': %v",
+			testDir, testID, err,
+		)
+	} else {
+		log.Infof("Removed directory '%s' for test '%s'", testDir, testID)
+	}
+	return ""
+}
+
+// compress compresses the binary and the output files of the given test directory, if the server
+// is configured to do so. Errors are logged but not returned, as the directory is still usable.
+func (h *postTestHandler) compress(testID, testDir string) {
+	if !h.compressKept {
+		return
+	}
+	err := compressDir(testDir)
+	if err != nil {
+		log.Errorf(
+			"Can't compress files of directory '%s' for test '%s': %v",
+			testDir, testID, err,
+		)
+		return
+	}
+	log.Infof("Compressed files of directory '%s' for test '%s'", testDir, testID)
+}
+
+// markTruncated adds to the given output file the marker that indicates that it was truncated.
+func (h *postTestHandler) markTruncated(testID string, file *os.File) {
+	log.Infof("Output file '%s' for test '%s' was truncated", file.Name(), testID)
+	_, err := file.WriteString(truncatedMarker)
+	if err != nil {
+		log.Errorf(
+			"Can't add truncation marker to file '%s' for test '%s': %v",
+			file.Name(), testID, err,
+		)
+	}
+}
+
+// sendResult sends to the client the given test result.
+func (h *postTestHandler) sendResult(w http.ResponseWriter, result *api.Test) error {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	responseEncoder := json.NewEncoder(w)
-	responseEncoder.SetIndent("", "  ")
-	err = responseEncoder.Encode(responseBody)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+// writeBinary writes the test binary to the given file, and also to the given hash. It returns
+// the number of bytes written.
+func (h *postTestHandler) writeBinary(path string, data io.Reader, hash io.Writer) (size int64,
+	err error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0700)
 	if err != nil {
-		log.Errorf("Can't send response body for test '%s'", testID)
 		return
 	}
+	size, err = io.Copy(io.MultiWriter(file, hash), data)
+	if err != nil {
+		file.Close()
+		return
+	}
+	err = file.Close()
+	return
 }
 
 func (h *postTestHandler) addEnv(env *[]string, name, value string) {
@@ -0,0 +1,402 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ = Describe("Test handler", func() {
+	var work string
+	var handler *postTestHandler
+
+	BeforeEach(func() {
+		var err error
+		work, err = ioutil.TempDir("", "handlers")
+		Expect(err).ToNot(HaveOccurred())
+		handler = &postTestHandler{
+			work:   work,
+			active: newActiveSet(),
+		}
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(work)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// send sends the given test to the handler and returns the recorded response.
+	send := func(test *api.Test) *httptest.ResponseRecorder {
+		body, err := json.Marshal(test)
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/tests", bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+
+	It("Returns the partial output when the timeout expires", func() {
+		// Send a binary that writes some output and then sleeps for much longer than
+		// the timeout:
+		start := time.Now()
+		recorder := send(&api.Test{
+			Binary:  []byte("#!/bin/sh\necho first\necho second >&2\nsleep 60\n"),
+			Timeout: "1s",
+		})
+		Expect(time.Since(start)).To(BeNumerically("<", 30*time.Second))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+
+		// Check that the response contains what the binary wrote before it was killed:
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.TimedOut).To(BeTrue())
+		Expect(response.Code).ToNot(BeZero())
+		Expect(string(response.Out)).To(Equal("first\n"))
+		Expect(string(response.Err)).To(Equal("second\n"))
+		Expect(response.Signal).To(Equal("SIGKILL"))
+	})
+
+	It("Doesn't report timeout when the binary finishes in time", func() {
+		recorder := send(&api.Test{
+			Binary:  []byte("#!/bin/sh\necho first\n"),
+			Timeout: "1m",
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.TimedOut).To(BeFalse())
+		Expect(response.Code).To(BeZero())
+		Expect(response.Signal).To(BeEmpty())
+		Expect(string(response.Out)).To(Equal("first\n"))
+	})
+
+	It("Reports the signal that killed the binary", func() {
+		recorder := send(&api.Test{
+			Binary: []byte("#!/bin/sh\necho first\nkill -SEGV $$\n"),
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.TimedOut).To(BeFalse())
+		Expect(response.Code).To(Equal(-1))
+		Expect(response.Signal).To(Equal("SIGSEGV"))
+		Expect(string(response.Out)).To(Equal("first\n"))
+	})
+
+	It("Doesn't report signal when the binary exits with an error", func() {
+		recorder := send(&api.Test{
+			Binary: []byte("#!/bin/sh\nexit 3\n"),
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Code).To(Equal(3))
+		Expect(response.Signal).To(BeEmpty())
+	})
+
+	It("Passes the deadline to the binary when requested", func() {
+		start := time.Now().UTC().Truncate(time.Second)
+		recorder := send(&api.Test{
+			Binary:   []byte("#!/bin/sh\necho \"$TEST_DEADLINE\"\necho \"$@\"\n"),
+			Args:     []string{"-test.v"},
+			Timeout:  "1m",
+			Deadline: true,
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		lines := strings.Split(strings.TrimSpace(string(response.Out)), "\n")
+		Expect(lines).To(HaveLen(2))
+		deadline, err := time.Parse(time.RFC3339, lines[0])
+		Expect(err).ToNot(HaveOccurred())
+		Expect(deadline).To(BeTemporally(">=", start.Add(time.Minute)))
+		Expect(deadline).To(BeTemporally("<=", time.Now().Add(time.Minute)))
+		Expect(lines[1]).To(Equal("-test.timeout=1m0s -test.v"))
+	})
+
+	It("Doesn't pass the deadline when there is no timeout", func() {
+		recorder := send(&api.Test{
+			Binary:   []byte("#!/bin/sh\necho \"$TEST_DEADLINE\"\necho \"$@\"\n"),
+			Deadline: true,
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(response.Out)).To(Equal("\n\n"))
+	})
+
+	It("Generates JSON events when requested", func() {
+		// Find the test2json tool of the Go distribution used to run the tests:
+		tool, err := exec.Command("go", "tool", "-n", "test2json").Output()
+		if err != nil {
+			Skip("The test2json tool isn't available")
+		}
+		handler.test2json = strings.TrimSpace(string(tool))
+
+		// Send a binary that writes the verbose output of a passing test:
+		recorder := send(&api.Test{
+			Binary: []byte(
+				"#!/bin/sh\n" +
+					"echo '=== RUN   TestA'\n" +
+					"echo '--- PASS: TestA (0.00s)'\n" +
+					"echo PASS\n",
+			),
+			JSONEvents: true,
+			Package:    "mypkg",
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err = json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(response.Out)).To(ContainSubstring("PASS"))
+		Expect(string(response.Events)).To(ContainSubstring(
+			`"Action":"pass","Package":"mypkg","Test":"TestA"`,
+		))
+		Expect(hasResultEvent(response.Events)).To(BeTrue())
+	})
+
+	It("Adds the result event when the binary is killed", func() {
+		tool, err := exec.Command("go", "tool", "-n", "test2json").Output()
+		if err != nil {
+			Skip("The test2json tool isn't available")
+		}
+		handler.test2json = strings.TrimSpace(string(tool))
+		recorder := send(&api.Test{
+			Binary:     []byte("#!/bin/sh\necho '=== RUN   TestA'\nkill -9 $$\n"),
+			JSONEvents: true,
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err = json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Signal).To(Equal("SIGKILL"))
+		Expect(hasResultEvent(response.Events)).To(BeTrue())
+		Expect(string(response.Events)).To(ContainSubstring(`"Action":"fail"`))
+	})
+
+	It("Rejects JSON events when test2json isn't available", func() {
+		recorder := send(&api.Test{
+			Binary:     []byte("#!/bin/sh\n"),
+			JSONEvents: true,
+		})
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Doesn't run again a test with the same key", func() {
+		// Use a binary that appends to a file outside of the test directory, so that we can
+		// count how many times it runs:
+		handler.results = newResultCache(time.Hour, 10)
+		counter := filepath.Join(work, "counter")
+		binary := []byte(fmt.Sprintf("#!/bin/sh\necho x >> %s\necho first\n", counter))
+		for i := 0; i < 2; i++ {
+			recorder := send(&api.Test{
+				Key:    "mykey",
+				Binary: binary,
+			})
+			Expect(recorder.Code).To(Equal(http.StatusOK))
+			response := &api.Test{}
+			err := json.Unmarshal(recorder.Body.Bytes(), response)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(response.Out)).To(Equal("first\n"))
+		}
+		data, err := ioutil.ReadFile(counter)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("x\n"))
+	})
+
+	It("Truncates output larger than the limit", func() {
+		handler.maxOutput = 1000
+		recorder := send(&api.Test{
+			Binary: []byte("#!/bin/sh\nhead -c 5000 /dev/zero | tr '\\0' x\necho first >&2\n"),
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Truncated).To(BeTrue())
+		Expect(string(response.Out)).To(Equal(strings.Repeat("x", 1000) + truncatedMarker))
+		Expect(string(response.Err)).To(Equal("first\n"))
+	})
+
+	It("Doesn't truncate output smaller than the limit", func() {
+		handler.maxOutput = 1000
+		recorder := send(&api.Test{
+			Binary: []byte("#!/bin/sh\necho first\n"),
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Truncated).To(BeFalse())
+		Expect(string(response.Out)).To(Equal("first\n"))
+	})
+
+	It("Removes the directory if the test can't run", func() {
+		handler.keepOnFailure = true
+		handler.uploads = newUploadStore(work, time.Hour, handler.active)
+		recorder := send(&api.Test{
+			UploadID: "missing",
+		})
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(testDirs(work)).To(BeEmpty())
+	})
+
+	It("Runs the binary inside the test directory", func() {
+		// Use a binary that writes to a relative path and then fails, so that the test
+		// directory is preserved and we can check that the file is there:
+		handler.keepOnFailure = true
+		recorder := send(&api.Test{
+			Binary: []byte("#!/bin/sh\necho first > relative.txt\nexit 1\n"),
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.Dir).ToNot(BeEmpty())
+		data, err := ioutil.ReadFile(filepath.Join(response.Dir, "relative.txt"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("first\n"))
+	})
+
+	It("Compresses the files of preserved directories", func() {
+		handler.keepOnFailure = true
+		handler.compressKept = true
+		recorder := send(&api.Test{
+			Binary: []byte("#!/bin/sh\necho first > relative.txt\necho second\nexit 1\n"),
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err := json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(response.Out)).To(Equal("second\n"))
+		Expect(response.Dir).ToNot(BeEmpty())
+
+		// Check that the output file has been replaced by the compressed one:
+		_, err = os.Stat(filepath.Join(response.Dir, "stdout"))
+		Expect(os.IsNotExist(err)).To(BeTrue())
+		file, err := os.Open(filepath.Join(response.Dir, "stdout.gz"))
+		Expect(err).ToNot(HaveOccurred())
+		defer file.Close()
+		reader, err := gzip.NewReader(file)
+		Expect(err).ToNot(HaveOccurred())
+		data, err := ioutil.ReadAll(reader)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(data)).To(Equal("second\n"))
+		Expect(filepath.Join(response.Dir, "binary.gz")).To(BeARegularFile())
+
+		// Check that the files created by the test haven't been compressed:
+		Expect(filepath.Join(response.Dir, "relative.txt")).To(BeARegularFile())
+	})
+
+	It("Runs the binary with a clean environment", func() {
+		// Set a variable in the environment of the server, and check that the binary
+		// doesn't see it:
+		err := os.Setenv("SANDBOX_HANDLERS_TEST", "server")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Unsetenv("SANDBOX_HANDLERS_TEST")
+		handler.cleanEnv = true
+		recorder := send(&api.Test{
+			Binary: []byte("#!/bin/sh\nenv | sort\n"),
+			Env: map[string]string{
+				"MYVAR": "myvalue",
+			},
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err = json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		names := map[string]string{}
+		for _, line := range strings.Split(strings.TrimSpace(string(response.Out)), "\n") {
+			parts := strings.SplitN(line, "=", 2)
+			Expect(parts).To(HaveLen(2))
+			names[parts[0]] = parts[1]
+		}
+		Expect(names).To(HaveKeyWithValue("PATH", baseEnvPath))
+		Expect(names).To(HaveKey("HOME"))
+		Expect(names).To(HaveKey("TMPDIR"))
+		Expect(names["TMPDIR"]).To(HavePrefix(names["HOME"]))
+		Expect(names).To(HaveKeyWithValue("MYVAR", "myvalue"))
+		Expect(names).ToNot(HaveKey("SANDBOX_HANDLERS_TEST"))
+	})
+
+	It("Passes allowed variables of the server in the clean environment", func() {
+		err := os.Setenv("SANDBOX_HANDLERS_TEST", "server")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Unsetenv("SANDBOX_HANDLERS_TEST")
+		handler.cleanEnv = true
+		handler.envAllow = []string{"SANDBOX_HANDLERS_"}
+		recorder := send(&api.Test{
+			Binary: []byte("#!/bin/sh\necho $SANDBOX_HANDLERS_TEST\n"),
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err = json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(response.Out)).To(Equal("server\n"))
+	})
+
+	It("Passes only the last value of duplicated variables", func() {
+		err := os.Setenv("SANDBOX_HANDLERS_TEST", "server")
+		Expect(err).ToNot(HaveOccurred())
+		defer os.Unsetenv("SANDBOX_HANDLERS_TEST")
+		recorder := send(&api.Test{
+			Binary:  []byte("#!/bin/sh\nenv | grep ^SANDBOX_HANDLERS_ | sort\n"),
+			EnvFile: []byte("SANDBOX_HANDLERS_TEST=file\nSANDBOX_HANDLERS_OTHER=file\n"),
+			Env: map[string]string{
+				"SANDBOX_HANDLERS_TEST": "request",
+			},
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err = json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(response.Out)).To(Equal(
+			"SANDBOX_HANDLERS_OTHER=file\n" +
+				"SANDBOX_HANDLERS_TEST=request\n",
+		))
+	})
+
+	It("Rejects invalid timeouts", func() {
+		recorder := send(&api.Test{
+			Binary:  []byte("#!/bin/sh\n"),
+			Timeout: "junk",
+		})
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+})
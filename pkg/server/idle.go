@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the timer that detects when the server has been idle,
+// without receiving requests, for a configurable time.
+
+package server
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// neverIdle is the channel returned by the Idle method of servers that don't have an idle timeout.
+// It is never closed.
+var neverIdle = make(chan struct{})
+
+// idleTimer detects when the server hasn't received requests for a given time. The time is
+// counted from the moment that the last request in progress finished, so long running tests
+// never make the server idle.
+type idleTimer struct {
+	timeout time.Duration
+	lock    sync.Mutex
+	active  int
+	last    time.Time
+	timer   *time.Timer
+	expired chan struct{}
+	closed  bool
+}
+
+// newIdleTimer creates a timer that will consider the server idle when it doesn't receive
+// requests for the given time. The timer isn't started; to start it use the start method.
+func newIdleTimer(timeout time.Duration) *idleTimer {
+	return &idleTimer{
+		timeout: timeout,
+		expired: make(chan struct{}),
+	}
+}
+
+// start starts counting the idle time.
+func (t *idleTimer) start() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.last = time.Now()
+	t.timer = time.AfterFunc(t.timeout, t.check)
+}
+
+// halt stops the timer. The channel returned by the expired method will not be closed after this.
+func (t *idleTimer) halt() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// begin records that a request has started.
+func (t *idleTimer) begin() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.active++
+}
+
+// end records that a request has finished, and starts counting the idle time again if there are
+// no other requests in progress.
+func (t *idleTimer) end() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.active--
+	if t.active == 0 {
+		t.last = time.Now()
+		if t.timer != nil {
+			t.timer.Reset(t.timeout)
+		}
+	}
+}
+
+// check is called when the timer fires. Note that it may be called by a timer that was reset
+// after it fired, so it checks again if the server has really been idle for the complete time.
+func (t *idleTimer) check() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.timer == nil || t.active > 0 || t.closed {
+		return
+	}
+	remaining := t.timeout - time.Since(t.last)
+	if remaining > 0 {
+		t.timer.Reset(remaining)
+		return
+	}
+	log.Infof("Server hasn't received requests for %s", t.timeout)
+	t.closed = true
+	close(t.expired)
+}
+
+// Make sure that the handler implements the HTTP handler interface:
+var _ http.Handler = &idleHandler{}
+
+// idleHandler is the handler that records the requests in the idle timer.
+type idleHandler struct {
+	timer *idleTimer
+	next  http.Handler
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *idleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.timer.begin()
+	defer h.timer.end()
+	h.next.ServeHTTP(w, r)
+}
+
+// idleMiddleware receives a handler and wraps it with another that records the requests in the
+// given idle timer.
+func idleMiddleware(timer *idleTimer) mux.MiddlewareFunc {
+	return func(handler http.Handler) http.Handler {
+		return &idleHandler{
+			timer: timer,
+			next:  handler,
+		}
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the writer used to limit the size of the output of the tests.
+
+package server
+
+import (
+	"io"
+)
+
+// limitWriter is an io.Writer that writes to another writer till a limit of bytes is reached,
+// and then silently discards the rest. Note that it always reports that all the bytes were
+// written, because otherwise the test binary would get errors when writing to its standard
+// output or error, and that would change its behaviour.
+type limitWriter struct {
+	writer    io.Writer
+	remaining int64
+	discarded bool
+}
+
+// newLimitWriter creates a writer that writes at most the given number of bytes to the given
+// writer.
+func newLimitWriter(writer io.Writer, limit int64) *limitWriter {
+	return &limitWriter{
+		writer:    writer,
+		remaining: limit,
+	}
+}
+
+// Write is the implementation of the io.Writer interface.
+func (w *limitWriter) Write(data []byte) (n int, err error) {
+	n = len(data)
+	if int64(len(data)) > w.remaining {
+		data = data[:w.remaining]
+		w.discarded = true
+	}
+	if len(data) > 0 {
+		var written int
+		written, err = w.writer.Write(data)
+		w.remaining -= int64(written)
+		if err != nil {
+			n = written
+		}
+	}
+	return
+}
+
+// Truncated returns true if some of the data was discarded because the limit was reached.
+func (w *limitWriter) Truncated() bool {
+	return w.discarded
+}
+
+// Marker added at the end of the output of the tests when it has been truncated:
+const truncatedMarker = "\n[output truncated]\n"
@@ -0,0 +1,64 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the functions that check the address where the server listens.
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// listenNetwork checks that the given listen address has the 'host:port' form, with IPv6 literals
+// inside brackets, for example '[::1]:8000', and returns the network that should be used to
+// listen on it, so that the server binds the family that the address indicates: 'tcp4' for IPv4
+// literals, 'tcp6' for IPv6 literals and 'tcp' for host names. An empty host also uses 'tcp', so
+// that the server listens on all the addresses of both families where the system supports it,
+// and on the addresses of the only available family in IPv4 only or IPv6 only systems.
+func listenNetwork(address string) (network string, err error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		err = fmt.Errorf("listen address '%s' isn't valid: %v", address, err)
+		return
+	}
+	_, err = net.LookupPort("tcp", port)
+	if err != nil {
+		err = fmt.Errorf("port of listen address '%s' isn't valid: %v", address, err)
+		return
+	}
+	if strings.Contains(host, "%") {
+		// IPv6 literals with a zone, like 'fe80::1%eth0', can't be parsed by the ParseIP
+		// function, but they are IPv6 anyhow:
+		network = "tcp6"
+		return
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		network = "tcp"
+	case ip.To4() != nil:
+		network = "tcp4"
+	default:
+		network = "tcp6"
+	}
+	return
+}
+
+// Default listen address. The host is empty, so that the server listens on all the addresses of
+// both families, and works in IPv4 only, IPv6 only and dual stack clusters:
+const defaultListen = ":8000"
@@ -0,0 +1,194 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Listen address", func() {
+	DescribeTable(
+		"Selects the network of valid addresses",
+		func(address, expected string) {
+			network, err := listenNetwork(address)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(network).To(Equal(expected))
+		},
+		Entry("IPv4 wildcard", "0.0.0.0:8000", "tcp4"),
+		Entry("IPv4 loopback", "127.0.0.1:8000", "tcp4"),
+		Entry("IPv6 wildcard", "[::]:8000", "tcp6"),
+		Entry("IPv6 loopback", "[::1]:8000", "tcp6"),
+		Entry("IPv6 with zone", "[fe80::1%eth0]:8000", "tcp6"),
+		Entry("IPv4 mapped IPv6", "[::ffff:127.0.0.1]:8000", "tcp4"),
+		Entry("Host name", "localhost:8000", "tcp"),
+		Entry("Empty host", ":8000", "tcp"),
+		Entry("Service name", ":http", "tcp"),
+	)
+
+	DescribeTable(
+		"Rejects invalid addresses",
+		func(address string) {
+			_, err := listenNetwork(address)
+			Expect(err).To(HaveOccurred())
+		},
+		Entry("Without port", "127.0.0.1"),
+		Entry("IPv6 without brackets", "::1:8000"),
+		Entry("Port out of range", "127.0.0.1:70000"),
+		Entry("Unknown service", "127.0.0.1:junk"),
+		Entry("Empty", ""),
+	)
+})
+
+var _ = Describe("Server address", func() {
+	var work string
+
+	BeforeEach(func() {
+		var err error
+		work, err = ioutil.TempDir("", "server")
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(work)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// start creates and starts a server listening in the given address.
+	start := func(address string) *Server {
+		srvr, err := NewServer().
+			Listen(address).
+			Token("mytoken").
+			Work(work).
+			Build()
+		Expect(err).ToNot(HaveOccurred())
+		err = srvr.Start()
+		Expect(err).ToNot(HaveOccurred())
+		return srvr
+	}
+
+	// check sends a request to the given address and checks that the server responds.
+	check := func(address net.Addr) {
+		url := fmt.Sprintf("http://%s/api/v1/capabilities", address)
+		request, err := http.NewRequest(http.MethodGet, url, nil)
+		Expect(err).ToNot(HaveOccurred())
+		request.Header.Set("Authorization", "Bearer mytoken")
+		response, err := http.DefaultClient.Do(request)
+		Expect(err).ToNot(HaveOccurred())
+		defer response.Body.Close()
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+	}
+
+	It("Listens in an IPv4 address", func() {
+		srvr := start("127.0.0.1:0")
+		defer srvr.Destroy()
+		address, ok := srvr.Addr().(*net.TCPAddr)
+		Expect(ok).To(BeTrue())
+		Expect(address.IP.To4()).ToNot(BeNil())
+		check(address)
+	})
+
+	It("Listens in an IPv6 address", func() {
+		listener, err := net.Listen("tcp6", "[::1]:0")
+		if err != nil {
+			Skip("IPv6 isn't available")
+		}
+		listener.Close()
+		srvr := start("[::1]:0")
+		defer srvr.Destroy()
+		address, ok := srvr.Addr().(*net.TCPAddr)
+		Expect(ok).To(BeTrue())
+		Expect(address.IP.To4()).To(BeNil())
+		check(address)
+	})
+
+	It("Listens in a host name", func() {
+		srvr := start("localhost:0")
+		defer srvr.Destroy()
+		Expect(srvr.Addr()).ToNot(BeNil())
+		check(srvr.Addr())
+	})
+
+	It("Doesn't have an address before it is started", func() {
+		srvr, err := NewServer().
+			Listen("127.0.0.1:0").
+			Token("mytoken").
+			Work(work).
+			Build()
+		Expect(err).ToNot(HaveOccurred())
+		defer srvr.Destroy()
+		Expect(srvr.Addr()).To(BeNil())
+	})
+
+	It("Rejects an invalid address", func() {
+		_, err := NewServer().
+			Listen("::1:8000").
+			Token("mytoken").
+			Work(work).
+			Build()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("isn't valid"))
+	})
+
+	It("Reports that the address is in use when it is started", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		defer listener.Close()
+		srvr, err := NewServer().
+			Listen(listener.Addr().String()).
+			Token("mytoken").
+			Work(work).
+			Build()
+		Expect(err).ToNot(HaveOccurred())
+		defer srvr.Destroy()
+		err = srvr.Start()
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("can't listen"))
+	})
+
+	It("Doesn't start the background tasks if it can't listen", func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).ToNot(HaveOccurred())
+		srvr, err := NewServer().
+			Listen(listener.Addr().String()).
+			Token("mytoken").
+			Work(work).
+			IdleTimeout(50 * time.Millisecond).
+			Build()
+		Expect(err).ToNot(HaveOccurred())
+		defer srvr.Destroy()
+		err = srvr.Start()
+		Expect(err).To(HaveOccurred())
+		Expect(srvr.Addr()).To(BeNil())
+		Consistently(srvr.Idle(), 200*time.Millisecond).ShouldNot(BeClosed())
+
+		// Once the address is free it can be started again:
+		err = listener.Close()
+		Expect(err).ToNot(HaveOccurred())
+		err = srvr.Start()
+		Expect(err).ToNot(HaveOccurred())
+		check(srvr.Addr())
+	})
+})
@@ -0,0 +1,289 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the handler that runs binaries only once, for example
+// to perform an expensive initialization shared by several test runs against the same server.
+
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+// onceStore manages the markers of the binaries that have been executed with the once endpoint.
+// Markers are files in a hidden sub-directory of the directory of each tenant, so they survive
+// restarts of the server as long as the work directory is preserved, and keys used with
+// different tokens are isolated from each other. A marker contains the result of the execution,
+// so that it can be returned to the clients that send the same key later. Only successful
+// executions create markers: when the binary fails the next request with the same key will run
+// it again. Markers older than the TTL are ignored and replaced by the next execution.
+type onceStore struct {
+	work    string
+	ttl     time.Duration
+	lock    sync.Mutex
+	running map[resultKey]bool
+}
+
+// newOnceStore creates a store that keeps the markers inside the given work directory. If the TTL
+// is zero markers never expire.
+func newOnceStore(work string, ttl time.Duration) *onceStore {
+	return &onceStore{
+		work:    work,
+		ttl:     ttl,
+		running: map[resultKey]bool{},
+	}
+}
+
+// path returns the path of the marker file of the given key.
+func (s *onceStore) path(tenant, key string) string {
+	return filepath.Join(s.work, tenant, onceDir, key)
+}
+
+// begin checks if there is a valid marker for the given tenant and key. If there is one it is
+// returned. If there is no marker but a binary with the same key is running it returns true in
+// the busy flag. Otherwise it records that the binary is running and returns nil and false. In
+// that case the caller must later call finish.
+func (s *onceStore) begin(tenant, key string) (marker *api.Once, busy bool, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	index := resultKey{tenant: tenant, key: key}
+	if s.running[index] {
+		busy = true
+		return
+	}
+	marker, err = s.load(tenant, key)
+	if err != nil || marker != nil {
+		return
+	}
+	s.running[index] = true
+	return
+}
+
+// finish records that the binary with the given tenant and key is no longer running and, if the
+// marker isn't nil, saves it.
+func (s *onceStore) finish(tenant, key string, marker *api.Once) (err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.running, resultKey{tenant: tenant, key: key})
+	if marker != nil {
+		err = s.save(tenant, key, marker)
+	}
+	return
+}
+
+// load reads the marker of the given tenant and key. It returns nil if the marker doesn't exist
+// or if it has expired.
+func (s *onceStore) load(tenant, key string) (marker *api.Once, err error) {
+	path := s.path(tenant, key)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	if s.ttl > 0 && time.Since(info.ModTime()) > s.ttl {
+		log.Infof(
+			"Marker '%s' is older than %s, the binary will run again",
+			path, s.ttl,
+		)
+		return
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	marker = &api.Once{}
+	err = json.Unmarshal(data, marker)
+	if err != nil {
+		marker = nil
+	}
+	return
+}
+
+// save writes the marker of the given tenant and key. The data is first written to a temporary
+// file and then renamed, so that a marker is never partially written.
+func (s *onceStore) save(tenant, key string, marker *api.Once) (err error) {
+	data, err := json.Marshal(marker)
+	if err != nil {
+		return
+	}
+	dir := filepath.Join(s.work, tenant, onceDir)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return
+	}
+	file, err := ioutil.TempFile(dir, ".marker")
+	if err != nil {
+		return
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	err = os.Rename(file.Name(), s.path(tenant, key))
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	return
+}
+
+// Make sure that the handler implements the HTTP handler interface:
+var _ http.Handler = &postOnceHandler{}
+
+// postOnceHandler is the handler that receives a POST containing a test and runs it only if it
+// hasn't already run successfully with the same key. The binary runs in the same way than a test
+// sent alone, so this uses the test handler to run it.
+type postOnceHandler struct {
+	tests *postTestHandler
+	once  *onceStore
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *postOnceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Check the key. It is used as the name of the marker file, so it has the same
+	// restrictions than the names of fixtures:
+	key := mux.Vars(r)["key"]
+	if !fixtureNameRE.MatchString(key) {
+		sendError(w, r, http.StatusBadRequest, "Key '%s' isn't valid", key)
+		return
+	}
+
+	// Unmarshal the request body:
+	requestBody := &api.Test{}
+	requestDecoder := json.NewDecoder(r.Body)
+	err := requestDecoder.Decode(requestBody)
+	if err != nil {
+		log.WithError(err).Info("Can't unmarshal request body")
+		sendError(w, r, http.StatusBadRequest, "Can't unmarshal request body")
+		return
+	}
+
+	// Check the request:
+	run, err := h.tests.prepare(requestBody)
+	if err != nil {
+		sendRequestError(w, r, err)
+		return
+	}
+
+	// Create the directory of the tenant:
+	tenantID, tenantDir, err := h.tests.ensureTenant(r)
+	if err != nil {
+		sendRequestError(w, r, err)
+		return
+	}
+
+	// If the binary already ran successfully with this key then return the saved result
+	// instead of running it again:
+	marker, busy, err := h.once.begin(tenantID, key)
+	if err != nil {
+		log.Errorf("Can't load marker '%s' for tenant '%s': %v", key, tenantID, err)
+		sendError(w, r, http.StatusInternalServerError, "Can't load marker '%s'", key)
+		return
+	}
+	if busy {
+		log.Infof("Rejected once request with key '%s' because it is still running", key)
+		sendError(w, r, http.StatusConflict, "Binary with key '%s' is still running", key)
+		return
+	}
+	if marker != nil {
+		log.Infof("Returning saved result for once request with key '%s'", key)
+		marker.Cached = true
+		h.sendMarker(w, r, marker)
+		return
+	}
+
+	// Calculate an identifier for the test:
+	testUUID, err := uuid.NewRandom()
+	if err != nil {
+		h.once.finish(tenantID, key, nil)
+		log.WithError(err).Error("Can't generate test identifier")
+		sendError(w, r, http.StatusInternalServerError, "Can't generate test identifier")
+		return
+	}
+	testID := testUUID.String()
+	log.Infof("Assigned test identifier '%s' to once request with key '%s'", testID, key)
+
+	// Run the binary:
+	run.tenant = tenantID
+	run.id = testID
+	started := time.Now()
+	result, err := h.tests.runAlone(r.Context(), run, tenantDir)
+	if err != nil {
+		h.once.finish(tenantID, key, nil)
+		sendRequestError(w, r, err)
+		return
+	}
+	marker = &api.Once{
+		Key:    key,
+		Time:   started.UTC().Format(time.RFC3339),
+		Result: result,
+	}
+
+	// Save the marker only if the binary succeeded, so that failures can be retried:
+	if result.Code == 0 {
+		err = h.once.finish(tenantID, key, marker)
+		if err != nil {
+			log.Errorf("Can't save marker '%s' for tenant '%s': %v", key, tenantID, err)
+			sendError(w, r, http.StatusInternalServerError, "Can't save marker '%s'", key)
+			return
+		}
+		log.Infof("Saved marker '%s' for tenant '%s'", key, tenantID)
+	} else {
+		h.once.finish(tenantID, key, nil)
+	}
+
+	// Send the response:
+	h.sendMarker(w, r, marker)
+}
+
+// sendMarker sends the given marker to the client.
+func (h *postOnceHandler) sendMarker(w http.ResponseWriter, r *http.Request, marker *api.Once) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(marker)
+	if err != nil {
+		log.Errorf("Can't send response body for once request with key '%s'", marker.Key)
+		return
+	}
+}
+
+// Name of the sub-directory of each tenant directory that contains the markers of the binaries
+// executed with the once endpoint:
+const onceDir = ".once"
@@ -0,0 +1,179 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/mux"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ = Describe("Once handler", func() {
+	var work string
+	var handler *postOnceHandler
+
+	BeforeEach(func() {
+		var err error
+		work, err = ioutil.TempDir("", "once")
+		Expect(err).ToNot(HaveOccurred())
+		handler = &postOnceHandler{
+			tests: &postTestHandler{
+				work:   work,
+				active: newActiveSet(),
+			},
+			once: newOnceStore(work, 0),
+		}
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(work)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// send sends the given test to the handler with the given key and returns the recorded
+	// response and the decoded response body.
+	send := func(key string, test *api.Test) (*httptest.ResponseRecorder, *api.Once) {
+		body, err := json.Marshal(test)
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest(
+			http.MethodPost,
+			"/api/v1/once/"+key,
+			bytes.NewReader(body),
+		)
+		request = mux.SetURLVars(request, map[string]string{
+			"key": key,
+		})
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		response := &api.Once{}
+		if recorder.Code == http.StatusOK {
+			err = json.Unmarshal(recorder.Body.Bytes(), response)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		return recorder, response
+	}
+
+	// counter returns a test that appends a line to a file outside of its directory, so that
+	// it is possible to count how many times it ran.
+	counter := func(code int) *api.Test {
+		script := fmt.Sprintf(
+			"#!/bin/sh\necho run >> %s\necho done\nexit %d\n",
+			filepath.Join(work, "count.txt"), code,
+		)
+		return &api.Test{
+			Binary: []byte(script),
+		}
+	}
+
+	// runs returns the number of times that the tests created by the counter function ran.
+	runs := func() int {
+		data, err := ioutil.ReadFile(filepath.Join(work, "count.txt"))
+		if os.IsNotExist(err) {
+			return 0
+		}
+		Expect(err).ToNot(HaveOccurred())
+		return bytes.Count(data, []byte("run\n"))
+	}
+
+	It("Runs the binary only the first time", func() {
+		recorder, response := send("dataset", counter(0))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(response.Key).To(Equal("dataset"))
+		Expect(response.Cached).To(BeFalse())
+		Expect(response.Time).ToNot(BeEmpty())
+		Expect(response.Result).ToNot(BeNil())
+		Expect(string(response.Result.Out)).To(Equal("done\n"))
+
+		recorder, second := send("dataset", counter(0))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(second.Cached).To(BeTrue())
+		Expect(second.Time).To(Equal(response.Time))
+		Expect(string(second.Result.Out)).To(Equal("done\n"))
+		Expect(runs()).To(Equal(1))
+	})
+
+	It("Runs the binary again if it failed", func() {
+		recorder, response := send("dataset", counter(2))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(response.Cached).To(BeFalse())
+		Expect(response.Result.Code).To(Equal(2))
+
+		recorder, response = send("dataset", counter(0))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(response.Cached).To(BeFalse())
+		Expect(response.Result.Code).To(BeZero())
+		Expect(runs()).To(Equal(2))
+	})
+
+	It("Uses different markers for different keys", func() {
+		send("first", counter(0))
+		_, response := send("second", counter(0))
+		Expect(response.Cached).To(BeFalse())
+		Expect(runs()).To(Equal(2))
+	})
+
+	It("Keeps the marker when the server is recreated", func() {
+		send("dataset", counter(0))
+		handler.once = newOnceStore(work, 0)
+		_, response := send("dataset", counter(0))
+		Expect(response.Cached).To(BeTrue())
+		Expect(runs()).To(Equal(1))
+	})
+
+	It("Runs the binary again when the marker expires", func() {
+		handler.once = newOnceStore(work, time.Hour)
+		send("dataset", counter(0))
+		markers, err := filepath.Glob(filepath.Join(work, "*", onceDir, "dataset"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(markers).To(HaveLen(1))
+		old := time.Now().Add(-2 * time.Hour)
+		err = os.Chtimes(markers[0], old, old)
+		Expect(err).ToNot(HaveOccurred())
+		_, response := send("dataset", counter(0))
+		Expect(response.Cached).To(BeFalse())
+		Expect(runs()).To(Equal(2))
+	})
+
+	It("Rejects the key while the binary is running", func() {
+		index := resultKey{
+			tenant: tokenFingerprint(""),
+			key:    "dataset",
+		}
+		handler.once.running[index] = true
+		recorder, _ := send("dataset", counter(0))
+		Expect(recorder.Code).To(Equal(http.StatusConflict))
+		Expect(runs()).To(BeZero())
+	})
+
+	It("Rejects invalid keys", func() {
+		recorder, _ := send(".hidden", counter(0))
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(runs()).To(BeZero())
+	})
+})
@@ -0,0 +1,175 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the handler that runs plans of ordered steps.
+
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+// Make sure that the handler implements the HTTP handler interface:
+var _ http.Handler = &postPlanHandler{}
+
+// postPlanHandler is the handler that receives a POST containing a plan, runs its steps in order
+// and returns the results. The steps run in the same way than tests sent alone, so this uses the
+// test handler to run them.
+type postPlanHandler struct {
+	tests *postTestHandler
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *postPlanHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Unmarshal the request body:
+	requestBody := &api.Plan{}
+	requestDecoder := json.NewDecoder(r.Body)
+	err := requestDecoder.Decode(requestBody)
+	if err != nil {
+		log.WithError(err).Info("Can't unmarshal request body")
+		sendError(w, r, http.StatusBadRequest, "Can't unmarshal request body")
+		return
+	}
+	if len(requestBody.Steps) == 0 {
+		log.Info("Rejected plan without steps")
+		sendError(w, r, http.StatusBadRequest, "Plan doesn't contain any step")
+		return
+	}
+
+	// Check all the steps before running any of them, so that a plan with an invalid step is
+	// rejected without side effects:
+	runs := make([]*testRun, len(requestBody.Steps))
+	for i := range requestBody.Steps {
+		runs[i], err = h.tests.prepare(&requestBody.Steps[i])
+		if err != nil {
+			requestErr, ok := err.(*requestError)
+			if ok {
+				requestErr.reason = fmt.Sprintf("Step %d: %s", i+1, requestErr.reason)
+			}
+			sendRequestError(w, r, err)
+			return
+		}
+	}
+
+	// Calculate an identifier for the plan:
+	planUUID, err := uuid.NewRandom()
+	if err != nil {
+		log.WithError(err).Error("Can't generate plan identifier")
+		sendError(w, r, http.StatusInternalServerError, "Can't generate plan identifier")
+		return
+	}
+	planID := planUUID.String()
+	log.Infof("Assigned plan identifier '%s'", planID)
+
+	// Create the directory of the tenant:
+	tenantID, tenantDir, err := h.tests.ensureTenant(r)
+	if err != nil {
+		sendRequestError(w, r, err)
+		return
+	}
+
+	// Create the plan directory, shared by all the steps:
+	planDir := filepath.Join(tenantDir, planID)
+	err = os.Mkdir(planDir, 0700)
+	if err != nil {
+		log.Errorf("Can't create directory for plan '%s': %v", planID, err)
+		sendError(w, r, http.StatusInternalServerError, "Can't generate plan directory")
+		return
+	}
+	log.Infof("Created plan directory '%s' for plan '%s'", planDir, planID)
+
+	// Make sure that the sweeper doesn't remove the plan directory while the plan is running:
+	h.tests.active.add(planDir)
+	defer h.tests.active.remove(planDir)
+
+	// If the plan can't be completed there is no response that could report where the plan
+	// directory has been preserved, so handle it as the directory of a plan that passed:
+	planDone := false
+	defer func() {
+		if !planDone {
+			h.tests.cleanup(planID, planDir, true)
+		}
+	}()
+
+	// Run the steps in order. The files specific of each step, like the binary and the output,
+	// are created in a sub-directory of the plan directory, so that they don't collide.
+	responseBody := &api.Plan{
+		Steps:         make([]api.Test, 0, len(runs)),
+		StopOnFailure: requestBody.StopOnFailure,
+	}
+	planPassed := true
+	for i, run := range runs {
+		stepID := fmt.Sprintf("%s-%d", planID, i+1)
+		stepDir := filepath.Join(planDir, "steps", fmt.Sprintf("%d", i+1))
+		err = os.MkdirAll(stepDir, 0700)
+		if err != nil {
+			log.Errorf("Can't create directory for step '%s': %v", stepID, err)
+			sendError(w, r, http.StatusInternalServerError, "Can't generate step directory")
+			return
+		}
+		log.Infof("Running step %d of %d of plan '%s'", i+1, len(runs), planID)
+		run.tenant = tenantID
+		run.id = stepID
+		run.dir = planDir
+		run.files = stepDir
+		var stepResult *api.Test
+		stepResult, err = h.tests.run(r.Context(), run)
+		if err != nil {
+			requestErr, ok := err.(*requestError)
+			if ok {
+				requestErr.reason = fmt.Sprintf("Step %d: %s", i+1, requestErr.reason)
+			}
+			sendRequestError(w, r, err)
+			return
+		}
+		responseBody.Steps = append(responseBody.Steps, *stepResult)
+		if stepResult.Code != 0 {
+			planPassed = false
+			if requestBody.StopOnFailure {
+				log.Infof(
+					"Step %d of plan '%s' failed, will not run the remaining %d steps",
+					i+1, planID, len(runs)-i-1,
+				)
+				break
+			}
+		}
+	}
+
+	// Remove the plan directory if all the steps succeeded, or report where it has been
+	// preserved if any of them failed:
+	responseBody.Dir = h.tests.cleanup(planID, planDir, planPassed)
+	planDone = true
+
+	// Send the response:
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(responseBody)
+	if err != nil {
+		log.Errorf("Can't send response body for plan '%s'", planID)
+		return
+	}
+}
@@ -0,0 +1,219 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ = Describe("Plan handler", func() {
+	var work string
+	var handler *postPlanHandler
+
+	BeforeEach(func() {
+		var err error
+		work, err = ioutil.TempDir("", "plans")
+		Expect(err).ToNot(HaveOccurred())
+		handler = &postPlanHandler{
+			tests: &postTestHandler{
+				work:   work,
+				active: newActiveSet(),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(work)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// send sends the given plan to the handler and returns the recorded response and the
+	// decoded response body.
+	send := func(plan *api.Plan) (*httptest.ResponseRecorder, *api.Plan) {
+		body, err := json.Marshal(plan)
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/plans", bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		response := &api.Plan{}
+		if recorder.Code == http.StatusOK {
+			err = json.Unmarshal(recorder.Body.Bytes(), response)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		return recorder, response
+	}
+
+	It("Runs the steps in order in a shared directory", func() {
+		recorder, response := send(&api.Plan{
+			Steps: []api.Test{
+				{
+					Binary: []byte("#!/bin/sh\necho first > state.txt\n"),
+				},
+				{
+					Binary: []byte("#!/bin/sh\necho second >> state.txt\n"),
+				},
+				{
+					Binary: []byte("#!/bin/sh\ncat state.txt\n"),
+				},
+			},
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(response.Steps).To(HaveLen(3))
+		Expect(string(response.Steps[2].Out)).To(Equal("first\nsecond\n"))
+	})
+
+	It("Shares the temporary directory but not the environment", func() {
+		recorder, response := send(&api.Plan{
+			Steps: []api.Test{
+				{
+					Binary: []byte("#!/bin/sh\necho $MYVAR > $TMPDIR/state.txt\n"),
+					Env: map[string]string{
+						"MYVAR": "myvalue",
+					},
+				},
+				{
+					Binary: []byte("#!/bin/sh\ncat $TMPDIR/state.txt\necho \"[$MYVAR]\"\n"),
+				},
+			},
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(response.Steps).To(HaveLen(2))
+		Expect(string(response.Steps[1].Out)).To(Equal("myvalue\n[]\n"))
+	})
+
+	It("Stops on failure if requested", func() {
+		handler.tests.keepOnFailure = true
+		recorder, response := send(&api.Plan{
+			StopOnFailure: true,
+			Steps: []api.Test{
+				{
+					Binary: []byte("#!/bin/sh\nexit 3\n"),
+				},
+				{
+					Binary: []byte("#!/bin/sh\necho second\n"),
+				},
+			},
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(response.StopOnFailure).To(BeTrue())
+		Expect(response.Steps).To(HaveLen(1))
+		Expect(response.Steps[0].Code).To(Equal(3))
+		Expect(response.Dir).ToNot(BeEmpty())
+	})
+
+	It("Continues after failure if not requested to stop", func() {
+		recorder, response := send(&api.Plan{
+			Steps: []api.Test{
+				{
+					Binary: []byte("#!/bin/sh\nexit 3\n"),
+				},
+				{
+					Binary: []byte("#!/bin/sh\necho second\n"),
+				},
+			},
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(response.Steps).To(HaveLen(2))
+		Expect(response.Steps[0].Code).To(Equal(3))
+		Expect(response.Steps[1].Code).To(BeZero())
+		Expect(string(response.Steps[1].Out)).To(Equal("second\n"))
+	})
+
+	It("Removes the directory if all the steps pass", func() {
+		handler.tests.keepOnFailure = true
+		recorder, response := send(&api.Plan{
+			Steps: []api.Test{
+				{
+					Binary: []byte("#!/bin/sh\necho first\n"),
+				},
+			},
+		})
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(response.Dir).To(BeEmpty())
+	})
+
+	It("Removes the directory if a step can't run", func() {
+		handler.tests.keepOnFailure = true
+		handler.tests.uploads = newUploadStore(work, time.Hour, handler.tests.active)
+		recorder, _ := send(&api.Plan{
+			Steps: []api.Test{
+				{
+					Binary: []byte("#!/bin/sh\necho first\n"),
+				},
+				{
+					UploadID: "missing",
+				},
+			},
+		})
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(recorder.Body.String()).To(ContainSubstring("Step 2"))
+		Expect(testDirs(work)).To(BeEmpty())
+	})
+
+	It("Rejects plans without steps", func() {
+		recorder, _ := send(&api.Plan{})
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Rejects the plan without running any step if one is invalid", func() {
+		recorder, _ := send(&api.Plan{
+			Steps: []api.Test{
+				{
+					Binary: []byte("#!/bin/sh\necho first\n"),
+				},
+				{
+					Binary:  []byte("#!/bin/sh\necho second\n"),
+					Timeout: "junk",
+				},
+			},
+		})
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		Expect(recorder.Body.String()).To(ContainSubstring("Step 2"))
+		tenants, err := ioutil.ReadDir(work)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tenants).To(BeEmpty())
+	})
+})
+
+// testDirs returns the directories of tests and plans that are inside the tenant directories of
+// the given work directory.
+func testDirs(work string) []string {
+	matches, err := filepath.Glob(filepath.Join(work, "*", "*"))
+	Expect(err).ToNot(HaveOccurred())
+	var dirs []string
+	for _, match := range matches {
+		name := filepath.Base(match)
+		if strings.HasPrefix(name, ".") || name == uploadsDir {
+			continue
+		}
+		dirs = append(dirs, match)
+	}
+	return dirs
+}
@@ -0,0 +1,138 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the cache of results of tests submitted with an idempotency key.
+
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+// resultCache remembers the results of the tests that were submitted with an idempotency key, so
+// that when the client sends the same test again, for example because the connection was lost
+// before it received the response, the server can return the saved result instead of running the
+// test binary again. Results are kept during a TTL, and the cache keeps at most a fixed number of
+// them, discarding the ones that expire sooner when it is full. Keys are scoped to the tenant,
+// so that clients using different tokens can't see the results of each other.
+type resultCache struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[resultKey]*resultEntry
+}
+
+// resultKey is the key used to index the entries of the cache.
+type resultKey struct {
+	tenant string
+	key    string
+}
+
+// resultEntry contains the result of a test. The result will be nil while the test is running.
+type resultEntry struct {
+	result  *api.Test
+	expires time.Time
+}
+
+// newResultCache creates a cache that keeps results for the given time and that can keep at most
+// the given number of results.
+func newResultCache(ttl time.Duration, size int) *resultCache {
+	return &resultCache{
+		ttl:     ttl,
+		size:    size,
+		entries: map[resultKey]*resultEntry{},
+	}
+}
+
+// begin checks if there is a result for the given tenant and key. If there is a result it is
+// returned. If there is no result but a test with the same key is running it returns true in the
+// busy flag. Otherwise it records that the test is running and returns nil and false. In that
+// case the caller must later call finish or abort.
+func (c *resultCache) begin(tenant, key string) (result *api.Test, busy bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	now := time.Now()
+	index := resultKey{tenant: tenant, key: key}
+	entry, ok := c.entries[index]
+	if ok && (entry.result == nil || now.Before(entry.expires)) {
+		result = entry.result
+		busy = result == nil
+		return
+	}
+	c.evict(now)
+	c.entries[index] = &resultEntry{}
+	return
+}
+
+// finish saves the result of the test with the given tenant and key.
+func (c *resultCache) finish(tenant, key string, result *api.Test) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	index := resultKey{tenant: tenant, key: key}
+	entry, ok := c.entries[index]
+	if ok {
+		entry.result = result
+		entry.expires = time.Now().Add(c.ttl)
+	}
+}
+
+// abort discards the entry of the test with the given tenant and key if it hasn't finished, so
+// that the client can try again. It does nothing if the test has finished.
+func (c *resultCache) abort(tenant, key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	index := resultKey{tenant: tenant, key: key}
+	entry, ok := c.entries[index]
+	if ok && entry.result == nil {
+		delete(c.entries, index)
+	}
+}
+
+// evict removes the expired results and, if the cache is still full, the results that expire
+// sooner, till there is space for one more entry. Tests that are still running are never
+// removed, so the number of entries can temporarily exceed the size when there are many of
+// them. Must be called with the lock acquired.
+func (c *resultCache) evict(now time.Time) {
+	for index, entry := range c.entries {
+		if entry.result != nil && !now.Before(entry.expires) {
+			delete(c.entries, index)
+		}
+	}
+	for len(c.entries) >= c.size {
+		var oldest resultKey
+		var found *resultEntry
+		for index, entry := range c.entries {
+			if entry.result == nil {
+				continue
+			}
+			if found == nil || entry.expires.Before(found.expires) {
+				oldest = index
+				found = entry
+			}
+		}
+		if found == nil {
+			return
+		}
+		delete(c.entries, oldest)
+	}
+}
+
+// Maximum number of results kept in the cache. Results contain the output of the tests, so this
+// limits the memory used by the cache.
+const maxResults = 100
@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ = Describe("Result cache", func() {
+	It("Returns nothing for unknown keys", func() {
+		cache := newResultCache(time.Hour, 10)
+		result, busy := cache.begin("mytenant", "mykey")
+		Expect(result).To(BeNil())
+		Expect(busy).To(BeFalse())
+	})
+
+	It("Reports busy while the test is running", func() {
+		cache := newResultCache(time.Hour, 10)
+		cache.begin("mytenant", "mykey")
+		result, busy := cache.begin("mytenant", "mykey")
+		Expect(result).To(BeNil())
+		Expect(busy).To(BeTrue())
+	})
+
+	It("Returns the saved result", func() {
+		cache := newResultCache(time.Hour, 10)
+		saved := &api.Test{Code: 1}
+		cache.begin("mytenant", "mykey")
+		cache.finish("mytenant", "mykey", saved)
+		result, busy := cache.begin("mytenant", "mykey")
+		Expect(result).To(BeIdenticalTo(saved))
+		Expect(busy).To(BeFalse())
+	})
+
+	It("Doesn't return results of other tenants", func() {
+		cache := newResultCache(time.Hour, 10)
+		cache.begin("mytenant", "mykey")
+		cache.finish("mytenant", "mykey", &api.Test{})
+		result, busy := cache.begin("yourtenant", "mykey")
+		Expect(result).To(BeNil())
+		Expect(busy).To(BeFalse())
+	})
+
+	It("Forgets aborted tests", func() {
+		cache := newResultCache(time.Hour, 10)
+		cache.begin("mytenant", "mykey")
+		cache.abort("mytenant", "mykey")
+		result, busy := cache.begin("mytenant", "mykey")
+		Expect(result).To(BeNil())
+		Expect(busy).To(BeFalse())
+	})
+
+	It("Doesn't forget finished tests when aborted", func() {
+		cache := newResultCache(time.Hour, 10)
+		cache.begin("mytenant", "mykey")
+		cache.finish("mytenant", "mykey", &api.Test{})
+		cache.abort("mytenant", "mykey")
+		result, _ := cache.begin("mytenant", "mykey")
+		Expect(result).ToNot(BeNil())
+	})
+
+	It("Forgets expired results", func() {
+		cache := newResultCache(time.Millisecond, 10)
+		cache.begin("mytenant", "mykey")
+		cache.finish("mytenant", "mykey", &api.Test{})
+		time.Sleep(10 * time.Millisecond)
+		result, busy := cache.begin("mytenant", "mykey")
+		Expect(result).To(BeNil())
+		Expect(busy).To(BeFalse())
+	})
+
+	It("Discards the results that expire sooner when full", func() {
+		cache := newResultCache(time.Hour, 3)
+		for i := 0; i < 4; i++ {
+			key := fmt.Sprintf("mykey%d", i)
+			cache.begin("mytenant", key)
+			cache.finish("mytenant", key, &api.Test{})
+		}
+		Expect(cache.entries).To(HaveLen(3))
+		result, _ := cache.begin("mytenant", "mykey0")
+		Expect(result).To(BeNil())
+	})
+})
@@ -0,0 +1,46 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the logic used to run test binaries as a different user.
+
+package server
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// uidRange is a range of user identifiers. Both limits are inclusive.
+type uidRange struct {
+	min int
+	max int
+}
+
+// contains checks if the given user identifier is inside the range.
+func (r *uidRange) contains(uid int) bool {
+	return r != nil && uid >= r.min && uid <= r.max
+}
+
+// chownTree changes the owner of the given directory and of all the files and directories that it
+// contains. Symbolic links themselves are changed, but not the files they point to.
+func chownTree(dir string, uid, gid int) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+}
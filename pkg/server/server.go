@@ -21,53 +21,360 @@ package server
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
 )
 
 // ServerBuilder contains the information and logic needed to create a test runner server. Don't
 // create instances of this type directly; use the NewServer function instead.
 type ServerBuilder struct {
-	listen string
-	token  string
-	work   string
+	listen        string
+	listener      net.Listener
+	token         string
+	work          string
+	audit         string
+	keepOnFailure bool
+	compressKept  bool
+	keepAge       time.Duration
+	sweepAge      time.Duration
+	envAllow      []string
+	envDeny       []string
+	tlsCert       string
+	tlsKey        string
+	fetchFrom     []string
+	fixtureTTL    time.Duration
+	onceTTL       time.Duration
+	uploadTTL     time.Duration
+	runAs         *uidRange
+	teeOutput     bool
+	resultTTL     time.Duration
+	maxOutput     int64
+	maxArtifacts  int64
+	basePath      string
+	memoryBudget  int64
+	cleanEnv      bool
+	idleTimeout   time.Duration
+	accessLog     bool
 }
 
 // Server is the test runner server.
 type Server struct {
-	listen string
-	token  string
-	work   string
-	ws     *http.Server
+	listen        string
+	network       string
+	listener      net.Listener
+	token         string
+	work          string
+	audit         *auditLog
+	keepOnFailure bool
+	compressKept  bool
+	keepAge       time.Duration
+	sweepAge      time.Duration
+	envAllow      []string
+	envDeny       []string
+	tlsCert       string
+	tlsKey        string
+	fetcher       *fetcher
+	fixtureTTL    time.Duration
+	fixtures      *fixtureStore
+	once          *onceStore
+	uploads       *uploadStore
+	runAs         *uidRange
+	teeOutput     bool
+	results       *resultCache
+	maxOutput     int64
+	maxArtifacts  int64
+	basePath      string
+	budget        *memoryBudget
+	cleanEnv      bool
+	accessLog     bool
+	test2json     string
+	idle          *idleTimer
+	active        *activeSet
+	sweeper       *sweeper
+	ws            *http.Server
+	lock          sync.Mutex
+	state         serverState
 }
 
+// serverState represents the stage of the life cycle of the server: it is created by the Build
+// method of the builder, started by the Start method, stopped by the Stop method and finally
+// destroyed by the Destroy method. Each stage is entered only once.
+type serverState int
+
+const (
+	serverCreated serverState = iota
+	serverStarted
+	serverStopped
+	serverDestroyed
+)
+
 // NewServer creates a new object that knows how to build servers.
 func NewServer() *ServerBuilder {
-	return &ServerBuilder{}
+	return &ServerBuilder{
+		accessLog:    true,
+		maxArtifacts: defaultMaxArtifacts,
+		uploadTTL:    defaultUploadTTL,
+	}
 }
 
-// Listen sets the address and port number where the server will be listening. If not specified
-// it will listen in all the addresses available and in port 8000.
+// Listen sets the address and port number where the server will be listening, in the 'host:port'
+// form. IPv6 addresses must be inside brackets, for example '[::1]:8000'. When the host is an IP
+// address the server listens only in the family of that address, so '0.0.0.0:8000' doesn't
+// accept IPv6 connections and '[::]:8000' doesn't accept IPv4 connections. When the host is empty
+// the server listens in all the addresses of both families. If not specified it will listen in
+// all the addresses available and in port 8000.
 func (b *ServerBuilder) Listen(value string) *ServerBuilder {
 	b.listen = value
 	return b
 }
 
+// Listener sets a listener that has already been created, for example the one passed by systemd
+// when using socket activation. When this is set the server accepts connections from this
+// listener, and the address set with the Listen method is ignored. The server takes ownership of
+// the listener and closes it when it is stopped.
+func (b *ServerBuilder) Listener(value net.Listener) *ServerBuilder {
+	b.listener = value
+	return b
+}
+
 // Token sets the authentication token that will be required in all the HTTP requests.
 func (b *ServerBuilder) Token(value string) *ServerBuilder {
 	b.token = value
 	return b
 }
 
-// Work sets the directory where the server will copy and execute the test binaries.
+// Work sets the directory where the server will copy and execute the test binaries. This is the
+// only directory where the server writes, and it must be writable. Inside this
+// directory the server creates one sub-directory for each tenant, named after a hash of the
+// token used by the client, and inside that one sub-directory for each test.
 func (b *ServerBuilder) Work(value string) *ServerBuilder {
 	b.work = value
 	return b
 }
 
+// Audit sets the path of the file where the server will write the audit log. This log contains
+// one JSON document per line for each test binary executed, including the fingerprint of the
+// token used by the client, the size and SHA-256 of the binary, the arguments and the exit code.
+// If not specified the audit log will not be written.
+func (b *ServerBuilder) Audit(value string) *ServerBuilder {
+	b.audit = value
+	return b
+}
+
+// KeepOnFailure indicates if the server should remove the directory of each test when it
+// succeeds and preserve it when it fails. When a directory is preserved its path is returned to
+// the client in the Dir field of the response.
+func (b *ServerBuilder) KeepOnFailure(value bool) *ServerBuilder {
+	b.keepOnFailure = value
+	return b
+}
+
+// CompressKept indicates if the server should compress the test binary and the files containing
+// the standard output and error of the tests whose directories aren't removed. The compressed
+// files have the same names and the '.gz' extension. This reduces the space used by the
+// directories that are kept for troubleshooting, as the output of tests is usually very
+// repetitive. The default is false.
+func (b *ServerBuilder) CompressKept(value bool) *ServerBuilder {
+	b.compressKept = value
+	return b
+}
+
+// TeeOutput indicates if the server should also write the standard output and error of the tests
+// to its log, in addition to returning them to the client. This is useful to troubleshoot tests
+// when the client disconnects before receiving the response. The lines are written at the debug
+// level, so this has no effect unless that level is enabled. The default is false.
+func (b *ServerBuilder) TeeOutput(value bool) *ServerBuilder {
+	b.teeOutput = value
+	return b
+}
+
+// ResultTTL sets the time that the server remembers the results of the tests submitted with an
+// idempotency key. If the same client sends another test with the same key during that time the
+// server returns the saved result instead of running the test again. The server keeps at most
+// one hundred results, discarding the ones that expire sooner when that limit is reached. The
+// default is zero, which means that idempotency keys are ignored.
+func (b *ServerBuilder) ResultTTL(value time.Duration) *ServerBuilder {
+	b.resultTTL = value
+	return b
+}
+
+// MaxOutputBytes sets the maximum number of bytes of the standard output and of the standard error
+// of each test that the server keeps. The rest is discarded, a marker is added at the end, and
+// the Truncated field of the result is set. The limit applies to each stream separately. This
+// protects the disk of the server and the client from tests that generate huge amounts of
+// output. The default is zero, which means that there is no limit.
+func (b *ServerBuilder) MaxOutputBytes(value int64) *ServerBuilder {
+	b.maxOutput = value
+	return b
+}
+
+// MaxArtifactBytes sets the maximum total number of bytes of the files produced by each test,
+// requested with the OutputGlobs field, that the server returns. Files that would exceed this
+// limit aren't returned, and their paths are reported in the OmittedArtifacts field of the
+// result. Zero means that there is no limit. The default is 10 MiB.
+func (b *ServerBuilder) MaxArtifactBytes(value int64) *ServerBuilder {
+	b.maxArtifacts = value
+	return b
+}
+
+// BasePath sets the path prefix of all the URLs served by the server. This is needed when the
+// server is exposed behind a shared route or ingress that routes requests by path. For example,
+// if the base path is '/sandbox' then tests will be accepted in '/sandbox/api/v1/tests'. The
+// default is empty, which means that URLs aren't prefixed.
+func (b *ServerBuilder) BasePath(value string) *ServerBuilder {
+	b.basePath = value
+	return b
+}
+
+// MemoryBudgetBytes sets the maximum total size of the test binaries that the server runs at the
+// same time. The size of each binary is used as an estimate of the memory that it needs, so this
+// protects the server from running out of memory when many large binaries, for example built with
+// the race detector, are sent at the same time. Binaries that don't fit in the budget aren't
+// rejected: they wait till the binaries that are running finish. A binary larger than the budget
+// runs when no other binary is running. The default is zero, which means that there is no limit.
+func (b *ServerBuilder) MemoryBudgetBytes(value int64) *ServerBuilder {
+	b.memoryBudget = value
+	return b
+}
+
+// IdleTimeout sets the time after which the server is considered idle if it doesn't receive
+// requests. Requests that are in progress, like long running tests, don't count as idle time.
+// When the server becomes idle the channel returned by the Idle method is closed, so that the
+// caller can stop the server and release its resources without waiting for the project to be
+// deleted. The default is zero, which means that the server is never considered idle.
+func (b *ServerBuilder) IdleTimeout(value time.Duration) *ServerBuilder {
+	b.idleTimeout = value
+	return b
+}
+
+// KeepAge sets the time that the directories preserved because of the KeepOnFailure option will
+// be kept. Directories older than this will be removed by a background task. The default is to
+// never remove them.
+func (b *ServerBuilder) KeepAge(value time.Duration) *ServerBuilder {
+	b.keepAge = value
+	return b
+}
+
+// SweepAge sets the maximum age of the test directories. A background task will periodically
+// remove the test directories that haven't been modified for longer than this, regardless of
+// the KeepOnFailure option. This is intended to remove the directories left behind by tests that
+// crashed or were killed. Directories of tests that are still running are never removed. If this
+// isn't set and KeepOnFailure is, then the value of KeepAge will be used. The default is to never
+// remove the test directories.
+func (b *ServerBuilder) SweepAge(value time.Duration) *ServerBuilder {
+	b.sweepAge = value
+	return b
+}
+
+// EnvAllow adds prefixes of names of environment variables of the server that will be passed to
+// the tests. If no prefix is added then all the environment variables are passed, except the ones
+// excluded with the EnvDeny method.
+func (b *ServerBuilder) EnvAllow(values ...string) *ServerBuilder {
+	b.envAllow = append(b.envAllow, values...)
+	return b
+}
+
+// EnvDeny adds prefixes of names of environment variables of the server that will not be passed to
+// the tests. Variables that start with AWS_, AZURE_, GOOGLE_ and POSTGRESQL_ are never passed.
+// Note that this only applies to the environment of the server: the variables explicitly sent by
+// the client in the request are always passed. The token of the server isn't in the environment:
+// the server command reads it from the file given with the '--token-file' option, which the
+// runner mounts from a secret in the '/etc/sandbox/token' directory. Tests that run as the same
+// user as the server can read that file. RunAsRange makes it possible to run them as other users,
+// but then the permissions of the file also need to exclude those users.
+func (b *ServerBuilder) EnvDeny(values ...string) *ServerBuilder {
+	b.envDeny = append(b.envDeny, values...)
+	return b
+}
+
+// CleanEnv indicates if the tests should run with a minimal environment instead of the
+// environment of the server. The minimal environment contains only PATH, with the standard
+// locations of binaries, HOME, pointing to the test directory, and TMPDIR, pointing to the
+// temporary directory of the test. The variables sent by the client in the request are added as
+// usual. Variables of the server are added only if they match one of the prefixes given with the
+// EnvAllow method. This is recommended, because it makes the results of tests independent of
+// how the server is deployed. The default is false, so that tests see the environment of the
+// server, filtered with the EnvAllow and EnvDeny methods.
+func (b *ServerBuilder) CleanEnv(value bool) *ServerBuilder {
+	b.cleanEnv = value
+	return b
+}
+
+// AccessLog indicates if the server should write to the log a line for each request that it
+// receives and another for each response that it sends. Disabling it reduces the volume of the
+// log, and the time spent writing it, in servers that receive many requests. Errors are still
+// written. The default is true.
+func (b *ServerBuilder) AccessLog(value bool) *ServerBuilder {
+	b.accessLog = value
+	return b
+}
+
+// TLS sets the files containing the TLS certificate and key that the server will use. If these
+// are set the server will use HTTPS, and will support HTTP/2. If not set it will use plain
+// HTTP/1.
+func (b *ServerBuilder) TLS(cert, key string) *ServerBuilder {
+	b.tlsCert = cert
+	b.tlsKey = key
+	return b
+}
+
+// AllowFetchFrom adds URLs of locations where the server is allowed to download test binaries
+// from, when the client sends the BinaryURL field instead of the binary itself. A URL is allowed
+// if it has the same scheme and host than one of these, and its path starts with the path of
+// that one. If no location is added then the server will refuse to download binaries.
+func (b *ServerBuilder) AllowFetchFrom(values ...string) *ServerBuilder {
+	b.fetchFrom = append(b.fetchFrom, values...)
+	return b
+}
+
+// FixtureTTL sets the time that fixtures will be kept after they were last uploaded or used by a
+// test. Fixtures that haven't been used for longer than this will be removed by a background
+// task. The default is to never remove them.
+func (b *ServerBuilder) FixtureTTL(value time.Duration) *ServerBuilder {
+	b.fixtureTTL = value
+	return b
+}
+
+// OnceTTL sets the time after which the markers of the binaries executed with the once endpoint
+// expire. When a marker expires the next request with the same key runs the binary again. The
+// default is zero, which means that markers never expire, so the binary runs only once while the
+// work directory is preserved.
+func (b *ServerBuilder) OnceTTL(value time.Duration) *ServerBuilder {
+	b.onceTTL = value
+	return b
+}
+
+// UploadTTL sets the time that binaries uploaded in chunks will be kept after they were last
+// modified or used by a test. Uploads that haven't been used for longer than this, including the
+// ones that were never completed, will be removed by a background task. Zero means that they are
+// never removed. The default is one hour.
+func (b *ServerBuilder) UploadTTL(value time.Duration) *ServerBuilder {
+	b.uploadTTL = value
+	return b
+}
+
+// RunAsRange sets the range of user identifiers that clients can request to run the test
+// binaries with, using the RunAsUser field. Both limits are inclusive. If not set the server
+// will reject requests that contain that field. Note that in order to run processes as other
+// users the server needs the SETUID, SETGID and CHOWN capabilities.
+func (b *ServerBuilder) RunAsRange(min, max int) *ServerBuilder {
+	b.runAs = &uidRange{
+		min: min,
+		max: max,
+	}
+	return b
+}
+
 // Build uses the information stored in the builder to create a new server. Note that the returned
 // server isn't started yet. To start it call the Start method.
 func (b *ServerBuilder) Build() (srvr *Server, err error) {
@@ -77,6 +384,62 @@ func (b *ServerBuilder) Build() (srvr *Server, err error) {
 		return
 	}
 
+	if b.runAs != nil && (b.runAs.min < 0 || b.runAs.min > b.runAs.max) {
+		err = fmt.Errorf(
+			"range of user identifiers %d-%d isn't valid",
+			b.runAs.min, b.runAs.max,
+		)
+		return
+	}
+
+	if (b.tlsCert == "") != (b.tlsKey == "") {
+		err = fmt.Errorf("TLS certificate and key must be used together")
+		return
+	}
+	if b.maxOutput < 0 {
+		err = fmt.Errorf("maximum output size can't be negative, but it is %d", b.maxOutput)
+		return
+	}
+	if b.maxArtifacts < 0 {
+		err = fmt.Errorf(
+			"maximum artifacts size can't be negative, but it is %d",
+			b.maxArtifacts,
+		)
+		return
+	}
+	if b.memoryBudget < 0 {
+		err = fmt.Errorf("memory budget can't be negative, but it is %d", b.memoryBudget)
+		return
+	}
+	if b.onceTTL < 0 {
+		err = fmt.Errorf("once TTL can't be negative, but it is %s", b.onceTTL)
+		return
+	}
+	if b.uploadTTL < 0 {
+		err = fmt.Errorf("upload TTL can't be negative, but it is %s", b.uploadTTL)
+		return
+	}
+	if b.idleTimeout < 0 {
+		err = fmt.Errorf("idle timeout can't be negative, but it is %s", b.idleTimeout)
+		return
+	}
+	listen := b.listen
+	if listen == "" {
+		listen = defaultListen
+	}
+	var network string
+	if b.listener == nil {
+		network, err = listenNetwork(listen)
+		if err != nil {
+			return
+		}
+	}
+	basePath := strings.TrimRight(b.basePath, "/")
+	if basePath != "" && !strings.HasPrefix(basePath, "/") {
+		err = fmt.Errorf("base path must start with a slash, but it is '%s'", b.basePath)
+		return
+	}
+
 	// Check that the working directory exists:
 	work := b.work
 	if work == "" {
@@ -92,41 +455,273 @@ func (b *ServerBuilder) Build() (srvr *Server, err error) {
 		return
 	}
 
+	// Check that the working directory is writable. This is the only directory where the server
+	// writes, so it can run with a read only root file system.
+	err = checkWritable(work)
+	if err != nil {
+		err = fmt.Errorf("working directory '%s' isn't writable: %v", work, err)
+		return
+	}
+
+	// Open the audit log:
+	var audit *auditLog
+	if b.audit != "" {
+		audit, err = openAuditLog(b.audit)
+		if err != nil {
+			err = fmt.Errorf("can't open audit log '%s': %v", b.audit, err)
+			return
+		}
+	}
+
+	// Create the object that downloads binaries:
+	fetcher, err := newFetcher(b.fetchFrom)
+	if err != nil {
+		return
+	}
+
+	// Find the tool used to generate JSON events. If it isn't available the server still works,
+	// but clients can't request the events:
+	test2json, _ := exec.LookPath(test2jsonTool)
+
+	// Make copies of the lists of allowed and denied environment variables:
+	envAllow := make([]string, len(b.envAllow))
+	copy(envAllow, b.envAllow)
+	envDeny := make([]string, len(defaultEnvDeny), len(defaultEnvDeny)+len(b.envDeny))
+	copy(envDeny, defaultEnvDeny)
+	envDeny = append(envDeny, b.envDeny...)
+
 	// Create and populate the object:
 	srvr = &Server{
-		listen: b.listen,
-		token:  b.token,
-		work:   work,
+		listen:        listen,
+		network:       network,
+		listener:      b.listener,
+		token:         b.token,
+		work:          work,
+		audit:         audit,
+		keepOnFailure: b.keepOnFailure,
+		compressKept:  b.compressKept,
+		keepAge:       b.keepAge,
+		sweepAge:      b.sweepAge,
+		envAllow:      envAllow,
+		envDeny:       envDeny,
+		tlsCert:       b.tlsCert,
+		tlsKey:        b.tlsKey,
+		fetcher:       fetcher,
+		fixtureTTL:    b.fixtureTTL,
+		runAs:         b.runAs,
+		teeOutput:     b.teeOutput,
+		maxOutput:     b.maxOutput,
+		maxArtifacts:  b.maxArtifacts,
+		basePath:      basePath,
+		cleanEnv:      b.cleanEnv,
+		accessLog:     b.accessLog,
+		test2json:     test2json,
+		active:        newActiveSet(),
+	}
+	if b.resultTTL > 0 {
+		srvr.results = newResultCache(b.resultTTL, maxResults)
 	}
+	if b.memoryBudget > 0 {
+		srvr.budget = newMemoryBudget(b.memoryBudget)
+	}
+	if b.idleTimeout > 0 {
+		srvr.idle = newIdleTimer(b.idleTimeout)
+	}
+	srvr.fixtures = newFixtureStore(work, srvr.fixtureTTL, srvr.active)
+	srvr.once = newOnceStore(work, b.onceTTL)
+	srvr.uploads = newUploadStore(work, b.uploadTTL, srvr.active)
 
 	return
 }
 
-// Start starts the server.
+// checkWritable checks that the given directory is writable, creating and then removing a
+// temporary file.
+func checkWritable(dir string) error {
+	file, err := ioutil.TempFile(dir, ".check")
+	if err != nil {
+		return err
+	}
+	err = file.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(file.Name())
+}
+
+// Start starts the server. It can be called only once, and it returns an error if the server was
+// already started, stopped or destroyed. If it fails to listen then none of the background tasks
+// are started, and it can be called again.
 func (s *Server) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state != serverCreated {
+		return fmt.Errorf("server can't be started because it was already started")
+	}
+
 	// Create the main router:
 	router := mux.NewRouter()
 	router.NotFoundHandler = &notFoundHandler{}
-	router.Use(accessLogMiddleware())
+	if s.accessLog {
+		router.Use(accessLogMiddleware())
+	}
 	router.Use(authMiddleware(s.token))
+	if s.idle != nil {
+		router.Use(idleMiddleware(s.idle))
+	}
+
+	// Create the fixture handler:
+	fixtureHandler := &putFixtureHandler{
+		fixtures: s.fixtures,
+	}
+
+	// Create the upload handlers:
+	postUpload := &postUploadHandler{
+		uploads: s.uploads,
+	}
+	getUpload := &getUploadHandler{
+		uploads: s.uploads,
+	}
+	patchUpload := &patchUploadHandler{
+		uploads: s.uploads,
+	}
+
+	// Create the capabilities handler:
+	capabilitiesHandler := &getCapabilitiesHandler{
+		capabilities: s.capabilities(),
+	}
+
+	// Create the configuration handler:
+	configHandler := &getConfigHandler{
+		config: s.config(),
+	}
 
 	// Create the test handler:
 	handler := &postTestHandler{
-		work: s.work,
+		work:          s.work,
+		audit:         s.audit,
+		keepOnFailure: s.keepOnFailure,
+		compressKept:  s.compressKept,
+		active:        s.active,
+		envAllow:      s.envAllow,
+		envDeny:       s.envDeny,
+		fetcher:       s.fetcher,
+		fixtures:      s.fixtures,
+		uploads:       s.uploads,
+		runAs:         s.runAs,
+		teeOutput:     s.teeOutput,
+		results:       s.results,
+		maxOutput:     s.maxOutput,
+		maxArtifacts:  s.maxArtifacts,
+		budget:        s.budget,
+		cleanEnv:      s.cleanEnv,
+		test2json:     s.test2json,
+	}
+
+	// Create the plan handler, that uses the test handler to run the steps:
+	planHandler := &postPlanHandler{
+		tests: handler,
 	}
 
-	// Register the API handlers:
-	// apiRouter := mainRouter.Path(apiPrefix).Subrouter()
-	// versionRouter := apiRouter.Path("/"+apiVersion).Subrouter()
-	router.Handle("/api/v1/tests", handler).Methods(http.MethodPost)
+	// Create the once handler, that also uses the test handler to run the binaries:
+	onceHandler := &postOnceHandler{
+		tests: handler,
+		once:  s.once,
+	}
+
+	// Register the API handlers, inside the base path if there is one:
+	apiRouter := router
+	if s.basePath != "" {
+		apiRouter = router.PathPrefix(s.basePath).Subrouter()
+	}
+	apiRouter.Handle("/api/v1/tests", handler).Methods(http.MethodPost)
+	apiRouter.Handle("/api/v1/plans", planHandler).Methods(http.MethodPost)
+	apiRouter.Handle("/api/v1/once/{key}", onceHandler).Methods(http.MethodPost)
+	apiRouter.Handle("/api/v1/fixtures/{name}", fixtureHandler).Methods(http.MethodPut)
+	apiRouter.Handle("/api/v1/uploads", postUpload).Methods(http.MethodPost)
+	apiRouter.Handle("/api/v1/uploads/{id}", getUpload).Methods(http.MethodGet)
+	apiRouter.Handle("/api/v1/uploads/{id}", patchUpload).Methods(http.MethodPatch)
+	apiRouter.Handle("/api/v1/capabilities", capabilitiesHandler).Methods(http.MethodGet)
+	apiRouter.Handle("/api/v1/config", configHandler).Methods(http.MethodGet)
 
-	// Create the HTTP server:
-	s.ws = &http.Server{
+	// Create the listener, unless one was given with the builder. This is done here instead
+	// of letting the web server do it so that it uses the network that corresponds to the
+	// address, and so that failures are reported to the caller:
+	listener := s.listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen(s.network, s.listen)
+		if err != nil {
+			return fmt.Errorf("can't listen in address '%s': %v", s.listen, err)
+		}
+	}
+
+	// Create the HTTP server, compressing all the responses, including the ones generated by
+	// the middlewares and by the not found handler. If this fails the listener created above
+	// is closed, and the server stays as it was, so that nothing is left running.
+	ws := &http.Server{
 		Addr:    s.listen,
-		Handler: router,
+		Handler: gzipMiddleware(router),
+	}
+	if s.tlsCert != "" {
+		err := http2.ConfigureServer(ws, nil)
+		if err != nil {
+			if listener != s.listener {
+				closeErr := listener.Close()
+				if closeErr != nil {
+					log.Errorf("Can't close listener: %v", closeErr)
+				}
+			}
+			return err
+		}
+	}
+	s.listener = listener
+	s.ws = ws
+	s.state = serverStarted
+
+	// Start the sweeper that removes the old test directories:
+	age := s.sweepAge
+	if age == 0 && s.keepOnFailure {
+		age = s.keepAge
+	}
+	if age > 0 {
+		s.sweeper = newSweeper(s.work, age, s.active)
+		s.sweeper.start()
+		log.Infof("Test directories older than %s will be removed", age)
+	}
+
+	// Start the task that removes the unused fixtures:
+	s.fixtures.start()
+	if s.fixtureTTL > 0 {
+		log.Infof("Fixtures not used for more than %s will be removed", s.fixtureTTL)
+	}
+
+	// Start the task that removes the unused uploads:
+	s.uploads.start()
+	if s.uploads.ttl > 0 {
+		log.Infof("Uploads not used for more than %s will be removed", s.uploads.ttl)
 	}
+
+	// Report if JSON events can be generated:
+	if s.test2json != "" {
+		log.Infof("JSON events will be generated with '%s'", s.test2json)
+	} else {
+		log.Infof("Tool '%s' isn't available, JSON events can't be generated", test2jsonTool)
+	}
+
+	// Start counting the time that the server is idle:
+	if s.idle != nil {
+		s.idle.start()
+		log.Infof("Server will be idle after %s without requests", s.idle.timeout)
+	}
+
+	// Start serving requests:
 	go func() {
-		err := s.ws.ListenAndServe()
+		var err error
+		if s.tlsCert != "" {
+			err = s.ws.ServeTLS(s.listener, s.tlsCert, s.tlsKey)
+		} else {
+			err = s.ws.Serve(s.listener)
+		}
 		if err != nil {
 			log.WithError(err).Info("Web server finished with error")
 		}
@@ -135,20 +730,100 @@ func (s *Server) Start() error {
 	return nil
 }
 
-// Stop stops the server.
+// Addr returns the address where the server is listening. This is useful when the port given
+// with the Listen method of the builder is zero, as then it is chosen by the operating system.
+// It returns nil if the server hasn't been started.
+func (s *Server) Addr() net.Addr {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state == serverCreated || s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// Idle returns a channel that is closed when the server has been idle for the time given with the
+// IdleTimeout method of the builder. If that time wasn't given the channel is never closed.
+func (s *Server) Idle() <-chan struct{} {
+	if s.idle == nil {
+		return neverIdle
+	}
+	return s.idle.expired
+}
+
+// Stop stops the server, waiting for the requests that are in progress to finish. It is safe to
+// call it multiple times, and also when the server wasn't started: only the first call after the
+// server was started does something.
 func (s *Server) Stop() error {
-	// Try to stop the web server:
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.stop()
+}
+
+// stop contains the implementation of the Stop method. Must be called with the lock held.
+func (s *Server) stop() error {
+	if s.state != serverStarted {
+		return nil
+	}
+	s.state = serverStopped
+
+	// Try to stop the web server. If this fails we still stop the background tasks, as the
+	// server will not be stopped again.
+	var err error
 	if s.ws != nil {
-		err := s.ws.Shutdown(context.Background())
+		err = s.ws.Shutdown(context.Background())
+	}
+
+	// Stop the sweeper:
+	if s.sweeper != nil {
+		s.sweeper.halt()
+		s.sweeper = nil
+	}
+
+	// Stop the task that removes unused fixtures:
+	s.fixtures.halt()
+
+	// Stop the task that removes unused uploads:
+	s.uploads.halt()
+
+	// Stop counting the idle time:
+	if s.idle != nil {
+		s.idle.halt()
+	}
+
+	return err
+}
+
+// Destroy releases all the resources used by the server. If the server is still running it is
+// stopped first. It is safe to call it multiple times: only the first call does something.
+func (s *Server) Destroy() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.state == serverDestroyed {
+		return nil
+	}
+
+	// If the server was never started then the listener given by the caller, if any, hasn't
+	// been used, so we need to close it explicitly:
+	if s.state == serverCreated && s.listener != nil {
+		err := s.listener.Close()
 		if err != nil {
-			return err
+			log.Errorf("Can't close listener: %v", err)
 		}
 	}
 
-	return nil
-}
+	// Stop the server, in case it wasn't explicitly stopped. If this fails we still release
+	// the rest of the resources, as the server will not be destroyed again.
+	err := s.stop()
+	s.state = serverDestroyed
 
-// Destroy releases all the resources used by the server.
-func (c *Server) Destroy() error {
-	return nil
+	// Close the audit log:
+	if s.audit != nil {
+		closeErr := s.audit.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
 }
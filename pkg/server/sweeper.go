@@ -0,0 +1,202 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the sweeper that periodically removes old test
+// directories from the working directory of the server.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// activeSet is the set of test directories and fixtures that are currently in use, and that the
+// sweepers should never remove, regardless of their age. The same path can be added multiple
+// times, for example when several tests use the same fixture, and it is considered in use till
+// it is removed the same number of times.
+type activeSet struct {
+	lock  sync.Mutex
+	paths map[string]int
+}
+
+// newActiveSet creates an empty set of active paths.
+func newActiveSet() *activeSet {
+	return &activeSet{
+		paths: map[string]int{},
+	}
+}
+
+// add adds the given path to the set.
+func (s *activeSet) add(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paths[path]++
+}
+
+// remove removes the given path from the set.
+func (s *activeSet) remove(path string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.paths[path]--
+	if s.paths[path] <= 0 {
+		delete(s.paths, path)
+	}
+}
+
+// contains checks if the given path is in the set.
+func (s *activeSet) contains(path string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.paths[path] > 0
+}
+
+// sweeper periodically removes the test directories that are older than a given age. Directories
+// of tests that are still running are never removed.
+type sweeper struct {
+	dir      string
+	age      time.Duration
+	active   *activeSet
+	interval time.Duration
+	stop     chan bool
+	done     chan bool
+}
+
+// newSweeper creates a sweeper that removes the sub-directories of the given directory that are
+// older than the given age and that aren't in the given set of active directories. The sweeper
+// isn't started; to start it use the start method.
+func newSweeper(dir string, age time.Duration, active *activeSet) *sweeper {
+	// Check the directory at least once per minute, but more frequently if the age is short:
+	interval := age / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	return &sweeper{
+		dir:      dir,
+		age:      age,
+		active:   active,
+		interval: interval,
+	}
+}
+
+// start starts the goroutine that periodically removes the old directories.
+func (s *sweeper) start() {
+	s.stop = make(chan bool)
+	s.done = make(chan bool)
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.sweep()
+			}
+		}
+	}()
+}
+
+// halt stops the goroutine that removes old directories and waits till it finishes.
+func (s *sweeper) halt() {
+	close(s.stop)
+	<-s.done
+}
+
+// sweep removes the test directories that are older than the configured age. The work directory
+// contains one sub-directory per tenant, and each of them contains one sub-directory per test.
+// Directories that don't contain the tenant marker weren't created by the server and are never
+// touched, as the work directory may be shared with other programs, for example when it is the
+// default temporary directory.
+func (s *sweeper) sweep() {
+	tenants, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		log.Errorf("Can't read work directory '%s': %v", s.dir, err)
+		return
+	}
+	limit := time.Now().Add(-s.age)
+	for _, tenant := range tenants {
+		if !tenant.IsDir() {
+			continue
+		}
+		dir := filepath.Join(s.dir, tenant.Name())
+		if !isTenant(dir) {
+			log.Debugf("Directory '%s' isn't a tenant directory, will not sweep it", dir)
+			continue
+		}
+		s.sweepTenant(dir, limit)
+	}
+}
+
+// sweepTenant removes the test directories of one tenant that haven't been modified since the
+// given limit. Hidden directories, like the one that contains the fixtures, are ignored.
+func (s *sweeper) sweepTenant(dir string, limit time.Time) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Errorf("Can't read tenant directory '%s': %v", dir, err)
+		return
+	}
+	for _, info := range infos {
+		if !info.IsDir() || info.ModTime().After(limit) {
+			continue
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, info.Name())
+		if s.active.contains(path) {
+			log.Debugf("Test directory '%s' is old but still in use", path)
+			continue
+		}
+		err = os.RemoveAll(path)
+		if err != nil {
+			log.Errorf("Can't remove old test directory '%s': %v", path, err)
+			continue
+		}
+		log.Infof(
+			"Removed test directory '%s' because it was modified more than %s ago",
+			path, s.age,
+		)
+	}
+}
+
+// markTenant writes to the given directory the marker that indicates that it is a tenant
+// directory created by the server, if it doesn't exist yet.
+func markTenant(dir string) error {
+	path := filepath.Join(dir, tenantMarker)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// isTenant checks if the given directory contains the tenant marker.
+func isTenant(dir string) bool {
+	info, err := os.Stat(filepath.Join(dir, tenantMarker))
+	return err == nil && info.Mode().IsRegular()
+}
+
+// tenantMarker is the name of the file that the server writes to the directories of the tenants,
+// so that the sweeper only removes directories created by the server.
+const tenantMarker = ".tenant"
@@ -0,0 +1,105 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Sweeper", func() {
+	var work string
+	var active *activeSet
+	var swpr *sweeper
+
+	BeforeEach(func() {
+		var err error
+		work, err = ioutil.TempDir("", "sweeper")
+		Expect(err).ToNot(HaveOccurred())
+		active = newActiveSet()
+		swpr = newSweeper(work, time.Hour, active)
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(work)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// makeDir creates a directory inside the work directory, optionally old enough to be
+	// removed by the sweeper, and returns its path.
+	makeDir := func(name string, old bool) string {
+		path := filepath.Join(work, name)
+		err := os.MkdirAll(path, 0700)
+		Expect(err).ToNot(HaveOccurred())
+		if old {
+			past := time.Now().Add(-2 * time.Hour)
+			err = os.Chtimes(path, past, past)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		return path
+	}
+
+	// makeTenant creates a tenant directory with the marker that the server writes.
+	makeTenant := func(name string) string {
+		path := makeDir(name, false)
+		err := markTenant(path)
+		Expect(err).ToNot(HaveOccurred())
+		return path
+	}
+
+	It("Removes old test directories of tenants", func() {
+		makeTenant("mytenant")
+		old := makeDir("mytenant/old", true)
+		recent := makeDir("mytenant/recent", false)
+		swpr.sweep()
+		Expect(old).ToNot(BeADirectory())
+		Expect(recent).To(BeADirectory())
+	})
+
+	It("Doesn't remove directories that are in use", func() {
+		makeTenant("mytenant")
+		old := makeDir("mytenant/old", true)
+		active.add(old)
+		swpr.sweep()
+		Expect(old).To(BeADirectory())
+	})
+
+	It("Doesn't remove hidden directories", func() {
+		makeTenant("mytenant")
+		hidden := makeDir("mytenant/.fixtures", true)
+		swpr.sweep()
+		Expect(hidden).To(BeADirectory())
+	})
+
+	It("Doesn't touch directories that weren't created by the server", func() {
+		foreign := makeDir("other/old", true)
+		swpr.sweep()
+		Expect(foreign).To(BeADirectory())
+	})
+
+	It("Accepts marking a tenant twice", func() {
+		tenant := makeTenant("mytenant")
+		err := markTenant(tenant)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(isTenant(tenant)).To(BeTrue())
+	})
+})
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the writer used to copy the output of the tests to the log of the server.
+
+package server
+
+import (
+	"bytes"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// teeWriter is an io.Writer that writes each line of the output of a test to the log of the
+// server, at the debug level. Incomplete lines are kept till the rest of the line is written or
+// the writer is flushed. Lines longer than maxTeeLine are truncated, to avoid filling the log with
+// the output of tests that write large amounts of data without line breaks.
+type teeWriter struct {
+	test   string
+	stream string
+	buffer bytes.Buffer
+}
+
+// newTeeWriter creates a writer that will write to the log the given stream of the given test.
+// The stream is the name that will be used in the log to distinguish the standard output from
+// the standard error, for example 'stdout' or 'stderr'.
+func newTeeWriter(test, stream string) *teeWriter {
+	return &teeWriter{
+		test:   test,
+		stream: stream,
+	}
+}
+
+// Write is the implementation of the io.Writer interface.
+func (w *teeWriter) Write(data []byte) (n int, err error) {
+	n = len(data)
+	for len(data) > 0 {
+		index := bytes.IndexByte(data, '\n')
+		if index == -1 {
+			w.buffer.Write(data[:w.room(len(data))])
+			break
+		}
+		w.buffer.Write(data[:w.room(index)])
+		w.flush()
+		data = data[index+1:]
+	}
+	return
+}
+
+// Flush writes to the log the incomplete line, if any.
+func (w *teeWriter) Flush() {
+	if w.buffer.Len() > 0 {
+		w.flush()
+	}
+}
+
+// room returns how many of the given number of bytes can be added to the current line without
+// exceeding the maximum length.
+func (w *teeWriter) room(size int) int {
+	available := maxTeeLine - w.buffer.Len()
+	if size > available {
+		return available
+	}
+	return size
+}
+
+// flush writes to the log the current line and then clears it.
+func (w *teeWriter) flush() {
+	log.WithFields(log.Fields{
+		"test":   w.test,
+		"stream": w.stream,
+	}).Debug(w.buffer.String())
+	w.buffer.Reset()
+}
+
+// Maximum length of the lines written to the log:
+const maxTeeLine = 4096
@@ -0,0 +1,90 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("Tee writer", func() {
+	var hook *logtest.Hook
+	var level log.Level
+
+	BeforeEach(func() {
+		level = log.GetLevel()
+		log.SetLevel(log.DebugLevel)
+		hook = logtest.NewGlobal()
+	})
+
+	AfterEach(func() {
+		log.StandardLogger().ReplaceHooks(make(log.LevelHooks))
+		log.SetLevel(level)
+	})
+
+	// lines returns the messages written to the log.
+	lines := func() []string {
+		var result []string
+		for _, entry := range hook.AllEntries() {
+			result = append(result, entry.Message)
+		}
+		return result
+	}
+
+	It("Writes complete lines", func() {
+		writer := newTeeWriter("mytest", "stdout")
+		_, err := writer.Write([]byte("first\nsecond\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lines()).To(Equal([]string{"first", "second"}))
+	})
+
+	It("Joins lines written in parts", func() {
+		writer := newTeeWriter("mytest", "stdout")
+		_, err := writer.Write([]byte("fir"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lines()).To(BeEmpty())
+		_, err = writer.Write([]byte("st\nsec"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(lines()).To(Equal([]string{"first"}))
+		writer.Flush()
+		Expect(lines()).To(Equal([]string{"first", "sec"}))
+	})
+
+	It("Truncates long lines", func() {
+		writer := newTeeWriter("mytest", "stdout")
+		n, err := writer.Write([]byte(strings.Repeat("x", 2*maxTeeLine) + "\n"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(n).To(Equal(2*maxTeeLine + 1))
+		Expect(lines()).To(Equal([]string{strings.Repeat("x", maxTeeLine)}))
+	})
+
+	It("Adds the test and the stream to the log", func() {
+		writer := newTeeWriter("mytest", "stderr")
+		_, err := writer.Write([]byte("first\n"))
+		Expect(err).ToNot(HaveOccurred())
+		entry := hook.LastEntry()
+		Expect(entry).ToNot(BeNil())
+		Expect(entry.Level).To(Equal(log.DebugLevel))
+		Expect(entry.Data).To(HaveKeyWithValue("test", "mytest"))
+		Expect(entry.Data).To(HaveKeyWithValue("stream", "stderr"))
+	})
+})
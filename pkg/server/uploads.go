@@ -0,0 +1,649 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the uploads, that allow clients to send large test
+// binaries in chunks, so that when sending a chunk fails the upload can be resumed instead of
+// started again.
+
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+// uploadStore manages the binaries uploaded in chunks. Uploads are stored in a hidden
+// sub-directory of the directory of each tenant, so uploads created with different tokens are
+// isolated from each other. Each upload uses two files: one containing the bytes received so far,
+// and another containing the size and checksum sent by the client when the upload was created,
+// and the flag that indicates if it is complete. Uploads that haven't been modified or used for
+// longer than the configured TTL are periodically removed, unless they are in use by a running
+// test.
+type uploadStore struct {
+	work     string
+	ttl      time.Duration
+	active   *activeSet
+	interval time.Duration
+	lock     sync.Mutex
+	busy     map[resultKey]bool
+	stop     chan bool
+	done     chan bool
+}
+
+// newUploadStore creates an upload store that keeps the uploads inside the given work directory.
+// If the TTL is zero uploads will never be removed.
+func newUploadStore(work string, ttl time.Duration, active *activeSet) *uploadStore {
+	interval := ttl / 2
+	if interval > time.Minute {
+		interval = time.Minute
+	}
+	return &uploadStore{
+		work:     work,
+		ttl:      ttl,
+		active:   active,
+		interval: interval,
+		busy:     map[resultKey]bool{},
+	}
+}
+
+// dataPath returns the path of the file that contains the bytes of the given upload.
+func (s *uploadStore) dataPath(tenant, id string) string {
+	return filepath.Join(s.work, tenant, uploadsDir, id)
+}
+
+// statePath returns the path of the file that contains the state of the given upload.
+func (s *uploadStore) statePath(tenant, id string) string {
+	return s.dataPath(tenant, id) + uploadStateSuffix
+}
+
+// create creates an empty upload for a binary with the given size and checksum, and returns its
+// state.
+func (s *uploadStore) create(tenant string, size int64, checksum string) (upload *api.Upload,
+	err error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return
+	}
+	dir := filepath.Join(s.work, tenant, uploadsDir)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return
+	}
+	upload = &api.Upload{
+		ID:       id.String(),
+		Size:     size,
+		Checksum: strings.ToLower(checksum),
+	}
+	data := s.dataPath(tenant, upload.ID)
+	file, err := os.OpenFile(data, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		upload = nil
+		return
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(data)
+		upload = nil
+		return
+	}
+	err = s.save(tenant, upload)
+	if err != nil {
+		os.Remove(data)
+		upload = nil
+		return
+	}
+	return
+}
+
+// get returns the state of the given upload, or nil if it doesn't exist.
+func (s *uploadStore) get(tenant, id string) (upload *api.Upload, err error) {
+	data, err := ioutil.ReadFile(s.statePath(tenant, id))
+	if os.IsNotExist(err) {
+		err = nil
+		return
+	}
+	if err != nil {
+		return
+	}
+	upload = &api.Upload{}
+	err = json.Unmarshal(data, upload)
+	if err != nil {
+		upload = nil
+		return
+	}
+	info, err := os.Stat(s.dataPath(tenant, id))
+	if os.IsNotExist(err) {
+		upload = nil
+		err = nil
+		return
+	}
+	if err != nil {
+		upload = nil
+		return
+	}
+	upload.ID = id
+	upload.Received = info.Size()
+	return
+}
+
+// append adds a chunk to the given upload. The chunk must start where the previous one ended, and
+// the total size must be the one sent when the upload was created. Bytes are written as they are
+// received, so if the connection fails in the middle of a chunk the bytes received till then are
+// kept, and the client can continue from there. When the last byte is received the checksum is
+// checked: if it matches the upload is marked as complete, and if it doesn't the upload is
+// removed. The returned state is also returned when the chunk doesn't start where expected, so
+// that the caller can tell the client where it should start.
+func (s *uploadStore) append(tenant, id string, offset, total int64,
+	chunk io.Reader) (upload *api.Upload, err error) {
+	// Make sure that chunks of the same upload aren't written at the same time:
+	index := resultKey{tenant: tenant, key: id}
+	s.lock.Lock()
+	if s.busy[index] {
+		s.lock.Unlock()
+		err = errUploadBusy
+		return
+	}
+	s.busy[index] = true
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.busy, index)
+		s.lock.Unlock()
+	}()
+
+	// Check that the chunk fits the upload:
+	upload, err = s.get(tenant, id)
+	if err != nil {
+		return
+	}
+	if upload == nil {
+		err = errUploadNotFound
+		return
+	}
+	if upload.Complete {
+		err = errUploadComplete
+		return
+	}
+	if total != upload.Size {
+		err = errUploadSize
+		return
+	}
+	if offset != upload.Received {
+		err = errUploadOffset
+		return
+	}
+
+	// Write the chunk, never beyond the size of the binary:
+	path := s.dataPath(tenant, id)
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return
+	}
+	written, err := io.Copy(file, io.LimitReader(chunk, upload.Size-upload.Received))
+	upload.Received += written
+	if err != nil {
+		file.Close()
+		return
+	}
+	err = file.Close()
+	if err != nil {
+		return
+	}
+	if upload.Received < upload.Size {
+		return
+	}
+
+	// This was the last chunk, so check the checksum of the complete binary:
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return
+	}
+	if sum != upload.Checksum {
+		log.Errorf(
+			"Checksum of upload '%s' for tenant '%s' is '%s' but client sent '%s'",
+			id, tenant, sum, upload.Checksum,
+		)
+		s.remove(tenant, id)
+		err = errUploadChecksum
+		return
+	}
+	upload.Complete = true
+	err = s.save(tenant, upload)
+	return
+}
+
+// acquire marks the given upload as in use, so that it isn't removed while a test is using it,
+// and returns the path of the file that contains the binary. It fails if the upload doesn't exist
+// or isn't complete. The caller must call the release method when the test finishes.
+func (s *uploadStore) acquire(tenant, id string) (path string, err error) {
+	upload, err := s.get(tenant, id)
+	if err != nil {
+		return
+	}
+	if upload == nil {
+		err = errUploadNotFound
+		return
+	}
+	if !upload.Complete {
+		err = errUploadIncomplete
+		return
+	}
+	path = s.dataPath(tenant, id)
+	s.active.add(path)
+	now := time.Now()
+	err = os.Chtimes(path, now, now)
+	if err != nil {
+		s.active.remove(path)
+		path = ""
+	}
+	return
+}
+
+// release marks the upload as no longer used by the test.
+func (s *uploadStore) release(path string) {
+	s.active.remove(path)
+}
+
+// save writes the state of the given upload. The data is first written to a temporary file and
+// then renamed, so that the state is never partially written.
+func (s *uploadStore) save(tenant string, upload *api.Upload) (err error) {
+	state := &api.Upload{
+		Size:     upload.Size,
+		Checksum: upload.Checksum,
+		Complete: upload.Complete,
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+	file, err := ioutil.TempFile(filepath.Join(s.work, tenant, uploadsDir), ".state")
+	if err != nil {
+		return
+	}
+	_, err = file.Write(data)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return
+	}
+	err = file.Close()
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	err = os.Rename(file.Name(), s.statePath(tenant, upload.ID))
+	if err != nil {
+		os.Remove(file.Name())
+		return
+	}
+	return
+}
+
+// remove removes the files of the given upload.
+func (s *uploadStore) remove(tenant, id string) {
+	for _, path := range []string{s.statePath(tenant, id), s.dataPath(tenant, id)} {
+		err := os.Remove(path)
+		if err != nil && !os.IsNotExist(err) {
+			log.Errorf("Can't remove upload file '%s': %v", path, err)
+		}
+	}
+}
+
+// start starts the goroutine that periodically removes the unused uploads. It does nothing if the
+// TTL is zero.
+func (s *uploadStore) start() {
+	if s.ttl <= 0 {
+		return
+	}
+	s.stop = make(chan bool)
+	s.done = make(chan bool)
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.clean()
+			}
+		}
+	}()
+}
+
+// halt stops the goroutine that removes unused uploads and waits till it finishes.
+func (s *uploadStore) halt() {
+	if s.stop == nil {
+		return
+	}
+	close(s.stop)
+	<-s.done
+}
+
+// clean removes the uploads that haven't been modified or used for longer than the TTL, including
+// the ones that were never completed.
+func (s *uploadStore) clean() {
+	pattern := filepath.Join(s.work, "*", uploadsDir, "*"+uploadStateSuffix)
+	states, err := filepath.Glob(pattern)
+	if err != nil {
+		log.Errorf("Can't find uploads in work directory '%s': %v", s.work, err)
+		return
+	}
+	limit := time.Now().Add(-s.ttl)
+	for _, state := range states {
+		data := strings.TrimSuffix(state, uploadStateSuffix)
+		info, err := os.Stat(data)
+		if os.IsNotExist(err) {
+			info, err = os.Stat(state)
+		}
+		if err != nil {
+			log.Errorf("Can't check upload '%s': %v", data, err)
+			continue
+		}
+		if info.ModTime().After(limit) {
+			continue
+		}
+		if s.active.contains(data) {
+			log.Debugf("Upload '%s' is old but still in use", data)
+			continue
+		}
+		tenant := filepath.Base(filepath.Dir(filepath.Dir(data)))
+		s.remove(tenant, filepath.Base(data))
+		log.Infof(
+			"Removed upload '%s' because it wasn't used for more than %s",
+			data, s.ttl,
+		)
+	}
+}
+
+// fileChecksum calculates the hexadecimal SHA-256 of the content of the given file.
+func fileChecksum(path string) (sum string, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return
+	}
+	sum = hex.EncodeToString(hash.Sum(nil))
+	return
+}
+
+// Make sure that the handlers implement the HTTP handler interface:
+var _ http.Handler = &postUploadHandler{}
+var _ http.Handler = &getUploadHandler{}
+var _ http.Handler = &patchUploadHandler{}
+
+// postUploadHandler is the handler that receives a POST containing the size and checksum of a
+// binary and creates the upload that will receive its chunks.
+type postUploadHandler struct {
+	uploads *uploadStore
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *postUploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Unmarshal the request body:
+	requestBody := &api.Upload{}
+	requestDecoder := json.NewDecoder(r.Body)
+	err := requestDecoder.Decode(requestBody)
+	if err != nil {
+		log.WithError(err).Info("Can't unmarshal request body")
+		sendError(w, r, http.StatusBadRequest, "Can't unmarshal request body")
+		return
+	}
+
+	// Check the request:
+	if requestBody.Size <= 0 {
+		sendError(
+			w, r,
+			http.StatusBadRequest,
+			"Size of upload must be positive, but it is %d",
+			requestBody.Size,
+		)
+		return
+	}
+	if !checksumRE.MatchString(requestBody.Checksum) {
+		sendError(
+			w, r,
+			http.StatusBadRequest,
+			"Checksum of upload '%s' isn't a valid SHA-256",
+			requestBody.Checksum,
+		)
+		return
+	}
+
+	// Create the upload:
+	tenant := tokenFingerprint(requestToken(r))
+	upload, err := h.uploads.create(tenant, requestBody.Size, requestBody.Checksum)
+	if err != nil {
+		log.Errorf("Can't create upload for tenant '%s': %v", tenant, err)
+		sendError(w, r, http.StatusInternalServerError, "Can't create upload")
+		return
+	}
+	log.Infof(
+		"Created upload '%s' for tenant '%s' with size %d and checksum '%s'",
+		upload.ID, tenant, upload.Size, upload.Checksum,
+	)
+
+	// Send the response:
+	sendUpload(w, r, upload)
+}
+
+// getUploadHandler is the handler that returns the state of an upload, so that clients can find
+// out where they should continue after a failure.
+type getUploadHandler struct {
+	uploads *uploadStore
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *getUploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Check the identifier. It is used as the name of the files of the upload, so it has the
+	// same restrictions than the names of fixtures:
+	id := mux.Vars(r)["id"]
+	if !fixtureNameRE.MatchString(id) {
+		sendError(w, r, http.StatusBadRequest, "Upload identifier '%s' isn't valid", id)
+		return
+	}
+
+	// Load the state:
+	tenant := tokenFingerprint(requestToken(r))
+	upload, err := h.uploads.get(tenant, id)
+	if err != nil {
+		log.Errorf("Can't load upload '%s' for tenant '%s': %v", id, tenant, err)
+		sendError(w, r, http.StatusInternalServerError, "Can't load upload '%s'", id)
+		return
+	}
+	if upload == nil {
+		sendError(w, r, http.StatusNotFound, "Upload '%s' doesn't exist", id)
+		return
+	}
+
+	// Send the response:
+	sendUpload(w, r, upload)
+}
+
+// patchUploadHandler is the handler that receives a PATCH containing a chunk of an upload. The
+// position of the chunk is given in the Content-Range header, for example 'bytes 0-1023/4096'.
+type patchUploadHandler struct {
+	uploads *uploadStore
+}
+
+// ServeHTTP is the implementation of the HTTP handler interface.
+func (h *patchUploadHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Check the identifier:
+	id := mux.Vars(r)["id"]
+	if !fixtureNameRE.MatchString(id) {
+		sendError(w, r, http.StatusBadRequest, "Upload identifier '%s' isn't valid", id)
+		return
+	}
+
+	// Check the range:
+	header := r.Header.Get("Content-Range")
+	start, end, total, err := parseContentRange(header)
+	if err != nil {
+		log.Infof("Rejected chunk for upload '%s': %v", id, err)
+		sendError(w, r, http.StatusBadRequest, "Content range '%s' isn't valid", header)
+		return
+	}
+
+	// Add the chunk:
+	tenant := tokenFingerprint(requestToken(r))
+	chunk := io.LimitReader(r.Body, end-start+1)
+	upload, err := h.uploads.append(tenant, id, start, total, chunk)
+	switch err {
+	case nil:
+	case errUploadNotFound:
+		sendError(w, r, http.StatusNotFound, "Upload '%s' doesn't exist", id)
+		return
+	case errUploadBusy:
+		sendError(
+			w, r,
+			http.StatusConflict,
+			"Upload '%s' is already receiving another chunk",
+			id,
+		)
+		return
+	case errUploadComplete:
+		sendError(w, r, http.StatusConflict, "Upload '%s' is already complete", id)
+		return
+	case errUploadSize:
+		sendError(
+			w, r,
+			http.StatusBadRequest,
+			"Total size %d doesn't match the size %d of upload '%s'",
+			total, upload.Size, id,
+		)
+		return
+	case errUploadOffset:
+		sendError(
+			w, r,
+			http.StatusConflict,
+			"Chunk for upload '%s' starts at byte %d but it should start at byte %d",
+			id, start, upload.Received,
+		)
+		return
+	case errUploadChecksum:
+		sendError(
+			w, r,
+			http.StatusBadRequest,
+			"Checksum of upload '%s' doesn't match, it has been discarded",
+			id,
+		)
+		return
+	default:
+		log.Errorf("Can't add chunk to upload '%s' for tenant '%s': %v", id, tenant, err)
+		sendError(w, r, http.StatusInternalServerError, "Can't add chunk to upload '%s'", id)
+		return
+	}
+	if upload.Complete {
+		log.Infof("Upload '%s' for tenant '%s' is complete", id, tenant)
+	}
+
+	// Send the response:
+	sendUpload(w, r, upload)
+}
+
+// sendUpload sends the state of the given upload to the client.
+func sendUpload(w http.ResponseWriter, r *http.Request, upload *api.Upload) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(upload)
+	if err != nil {
+		log.Errorf("Can't send response body for upload '%s'", upload.ID)
+		return
+	}
+}
+
+// parseContentRange parses the value of a Content-Range header, for example 'bytes 0-1023/4096',
+// and returns the first and last bytes of the range, both inclusive, and the total size.
+func parseContentRange(value string) (start, end, total int64, err error) {
+	match := contentRangeRE.FindStringSubmatch(value)
+	if match == nil {
+		err = fmt.Errorf("content range '%s' doesn't have the 'bytes start-end/total' form", value)
+		return
+	}
+	start, err = strconv.ParseInt(match[1], 10, 64)
+	if err != nil {
+		return
+	}
+	end, err = strconv.ParseInt(match[2], 10, 64)
+	if err != nil {
+		return
+	}
+	total, err = strconv.ParseInt(match[3], 10, 64)
+	if err != nil {
+		return
+	}
+	if start > end || end >= total {
+		err = fmt.Errorf(
+			"range %d-%d of content range '%s' isn't within the total size %d",
+			start, end, value, total,
+		)
+		return
+	}
+	return
+}
+
+// Errors returned by the upload store:
+var (
+	errUploadNotFound   = fmt.Errorf("upload doesn't exist")
+	errUploadBusy       = fmt.Errorf("upload is receiving another chunk")
+	errUploadComplete   = fmt.Errorf("upload is already complete")
+	errUploadIncomplete = fmt.Errorf("upload isn't complete")
+	errUploadSize       = fmt.Errorf("total size doesn't match the size of the upload")
+	errUploadOffset     = fmt.Errorf("chunk doesn't start where the previous one ended")
+	errUploadChecksum   = fmt.Errorf("checksum doesn't match")
+)
+
+// contentRangeRE is the regular expression used to parse the Content-Range header of chunks.
+var contentRangeRE = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+)$`)
+
+// checksumRE is the regular expression used to check the checksums of uploads.
+var checksumRE = regexp.MustCompile(`^[0-9A-Fa-f]{64}$`)
+
+// Name of the sub-directory of each tenant directory that contains the uploads, and suffix of the
+// files that contain their state:
+const (
+	uploadsDir        = ".uploads"
+	uploadStateSuffix = ".json"
+)
+
+// Default time after which unused uploads are removed:
+const defaultUploadTTL = time.Hour
@@ -0,0 +1,268 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gorilla/mux"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/jhernand/sandbox/pkg/api"
+)
+
+var _ = Describe("Uploads", func() {
+	var work string
+	var uploads *uploadStore
+	var binary []byte
+	var checksum string
+
+	BeforeEach(func() {
+		var err error
+		work, err = ioutil.TempDir("", "uploads")
+		Expect(err).ToNot(HaveOccurred())
+		uploads = newUploadStore(work, time.Hour, newActiveSet())
+		binary = []byte("#!/bin/sh\necho uploaded\n")
+		sum := sha256.Sum256(binary)
+		checksum = hex.EncodeToString(sum[:])
+	})
+
+	AfterEach(func() {
+		err := os.RemoveAll(work)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	// create creates an upload for the given size and checksum and returns the recorded
+	// response and the decoded response body.
+	create := func(size int64, checksum string) (*httptest.ResponseRecorder, *api.Upload) {
+		body, err := json.Marshal(&api.Upload{
+			Size:     size,
+			Checksum: checksum,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest(
+			http.MethodPost,
+			"/api/v1/uploads",
+			bytes.NewReader(body),
+		)
+		recorder := httptest.NewRecorder()
+		handler := &postUploadHandler{
+			uploads: uploads,
+		}
+		handler.ServeHTTP(recorder, request)
+		response := &api.Upload{}
+		if recorder.Code == http.StatusOK {
+			err = json.Unmarshal(recorder.Body.Bytes(), response)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		return recorder, response
+	}
+
+	// patch sends the given chunk of the binary, from the start byte to the end byte, both
+	// inclusive, and returns the recorded response and the decoded response body.
+	patch := func(id string, start, end int) (*httptest.ResponseRecorder, *api.Upload) {
+		request := httptest.NewRequest(
+			http.MethodPatch,
+			"/api/v1/uploads/"+id,
+			bytes.NewReader(binary[start:end+1]),
+		)
+		request.Header.Set(
+			"Content-Range",
+			fmt.Sprintf("bytes %d-%d/%d", start, end, len(binary)),
+		)
+		request = mux.SetURLVars(request, map[string]string{
+			"id": id,
+		})
+		recorder := httptest.NewRecorder()
+		handler := &patchUploadHandler{
+			uploads: uploads,
+		}
+		handler.ServeHTTP(recorder, request)
+		response := &api.Upload{}
+		if recorder.Code == http.StatusOK {
+			err := json.Unmarshal(recorder.Body.Bytes(), response)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		return recorder, response
+	}
+
+	// get retrieves the state of the given upload and returns the recorded response and the
+	// decoded response body.
+	get := func(id string) (*httptest.ResponseRecorder, *api.Upload) {
+		request := httptest.NewRequest(http.MethodGet, "/api/v1/uploads/"+id, nil)
+		request = mux.SetURLVars(request, map[string]string{
+			"id": id,
+		})
+		recorder := httptest.NewRecorder()
+		handler := &getUploadHandler{
+			uploads: uploads,
+		}
+		handler.ServeHTTP(recorder, request)
+		response := &api.Upload{}
+		if recorder.Code == http.StatusOK {
+			err := json.Unmarshal(recorder.Body.Bytes(), response)
+			Expect(err).ToNot(HaveOccurred())
+		}
+		return recorder, response
+	}
+
+	It("Assembles the chunks and checks the checksum", func() {
+		size := len(binary)
+		recorder, upload := create(int64(size), checksum)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(upload.ID).ToNot(BeEmpty())
+		Expect(upload.Received).To(BeZero())
+
+		recorder, upload = patch(upload.ID, 0, 9)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(upload.Received).To(BeEquivalentTo(10))
+		Expect(upload.Complete).To(BeFalse())
+
+		recorder, upload = patch(upload.ID, 10, size-1)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(upload.Received).To(BeEquivalentTo(size))
+		Expect(upload.Complete).To(BeTrue())
+
+		tenant := tokenFingerprint("")
+		data, err := ioutil.ReadFile(uploads.dataPath(tenant, upload.ID))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(data).To(Equal(binary))
+	})
+
+	It("Reports where the upload should be resumed", func() {
+		recorder, upload := create(int64(len(binary)), checksum)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		patch(upload.ID, 0, 9)
+
+		// Sending a chunk that doesn't start where the previous ended fails:
+		recorder, _ = patch(upload.ID, 5, 14)
+		Expect(recorder.Code).To(Equal(http.StatusConflict))
+
+		// The state tells where to continue:
+		recorder, state := get(upload.ID)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(state.Received).To(BeEquivalentTo(10))
+		recorder, state = patch(upload.ID, 10, len(binary)-1)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		Expect(state.Complete).To(BeTrue())
+	})
+
+	It("Discards the upload if the checksum doesn't match", func() {
+		sum := sha256.Sum256([]byte("other"))
+		recorder, upload := create(int64(len(binary)), hex.EncodeToString(sum[:]))
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		recorder, _ = patch(upload.ID, 0, len(binary)-1)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+		recorder, _ = get(upload.ID)
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("Rejects chunks with invalid ranges", func() {
+		_, upload := create(int64(len(binary)), checksum)
+		request := httptest.NewRequest(
+			http.MethodPatch,
+			"/api/v1/uploads/"+upload.ID,
+			bytes.NewReader(binary),
+		)
+		request.Header.Set("Content-Range", "bytes 10-5/100")
+		request = mux.SetURLVars(request, map[string]string{
+			"id": upload.ID,
+		})
+		recorder := httptest.NewRecorder()
+		handler := &patchUploadHandler{
+			uploads: uploads,
+		}
+		handler.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Rejects uploads without a valid checksum", func() {
+		recorder, _ := create(int64(len(binary)), "junk")
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Returns not found for uploads that don't exist", func() {
+		recorder, _ := get("3f0c7a1e-7b5a-4f57-9a8e-0d6a1c1c2b3d")
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("Runs a test using the binary of a complete upload", func() {
+		_, upload := create(int64(len(binary)), checksum)
+		patch(upload.ID, 0, len(binary)-1)
+		handler := &postTestHandler{
+			work:    work,
+			active:  newActiveSet(),
+			uploads: uploads,
+		}
+		body, err := json.Marshal(&api.Test{
+			UploadID: upload.ID,
+			Checksum: checksum,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/tests", bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusOK))
+		response := &api.Test{}
+		err = json.Unmarshal(recorder.Body.Bytes(), response)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(response.Out)).To(Equal("uploaded\n"))
+	})
+
+	It("Rejects tests that use an incomplete upload", func() {
+		_, upload := create(int64(len(binary)), checksum)
+		patch(upload.ID, 0, 9)
+		handler := &postTestHandler{
+			work:    work,
+			active:  newActiveSet(),
+			uploads: uploads,
+		}
+		body, err := json.Marshal(&api.Test{
+			UploadID: upload.ID,
+		})
+		Expect(err).ToNot(HaveOccurred())
+		request := httptest.NewRequest(http.MethodPost, "/api/v1/tests", bytes.NewReader(body))
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		Expect(recorder.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Removes uploads that haven't been used", func() {
+		_, upload := create(int64(len(binary)), checksum)
+		tenant := tokenFingerprint("")
+		old := time.Now().Add(-2 * time.Hour)
+		err := os.Chtimes(uploads.dataPath(tenant, upload.ID), old, old)
+		Expect(err).ToNot(HaveOccurred())
+		uploads.clean()
+		recorder, _ := get(upload.ID)
+		Expect(recorder.Code).To(Equal(http.StatusNotFound))
+		files, err := ioutil.ReadDir(filepath.Join(work, tenant, uploadsDir))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(BeEmpty())
+	})
+})
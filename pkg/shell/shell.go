@@ -0,0 +1,257 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the implementation of the shell that runs inside the server pod of a project
+// created by the runner.
+
+package shell
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh/terminal"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/remotecommand"
+
+	"github.com/jhernand/sandbox/pkg/internal"
+)
+
+// ShellBuilder contains the information and logic needed to create a shell. Don't create
+// instances of this type directly; use the NewShell function instead.
+type ShellBuilder struct {
+	config  string
+	project string
+	command []string
+}
+
+// Shell is the implementation of the shell.
+type Shell struct {
+	restConfig *rest.Config
+	coreV1     *corev1client.CoreV1Client
+	project    string
+	command    []string
+}
+
+// NewShell creates a new object that knows how to build shells.
+func NewShell() *ShellBuilder {
+	return &ShellBuilder{}
+}
+
+// Config sets the configuration file that will be used to connect to the OpenShift API.
+func (b *ShellBuilder) Config(value string) *ShellBuilder {
+	b.config = value
+	return b
+}
+
+// Project sets the name of the project created by the runner that contains the server pod. This
+// is mandatory.
+func (b *ShellBuilder) Project(value string) *ShellBuilder {
+	b.project = value
+	return b
+}
+
+// Command sets the command that will be executed inside the server pod. The default is to run
+// '/bin/sh'.
+func (b *ShellBuilder) Command(values ...string) *ShellBuilder {
+	b.command = values
+	return b
+}
+
+// Build uses the information stored in the builder to create a new shell.
+func (b *ShellBuilder) Build() (s *Shell, err error) {
+	// Check parameters:
+	if b.project == "" {
+		err = fmt.Errorf("project is mandatory")
+		return
+	}
+	command := b.command
+	if len(command) == 0 {
+		command = defaultCommand
+	}
+
+	// Load the configuration either from the given configuration file or from the default
+	// location used when running inside a cluster:
+	restConfig, err := clientcmd.BuildConfigFromFlags("", b.config)
+	if err != nil {
+		return
+	}
+
+	// Create the client:
+	coreV1, err := corev1client.NewForConfig(restConfig)
+	if err != nil {
+		return
+	}
+
+	// Create and populate the object:
+	s = &Shell{
+		restConfig: restConfig,
+		coreV1:     coreV1,
+		project:    b.project,
+		command:    command,
+	}
+
+	return
+}
+
+// Run executes the command inside the server pod, connected to the standard input, output and
+// error of the current process, and waits till it finishes. When the standard input is a
+// terminal it is put in raw mode, and changes of its size are sent to the pod. If the command
+// finishes with an exit code different than zero the returned error will implement the ExitError
+// interface of the 'k8s.io/client-go/util/exec' package.
+func (s *Shell) Run() error {
+	// Check that the server pod is running:
+	pod, err := s.coreV1.Pods(s.project).Get(internal.ServerApp, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("can't get server pod of project '%s': %v", s.project, err)
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Errorf(
+			"server pod of project '%s' isn't running, it is in phase '%s'",
+			s.project, pod.Status.Phase,
+		)
+	}
+
+	// When a terminal is used the remote side merges the standard output and error, so
+	// requesting both would fail:
+	fd := int(os.Stdin.Fd())
+	tty := terminal.IsTerminal(fd)
+
+	// Create the executor:
+	request := s.coreV1.RESTClient().
+		Post().
+		Namespace(s.project).
+		Resource("pods").
+		Name(pod.Name).
+		SubResource("exec").
+		VersionedParams(
+			&corev1.PodExecOptions{
+				Container: internal.ServerApp,
+				Command:   s.command,
+				Stdin:     true,
+				Stdout:    true,
+				Stderr:    !tty,
+				TTY:       tty,
+			},
+			scheme.ParameterCodec,
+		)
+	executor, err := remotecommand.NewSPDYExecutor(s.restConfig, http.MethodPost, request.URL())
+	if err != nil {
+		return err
+	}
+	options := remotecommand.StreamOptions{
+		Stdin:  os.Stdin,
+		Stdout: os.Stdout,
+		Tty:    tty,
+	}
+	if !tty {
+		options.Stderr = os.Stderr
+		return executor.Stream(options)
+	}
+
+	// Put the terminal in raw mode, so that keys like Ctrl+C are sent to the remote shell
+	// instead of being processed locally, and make sure that it is restored when we finish:
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return fmt.Errorf("can't put terminal in raw mode: %v", err)
+	}
+	restore := func() {
+		err := terminal.Restore(fd, state)
+		if err != nil {
+			log.Errorf("Can't restore terminal: %v", err)
+		}
+	}
+	defer restore()
+
+	// Send the initial size of the terminal, and then the new size each time that it changes:
+	queue := newSizeQueue()
+	queue.update(fd)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		watchSize(fd, queue, stop)
+		close(done)
+	}()
+	halt := func() {
+		close(stop)
+		<-done
+		queue.close()
+	}
+	defer halt()
+	options.TerminalSizeQueue = queue
+
+	return executor.Stream(options)
+}
+
+// sizeQueue is the implementation of the remotecommand.TerminalSizeQueue interface that returns
+// the sizes of the local terminal.
+type sizeQueue struct {
+	sizes chan remotecommand.TerminalSize
+}
+
+// Make sure that we implement the interface:
+var _ remotecommand.TerminalSizeQueue = &sizeQueue{}
+
+// newSizeQueue creates a new empty queue.
+func newSizeQueue() *sizeQueue {
+	return &sizeQueue{
+		sizes: make(chan remotecommand.TerminalSize, 1),
+	}
+}
+
+// update puts in the queue the current size of the given terminal. Only the last size matters,
+// so if there is a pending size that hasn't been sent yet it is replaced. This should only be
+// called from one goroutine at a time.
+func (q *sizeQueue) update(fd int) {
+	width, height, err := terminal.GetSize(fd)
+	if err != nil {
+		log.Debugf("Can't get size of terminal: %v", err)
+		return
+	}
+	select {
+	case <-q.sizes:
+	default:
+	}
+	q.sizes <- remotecommand.TerminalSize{
+		Width:  uint16(width),
+		Height: uint16(height),
+	}
+}
+
+// close closes the queue, so that the Next method returns nil and the executor stops asking for
+// sizes.
+func (q *sizeQueue) close() {
+	close(q.sizes)
+}
+
+// Next is the implementation of the remotecommand.TerminalSizeQueue interface.
+func (q *sizeQueue) Next() *remotecommand.TerminalSize {
+	size, ok := <-q.sizes
+	if !ok {
+		return nil
+	}
+	return &size
+}
+
+// Command executed when no other is given:
+var defaultCommand = []string{"/bin/sh"}
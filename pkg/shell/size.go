@@ -0,0 +1,45 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that detects changes of the size of the terminal in systems that
+// support the SIGWINCH signal.
+
+package shell
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// watchSize updates the given queue each time that the size of the given terminal changes, till
+// the stop channel is closed.
+func watchSize(fd int, queue *sizeQueue, stop <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGWINCH)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-signals:
+			queue.update(fd)
+		case <-stop:
+			return
+		}
+	}
+}
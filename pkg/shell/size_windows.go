@@ -0,0 +1,26 @@
+/*
+Copyright (c) 2019 Red Hat, Inc.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// This file contains the code that detects changes of the size of the terminal in Windows, where
+// there is no signal to detect them, so only the initial size is used.
+
+package shell
+
+// watchSize waits till the stop channel is closed. Changes of the size of the terminal aren't
+// detected.
+func watchSize(fd int, queue *sizeQueue, stop <-chan struct{}) {
+	<-stop
+}
@@ -18,6 +18,7 @@ package database
 
 import (
 	"database/sql"
+	"sync"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -71,6 +72,51 @@ var _ = Describe("Database", func() {
 		defer rows.Close()
 	})
 
+	It("Can snapshot and restore a database", func() {
+		// Create the sandbox:
+		sb, err := sandbox.NewSandbox().Build()
+		Expect(err).ToNot(HaveOccurred())
+		defer sb.Destroy()
+
+		// Create the database:
+		db, err := sb.Database()
+		Expect(err).ToNot(HaveOccurred())
+		defer db.Destroy()
+
+		// Create a table with one row:
+		handle, err := sql.Open(db.Driver(), db.Source())
+		Expect(err).ToNot(HaveOccurred())
+		_, err = handle.Exec("CREATE TABLE items (id INTEGER)")
+		Expect(err).ToNot(HaveOccurred())
+		_, err = handle.Exec("INSERT INTO items VALUES (1)")
+		Expect(err).ToNot(HaveOccurred())
+		err = handle.Close()
+		Expect(err).ToNot(HaveOccurred())
+
+		// Take the snapshot:
+		snapshot, err := db.Snapshot()
+		Expect(err).ToNot(HaveOccurred())
+
+		// Add another row:
+		handle, err = sql.Open(db.Driver(), db.Source())
+		Expect(err).ToNot(HaveOccurred())
+		_, err = handle.Exec("INSERT INTO items VALUES (2)")
+		Expect(err).ToNot(HaveOccurred())
+		err = handle.Close()
+		Expect(err).ToNot(HaveOccurred())
+
+		// Restore the snapshot and check that only the first row is there:
+		err = db.Restore(snapshot)
+		Expect(err).ToNot(HaveOccurred())
+		handle, err = sql.Open(db.Driver(), db.Source())
+		Expect(err).ToNot(HaveOccurred())
+		defer handle.Close()
+		var count int
+		err = handle.QueryRow("SELECT count(*) FROM items").Scan(&count)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(1))
+	})
+
 	It("Can create multiple databases", func() {
 		// Create the sandbox:
 		sb, err := sandbox.NewSandbox().Build()
@@ -84,4 +130,43 @@ var _ = Describe("Database", func() {
 			defer db.Destroy()
 		}
 	})
+
+	It("Can create databases from concurrent sandboxes", func() {
+		// Create the sandboxes and the databases concurrently, so that they compete to prepare
+		// the database server and to generate the names of the users and the databases:
+		const count = 5
+		sandboxes := make([]*sandbox.Sandbox, count)
+		databases := make([]*sandbox.Database, count)
+		errs := make([]error, count)
+		var wg sync.WaitGroup
+		for i := 0; i < count; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				sandboxes[i], errs[i] = sandbox.NewSandbox().Build()
+				if errs[i] != nil {
+					return
+				}
+				databases[i], errs[i] = sandboxes[i].Database()
+			}(i)
+		}
+		wg.Wait()
+		for i := 0; i < count; i++ {
+			if databases[i] != nil {
+				defer databases[i].Destroy()
+			}
+			if sandboxes[i] != nil {
+				defer sandboxes[i].Destroy()
+			}
+		}
+
+		// Check that all the databases were created and that they are different:
+		sources := map[string]bool{}
+		for i := 0; i < count; i++ {
+			Expect(errs[i]).ToNot(HaveOccurred())
+			source := databases[i].Source()
+			Expect(sources).ToNot(HaveKey(source))
+			sources[source] = true
+		}
+	})
 })